@@ -0,0 +1,246 @@
+package main
+
+import (
+	"context"
+	"log/slog"
+	"time"
+
+	"go.mongodb.org/mongo-driver/v2/bson"
+	"go.mongodb.org/mongo-driver/v2/mongo"
+	"go.mongodb.org/mongo-driver/v2/mongo/options"
+)
+
+const (
+	alertLowStock   = "low_stock"
+	alertOutOfStock = "out_of_stock"
+
+	stockAlertInterval = time.Minute
+	maxStockAlerts     = 200
+)
+
+var alert_repo_source = slog.Any("source", "AlertRepository")
+
+// stockLevel is the alert the quantity of an item calls for, empty while it is above its reorder threshold
+func stockLevel(quantity, threshold int) string {
+	switch {
+	case quantity <= 0:
+		return alertOutOfStock
+	case quantity <= threshold:
+		return alertLowStock
+	}
+	return ""
+}
+
+// stockItem is a store item at or below its reorder threshold
+type stockItem struct {
+	VendorID  bson.ObjectID `bson:"vendor_id"`
+	StoreID   bson.ObjectID `bson:"store_id"`
+	StoreName string        `bson:"store_name"`
+	Item      *Item         `bson:"item"`
+}
+
+// stockTransitions compares the items that need an alert with the alerts still open. An item gets a new alert
+// when it has none or its level changed, the alert it had is resolved then and so is every open alert of an
+// item that no longer needs one
+func stockTransitions(items []*stockItem, open []*StockAlert, now time.Time) (raised []*StockAlert, resolved []bson.ObjectID) {
+	type key struct{ store, item bson.ObjectID }
+	openByItem := make(map[key]*StockAlert, len(open))
+	for _, alert := range open {
+		openByItem[key{alert.StoreID, alert.ItemID}] = alert
+	}
+
+	seen := make(map[key]bool, len(items))
+	for _, stock := range items {
+		k := key{stock.StoreID, stock.Item.IngredientID}
+		level := stockLevel(stock.Item.Quantity, stock.Item.ReorderThreshold)
+		if level == "" || seen[k] {
+			continue
+		}
+		seen[k] = true
+
+		if alert, ok := openByItem[k]; ok {
+			if alert.Kind == level {
+				continue
+			}
+			resolved = append(resolved, alert.ID)
+		}
+		raised = append(raised, &StockAlert{
+			VendorID:  stock.VendorID,
+			StoreID:   stock.StoreID,
+			StoreName: stock.StoreName,
+			ItemID:    stock.Item.IngredientID,
+			Name:      stock.Item.Name,
+			Kind:      level,
+			Quantity:  stock.Item.Quantity,
+			Threshold: stock.Item.ReorderThreshold,
+			CreatedAt: now,
+		})
+	}
+
+	for k, alert := range openByItem {
+		if !seen[k] {
+			resolved = append(resolved, alert.ID)
+		}
+	}
+	return raised, resolved
+}
+
+type AlertRepository interface {
+	EvaluateStock(context.Context) ([]*StockAlert, error)
+	FindStockAlerts(context.Context, ID, ID, bool) ([]*StockAlert, error)
+	MarkAlertsNotified(context.Context, []bson.ObjectID) error
+}
+
+type MongoAlertRepository struct {
+	col    *mongo.Collection
+	vendor *mongo.Collection
+}
+
+func newMongoAlertRepository(client *mongo.Client, dbName string) AlertRepository {
+	db := client.Database(dbName)
+	return &MongoAlertRepository{col: db.Collection("stock_alerts"), vendor: db.Collection("vendor")}
+}
+
+// EvaluateStock raises and resolves alerts for every store item and returns the alerts it raised
+func (m MongoAlertRepository) EvaluateStock(ctx context.Context) ([]*StockAlert, error) {
+	ctx, span := Tracer.Start(ctx, "EvaluateStock")
+	defer span.End()
+
+	threshold := bson.D{{Key: "$max", Value: bson.A{bson.D{{Key: "$ifNull", Value: bson.A{"$stores.items.reorder_threshold", 0}}}, 0}}}
+	pipeline := mongo.Pipeline{
+		{{Key: "$unwind", Value: "$stores"}},
+		{{Key: "$unwind", Value: "$stores.items"}},
+		{{Key: "$match", Value: bson.D{{Key: "$expr", Value: bson.D{{Key: "$lte", Value: bson.A{"$stores.items.quantity", threshold}}}}}}},
+		{{Key: "$project", Value: bson.D{
+			{Key: "_id", Value: 0},
+			{Key: "vendor_id", Value: "$_id"},
+			{Key: "store_id", Value: "$stores._id"},
+			{Key: "store_name", Value: "$stores.name"},
+			{Key: "item", Value: "$stores.items"},
+		}}},
+	}
+	cursor, err := m.vendor.Aggregate(ctx, pipeline)
+	if err != nil {
+		Logger.ErrorContext(ctx, "Error reading store stock", slog.Any("error", err), alert_repo_source)
+		return nil, err
+	}
+	var items []*stockItem
+	if err := cursor.All(ctx, &items); err != nil {
+		Logger.ErrorContext(ctx, "Error decoding store stock", slog.Any("error", err), alert_repo_source)
+		return nil, err
+	}
+
+	cursor, err = m.col.Find(ctx, bson.D{{Key: "resolved_at", Value: nil}})
+	if err != nil {
+		Logger.ErrorContext(ctx, "Error reading open alerts", slog.Any("error", err), alert_repo_source)
+		return nil, err
+	}
+	var open []*StockAlert
+	if err := cursor.All(ctx, &open); err != nil {
+		Logger.ErrorContext(ctx, "Error decoding open alerts", slog.Any("error", err), alert_repo_source)
+		return nil, err
+	}
+
+	now := time.Now()
+	raised, resolved := stockTransitions(items, open, now)
+	if len(resolved) > 0 {
+		filter := bson.D{{Key: "_id", Value: bson.D{{Key: "$in", Value: resolved}}}}
+		if _, err := m.col.UpdateMany(ctx, filter, bson.D{{Key: "$set", Value: bson.M{"resolved_at": now}}}); err != nil {
+			Logger.ErrorContext(ctx, "Error resolving alerts", slog.Any("error", err), alert_repo_source)
+			return nil, err
+		}
+	}
+	if len(raised) > 0 {
+		docs := make([]any, len(raised))
+		for i, alert := range raised {
+			alert.ID = bson.NewObjectID()
+			docs[i] = alert
+		}
+		if _, err := m.col.InsertMany(ctx, docs); err != nil {
+			Logger.ErrorContext(ctx, "Error raising alerts", slog.Any("error", err), alert_repo_source)
+			return nil, err
+		}
+	}
+
+	Logger.InfoContext(ctx, "Stock evaluated", slog.Int("raised", len(raised)), slog.Int("resolved", len(resolved)),
+		alert_repo_source)
+	return raised, nil
+}
+
+// FindStockAlerts is the alert feed of a store, newest first
+func (m MongoAlertRepository) FindStockAlerts(ctx context.Context, vendorID, storeID ID, openOnly bool) ([]*StockAlert, error) {
+	ctx, span := Tracer.Start(ctx, "FindStockAlerts")
+	defer span.End()
+
+	filter := bson.D{{Key: "vendor_id", Value: vendorID.value}, {Key: "store_id", Value: storeID.value}}
+	if openOnly {
+		filter = append(filter, bson.E{Key: "resolved_at", Value: nil})
+	}
+	opts := options.Find().SetSort(bson.D{{Key: "created_at", Value: -1}}).SetLimit(maxStockAlerts)
+	cursor, err := m.col.Find(ctx, filter, opts)
+	if err != nil {
+		Logger.ErrorContext(ctx, "Error reading store alerts", slog.Any("error", err), alert_repo_source)
+		return nil, err
+	}
+	alerts := []*StockAlert{}
+	if err := cursor.All(ctx, &alerts); err != nil {
+		Logger.ErrorContext(ctx, "Error decoding store alerts", slog.Any("error", err), alert_repo_source)
+		return nil, err
+	}
+	return alerts, nil
+}
+
+func (m MongoAlertRepository) MarkAlertsNotified(ctx context.Context, ids []bson.ObjectID) error {
+	ctx, span := Tracer.Start(ctx, "MarkAlertsNotified")
+	defer span.End()
+
+	filter := bson.D{{Key: "_id", Value: bson.D{{Key: "$in", Value: ids}}}}
+	if _, err := m.col.UpdateMany(ctx, filter, bson.D{{Key: "$set", Value: bson.M{"notified_at": time.Now()}}}); err != nil {
+		Logger.ErrorContext(ctx, "Error marking alerts notified", slog.Any("error", err), alert_repo_source)
+		return err
+	}
+	return nil
+}
+
+// evaluateStock is the stock alert job, it raises alerts and sends every vendor one event per kind with the
+// alerts it raised for them
+func evaluateStock(ctx context.Context) error {
+	raised, err := Repos.Alert.EvaluateStock(ctx)
+	if err != nil {
+		return err
+	}
+
+	type key struct {
+		vendor bson.ObjectID
+		kind   string
+	}
+	var order []key
+	batches := make(map[key][]*StockAlert)
+	for _, alert := range raised {
+		k := key{alert.VendorID, alert.Kind}
+		if _, ok := batches[k]; !ok {
+			order = append(order, k)
+		}
+		batches[k] = append(batches[k], alert)
+	}
+
+	var notified []bson.ObjectID
+	for _, k := range order {
+		alerts := batches[k]
+		sent, err := notify(ctx, ID{k.vendor}, k.kind, alerts)
+		if err != nil {
+			Logger.ErrorContext(ctx, "Unable to notify vendor", slog.String("vendorID", k.vendor.Hex()),
+				slog.String("kind", k.kind), slog.Any("error", err), alert_repo_source)
+			continue
+		}
+		if sent {
+			for _, alert := range alerts {
+				notified = append(notified, alert.ID)
+			}
+		}
+	}
+	if len(notified) > 0 {
+		return Repos.Alert.MarkAlertsNotified(ctx, notified)
+	}
+	return nil
+}
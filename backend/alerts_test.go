@@ -0,0 +1,58 @@
+package main
+
+import (
+	"slices"
+	"testing"
+	"time"
+
+	"go.mongodb.org/mongo-driver/v2/bson"
+)
+
+func TestStockLevel(t *testing.T) {
+	tests := []struct {
+		quantity, threshold int
+		expected            string
+	}{
+		{10, 5, ""},
+		{5, 5, alertLowStock},
+		{1, 5, alertLowStock},
+		{0, 5, alertOutOfStock},
+		{-2, 0, alertOutOfStock},
+		{3, 0, ""},
+	}
+	for _, test := range tests {
+		if got := stockLevel(test.quantity, test.threshold); got != test.expected {
+			t.Fatalf("quantity %d threshold %d -> expected %q got %q", test.quantity, test.threshold, test.expected, got)
+		}
+	}
+}
+
+func TestStockTransitions(t *testing.T) {
+	vendor, store := bson.NewObjectID(), bson.NewObjectID()
+	stock := func(item *Item) *stockItem { return &stockItem{VendorID: vendor, StoreID: store, Item: item} }
+	alert := func(item *Item, kind string) *StockAlert {
+		return &StockAlert{ID: bson.NewObjectID(), VendorID: vendor, StoreID: store, ItemID: item.IngredientID, Kind: kind}
+	}
+
+	milk, flour, eggs, butter := newTestItem("Milk", 1, "l", 1), newTestItem("Flour", 1, "kg", 2),
+		newTestItem("Eggs", 12, "each", 3), newTestItem("Butter", 250, "g", 2)
+	milk.Quantity, milk.ReorderThreshold = 2, 5
+	flour.Quantity, flour.ReorderThreshold = 0, 5
+	eggs.Quantity, eggs.ReorderThreshold = 1, 4
+
+	// milk is newly low, flour went from low to out, eggs are still low and butter was restocked
+	items := []*stockItem{stock(milk), stock(flour), stock(eggs)}
+	flourLow, eggsLow, butterOut := alert(flour, alertLowStock), alert(eggs, alertLowStock), alert(butter, alertOutOfStock)
+	raised, resolved := stockTransitions(items, []*StockAlert{flourLow, eggsLow, butterOut}, time.Now())
+
+	if len(raised) != 2 || raised[0].ItemID != milk.IngredientID || raised[0].Kind != alertLowStock ||
+		raised[1].ItemID != flour.IngredientID || raised[1].Kind != alertOutOfStock {
+		t.Fatalf("expected milk low and flour out got %+v", raised)
+	}
+	slices.SortFunc(resolved, func(a, b bson.ObjectID) int { return slices.Compare(a[:], b[:]) })
+	expected := []bson.ObjectID{flourLow.ID, butterOut.ID}
+	slices.SortFunc(expected, func(a, b bson.ObjectID) int { return slices.Compare(a[:], b[:]) })
+	if !slices.Equal(resolved, expected) {
+		t.Fatalf("expected the flour and butter alerts resolved got %v", resolved)
+	}
+}
@@ -0,0 +1,119 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"io"
+	"io/fs"
+	"log/slog"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+)
+
+const defaultBlobDir = "blobs"
+
+var (
+	// Blobs keeps uploaded files, the database only records their keys
+	Blobs       BlobStore
+	blob_source = slog.Any("source", "BlobStore")
+
+	errBlobKey = errors.New("invalid blob key")
+)
+
+// BlobStore keeps files under slash separated keys, backends other than the local filesystem only have to
+// implement it
+type BlobStore interface {
+	Put(context.Context, string, io.Reader) (int64, error)
+	Get(context.Context, string) (io.ReadCloser, error)
+	Delete(context.Context, string) error
+}
+
+// initBlobStore opens the blob store, BLOB_DIR is the directory of the local backend
+func initBlobStore() (err error) {
+	dir := os.Getenv("BLOB_DIR")
+	if dir == "" {
+		dir = defaultBlobDir
+	}
+	Blobs, err = newLocalBlobStore(dir)
+	return
+}
+
+// LocalBlobStore keeps every blob as a file under root
+type LocalBlobStore struct{ root string }
+
+func newLocalBlobStore(root string) (*LocalBlobStore, error) {
+	if err := os.MkdirAll(root, 0o755); err != nil {
+		return nil, err
+	}
+	return &LocalBlobStore{root: root}, nil
+}
+
+// path is the file of the key, keys must be clean relative paths so they cannot leave the root
+func (l *LocalBlobStore) path(key string) (string, error) {
+	if key == "" || path.IsAbs(key) || path.Clean(key) != key || key == ".." || strings.HasPrefix(key, "../") ||
+		strings.Contains(key, "\\") {
+		return "", errBlobKey
+	}
+	return filepath.Join(l.root, filepath.FromSlash(key)), nil
+}
+
+// Put writes the blob to a temporary file first so a failed upload never leaves half a blob under the key
+func (l *LocalBlobStore) Put(ctx context.Context, key string, r io.Reader) (int64, error) {
+	name, err := l.path(key)
+	if err != nil {
+		return 0, err
+	}
+	if err := os.MkdirAll(filepath.Dir(name), 0o755); err != nil {
+		Logger.ErrorContext(ctx, "Unable to create the blob directory", slog.Any("error", err), blob_source)
+		return 0, err
+	}
+	tmp, err := os.CreateTemp(filepath.Dir(name), ".upload-*")
+	if err != nil {
+		Logger.ErrorContext(ctx, "Unable to create the blob file", slog.Any("error", err), blob_source)
+		return 0, err
+	}
+	defer os.Remove(tmp.Name())
+
+	size, err := io.Copy(tmp, r)
+	if err = errors.Join(err, tmp.Close()); err != nil {
+		Logger.ErrorContext(ctx, "Unable to write the blob", slog.String("key", key), slog.Any("error", err), blob_source)
+		return 0, err
+	}
+	if err := os.Rename(tmp.Name(), name); err != nil {
+		Logger.ErrorContext(ctx, "Unable to store the blob", slog.String("key", key), slog.Any("error", err), blob_source)
+		return 0, err
+	}
+	return size, nil
+}
+
+// Get opens the blob, a missing blob is NoItems
+func (l *LocalBlobStore) Get(ctx context.Context, key string) (io.ReadCloser, error) {
+	name, err := l.path(key)
+	if err != nil {
+		return nil, err
+	}
+	file, err := os.Open(name)
+	if err != nil {
+		if errors.Is(err, fs.ErrNotExist) {
+			return nil, &NoItems{}
+		}
+		Logger.ErrorContext(ctx, "Unable to open the blob", slog.String("key", key), slog.Any("error", err), blob_source)
+		return nil, err
+	}
+	return file, nil
+}
+
+// Delete removes the blob, deleting a missing blob is not an error
+func (l *LocalBlobStore) Delete(ctx context.Context, key string) error {
+	name, err := l.path(key)
+	if err != nil {
+		return err
+	}
+	if err := os.Remove(name); err != nil && !errors.Is(err, fs.ErrNotExist) {
+		Logger.ErrorContext(ctx, "Unable to delete the blob", slog.String("key", key), slog.Any("error", err), blob_source)
+		return err
+	}
+	return nil
+}
@@ -0,0 +1,46 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"io"
+	"strings"
+	"testing"
+)
+
+func TestLocalBlobStore(t *testing.T) {
+	ctx := context.Background()
+	store, err := newLocalBlobStore(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if size, err := store.Put(ctx, "issues/a/b", strings.NewReader("photo")); err != nil || size != 5 {
+		t.Fatalf("unexpected put %d %v", size, err)
+	}
+	blob, err := store.Get(ctx, "issues/a/b")
+	if err != nil {
+		t.Fatal(err)
+	}
+	data, _ := io.ReadAll(blob)
+	blob.Close()
+	if string(data) != "photo" {
+		t.Fatalf("expected the blob back got %q", data)
+	}
+
+	if err := store.Delete(ctx, "issues/a/b"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := store.Get(ctx, "issues/a/b"); !errors.Is(err, &NoItems{}) {
+		t.Fatalf("expected a deleted blob to be gone got %v", err)
+	}
+	if err := store.Delete(ctx, "issues/a/b"); err != nil {
+		t.Fatal("expected deleting a missing blob to succeed")
+	}
+
+	for _, key := range []string{"", "../x", "/etc/passwd", "a/../../x", "a//b", `a\b`} {
+		if _, err := store.Put(ctx, key, strings.NewReader("x")); !errors.Is(err, errBlobKey) {
+			t.Fatalf("expected %q to be rejected got %v", key, err)
+		}
+	}
+}
@@ -28,6 +28,26 @@ func InitCachedMongoRepositories(ctx context.Context, redisClient *redis.Client,
 		Logger.ErrorContext(ctx, "Unable to init Repos", slog.Any("error", err), cached_repo)
 		return err
 	}
+	if err = initIndexes(ctx, mongoClient); err != nil {
+		Logger.ErrorContext(ctx, "Unable to init indexes", slog.Any("error", err), cached_repo)
+		return err
+	}
+	if _, err = mongoRepos.Catalog.MigrateAdminIngredients(ctx); err != nil {
+		Logger.ErrorContext(ctx, "Unable to migrate admin ingredients", slog.Any("error", err), cached_repo)
+		return err
+	}
+	if _, err = mongoRepos.Catalog.IndexCatalogNames(ctx); err != nil {
+		Logger.ErrorContext(ctx, "Unable to index catalog names", slog.Any("error", err), cached_repo)
+		return err
+	}
+	if _, err = mongoRepos.Vendor.IndexItemNames(ctx); err != nil {
+		Logger.ErrorContext(ctx, "Unable to index store item names", slog.Any("error", err), cached_repo)
+		return err
+	}
+	if _, err = mongoRepos.Ledger.OpenLedger(ctx); err != nil {
+		Logger.ErrorContext(ctx, "Unable to open the inventory ledger", slog.Any("error", err), cached_repo)
+		return err
+	}
 	mongoRepos.User = &CachedUserRepository{
 		redis:      redisClient,
 		userRepo:   mongoRepos.User,
@@ -0,0 +1,837 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/url"
+	"regexp"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"go.mongodb.org/mongo-driver/v2/bson"
+	"go.mongodb.org/mongo-driver/v2/mongo"
+	"go.mongodb.org/mongo-driver/v2/mongo/options"
+)
+
+func linkToCatalog(ctx context.Context, stores []*Store, update bool, source slog.Attr) error {
+	catalog, err := getAllIngredients(ctx, source)
+	if err != nil {
+		return errors.New("unable to load the ingredient catalog")
+	}
+	if err := newIngredientMatcher(catalog).linkStoreItems(stores, update); err != nil {
+		Logger.ErrorContext(ctx, "Store items do not match the catalog", slog.Any("error", err), source)
+		return err
+	}
+	return nil
+}
+
+// linkStoreItems ties every store item to the catalog ingredient it sells, an item names its ingredient by
+// catalog_id or by a name or alias the catalog knows and its unit has to convert to the catalog unit. Updates
+// to existing items only need a link when they change the name or the unit
+func (m *ingredientMatcher) linkStoreItems(stores []*Store, update bool) error {
+	for _, store := range stores {
+		for _, item := range store.Items {
+			existing := update && item.IngredientID != bson.NilObjectID
+			if existing && item.CatalogID == bson.NilObjectID && item.Name == "" && item.Unit == "" {
+				continue
+			}
+			if err := m.linkItem(item, existing); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+func (m *ingredientMatcher) linkItem(item *Item, existing bool) error {
+	var entry *Ingredient
+	switch {
+	case item.CatalogID != bson.NilObjectID:
+		if entry = m.byID[item.CatalogID]; entry == nil {
+			return fmt.Errorf("catalog ingredient %s does not exist", item.CatalogID.Hex())
+		}
+		if item.Name != "" && m.resolve(item.Name) != entry {
+			return fmt.Errorf("%q is not a name of the catalog ingredient %q", item.Name, entry.Name)
+		}
+	case item.Name != "":
+		if entry = m.resolve(item.Name); entry == nil {
+			return fmt.Errorf("%q is not in the ingredient catalog", item.Name)
+		}
+	default:
+		return fmt.Errorf("changing the unit of item %s needs its catalog_id", item.IngredientID.Hex())
+	}
+
+	if item.Name == "" && !existing {
+		item.Name = entry.Name
+	}
+	if item.Unit == "" && !existing {
+		return fmt.Errorf("%q needs a unit", entry.Name)
+	}
+	if item.Unit != "" && !unitAllowed(entry, item.Unit) {
+		return fmt.Errorf("%q can not be sold in %q", entry.Name, item.Unit)
+	}
+	item.CatalogID = entry.IngredientID
+	return nil
+}
+
+// unitAllowed is true when the unit converts to the unit of the catalog ingredient, units missing from the
+// unit table have to be spelled like the catalog unit
+func unitAllowed(entry *Ingredient, unit string) bool {
+	catalogAmount, catalogOk := toAmount(1, entry.Unit)
+	itemAmount, itemOk := toAmount(1, unit)
+	if !catalogOk || !itemOk {
+		return canonicalUnit(entry.Unit) == canonicalUnit(unit)
+	}
+	_, ok := itemAmount.in(catalogAmount.dim, entry.Density)
+	return ok
+}
+
+const (
+	defaultCatalogPageSize = 100
+	maxCatalogPageSize     = 500
+)
+
+var (
+	catalog_repo_source = slog.Any("source", "CatalogRepository")
+
+	errCatalogNameTaken = errors.New("an ingredient with that name is already in the catalog")
+)
+
+type CatalogRepository interface {
+	CreateCatalogIngredients(context.Context, ID, []*CatalogIngredient) ([]*ID, error)
+	CreateCategory(context.Context, ID, *Category) (ID, error)
+	MigrateAdminIngredients(context.Context) (int, error)
+	IndexCatalogNames(context.Context) (int, error)
+
+	FindCatalog(context.Context, *CatalogQuery) (*CatalogPage, error)
+	FindCatalogIngredient(context.Context, ID) (*CatalogIngredient, error)
+	FindAllCatalogIngredients(context.Context) ([]*Ingredient, error)
+	FindCategories(context.Context) ([]*Category, error)
+	FindCatalogChanges(context.Context, ID) ([]*CatalogChange, error)
+	FindDuplicateIngredients(context.Context) ([]*DuplicateGroup, error)
+	PreviewMerge(context.Context, *MergeReq) (*MergePreview, error)
+
+	UpdateCatalogIngredient(context.Context, ID, *CatalogIngredient) error
+	UpdateCategory(context.Context, ID, *Category) error
+	MergeIngredients(context.Context, ID, *MergeReq) (*MergePreview, []ID, error)
+
+	DeleteCatalogIngredient(context.Context, ID, ID) error
+	DeleteCategory(context.Context, ID, ID) error
+}
+
+type MongoCatalogRepository struct {
+	col        *mongo.Collection
+	categories *mongo.Collection
+	audit      *mongo.Collection
+	vendor     *mongo.Collection
+	user       *mongo.Collection
+	admin      *mongo.Collection
+}
+
+func newMongoCatalogRepository(client *mongo.Client, dbName string) CatalogRepository {
+	db := client.Database(dbName)
+	return &MongoCatalogRepository{
+		col:        db.Collection("catalog"),
+		categories: db.Collection("categories"),
+		audit:      db.Collection("catalog_audit"),
+		vendor:     db.Collection("vendor"),
+		user:       db.Collection("user"),
+		admin:      db.Collection("admin"),
+	}
+}
+
+func (c *CatalogIngredient) validate() error {
+	switch {
+	case normalizeName(c.Name) == "":
+		return errors.New("catalog ingredient needs a name")
+	case c.UnitQuantity < 0 || c.Price < 0 || c.Density < 0:
+		return fmt.Errorf("%q can not have a negative unit quantity, price or density", c.Name)
+	}
+	if _, ok := lookupUnit(c.Unit); !ok {
+		return fmt.Errorf("%q has an unknown unit %q", c.Name, c.Unit)
+	}
+	return nil
+}
+
+// stamp fills in who added the ingredient and when, with empty lists where none were given
+func (c *CatalogIngredient) stamp(admin ID, now time.Time) {
+	c.CreatedAt, c.UpdatedAt = now, now
+	c.CreatedBy, c.UpdatedBy = admin.value, admin.value
+	if c.CategoryPath == nil {
+		c.CategoryPath = []bson.ObjectID{}
+	}
+	for _, list := range []*[]string{&c.Aliases, &c.Attributes, &c.Allergens, &c.Images} {
+		if *list == nil {
+			*list = []string{}
+		}
+	}
+}
+
+func parseCatalogQuery(q url.Values) (*CatalogQuery, error) {
+	query := &CatalogQuery{
+		Search:       strings.TrimSpace(q.Get("q")),
+		Attributes:   q["attribute"],
+		AllergenFree: q["allergen_free"],
+		Page:         1,
+		PageSize:     defaultCatalogPageSize,
+	}
+
+	if category := q.Get("category"); category != "" {
+		id, err := bson.ObjectIDFromHex(category)
+		if err != nil {
+			return nil, errors.New("category must be a valid id")
+		}
+		query.CategoryID = id
+	}
+
+	if err := parsePaging(q, &query.Page, &query.PageSize, maxCatalogPageSize); err != nil {
+		return nil, err
+	}
+	return query, nil
+}
+
+// parsePaging reads the page and page_size parameters over the defaults already in page and pageSize
+func parsePaging(q url.Values, page, pageSize *int, maxPageSize int) error {
+	for key, value := range map[string]*int{"page": page, "page_size": pageSize} {
+		if s := q.Get(key); s != "" {
+			n, err := strconv.Atoi(s)
+			if err != nil || n < 1 {
+				return fmt.Errorf("%s must be a positive number", key)
+			}
+			*value = n
+		}
+	}
+	if *pageSize > maxPageSize {
+		return fmt.Errorf("page_size can not be more than %d", maxPageSize)
+	}
+	return nil
+}
+
+func (query *CatalogQuery) filter() bson.D {
+	filter := bson.D{}
+	if query.Search != "" {
+		pattern := bson.Regex{Pattern: regexp.QuoteMeta(query.Search), Options: "i"}
+		filter = append(filter, bson.E{Key: "$or", Value: bson.A{
+			bson.D{{Key: "name", Value: pattern}},
+			bson.D{{Key: "aliases", Value: pattern}},
+		}})
+	}
+	if query.CategoryID != bson.NilObjectID {
+		filter = append(filter, bson.E{Key: "category_path", Value: query.CategoryID})
+	}
+	if len(query.Attributes) > 0 {
+		filter = append(filter, bson.E{Key: "attributes", Value: bson.D{{Key: "$all", Value: query.Attributes}}})
+	}
+	if len(query.AllergenFree) > 0 {
+		filter = append(filter, bson.E{Key: "allergens", Value: bson.D{{Key: "$nin", Value: query.AllergenFree}}})
+	}
+	return filter
+}
+
+// categoryPath is the category_path of an ingredient filed under the category
+func (m MongoCatalogRepository) categoryPath(ctx context.Context, categoryID bson.ObjectID) ([]bson.ObjectID, error) {
+	if categoryID == bson.NilObjectID {
+		return []bson.ObjectID{}, nil
+	}
+	var category Category
+	if err := m.categories.FindOne(ctx, bson.D{{Key: "_id", Value: categoryID}}).Decode(&category); err != nil {
+		Logger.ErrorContext(ctx, "Category not found", slog.String("categoryID", categoryID.Hex()),
+			slog.Any("error", err), catalog_repo_source)
+		return nil, fmt.Errorf("category %s not found", categoryID.Hex())
+	}
+	return append(category.Path, category.ID), nil
+}
+
+// findCategoryPaths reads the category path of the catalog ingredients by their id, for the repositories that
+// match items against categories
+func findCategoryPaths(ctx context.Context, catalog *mongo.Collection, ids []bson.ObjectID, source slog.Attr) (map[bson.ObjectID][]bson.ObjectID, error) {
+	projection := bson.D{{Key: "ingredient_id", Value: 1}, {Key: "category_path", Value: 1}}
+	cursor, err := catalog.Find(ctx, bson.D{{Key: "ingredient_id", Value: bson.D{{Key: "$in", Value: ids}}}},
+		options.Find().SetProjection(projection))
+	if err != nil {
+		Logger.ErrorContext(ctx, "Error reading catalog categories", slog.Any("error", err), source)
+		return nil, err
+	}
+	var ingredients []*CatalogIngredient
+	if err := cursor.All(ctx, &ingredients); err != nil {
+		Logger.ErrorContext(ctx, "Error decoding catalog categories", slog.Any("error", err), source)
+		return nil, err
+	}
+	paths := make(map[bson.ObjectID][]bson.ObjectID, len(ingredients))
+	for _, ingredient := range ingredients {
+		paths[ingredient.IngredientID] = ingredient.CategoryPath
+	}
+	return paths, nil
+}
+
+func newCatalogChange(admin ID, action, target string, targetID bson.ObjectID, changes any, now time.Time) *CatalogChange {
+	return &CatalogChange{
+		AdminID:  admin.value,
+		Action:   action,
+		Target:   target,
+		TargetID: targetID,
+		Changes:  changes,
+		At:       now,
+	}
+}
+
+// record adds an entry to the catalog audit log, the catalog change has already been written so a failure is
+// only logged
+func (m MongoCatalogRepository) record(ctx context.Context, admin ID, action, target string, targetID bson.ObjectID, changes any) {
+	change := newCatalogChange(admin, action, target, targetID, changes, time.Now())
+	if _, err := m.audit.InsertOne(ctx, change); err != nil {
+		Logger.ErrorContext(ctx, "Error recording catalog change", slog.String("adminID", admin.String()),
+			slog.String("action", action), slog.String("targetID", targetID.Hex()), slog.Any("error", err),
+			catalog_repo_source)
+	}
+}
+
+func (m MongoCatalogRepository) CreateCatalogIngredients(ctx context.Context, admin ID, ingredients []*CatalogIngredient) ([]*ID, error) {
+	ctx, span := Tracer.Start(ctx, "CreateCatalogIngredients")
+	defer span.End()
+	Logger.InfoContext(ctx, "Adding ingredients to the catalog", slog.Int("count", len(ingredients)), catalog_repo_source)
+
+	existing, err := m.FindAllCatalogIngredients(ctx)
+	if err != nil {
+		return nil, err
+	}
+	matcher := newIngredientMatcher(existing)
+
+	now := time.Now()
+	docs := make([]any, len(ingredients))
+	ids := make([]*ID, len(ingredients))
+	for i, ingredient := range ingredients {
+		if err := ingredient.validate(); err != nil {
+			return nil, err
+		}
+		if err := matcher.nameTaken(&ingredient.Ingredient); err != nil {
+			return nil, err
+		}
+		if ingredient.CategoryPath, err = m.categoryPath(ctx, ingredient.CategoryID); err != nil {
+			return nil, err
+		}
+
+		ingredient.IngredientID, ingredient.NameKey = bson.NewObjectID(), normalizeName(ingredient.Name)
+		ingredient.stamp(admin, now)
+		docs[i], ids[i] = ingredient, &ID{ingredient.IngredientID}
+		matcher.add(&ingredient.Ingredient)
+	}
+
+	if _, err := m.col.InsertMany(ctx, docs); err != nil {
+		if mongo.IsDuplicateKeyError(err) {
+			return nil, errCatalogNameTaken
+		}
+		Logger.ErrorContext(ctx, "Error inserting catalog ingredients", slog.Any("error", err), catalog_repo_source)
+		return nil, err
+	}
+	for _, ingredient := range ingredients {
+		m.record(ctx, admin, "create", "ingredient", ingredient.IngredientID, ingredient)
+	}
+	Logger.InfoContext(ctx, "Catalog ingredients added", slog.Int("count", len(ids)), catalog_repo_source)
+	return ids, nil
+}
+
+func (m MongoCatalogRepository) CreateCategory(ctx context.Context, admin ID, category *Category) (ID, error) {
+	ctx, span := Tracer.Start(ctx, "CreateCategory")
+	defer span.End()
+	Logger.InfoContext(ctx, "Creating a catalog category", slog.String("name", category.Name), catalog_repo_source)
+
+	if strings.TrimSpace(category.Name) == "" {
+		return ID{}, errors.New("category needs a name")
+	}
+	path, err := m.categoryPath(ctx, category.ParentID)
+	if err != nil {
+		return ID{}, err
+	}
+
+	category.ID, category.Path = bson.NewObjectID(), path
+	category.CreatedAt = time.Now()
+	category.UpdatedAt = category.CreatedAt
+	category.CreatedBy, category.UpdatedBy = admin.value, admin.value
+	if _, err := m.categories.InsertOne(ctx, category); err != nil {
+		Logger.ErrorContext(ctx, "Error inserting category", slog.Any("error", err), catalog_repo_source)
+		return ID{}, err
+	}
+	m.record(ctx, admin, "create", "category", category.ID, category)
+	return ID{category.ID}, nil
+}
+
+func (m MongoCatalogRepository) FindCatalog(ctx context.Context, query *CatalogQuery) (*CatalogPage, error) {
+	ctx, span := Tracer.Start(ctx, "FindCatalog")
+	defer span.End()
+	Logger.InfoContext(ctx, "Searching the catalog", slog.Any("query", query), catalog_repo_source)
+
+	filter := query.filter()
+	total, err := m.col.CountDocuments(ctx, filter)
+	if err != nil {
+		Logger.ErrorContext(ctx, "Error counting catalog ingredients", slog.Any("error", err), catalog_repo_source)
+		return nil, err
+	}
+
+	opts := options.Find().
+		SetSort(bson.D{{Key: "name", Value: 1}}).
+		SetSkip(int64((query.Page - 1) * query.PageSize)).
+		SetLimit(int64(query.PageSize))
+	cursor, err := m.col.Find(ctx, filter, opts)
+	if err != nil {
+		Logger.ErrorContext(ctx, "Error searching the catalog", slog.Any("error", err), catalog_repo_source)
+		return nil, err
+	}
+
+	page := &CatalogPage{Ingredients: []*CatalogIngredient{}, Total: total, Page: query.Page, PageSize: query.PageSize}
+	if err := cursor.All(ctx, &page.Ingredients); err != nil {
+		Logger.ErrorContext(ctx, "Error decoding catalog ingredients", slog.Any("error", err), catalog_repo_source)
+		return nil, err
+	}
+	return page, nil
+}
+
+func (m MongoCatalogRepository) FindCatalogIngredient(ctx context.Context, id ID) (*CatalogIngredient, error) {
+	ctx, span := Tracer.Start(ctx, "FindCatalogIngredient")
+	defer span.End()
+
+	var ingredient CatalogIngredient
+	if err := m.col.FindOne(ctx, bson.D{{Key: "ingredient_id", Value: id.value}}).Decode(&ingredient); err != nil {
+		if errors.Is(err, mongo.ErrNoDocuments) {
+			Logger.ErrorContext(ctx, "Catalog ingredient not found", slog.String("ID", id.String()), catalog_repo_source)
+			return nil, &NoItems{}
+		}
+		Logger.ErrorContext(ctx, "Error finding catalog ingredient", slog.Any("error", err), catalog_repo_source)
+		return nil, err
+	}
+	return &ingredient, nil
+}
+
+func (m MongoCatalogRepository) FindAllCatalogIngredients(ctx context.Context) ([]*Ingredient, error) {
+	ctx, span := Tracer.Start(ctx, "FindAllCatalogIngredients")
+	defer span.End()
+
+	cursor, err := m.col.Find(ctx, bson.D{})
+	if err != nil {
+		Logger.ErrorContext(ctx, "Error reading the catalog", slog.Any("error", err), catalog_repo_source)
+		return nil, err
+	}
+	ingredients := []*Ingredient{}
+	if err := cursor.All(ctx, &ingredients); err != nil {
+		Logger.ErrorContext(ctx, "Error decoding the catalog", slog.Any("error", err), catalog_repo_source)
+		return nil, err
+	}
+	return ingredients, nil
+}
+
+func (m MongoCatalogRepository) FindCategories(ctx context.Context) ([]*Category, error) {
+	ctx, span := Tracer.Start(ctx, "FindCategories")
+	defer span.End()
+
+	cursor, err := m.categories.Find(ctx, bson.D{}, options.Find().SetSort(bson.D{{Key: "name", Value: 1}}))
+	if err != nil {
+		Logger.ErrorContext(ctx, "Error reading categories", slog.Any("error", err), catalog_repo_source)
+		return nil, err
+	}
+	categories := []*Category{}
+	if err := cursor.All(ctx, &categories); err != nil {
+		Logger.ErrorContext(ctx, "Error decoding categories", slog.Any("error", err), catalog_repo_source)
+		return nil, err
+	}
+	return categories, nil
+}
+
+// FindCatalogChanges is the audit log of one ingredient or category, newest first, a nil target returns the
+// latest changes to the whole catalog
+func (m MongoCatalogRepository) FindCatalogChanges(ctx context.Context, target ID) ([]*CatalogChange, error) {
+	ctx, span := Tracer.Start(ctx, "FindCatalogChanges")
+	defer span.End()
+
+	filter := bson.D{}
+	if target.value != bson.NilObjectID {
+		filter = append(filter, bson.E{Key: "target_id", Value: target.value})
+	}
+	opts := options.Find().SetSort(bson.D{{Key: "at", Value: -1}}).SetLimit(maxCatalogPageSize)
+	cursor, err := m.audit.Find(ctx, filter, opts)
+	if err != nil {
+		Logger.ErrorContext(ctx, "Error reading the catalog audit log", slog.Any("error", err), catalog_repo_source)
+		return nil, err
+	}
+	changes := []*CatalogChange{}
+	if err := cursor.All(ctx, &changes); err != nil {
+		Logger.ErrorContext(ctx, "Error decoding the catalog audit log", slog.Any("error", err), catalog_repo_source)
+		return nil, err
+	}
+	return changes, nil
+}
+
+// nameTaken rejects a name or alias of the ingredient that another catalog entry already goes by
+func (m *ingredientMatcher) nameTaken(ingredient *Ingredient) error {
+	for _, name := range append([]string{ingredient.Name}, ingredient.Aliases...) {
+		if normalizeName(name) == "" {
+			continue
+		}
+		if entry := m.resolve(name); entry != nil && entry.IngredientID != ingredient.IngredientID {
+			return fmt.Errorf("%q is already in the catalog as %q", name, entry.Name)
+		}
+	}
+	return nil
+}
+
+// migrationEntries are the catalog entries for the ingredients an admin kept, they keep their id so recipes
+// and carts pointing at them still resolve. Ones already in the catalog or earlier in the list are skipped,
+// ingredients without an id get a new one. Legacy ingredients with a name the catalog already knows are added
+// all the same without a name key and show up as duplicates to merge
+func (m *ingredientMatcher) migrationEntries(admin ID, ingredients []*Ingredient, now time.Time) []*CatalogIngredient {
+	var entries []*CatalogIngredient
+	for _, ingredient := range ingredients {
+		if m.byID[ingredient.IngredientID] != nil {
+			continue
+		}
+		entry := &CatalogIngredient{Ingredient: *ingredient}
+		if entry.IngredientID.IsZero() {
+			entry.IngredientID = bson.NewObjectID()
+		}
+		if m.resolve(entry.Name) == nil {
+			entry.NameKey = normalizeName(entry.Name)
+		}
+		entry.stamp(admin, now)
+		entries = append(entries, entry)
+		m.add(&entry.Ingredient)
+	}
+	return entries
+}
+
+// MigrateAdminIngredients moves the ingredients admins kept in their own document into the shared catalog. Each
+// admin is moved in a transaction, the entries, their audit records and the removal from the admin document
+// land together so a failed run is simply retried on the next start. Only the ingredients that were read are
+// pulled from the admin, anything added meanwhile is left for the next run
+func (m MongoCatalogRepository) MigrateAdminIngredients(ctx context.Context) (int, error) {
+	ctx, span := Tracer.Start(ctx, "MigrateAdminIngredients")
+	defer span.End()
+
+	cursor, err := m.admin.Find(ctx, bson.D{{Key: "ingredients.0", Value: bson.D{{Key: "$exists", Value: true}}}})
+	if err != nil {
+		Logger.ErrorContext(ctx, "Error finding admin ingredients", slog.Any("error", err), catalog_repo_source)
+		return 0, err
+	}
+	var admins []*Admin
+	if err := cursor.All(ctx, &admins); err != nil {
+		Logger.ErrorContext(ctx, "Error decoding admins", slog.Any("error", err), catalog_repo_source)
+		return 0, err
+	}
+	if len(admins) == 0 {
+		return 0, nil
+	}
+
+	existing, err := m.FindAllCatalogIngredients(ctx)
+	if err != nil {
+		return 0, err
+	}
+	matcher := newIngredientMatcher(existing)
+
+	session, err := MongoClient.StartSession(sesOp)
+	if err != nil {
+		Logger.ErrorContext(ctx, "Unable to create a session", slog.Any("error", err), catalog_repo_source)
+		return 0, err
+	}
+	defer session.EndSession(ctx)
+
+	migrated := 0
+	now := time.Now()
+	for _, admin := range admins {
+		adminID := ID{admin.ID}
+		read := make([]bson.ObjectID, len(admin.Ingredients))
+		for i, ingredient := range admin.Ingredients {
+			read[i] = ingredient.IngredientID
+		}
+		entries := matcher.migrationEntries(adminID, admin.Ingredients, now)
+		var docs, changes []any
+		for _, entry := range entries {
+			docs = append(docs, entry)
+			changes = append(changes, newCatalogChange(adminID, "migrate", "ingredient", entry.IngredientID, entry, now))
+		}
+
+		_, err := session.WithTransaction(ctx, func(sessCtx context.Context) (any, error) {
+			if len(docs) > 0 {
+				if _, err := m.col.InsertMany(sessCtx, docs); err != nil {
+					return nil, err
+				}
+				if _, err := m.audit.InsertMany(sessCtx, changes); err != nil {
+					return nil, err
+				}
+			}
+			pull := bson.D{{Key: "$pull", Value: bson.M{"ingredients": bson.M{"ingredient_id": bson.M{"$in": read}}}}}
+			_, err := m.admin.UpdateOne(sessCtx, bson.D{{Key: "_id", Value: admin.ID}}, pull)
+			return nil, err
+		})
+		if err != nil {
+			Logger.ErrorContext(ctx, "Error migrating admin ingredients", slog.String("adminID", adminID.String()),
+				slog.Any("error", err), catalog_repo_source)
+			return migrated, err
+		}
+		migrated += len(docs)
+	}
+
+	Logger.InfoContext(ctx, "Admin ingredients migrated to the catalog", slog.Int("admins", len(admins)),
+		slog.Int("ingredients", migrated), catalog_repo_source)
+	return migrated, nil
+}
+
+// IndexCatalogNames writes the name key of the entries saved before names were unique, the oldest entry of
+// a name gets it and later ones stay duplicates to merge
+func (m MongoCatalogRepository) IndexCatalogNames(ctx context.Context) (int, error) {
+	ctx, span := Tracer.Start(ctx, "IndexCatalogNames")
+	defer span.End()
+
+	projection := bson.D{{Key: "ingredient_id", Value: 1}, {Key: "name", Value: 1}, {Key: "name_key", Value: 1}}
+	opts := options.Find().SetProjection(projection).SetSort(bson.D{{Key: "created_at", Value: 1}, {Key: "_id", Value: 1}})
+	entries, err := m.findCatalogEntries(ctx, bson.D{}, opts)
+	if err != nil {
+		return 0, err
+	}
+	taken := make(map[string]bool, len(entries))
+	for _, entry := range entries {
+		if entry.NameKey != "" {
+			taken[entry.NameKey] = true
+		}
+	}
+	var models []mongo.WriteModel
+	for _, entry := range entries {
+		key := normalizeName(entry.Name)
+		if entry.NameKey != "" || key == "" || taken[key] {
+			continue
+		}
+		taken[key] = true
+		models = append(models, mongo.NewUpdateOneModel().
+			SetFilter(bson.D{{Key: "ingredient_id", Value: entry.IngredientID}}).
+			SetUpdate(bson.D{{Key: "$set", Value: bson.M{"name_key": key}}}))
+	}
+	if len(models) == 0 {
+		return 0, nil
+	}
+	if _, err := m.col.BulkWrite(ctx, models, options.BulkWrite().SetOrdered(false)); err != nil {
+		Logger.ErrorContext(ctx, "Error indexing catalog names", slog.Any("error", err), catalog_repo_source)
+		return 0, err
+	}
+	Logger.InfoContext(ctx, "Catalog names indexed", slog.Int("ingredients", len(models)), catalog_repo_source)
+	return len(models), nil
+}
+
+func (m MongoCatalogRepository) UpdateCatalogIngredient(ctx context.Context, admin ID, ingredient *CatalogIngredient) error {
+	ctx, span := Tracer.Start(ctx, "UpdateCatalogIngredient")
+	defer span.End()
+	Logger.InfoContext(ctx, "Updating catalog ingredient", slog.String("ID", ingredient.IngredientID.Hex()), catalog_repo_source)
+
+	set := bson.M{"updated_at": time.Now(), "updated_by": admin.value}
+	if ingredient.Name != "" || len(ingredient.Aliases) > 0 {
+		existing, err := m.FindAllCatalogIngredients(ctx)
+		if err != nil {
+			return err
+		}
+		if err := newIngredientMatcher(existing).nameTaken(&ingredient.Ingredient); err != nil {
+			return err
+		}
+	}
+	if ingredient.Name != "" {
+		set["name"], set["name_key"] = ingredient.Name, normalizeName(ingredient.Name)
+	}
+	if ingredient.Unit != "" {
+		if _, ok := lookupUnit(ingredient.Unit); !ok {
+			return fmt.Errorf("unknown unit %q", ingredient.Unit)
+		}
+		set["unit"] = ingredient.Unit
+	}
+	if ingredient.UnitQuantity > 0 {
+		set["unit_quantity"] = ingredient.UnitQuantity
+	}
+	if ingredient.Price > 0 {
+		set["price"] = ingredient.Price
+	}
+	if ingredient.Density > 0 {
+		set["density"] = ingredient.Density
+	}
+	if ingredient.CategoryID != bson.NilObjectID {
+		path, err := m.categoryPath(ctx, ingredient.CategoryID)
+		if err != nil {
+			return err
+		}
+		set["category_id"], set["category_path"] = ingredient.CategoryID, path
+	}
+	for key, list := range map[string][]string{
+		"aliases": ingredient.Aliases, "attributes": ingredient.Attributes,
+		"allergens": ingredient.Allergens, "images": ingredient.Images,
+	} {
+		if list != nil {
+			set[key] = list
+		}
+	}
+
+	result, err := m.col.UpdateOne(ctx, bson.D{{Key: "ingredient_id", Value: ingredient.IngredientID}},
+		bson.D{{Key: "$set", Value: set}})
+	if err != nil {
+		if mongo.IsDuplicateKeyError(err) {
+			return errCatalogNameTaken
+		}
+		Logger.ErrorContext(ctx, "Error updating catalog ingredient", slog.Any("error", err), catalog_repo_source)
+		return err
+	}
+	if result.MatchedCount == 0 {
+		return fmt.Errorf("catalog ingredient %s not found", ingredient.IngredientID.Hex())
+	}
+	m.record(ctx, admin, "update", "ingredient", ingredient.IngredientID, set)
+	return nil
+}
+
+// UpdateCategory renames and moves a category, moving rewrites the path of every category and ingredient in
+// its subtree
+func (m MongoCatalogRepository) UpdateCategory(ctx context.Context, admin ID, category *Category) error {
+	ctx, span := Tracer.Start(ctx, "UpdateCategory")
+	defer span.End()
+	Logger.InfoContext(ctx, "Updating category", slog.String("ID", category.ID.Hex()), catalog_repo_source)
+
+	var current Category
+	if err := m.categories.FindOne(ctx, bson.D{{Key: "_id", Value: category.ID}}).Decode(&current); err != nil {
+		Logger.ErrorContext(ctx, "Category not found", slog.Any("error", err), catalog_repo_source)
+		return fmt.Errorf("category %s not found", category.ID.Hex())
+	}
+
+	set := bson.M{"updated_at": time.Now(), "updated_by": admin.value}
+	if strings.TrimSpace(category.Name) != "" {
+		set["name"] = category.Name
+	}
+
+	var models []mongo.WriteModel
+	var ingredientModels []mongo.WriteModel
+	if category.ParentID != bson.NilObjectID && category.ParentID != current.ParentID {
+		path, err := m.categoryPath(ctx, category.ParentID)
+		if err != nil {
+			return err
+		}
+		if slices.Contains(path, category.ID) {
+			return errors.New("a category can not be moved under itself")
+		}
+		set["parent_id"], set["path"] = category.ParentID, path
+
+		// everything below keeps the part of its path under the moved category
+		prefix := slices.Concat(path, []bson.ObjectID{category.ID})
+		rebase := func(old []bson.ObjectID) []bson.ObjectID {
+			i := slices.Index(old, category.ID)
+			return slices.Concat(prefix, old[i+1:])
+		}
+
+		var below []*Category
+		cursor, err := m.categories.Find(ctx, bson.D{{Key: "path", Value: category.ID}})
+		if err == nil {
+			err = cursor.All(ctx, &below)
+		}
+		if err != nil {
+			Logger.ErrorContext(ctx, "Error reading sub categories", slog.Any("error", err), catalog_repo_source)
+			return err
+		}
+		for _, sub := range below {
+			models = append(models, mongo.NewUpdateOneModel().SetFilter(bson.D{{Key: "_id", Value: sub.ID}}).
+				SetUpdate(bson.D{{Key: "$set", Value: bson.M{"path": rebase(sub.Path)}}}))
+		}
+
+		var ingredients []*CatalogIngredient
+		cursor, err = m.col.Find(ctx, bson.D{{Key: "category_path", Value: category.ID}})
+		if err == nil {
+			err = cursor.All(ctx, &ingredients)
+		}
+		if err != nil {
+			Logger.ErrorContext(ctx, "Error reading category ingredients", slog.Any("error", err), catalog_repo_source)
+			return err
+		}
+		for _, ingredient := range ingredients {
+			ingredientModels = append(ingredientModels, mongo.NewUpdateOneModel().
+				SetFilter(bson.D{{Key: "ingredient_id", Value: ingredient.IngredientID}}).
+				SetUpdate(bson.D{{Key: "$set", Value: bson.M{"category_path": rebase(ingredient.CategoryPath)}}}))
+		}
+	}
+
+	models = append(models, mongo.NewUpdateOneModel().SetFilter(bson.D{{Key: "_id", Value: category.ID}}).
+		SetUpdate(bson.D{{Key: "$set", Value: set}}))
+
+	session, err := MongoClient.StartSession(sesOp)
+	if err != nil {
+		Logger.ErrorContext(ctx, "Unable to create a session", slog.Any("error", err), catalog_repo_source)
+		return err
+	}
+	defer session.EndSession(ctx)
+
+	// the categories and their ingredients move together so no ingredient is left on a half rewritten path
+	_, err = session.WithTransaction(ctx, func(sessCtx context.Context) (any, error) {
+		if _, err := m.categories.BulkWrite(sessCtx, models); err != nil {
+			Logger.ErrorContext(sessCtx, "Error updating categories", slog.Any("error", err), catalog_repo_source)
+			return nil, err
+		}
+		if len(ingredientModels) > 0 {
+			if _, err := m.col.BulkWrite(sessCtx, ingredientModels); err != nil {
+				Logger.ErrorContext(sessCtx, "Error moving category ingredients", slog.Any("error", err), catalog_repo_source)
+				return nil, err
+			}
+		}
+		return nil, nil
+	})
+	if err != nil {
+		return err
+	}
+	m.record(ctx, admin, "update", "category", category.ID, set)
+	return nil
+}
+
+// DeleteCatalogIngredient refuses to remove an ingredient that store items still sell
+func (m MongoCatalogRepository) DeleteCatalogIngredient(ctx context.Context, admin, id ID) error {
+	ctx, span := Tracer.Start(ctx, "DeleteCatalogIngredient")
+	defer span.End()
+	Logger.InfoContext(ctx, "Deleting catalog ingredient", slog.String("ID", id.String()), catalog_repo_source)
+
+	inUse, err := m.vendor.CountDocuments(ctx, bson.D{{Key: "stores.items.catalog_id", Value: id.value}})
+	if err != nil {
+		Logger.ErrorContext(ctx, "Error checking store items", slog.Any("error", err), catalog_repo_source)
+		return err
+	}
+	if inUse > 0 {
+		return fmt.Errorf("catalog ingredient %s is sold by %d vendors", id.String(), inUse)
+	}
+
+	result, err := m.col.DeleteOne(ctx, bson.D{{Key: "ingredient_id", Value: id.value}})
+	if err != nil {
+		Logger.ErrorContext(ctx, "Error deleting catalog ingredient", slog.Any("error", err), catalog_repo_source)
+		return err
+	}
+	if result.DeletedCount == 0 {
+		return fmt.Errorf("catalog ingredient %s not found", id.String())
+	}
+	m.record(ctx, admin, "delete", "ingredient", id.value, nil)
+	return nil
+}
+
+// DeleteCategory only removes empty categories, without sub categories or ingredients
+func (m MongoCatalogRepository) DeleteCategory(ctx context.Context, admin, id ID) error {
+	ctx, span := Tracer.Start(ctx, "DeleteCategory")
+	defer span.End()
+	Logger.InfoContext(ctx, "Deleting category", slog.String("ID", id.String()), catalog_repo_source)
+
+	for col, key := range map[*mongo.Collection]string{m.categories: "parent_id", m.col: "category_id"} {
+		count, err := col.CountDocuments(ctx, bson.D{{Key: key, Value: id.value}})
+		if err != nil {
+			Logger.ErrorContext(ctx, "Error checking category contents", slog.Any("error", err), catalog_repo_source)
+			return err
+		}
+		if count > 0 {
+			return fmt.Errorf("category %s is not empty", id.String())
+		}
+	}
+
+	result, err := m.categories.DeleteOne(ctx, bson.D{{Key: "_id", Value: id.value}})
+	if err != nil {
+		Logger.ErrorContext(ctx, "Error deleting category", slog.Any("error", err), catalog_repo_source)
+		return err
+	}
+	if result.DeletedCount == 0 {
+		return fmt.Errorf("category %s not found", id.String())
+	}
+	m.record(ctx, admin, "delete", "category", id.value, nil)
+	return nil
+}
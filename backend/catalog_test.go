@@ -0,0 +1,155 @@
+package main
+
+import (
+	"net/url"
+	"testing"
+	"time"
+
+	"go.mongodb.org/mongo-driver/v2/bson"
+)
+
+func TestLinkStoreItems(t *testing.T) {
+	milk := &Ingredient{IngredientID: bson.NewObjectID(), Name: "Milk", Unit: "l", Aliases: []string{"Whole milk"}}
+	flour := &Ingredient{IngredientID: bson.NewObjectID(), Name: "Flour", Unit: "kg"}
+	matcher := newIngredientMatcher([]*Ingredient{milk, flour})
+
+	tests := []struct {
+		item    *Item
+		update  bool
+		catalog bson.ObjectID
+		ok      bool
+	}{
+		{&Item{Ingredient: Ingredient{Name: "Whole Milk", Unit: "ml"}}, false, milk.IngredientID, true},
+		{&Item{Ingredient: Ingredient{Unit: "g"}, CatalogID: flour.IngredientID}, false, flour.IngredientID, true},
+		{&Item{Ingredient: Ingredient{Name: "Milk", Unit: "ml"}, CatalogID: flour.IngredientID}, false, flour.IngredientID, false},
+		{&Item{Ingredient: Ingredient{Name: "Caviar", Unit: "g"}}, false, bson.NilObjectID, false},
+		{&Item{Ingredient: Ingredient{Name: "Milk", Unit: "kg"}}, false, bson.NilObjectID, false},
+		{&Item{Ingredient: Ingredient{Name: "Flour"}}, false, bson.NilObjectID, false},
+		{&Item{Ingredient: Ingredient{IngredientID: bson.NewObjectID(), Price: 3}}, true, bson.NilObjectID, true},
+		{&Item{Ingredient: Ingredient{IngredientID: bson.NewObjectID(), Unit: "g"}}, true, bson.NilObjectID, false},
+	}
+
+	for i, test := range tests {
+		err := matcher.linkStoreItems([]*Store{{Items: []*Item{test.item}}}, test.update)
+		if (err == nil) != test.ok {
+			t.Fatalf("case %d -> expected ok %v got %v", i, test.ok, err)
+		}
+		if err == nil && test.item.CatalogID != test.catalog {
+			t.Fatalf("case %d -> expected catalog id %s got %s", i, test.catalog.Hex(), test.item.CatalogID.Hex())
+		}
+	}
+}
+
+func TestBestMatchJoinsOnCatalog(t *testing.T) {
+	tomato := &Ingredient{IngredientID: bson.NewObjectID(), Name: "Tomato", Unit: "kg"}
+	matcher := newIngredientMatcher([]*Ingredient{tomato})
+
+	linked := newTestItem("Vine ripened", 1, "kg", 4)
+	linked.CatalogID = tomato.IngredientID
+	other := newTestItem("Tomato", 1, "kg", 1)
+	other.CatalogID = bson.NewObjectID()
+	items := []*Item{other, newTestItem("Tomatoes", 1, "kg", 3), linked}
+
+	match, _ := matcher.bestMatch(items, &ReqIng{Name: "tomatoes", UnitQuantity: 1, Unit: "kg"})
+	if match == nil || match.CatalogID != tomato.IngredientID || match.MatchType != matchCatalog {
+		t.Fatalf("expected the catalog linked item got %+v", match)
+	}
+}
+
+func TestParseCatalogQuery(t *testing.T) {
+	category := bson.NewObjectID()
+	tests := []struct {
+		query    string
+		page     int
+		pageSize int
+		filters  int
+		ok       bool
+	}{
+		{"", 1, defaultCatalogPageSize, 0, true},
+		{"q=tomato&page=2&page_size=10", 2, 10, 1, true},
+		{"category=" + category.Hex() + "&attribute=organic&attribute=vegan&allergen_free=nuts", 1, defaultCatalogPageSize, 3, true},
+		{"category=produce", 0, 0, 0, false},
+		{"page=0", 0, 0, 0, false},
+		{"page_size=1000", 0, 0, 0, false},
+	}
+
+	for _, test := range tests {
+		q, _ := url.ParseQuery(test.query)
+		query, err := parseCatalogQuery(q)
+		if (err == nil) != test.ok {
+			t.Fatalf("%q -> expected ok %v got %v", test.query, test.ok, err)
+		}
+		if err != nil {
+			continue
+		}
+		if query.Page != test.page || query.PageSize != test.pageSize || len(query.filter()) != test.filters {
+			t.Fatalf("%q -> expected page %d size %d with %d filters got %+v", test.query, test.page, test.pageSize,
+				test.filters, query)
+		}
+	}
+}
+
+func TestNameTaken(t *testing.T) {
+	milk := &Ingredient{IngredientID: bson.NewObjectID(), Name: "Milk", Unit: "l", Aliases: []string{"Whole milk"}}
+	matcher := newIngredientMatcher([]*Ingredient{milk})
+
+	tests := []struct {
+		ingredient *Ingredient
+		ok         bool
+	}{
+		{&Ingredient{Name: "Flour", Aliases: []string{"Plain flour"}}, true},
+		{&Ingredient{Name: "milks"}, false},
+		{&Ingredient{Name: "Flour", Aliases: []string{"whole milk"}}, false},
+		{&Ingredient{Name: "Flour", Aliases: []string{"Milk"}}, false},
+		{&Ingredient{IngredientID: milk.IngredientID, Name: "Milk", Aliases: []string{"Whole Milk", "Full fat milk"}}, true},
+		{&Ingredient{IngredientID: bson.NewObjectID(), Aliases: []string{"whole milk"}}, false},
+	}
+	for i, test := range tests {
+		if err := matcher.nameTaken(test.ingredient); (err == nil) != test.ok {
+			t.Fatalf("case %d -> expected ok %v got %v", i, test.ok, err)
+		}
+	}
+}
+
+func TestMigrationEntries(t *testing.T) {
+	milk := &Ingredient{IngredientID: bson.NewObjectID(), Name: "Milk", Unit: "l"}
+	matcher := newIngredientMatcher([]*Ingredient{milk})
+	admin := ID{bson.NewObjectID()}
+	now := time.Now()
+
+	flour := &Ingredient{IngredientID: bson.NewObjectID(), Name: "Flour", Unit: "kg"}
+	legacy := &Ingredient{Name: "Sugar", Unit: "kg"}
+	entries := matcher.migrationEntries(admin, []*Ingredient{
+		{IngredientID: milk.IngredientID, Name: "Milk", Unit: "l"}, flour, legacy, flour,
+		{IngredientID: bson.NewObjectID(), Name: "milk", Unit: "l"},
+	}, now)
+	if len(entries) != 3 {
+		t.Fatalf("expected the catalog and repeated ingredients to be skipped got %d entries", len(entries))
+	}
+	if entries[0].IngredientID != flour.IngredientID || entries[0].CreatedBy != admin.value || !entries[0].CreatedAt.Equal(now) {
+		t.Fatalf("expected flour to keep its id and be stamped got %+v", entries[0])
+	}
+	if entries[1].IngredientID.IsZero() || !legacy.IngredientID.IsZero() {
+		t.Fatalf("expected a new id on the entry only got %s and %s", entries[1].IngredientID.Hex(), legacy.IngredientID.Hex())
+	}
+	if entries[2].Name != "milk" || entries[2].IngredientID == milk.IngredientID || entries[2].NameKey != "" {
+		t.Fatal("expected a legacy duplicate name to be added for merging without a name key")
+	}
+	if entries[0].NameKey != "flour" {
+		t.Fatalf("expected a new name to get its key got %q", entries[0].NameKey)
+	}
+	if again := matcher.migrationEntries(admin, []*Ingredient{flour}, now); len(again) != 0 {
+		t.Fatalf("expected a second run to skip what was migrated got %d entries", len(again))
+	}
+}
+
+func TestNewCatalogChange(t *testing.T) {
+	admin, target := ID{bson.NewObjectID()}, bson.NewObjectID()
+	now := time.Now()
+	entry := &CatalogIngredient{Ingredient: Ingredient{IngredientID: target, Name: "Flour"}}
+	change := newCatalogChange(admin, "migrate", "ingredient", target, entry, now)
+	if change.AdminID != admin.value || change.Action != "migrate" || change.Target != "ingredient" ||
+		change.TargetID != target || change.Changes != entry || !change.At.Equal(now) || !change.ID.IsZero() {
+		t.Fatalf("expected the change to record who migrated what got %+v", change)
+	}
+}
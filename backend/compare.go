@@ -0,0 +1,521 @@
+package main
+
+import (
+	"cmp"
+	"context"
+	"errors"
+	"log/slog"
+	"math"
+	"regexp"
+	"slices"
+	"strings"
+
+	"go.mongodb.org/mongo-driver/v2/bson"
+	"go.mongodb.org/mongo-driver/v2/mongo"
+	"go.mongodb.org/mongo-driver/v2/mongo/options"
+)
+
+const (
+	sortByCoverage = "coverage"
+	sortByPrice    = "price"
+	sortByDistance = "distance"
+)
+
+func (req *ReqIngArray) validate() error {
+	if len(req.Compare) == 0 {
+		return errors.New("no ingredients to compare")
+	}
+	for _, reqIng := range req.Compare {
+		if normalizeName(reqIng.Name) == "" {
+			return errors.New("every ingredient to compare needs a name")
+		}
+	}
+
+	switch req.SortBy {
+	case "":
+		req.SortBy = sortByCoverage
+	case sortByCoverage, sortByPrice, sortByDistance:
+	default:
+		return errors.New("sort_by must be one of coverage, price or distance")
+	}
+
+	if req.Location == nil {
+		if req.Radius != 0 {
+			return errors.New("radius needs a location")
+		}
+		if req.SortBy == sortByDistance {
+			return errors.New("sorting by distance needs a location")
+		}
+		return nil
+	}
+
+	if err := req.Location.validPoint(); err != nil {
+		return err
+	}
+	radius, err := validRadius(req.Radius)
+	if err != nil {
+		return err
+	}
+	req.Radius = radius
+	return nil
+}
+
+// ingredientMatcher decides which store items satisfy a requested ingredient, everything it knows about the
+// admin catalog is keyed by normalized name, aliases resolve to the name of their catalog ingredient and the
+// catalog entries carry the densities that let mass and volume packs be compared
+type ingredientMatcher struct {
+	aliases  map[string]string
+	synonyms map[string][]string
+	catalog  map[string]*Ingredient
+	byID     map[bson.ObjectID]*Ingredient
+}
+
+func newIngredientMatcher(catalog []*Ingredient) *ingredientMatcher {
+	m := &ingredientMatcher{
+		aliases:  make(map[string]string),
+		synonyms: make(map[string][]string),
+		catalog:  make(map[string]*Ingredient),
+		byID:     make(map[bson.ObjectID]*Ingredient),
+	}
+	for _, ingredient := range catalog {
+		m.add(ingredient)
+	}
+	return m
+}
+
+func (m *ingredientMatcher) add(ingredient *Ingredient) {
+	name := normalizeName(ingredient.Name)
+	for _, alias := range ingredient.Aliases {
+		m.aliases[normalizeName(alias)] = name
+		m.synonyms[name] = append(m.synonyms[name], normalizeName(alias))
+	}
+	m.catalog[name] = ingredient
+	m.byID[ingredient.IngredientID] = ingredient
+}
+
+func (m *ingredientMatcher) canonical(name string) string {
+	if resolved, ok := m.aliases[name]; ok {
+		return resolved
+	}
+	return name
+}
+
+// resolve finds the catalog ingredient sold under the name, fuzzy matches are never used to pick one
+func (m *ingredientMatcher) resolve(name string) *Ingredient {
+	return m.catalog[m.canonical(normalizeName(name))]
+}
+
+// resolveRequest is the catalog ingredient the request asks for, by catalog id when it names one
+func (m *ingredientMatcher) resolveRequest(reqIng *ReqIng) *Ingredient {
+	if reqIng.CatalogID != bson.NilObjectID {
+		return m.byID[reqIng.CatalogID]
+	}
+	return m.resolve(reqIng.Name)
+}
+
+// catalogIDs are the catalog ingredients the requests resolve to
+func (m *ingredientMatcher) catalogIDs(reqIngs []*ReqIng) []bson.ObjectID {
+	ids := []bson.ObjectID{}
+	for _, reqIng := range reqIngs {
+		if ingredient := m.resolveRequest(reqIng); ingredient != nil && !slices.Contains(ids, ingredient.IngredientID) {
+			ids = append(ids, ingredient.IngredientID)
+		}
+	}
+	return ids
+}
+
+// nameStems are the word stems of the requested names and aliases, an item is read when a key starts with one
+func (m *ingredientMatcher) nameStems(reqIngs []*ReqIng) []string {
+	var stems []string
+	for _, reqIng := range reqIngs {
+		name := m.canonical(normalizeName(reqIng.Name))
+		if ingredient := m.resolveRequest(reqIng); ingredient != nil {
+			name = normalizeName(ingredient.Name)
+		}
+		for _, n := range append([]string{name}, m.synonyms[name]...) {
+			for _, word := range strings.Fields(n) {
+				if stem := wordStem(word); !slices.Contains(stems, stem) {
+					stems = append(stems, stem)
+				}
+			}
+		}
+	}
+	return stems
+}
+
+// stemPattern is the regex of a name key that starts with one of the stems
+func stemPattern(stems []string) string {
+	quoted := make([]string, len(stems))
+	for i, stem := range stems {
+		quoted[i] = regexp.QuoteMeta(stem)
+	}
+	return "^(" + strings.Join(quoted, "|") + ")"
+}
+
+// matchItem joins on the catalog when both the item and the request are linked to it and falls back to the
+// item name otherwise
+func (m *ingredientMatcher) matchItem(item *Item, reqIng *ReqIng, entry *Ingredient) (string, float64, bool) {
+	if entry != nil && item.CatalogID != bson.NilObjectID {
+		if item.CatalogID != entry.IngredientID {
+			return "", 0, false
+		}
+		return matchCatalog, matchConfidence[matchCatalog], true
+	}
+	return m.matchName(item.Name, reqIng.Name)
+}
+
+// matchName reports how the item name matches the requested one, ok is false when they are different
+// ingredients
+func (m *ingredientMatcher) matchName(itemName, reqName string) (matchType string, confidence float64, ok bool) {
+	if itemName == reqName {
+		return matchExact, matchConfidence[matchExact], true
+	}
+
+	a, b := normalizeName(itemName), normalizeName(reqName)
+	if a == b {
+		return matchNormalized, matchConfidence[matchNormalized], true
+	}
+
+	a, b = m.canonical(a), m.canonical(b)
+	if a == b {
+		return matchSynonym, matchConfidence[matchSynonym], true
+	}
+
+	ingredient := func(word string) bool { return m.catalog[m.canonical(word)] != nil }
+	if score := similarity(a, b, ingredient); score >= fuzzyThreshold {
+		return matchFuzzy, math.Round(score*fuzzyWeight*100) / 100, true
+	}
+	return "", 0, false
+}
+
+// sizes returns the requested and the item pack size in the base unit of the request, ok is false when the
+// item unit can not be converted to the requested one
+func (m *ingredientMatcher) sizes(item *Item, reqIng *ReqIng) (want, have float64, ok bool) {
+	reqAmount, reqOk := toAmount(float64(reqIng.UnitQuantity), reqIng.Unit)
+	itemAmount, itemOk := toAmount(float64(item.UnitQuantity), item.Unit)
+	if !reqOk || !itemOk {
+		// units missing from the table only match when they are spelled the same
+		if canonicalUnit(item.Unit) != canonicalUnit(reqIng.Unit) {
+			return 0, 0, false
+		}
+		return float64(reqIng.UnitQuantity), float64(item.UnitQuantity), true
+	}
+
+	var density float64
+	if entry := m.resolveRequest(reqIng); entry != nil {
+		density = entry.Density
+	}
+	have, ok = itemAmount.in(reqAmount.dim, density)
+	return reqAmount.value, have, ok
+}
+
+// comparePipeline trims the vendors down to the in stock items matching a stem or a catalog id, see nameStems
+func comparePipeline(req *ReqIngArray, stems []string, catalogIDs []bson.ObjectID) mongo.Pipeline {
+	prefixes := make(bson.A, len(stems))
+	for i, stem := range stems {
+		prefixes[i] = bson.Regex{Pattern: "^" + regexp.QuoteMeta(stem)}
+	}
+	match := bson.D{{Key: "$or", Value: bson.A{
+		bson.D{{Key: "stores.items.name_keys", Value: bson.D{{Key: "$in", Value: prefixes}}}},
+		bson.D{{Key: "stores.items.catalog_id", Value: bson.D{{Key: "$in", Value: catalogIDs}}}},
+	}}}
+	if req.Location != nil {
+		match = append(match, geoWithinFilter("stores.location", req.Location, req.Radius))
+	}
+
+	// without stems the pattern would match every key
+	var keyMatches any = false
+	if len(stems) > 0 {
+		keyMatches = bson.D{{Key: "$gt", Value: bson.A{bson.D{{Key: "$size", Value: bson.D{{Key: "$filter", Value: bson.D{
+			{Key: "input", Value: bson.D{{Key: "$ifNull", Value: bson.A{"$$item.name_keys", bson.A{}}}}},
+			{Key: "as", Value: "key"},
+			{Key: "cond", Value: bson.D{{Key: "$regexMatch", Value: bson.D{
+				{Key: "input", Value: "$$key"},
+				{Key: "regex", Value: stemPattern(stems)},
+			}}}},
+		}}}}}, 0}}}
+	}
+	itemMatches := bson.D{{Key: "$or", Value: bson.A{
+		keyMatches,
+		bson.D{{Key: "$in", Value: bson.A{"$$item.catalog_id", catalogIDs}}},
+	}}}
+	// items that ran out are left out, alerts tell the vendor about them
+	inStock := bson.D{{Key: "$gt", Value: bson.A{"$$item.quantity", 0}}}
+	stores := bson.D{{Key: "$map", Value: bson.D{
+		{Key: "input", Value: "$stores"},
+		{Key: "as", Value: "store"},
+		{Key: "in", Value: bson.D{
+			{Key: "_id", Value: "$$store._id"},
+			{Key: "name", Value: "$$store.name"},
+			{Key: "store_type", Value: "$$store.store_type"},
+			{Key: "location", Value: "$$store.location"},
+			{Key: "hours", Value: "$$store.hours"},
+			{Key: "delivery_zones", Value: "$$store.delivery_zones"},
+			{Key: "rating", Value: "$$store.rating"},
+			{Key: "items", Value: bson.D{{Key: "$filter", Value: bson.D{
+				{Key: "input", Value: bson.D{{Key: "$ifNull", Value: bson.A{"$$store.items", bson.A{}}}}},
+				{Key: "as", Value: "item"},
+				{Key: "cond", Value: bson.D{{Key: "$and", Value: bson.A{itemMatches, inStock}}}},
+			}}}},
+		}},
+	}}}
+	storesWithItems := bson.D{{Key: "$filter", Value: bson.D{
+		{Key: "input", Value: "$stores"},
+		{Key: "as", Value: "store"},
+		{Key: "cond", Value: bson.D{{Key: "$gt", Value: bson.A{bson.D{{Key: "$size", Value: "$$store.items"}}, 0}}}},
+	}}}
+
+	return mongo.Pipeline{
+		{{Key: "$match", Value: match}},
+		{{Key: "$project", Value: bson.D{{Key: "stores", Value: stores}}}},
+		{{Key: "$project", Value: bson.D{{Key: "stores", Value: storesWithItems}}}},
+	}
+}
+
+// matchStore picks the best item in the store for every requested ingredient, it returns nil when the store
+// is out of range or carries none of them
+func (m *ingredientMatcher) matchStore(store *Store, req *ReqIngArray) *StoreMatch {
+	match := &StoreMatch{
+		Store: &Store{
+			ID:        store.ID,
+			Name:      store.Name,
+			StoreType: store.StoreType,
+			Location:  store.Location,
+			Hours:     store.Hours,
+			Rating:    store.Rating,
+			Items:     []*Item{},
+		},
+	}
+
+	if req.Location != nil {
+		if store.Location.validPoint() != nil {
+			return nil
+		}
+		distance := haversineMiles(req.Location, store.Location)
+		if distance > req.Radius {
+			return nil
+		}
+		match.Distance = &distance
+	}
+
+	match.picks = make([]*Item, len(req.Compare))
+	match.packs = make([]int, len(req.Compare))
+	for i, reqIng := range req.Compare {
+		if item, packs := m.bestMatch(store.Items, reqIng); item != nil {
+			match.picks[i], match.packs[i] = item, packs
+			match.Items = append(match.Items, item)
+			match.Covered++
+			match.BasketTotal += item.linePrice(packs)
+		}
+	}
+
+	if match.Covered == 0 {
+		return nil
+	}
+	if req.Location != nil {
+		match.Delivery = store.deliveryQuote(req.Location, match.BasketTotal)
+	}
+	return match
+}
+
+type candidate struct {
+	item       *Item
+	want, have float64
+	matchType  string
+	confidence float64
+}
+
+// bestMatch returns the best item for the request together with how many packs of it cover the request, only
+// the items with the most confident name match take part in the tiers and pack sizes are compared after
+// converting them to the unit of the request
+func (m *ingredientMatcher) bestMatch(items []*Item, reqIng *ReqIng) (*Item, int) {
+	var candidates []*candidate
+	best := 0.0
+	entry := m.resolveRequest(reqIng)
+	for _, item := range items {
+		matchType, confidence, ok := m.matchItem(item, reqIng, entry)
+		if !ok || confidence < best {
+			continue
+		}
+		want, have, ok := m.sizes(item, reqIng)
+		if !ok {
+			continue
+		}
+		if confidence > best {
+			best, candidates = confidence, candidates[:0]
+		}
+		candidates = append(candidates, &candidate{item, want, have, matchType, confidence})
+	}
+
+	pick := func(c *candidate, packs int) (*Item, int) {
+		item := createMatchItem(c.item)
+		item.MatchType, item.Confidence = c.matchType, c.confidence
+		return item, packs
+	}
+
+	// Tier 1: Exact match (the pack holds exactly the required amount)
+	for _, c := range candidates {
+		if sameAmount(c.want, c.have) {
+			return pick(c, 1)
+		}
+	}
+
+	// Tier 2: Ceiling match (smallest pack holding more than required)
+	var ceiling *candidate
+	for _, c := range candidates {
+		if c.have > c.want && (ceiling == nil || c.have-c.want < ceiling.have-ceiling.want) {
+			ceiling = c
+		}
+	}
+	if ceiling != nil {
+		return pick(ceiling, 1)
+	}
+
+	// Tier 3: Any match (the units convert, regardless of quantity)
+	if len(candidates) > 0 {
+		return pick(candidates[0], packsNeeded(candidates[0].want, candidates[0].have))
+	}
+	return nil, 0
+}
+
+func createMatchItem(item *Item) *Item {
+	match := &Item{
+		Ingredient: Ingredient{
+			IngredientID: item.IngredientID,
+			Name:         item.Name,
+			UnitQuantity: item.UnitQuantity,
+			Unit:         item.Unit,
+			Price:        item.Price,
+		},
+		CatalogID:  item.CatalogID,
+		Quantity:   item.Quantity,
+		Promotion:  item.Promotion,
+		UnitPrice:  item.UnitPrice,
+		MatchType:  item.MatchType,
+		Confidence: item.Confidence,
+	}
+	if pack, ok := toAmount(float64(item.UnitQuantity), item.Unit); ok {
+		match.UnitPrice = unitPrice(item.unitPrice(), pack)
+	}
+	return match
+}
+
+// packsNeeded is how many packs of the given size have to be bought to cover the wanted amount
+func packsNeeded(want, have float64) int {
+	if have <= 0 || have >= want {
+		return 1
+	}
+	// the tolerance keeps float noise from conversions from asking for an extra pack
+	return int(math.Ceil(want/have - 1e-9))
+}
+
+func compareStoreMatches(sortBy string) func(a, b *StoreMatch) int {
+	byCoverage := func(a, b *StoreMatch) int { return cmp.Compare(b.Covered, a.Covered) }
+	byPrice := func(a, b *StoreMatch) int { return cmp.Compare(a.BasketTotal, b.BasketTotal) }
+	byDistance := func(a, b *StoreMatch) int {
+		if a.Distance == nil || b.Distance == nil {
+			return 0
+		}
+		return cmp.Compare(*a.Distance, *b.Distance)
+	}
+
+	order := []func(a, b *StoreMatch) int{byCoverage, byPrice, byDistance}
+	switch sortBy {
+	case sortByPrice:
+		order = []func(a, b *StoreMatch) int{byPrice, byCoverage, byDistance}
+	case sortByDistance:
+		order = []func(a, b *StoreMatch) int{byDistance, byCoverage, byPrice}
+	}
+
+	return func(a, b *StoreMatch) int {
+		for _, compare := range order {
+			if c := compare(a, b); c != 0 {
+				return c
+			}
+		}
+		return 0
+	}
+}
+
+// rankStores orders every matched store and then groups them back by vendor, so vendors are ordered by their
+// best store and the stores of a vendor keep their relative rank
+func rankStores(matches []*StoreMatch, vendorIDs []bson.ObjectID, sortBy string) []*ResIng {
+	order := make([]int, len(matches))
+	for i := range order {
+		order[i] = i
+	}
+	compare := compareStoreMatches(sortBy)
+	slices.SortStableFunc(order, func(a, b int) int { return compare(matches[a], matches[b]) })
+
+	var results []*ResIng
+	byVendor := make(map[bson.ObjectID]*ResIng)
+	for _, i := range order {
+		res, ok := byVendor[vendorIDs[i]]
+		if !ok {
+			res = &ResIng{ID: vendorIDs[i], Stores: []*StoreMatch{}}
+			byVendor[vendorIDs[i]] = res
+			results = append(results, res)
+		}
+		res.Stores = append(res.Stores, matches[i])
+	}
+	return results
+}
+
+// IndexItemNames backfills the name keys of store items that have none
+func (m MongoVendorRepository) IndexItemNames(ctx context.Context) (int, error) {
+	ctx, span := Tracer.Start(ctx, "IndexItemNames")
+	defer span.End()
+
+	missing := bson.D{{Key: "name_keys", Value: bson.D{{Key: "$exists", Value: false}}}}
+	projection := bson.D{
+		{Key: "stores._id", Value: 1},
+		{Key: "stores.items.ingredient_id", Value: 1},
+		{Key: "stores.items.name", Value: 1},
+		{Key: "stores.items.name_keys", Value: 1},
+	}
+	cursor, err := m.col.Find(ctx, bson.D{{Key: "stores.items", Value: bson.D{{Key: "$elemMatch", Value: missing}}}},
+		options.Find().SetProjection(projection))
+	if err != nil {
+		Logger.ErrorContext(ctx, "Error finding unindexed store items", slog.Any("error", err), vendor_repo_source)
+		return 0, err
+	}
+	defer cursor.Close(ctx)
+
+	indexed := 0
+	for cursor.Next(ctx) {
+		var vendor Vendor
+		if err := cursor.Decode(&vendor); err != nil {
+			Logger.ErrorContext(ctx, "Error decoding vendor", slog.Any("error", err), vendor_repo_source)
+			return indexed, err
+		}
+		var models []mongo.WriteModel
+		for _, store := range vendor.Stores {
+			for _, item := range store.Items {
+				if item == nil || item.NameKeys != nil {
+					continue
+				}
+				models = append(models, mongo.NewUpdateOneModel().
+					SetFilter(bson.D{{Key: "_id", Value: vendor.ID}}).
+					SetUpdate(bson.D{{Key: "$set", Value: bson.M{"stores.$[s].items.$[i].name_keys": nameKeys(item.Name)}}}).
+					SetArrayFilters([]any{bson.M{"s._id": store.ID}, bson.M{"i.ingredient_id": item.IngredientID}}))
+			}
+		}
+		if len(models) == 0 {
+			continue
+		}
+		if _, err := m.col.BulkWrite(ctx, models, options.BulkWrite().SetOrdered(false)); err != nil {
+			Logger.ErrorContext(ctx, "Error indexing store item names", slog.String("vendorID", vendor.ID.Hex()),
+				slog.Any("error", err), vendor_repo_source)
+			return indexed, err
+		}
+		indexed += len(models)
+	}
+	if err := cursor.Err(); err != nil {
+		Logger.ErrorContext(ctx, "Error iterating vendors", slog.Any("error", err), vendor_repo_source)
+		return indexed, err
+	}
+	if indexed > 0 {
+		Logger.InfoContext(ctx, "Store item names indexed", slog.Int("items", indexed), vendor_repo_source)
+	}
+	return indexed, nil
+}
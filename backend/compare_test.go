@@ -0,0 +1,254 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"regexp"
+	"slices"
+	"strings"
+	"testing"
+
+	"go.mongodb.org/mongo-driver/v2/bson"
+	"go.mongodb.org/mongo-driver/v2/mongo"
+	"go.mongodb.org/mongo-driver/v2/mongo/options"
+)
+
+func newTestItem(name string, unitQuantity int, unit string, price float64) *Item {
+	return &Item{
+		Ingredient: Ingredient{
+			IngredientID: bson.NewObjectID(),
+			Name:         name,
+			UnitQuantity: unitQuantity,
+			Unit:         unit,
+			Price:        price,
+		},
+		Quantity: 10,
+	}
+}
+
+func TestBestMatchTiers(t *testing.T) {
+	items := []*Item{
+		newTestItem("Milk", 2, "litre", 4),
+		newTestItem("Milk", 1, "litre", 2),
+		newTestItem("Milk", 5, "litre", 9),
+		newTestItem("Flour", 500, "g", 3),
+		newTestItem("Sugar", 1, "kg", 2),
+		newTestItem("Oil", 500, "ml", 4),
+		newTestItem("Roma Tomato", 1, "kg", 5),
+		newTestItem("Tomatoes", 500, "g", 2),
+	}
+	matcher := newIngredientMatcher([]*Ingredient{{Name: "Oil", Density: 0.92}})
+
+	tests := []struct {
+		req          *ReqIng
+		unitQuantity int
+		packs        int
+	}{
+		{&ReqIng{Name: "Milk", UnitQuantity: 1, Unit: "litre"}, 1, 1},
+		{&ReqIng{Name: "Milk", UnitQuantity: 3, Unit: "litre"}, 5, 1},
+		{&ReqIng{Name: "Milk", UnitQuantity: 9, Unit: "litre"}, 2, 5},
+		{&ReqIng{Name: "Milk", UnitQuantity: 1000, Unit: "ml"}, 1, 1},
+		{&ReqIng{Name: "Flour", UnitQuantity: 1, Unit: "kg"}, 500, 2},
+		{&ReqIng{Name: "Flour", UnitQuantity: 2, Unit: "cups"}, 0, 0},
+		{&ReqIng{Name: "Sugar", UnitQuantity: 500, Unit: "grams"}, 1, 1},
+		{&ReqIng{Name: "Oil", UnitQuantity: 920, Unit: "g"}, 500, 2},
+		{&ReqIng{Name: "tomato", UnitQuantity: 1, Unit: "kg"}, 500, 2},
+	}
+
+	for _, test := range tests {
+		match, packs := matcher.bestMatch(items, test.req)
+		switch {
+		case test.unitQuantity == 0 && match != nil:
+			t.Fatalf("%+v -> expected no match got %+v", test.req, match)
+		case test.unitQuantity != 0 && (match == nil || match.UnitQuantity != test.unitQuantity || packs != test.packs):
+			t.Fatalf("%+v -> expected unit quantity %d in %d packs got %+v in %d packs", test.req, test.unitQuantity,
+				test.packs, match, packs)
+		}
+	}
+}
+
+func TestMatchStoreRadiusAndBasket(t *testing.T) {
+	store := &Store{
+		ID:       bson.NewObjectID(),
+		Location: newPoint(40.7128, -74.0060),
+		Items:    []*Item{newTestItem("Milk", 1, "litre", 2), newTestItem("Eggs", 12, "count", 5)},
+		Rating:   &StoreRating{Average: 4.5, Count: 2},
+	}
+	req := &ReqIngArray{
+		Compare: []*ReqIng{
+			{Name: "Milk", UnitQuantity: 3, Unit: "litre"},
+			{Name: "Eggs", UnitQuantity: 12, Unit: "count"},
+			{Name: "Salt", UnitQuantity: 1, Unit: "kg"},
+		},
+		Location: newPoint(40.73, -74.0),
+		Radius:   5,
+	}
+
+	match := newIngredientMatcher(nil).matchStore(store, req)
+	if match == nil {
+		t.Fatal("store in range should match")
+	}
+	if match.Covered != 2 {
+		t.Fatalf("expected 2 covered got %d", match.Covered)
+	}
+	if match.BasketTotal != 3*2+5 {
+		t.Fatalf("expected basket total 11 got %f", match.BasketTotal)
+	}
+	if match.Distance == nil || *match.Distance > 5 {
+		t.Fatalf("expected a distance under 5 miles got %v", match.Distance)
+	}
+	if match.Store.Rating != store.Rating {
+		t.Fatal("expected the store rating on the match")
+	}
+
+	req.Location = newPoint(34.0522, -118.2437)
+	if match := newIngredientMatcher(nil).matchStore(store, req); match != nil {
+		t.Fatalf("store out of range should not match got %+v", match)
+	}
+}
+
+func TestRankStores(t *testing.T) {
+	near, far := 1.0, 8.0
+	vendorA, vendorB := bson.NewObjectID(), bson.NewObjectID()
+	matches := []*StoreMatch{
+		{Store: &Store{Name: "A1"}, Distance: &far, Covered: 2, BasketTotal: 10},
+		{Store: &Store{Name: "B1"}, Distance: &near, Covered: 1, BasketTotal: 3},
+		{Store: &Store{Name: "A2"}, Distance: &near, Covered: 2, BasketTotal: 12},
+	}
+	vendors := []bson.ObjectID{vendorA, vendorB, vendorA}
+
+	tests := []struct {
+		sortBy string
+		first  string
+		order  []string
+	}{
+		{sortByCoverage, "A1", []string{"A1", "A2"}},
+		{sortByPrice, "B1", []string{"A1", "A2"}},
+		{sortByDistance, "A2", []string{"A2", "A1"}},
+	}
+
+	for _, test := range tests {
+		res := rankStores(matches, vendors, test.sortBy)
+		if got := res[0].Stores[0].Name; got != test.first {
+			t.Fatalf("%s -> expected %s first got %s", test.sortBy, test.first, got)
+		}
+		for _, v := range res {
+			if v.ID != vendorA {
+				continue
+			}
+			for i, name := range test.order {
+				if v.Stores[i].Name != name {
+					t.Fatalf("%s -> expected vendor A stores %v got %s at %d", test.sortBy, test.order, v.Stores[i].Name, i)
+				}
+			}
+		}
+	}
+}
+
+func TestNameStems(t *testing.T) {
+	matcher := newIngredientMatcher([]*Ingredient{{Name: "Green Onion", Aliases: []string{"Scallion"}}})
+	pattern := regexp.MustCompile(stemPattern(matcher.nameStems([]*ReqIng{{Name: "Tomatoes"}, {Name: "green onions"}})))
+	candidate := func(name string) bool {
+		return slices.ContainsFunc(nameKeys(name), pattern.MatchString)
+	}
+
+	for _, name := range []string{"Tomato", "Roma Tomatoes", "tomatoe", "Scallions", "Spring Onion"} {
+		if !candidate(name) {
+			t.Fatalf("%q should pass the name prefilter %s", name, pattern)
+		}
+	}
+	// typos within the stem are not candidates, see nameStems
+	for _, name := range []string{"Potato", "Milk", "Yoghurt", "Tomtao"} {
+		if candidate(name) {
+			t.Fatalf("%q should not pass the name prefilter %s", name, pattern)
+		}
+	}
+	if keys := nameKeys("Roma  TOMATOES!"); !slices.Equal(keys, []string{"roma", "tomato"}) {
+		t.Fatalf("expected lower case singular keys got %v", keys)
+	}
+}
+
+// BenchmarkComparePipeline runs the compare pipeline against the database in DB_URI, next to the unanchored
+// case insensitive regex on the item names it replaced. It seeds a scratch database of vendors where only a
+// few stores carry the requested items and drops it afterwards
+func BenchmarkComparePipeline(b *testing.B) {
+	uri := os.Getenv("DB_URI")
+	if uri == "" {
+		b.Skip("DB_URI is not set")
+	}
+	ctx := context.Background()
+	client, err := mongo.Connect(options.Client().ApplyURI(uri))
+	if err != nil {
+		b.Fatal(err)
+	}
+	defer client.Disconnect(ctx)
+	db := client.Database(fmt.Sprintf("compare_bench_%s", bson.NewObjectID().Hex()))
+	defer db.Drop(ctx)
+	col := db.Collection("vendor")
+
+	_, err = col.Indexes().CreateMany(ctx, []mongo.IndexModel{
+		{Keys: bson.D{{Key: "stores.items.name", Value: 1}}},
+		{Keys: bson.D{{Key: "stores.items.name_keys", Value: 1}}},
+		{Keys: bson.D{{Key: "stores.items.catalog_id", Value: 1}}},
+	})
+	if err != nil {
+		b.Fatal(err)
+	}
+	var vendors []any
+	for v := range 2000 {
+		vendor := &Vendor{Common: Common{ID: bson.NewObjectID()}}
+		for s := range 3 {
+			store := &Store{ID: bson.NewObjectID(), Name: fmt.Sprintf("store %d %d", v, s)}
+			for i := range 50 {
+				store.Items = append(store.Items, newTestItem(fmt.Sprintf("product %d %d", v, i), 1, "kg", 2))
+			}
+			if v%50 == 0 {
+				store.Items = append(store.Items, newTestItem("Milk", 1, "l", 2), newTestItem("Free Range Eggs", 6, "each", 3))
+			}
+			for _, item := range store.Items {
+				item.NameKeys = nameKeys(item.Name)
+			}
+			vendor.Stores = append(vendor.Stores, store)
+		}
+		vendors = append(vendors, vendor)
+	}
+	if _, err := col.InsertMany(ctx, vendors); err != nil {
+		b.Fatal(err)
+	}
+
+	req := &ReqIngArray{Compare: []*ReqIng{
+		{Name: "Milk", UnitQuantity: 1, Unit: "l"},
+		{Name: "Eggs", UnitQuantity: 12, Unit: "each"},
+		{Name: "Flour", UnitQuantity: 1, Unit: "kg"},
+	}}
+	matcher := newIngredientMatcher(nil)
+	stems := matcher.nameStems(req.Compare)
+	pipeline := comparePipeline(req, stems, matcher.catalogIDs(req.Compare))
+	regex := slices.Clone(pipeline)
+	regex[0] = bson.D{{Key: "$match", Value: bson.D{{Key: "$or", Value: bson.A{
+		bson.D{{Key: "stores.items.name", Value: bson.Regex{Pattern: `\b(` + strings.Join(stems, "|") + `)`, Options: "i"}}},
+		bson.D{{Key: "stores.items.catalog_id", Value: bson.D{{Key: "$in", Value: matcher.catalogIDs(req.Compare)}}}},
+	}}}}}
+
+	for _, bench := range []struct {
+		name     string
+		pipeline mongo.Pipeline
+	}{{"regex", regex}, {"name_keys", pipeline}} {
+		b.Run(bench.name, func(b *testing.B) {
+			for b.Loop() {
+				cursor, err := col.Aggregate(ctx, bench.pipeline)
+				if err != nil {
+					b.Fatal(err)
+				}
+				var found []*Vendor
+				if err := cursor.All(ctx, &found); err != nil {
+					b.Fatal(err)
+				}
+				if len(found) != 40 {
+					b.Fatalf("expected the 40 vendors with the items got %d", len(found))
+				}
+			}
+		})
+	}
+}
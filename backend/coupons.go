@@ -0,0 +1,365 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"regexp"
+	"strings"
+	"time"
+
+	"go.mongodb.org/mongo-driver/v2/bson"
+	"go.mongodb.org/mongo-driver/v2/mongo"
+	"go.mongodb.org/mongo-driver/v2/mongo/options"
+)
+
+const (
+	couponPercentOff = "percent_off"
+	couponAmountOff  = "amount_off"
+
+	couponIssuerPlatform = "platform"
+	couponIssuerVendor   = "vendor"
+
+	redemptionRedeemed = "redeemed"
+	redemptionReleased = "released"
+)
+
+var (
+	coupon_repo_source = slog.Any("source", "CouponRepository")
+
+	errCouponExists = errors.New("a coupon with the code already exists")
+
+	couponCodePattern = regexp.MustCompile(`^[A-Z0-9-]{3,32}$`)
+)
+
+// couponError is a coupon that can not be used on the order, the message is meant for the user
+type couponError struct{ reason string }
+
+func (e *couponError) Error() string { return e.reason }
+
+func rejectCoupon(format string, args ...any) error {
+	return &couponError{fmt.Sprintf(format, args...)}
+}
+
+func normalizeCouponCode(code string) string {
+	return strings.ToUpper(strings.TrimSpace(code))
+}
+
+func (c *Coupon) validate(now time.Time) error {
+	c.Code, c.Kind = normalizeCouponCode(c.Code), strings.ToLower(strings.TrimSpace(c.Kind))
+	if !couponCodePattern.MatchString(c.Code) {
+		return errors.New("code must be 3 to 32 letters, digits or dashes")
+	}
+	switch c.Kind {
+	case couponPercentOff:
+		if c.Value <= 0 || c.Value > 100 {
+			return errors.New("a percent_off coupon needs a value above 0 and at most 100")
+		}
+	case couponAmountOff:
+		if c.Value <= 0 {
+			return errors.New("an amount_off coupon needs a value above 0")
+		}
+	default:
+		return fmt.Errorf("kind must be %s or %s", couponPercentOff, couponAmountOff)
+	}
+	if c.MinBasket < 0 || c.PerUserLimit < 0 || c.TotalLimit < 0 {
+		return errors.New("min_basket, per_user_limit and total_limit can not be negative")
+	}
+	if c.ExpiresAt != nil && !c.ExpiresAt.After(now) {
+		return errors.New("expires_at must be in the future")
+	}
+	c.Redeemed = 0
+	return nil
+}
+
+func (c *Coupon) issuer() string {
+	if c.VendorID.IsZero() {
+		return couponIssuerPlatform
+	}
+	return couponIssuerVendor
+}
+
+// check is whether the coupon can be used on the order, the rules that need other orders are checked when it
+// is redeemed
+func (c *Coupon) check(order *UserOrder, now time.Time) error {
+	switch {
+	case c.ExpiresAt != nil && !now.Before(*c.ExpiresAt):
+		return rejectCoupon("coupon %s has expired", c.Code)
+	case !c.VendorID.IsZero() && (order.VendorID != c.VendorID || (!c.StoreID.IsZero() && order.StoreID != c.StoreID)):
+		return rejectCoupon("coupon %s is not valid at this store", c.Code)
+	case order.Subtotal < c.MinBasket:
+		return rejectCoupon("coupon %s needs a basket of at least %.2f", c.Code, c.MinBasket)
+	case c.TotalLimit > 0 && c.Redeemed >= c.TotalLimit:
+		return rejectCoupon("coupon %s has been fully redeemed", c.Code)
+	}
+	return nil
+}
+
+// discount is what the coupon takes off a subtotal, never more than the subtotal
+func (c *Coupon) discount(subtotal float64) float64 {
+	if c.Kind == couponPercentOff {
+		return roundCents(subtotal * c.Value / 100)
+	}
+	return min(c.Value, subtotal)
+}
+
+// applyDiscount puts the redeemed discount on the order and takes it off the total
+func applyDiscount(order *Order, discount *Discount) {
+	order.Discount = discount
+	order.TotalPrice = roundCents(order.TotalPrice - discount.Amount)
+}
+
+// releaseCoupons gives back the coupons redeemed for orders that were not placed
+func releaseCoupons(ctx context.Context, orders []*UserOrder, source slog.Attr) {
+	for _, order := range orders {
+		if order.Discount == nil {
+			continue
+		}
+		if err := Repos.Coupon.ReleaseRedemption(ctx, order.Discount.RedemptionID); err != nil {
+			Logger.ErrorContext(ctx, "Unable to release the coupon", slog.String("redemptionID", order.Discount.RedemptionID.Hex()),
+				slog.Any("error", err), source)
+		}
+	}
+}
+
+// CouponRepository keeps the coupons and their redemptions, a redemption is checked and counted against the
+// coupon's limits in one transaction
+type CouponRepository interface {
+	CreateCoupon(context.Context, *Coupon) error
+	FindCoupons(context.Context, bson.ObjectID) ([]*Coupon, error)
+	DeleteCoupon(context.Context, bson.ObjectID, bson.ObjectID) error
+	RedeemCoupon(context.Context, ID, *UserOrder, time.Time) (*Discount, error)
+	AttachRedemption(context.Context, bson.ObjectID, bson.ObjectID) error
+	ReleaseRedemption(context.Context, bson.ObjectID) error
+}
+
+type MongoCouponRepository struct {
+	col         *mongo.Collection
+	redemptions *mongo.Collection
+	user        *mongo.Collection
+}
+
+func newMongoCouponRepository(client *mongo.Client, dbName string) CouponRepository {
+	db := client.Database(dbName)
+	return &MongoCouponRepository{col: db.Collection("coupons"), redemptions: db.Collection("coupon_redemptions"),
+		user: db.Collection("user")}
+}
+
+func (m MongoCouponRepository) CreateCoupon(ctx context.Context, coupon *Coupon) error {
+	ctx, span := Tracer.Start(ctx, "CreateCoupon")
+	defer span.End()
+
+	coupon.ID, coupon.CreatedAt = bson.NewObjectID(), time.Now()
+	if _, err := m.col.InsertOne(ctx, coupon); err != nil {
+		if mongo.IsDuplicateKeyError(err) {
+			return errCouponExists
+		}
+		Logger.ErrorContext(ctx, "Error creating coupon", slog.Any("error", err), coupon_repo_source)
+		return err
+	}
+	Logger.InfoContext(ctx, "Coupon created", slog.String("code", coupon.Code), slog.String("issuer", coupon.issuer()),
+		coupon_repo_source)
+	return nil
+}
+
+// FindCoupons lists the coupons of the vendor, the platform coupons for a nil vendor
+func (m MongoCouponRepository) FindCoupons(ctx context.Context, vendorID bson.ObjectID) ([]*Coupon, error) {
+	ctx, span := Tracer.Start(ctx, "FindCoupons")
+	defer span.End()
+
+	cursor, err := m.col.Find(ctx, couponOwner(vendorID), options.Find().SetSort(bson.D{{Key: "created_at", Value: -1}}))
+	if err != nil {
+		Logger.ErrorContext(ctx, "Error finding coupons", slog.Any("error", err), coupon_repo_source)
+		return nil, err
+	}
+	coupons := []*Coupon{}
+	if err := cursor.All(ctx, &coupons); err != nil {
+		Logger.ErrorContext(ctx, "Error decoding coupons", slog.Any("error", err), coupon_repo_source)
+		return nil, err
+	}
+	return coupons, nil
+}
+
+// DeleteCoupon removes a coupon of the vendor so it can not be redeemed again, past redemptions stay
+func (m MongoCouponRepository) DeleteCoupon(ctx context.Context, vendorID, couponID bson.ObjectID) error {
+	ctx, span := Tracer.Start(ctx, "DeleteCoupon")
+	defer span.End()
+
+	filter := append(bson.D{{Key: "_id", Value: couponID}}, couponOwner(vendorID)...)
+	result, err := m.col.DeleteOne(ctx, filter)
+	if err != nil {
+		Logger.ErrorContext(ctx, "Error deleting coupon", slog.Any("error", err), coupon_repo_source)
+		return err
+	}
+	if result.DeletedCount == 0 {
+		return &NoItems{}
+	}
+	return nil
+}
+
+func couponOwner(vendorID bson.ObjectID) bson.D {
+	if vendorID.IsZero() {
+		return bson.D{{Key: "vendor_id", Value: nil}}
+	}
+	return bson.D{{Key: "vendor_id", Value: vendorID}}
+}
+
+// RedeemCoupon checks the order's coupon against every rule and records its use, a coupon error says why the
+// coupon was turned down. Redemptions of the same coupon all write the coupon so concurrent ones are retried
+// and see each other
+func (m MongoCouponRepository) RedeemCoupon(ctx context.Context, user ID, order *UserOrder, now time.Time) (*Discount, error) {
+	ctx, span := Tracer.Start(ctx, "RedeemCoupon")
+	defer span.End()
+
+	code := normalizeCouponCode(order.CouponCode)
+	Logger.InfoContext(ctx, "Redeeming a coupon", slog.String("userID", user.String()), slog.String("code", code),
+		coupon_repo_source)
+
+	session, err := MongoClient.StartSession(sesOp)
+	if err != nil {
+		Logger.ErrorContext(ctx, "Unable to create a session", slog.Any("error", err), coupon_repo_source)
+		return nil, err
+	}
+	defer session.EndSession(ctx)
+
+	discount, err := session.WithTransaction(ctx, func(sessCtx context.Context) (any, error) {
+		var coupon Coupon
+		if err := m.col.FindOne(sessCtx, bson.D{{Key: "code", Value: code}}).Decode(&coupon); err != nil {
+			if errors.Is(err, mongo.ErrNoDocuments) {
+				return nil, rejectCoupon("coupon %s does not exist", code)
+			}
+			Logger.ErrorContext(sessCtx, "Error finding coupon", slog.Any("error", err), coupon_repo_source)
+			return nil, err
+		}
+		if err := coupon.check(order, now); err != nil {
+			return nil, err
+		}
+
+		// past orders can be deleted, so a first order coupon is also refused once one was redeemed, which the
+		// first_order_redemption index holds to for orders of the same request and concurrent ones
+		if coupon.FirstOrderOnly {
+			filter := bson.D{{Key: "_id", Value: user.value}, {Key: "orders.0", Value: bson.D{{Key: "$exists", Value: true}}}}
+			ordered, err := m.user.CountDocuments(sessCtx, filter)
+			if err != nil {
+				Logger.ErrorContext(sessCtx, "Error counting user orders", slog.Any("error", err), coupon_repo_source)
+				return nil, err
+			}
+			filter = bson.D{{Key: "user_id", Value: user.value}, {Key: "first_order", Value: true}, {Key: "status", Value: redemptionRedeemed}}
+			redeemed, err := m.redemptions.CountDocuments(sessCtx, filter)
+			if err != nil {
+				Logger.ErrorContext(sessCtx, "Error counting first order redemptions", slog.Any("error", err), coupon_repo_source)
+				return nil, err
+			}
+			if ordered+redeemed > 0 {
+				return nil, rejectCoupon("coupon %s is only valid on a first order", code)
+			}
+		}
+		if coupon.PerUserLimit > 0 {
+			filter := bson.D{
+				{Key: "coupon_id", Value: coupon.ID},
+				{Key: "user_id", Value: user.value},
+				{Key: "status", Value: redemptionRedeemed},
+			}
+			used, err := m.redemptions.CountDocuments(sessCtx, filter)
+			if err != nil {
+				Logger.ErrorContext(sessCtx, "Error counting redemptions", slog.Any("error", err), coupon_repo_source)
+				return nil, err
+			}
+			if used >= int64(coupon.PerUserLimit) {
+				return nil, rejectCoupon("coupon %s can be used %d times per customer", code, coupon.PerUserLimit)
+			}
+		}
+
+		filter := bson.D{{Key: "_id", Value: coupon.ID}}
+		if coupon.TotalLimit > 0 {
+			filter = append(filter, bson.E{Key: "redeemed", Value: bson.D{{Key: "$lt", Value: coupon.TotalLimit}}})
+		}
+		result, err := m.col.UpdateOne(sessCtx, filter, bson.D{{Key: "$inc", Value: bson.M{"redeemed": 1}}})
+		if err != nil {
+			Logger.ErrorContext(sessCtx, "Error counting the redemption", slog.Any("error", err), coupon_repo_source)
+			return nil, err
+		}
+		if result.MatchedCount == 0 {
+			return nil, rejectCoupon("coupon %s has been fully redeemed", code)
+		}
+
+		redemption := &CouponRedemption{
+			ID:         bson.NewObjectID(),
+			CouponID:   coupon.ID,
+			Code:       coupon.Code,
+			UserID:     user.value,
+			VendorID:   order.VendorID,
+			StoreID:    order.StoreID,
+			Amount:     coupon.discount(order.Subtotal),
+			FirstOrder: coupon.FirstOrderOnly,
+			Status:     redemptionRedeemed,
+			At:         now,
+		}
+		if _, err := m.redemptions.InsertOne(sessCtx, redemption); err != nil {
+			if mongo.IsDuplicateKeyError(err) {
+				return nil, rejectCoupon("coupon %s is only valid on a first order", code)
+			}
+			Logger.ErrorContext(sessCtx, "Error saving the redemption", slog.Any("error", err), coupon_repo_source)
+			return nil, err
+		}
+		return &Discount{
+			RedemptionID: redemption.ID,
+			CouponID:     coupon.ID,
+			Code:         coupon.Code,
+			Issuer:       coupon.issuer(),
+			Kind:         coupon.Kind,
+			Value:        coupon.Value,
+			Amount:       redemption.Amount,
+		}, nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return discount.(*Discount), nil
+}
+
+// AttachRedemption links a redemption to the order that was placed with it
+func (m MongoCouponRepository) AttachRedemption(ctx context.Context, redemptionID, orderID bson.ObjectID) error {
+	ctx, span := Tracer.Start(ctx, "AttachRedemption")
+	defer span.End()
+
+	_, err := m.redemptions.UpdateOne(ctx, bson.D{{Key: "_id", Value: redemptionID}},
+		bson.D{{Key: "$set", Value: bson.M{"order_id": orderID}}})
+	if err != nil {
+		Logger.ErrorContext(ctx, "Error linking the redemption to its order", slog.Any("error", err), coupon_repo_source)
+	}
+	return err
+}
+
+// ReleaseRedemption gives a use of the coupon back, releasing twice does nothing
+func (m MongoCouponRepository) ReleaseRedemption(ctx context.Context, redemptionID bson.ObjectID) error {
+	ctx, span := Tracer.Start(ctx, "ReleaseRedemption")
+	defer span.End()
+
+	session, err := MongoClient.StartSession(sesOp)
+	if err != nil {
+		Logger.ErrorContext(ctx, "Unable to create a session", slog.Any("error", err), coupon_repo_source)
+		return err
+	}
+	defer session.EndSession(ctx)
+
+	_, err = session.WithTransaction(ctx, func(sessCtx context.Context) (any, error) {
+		var redemption CouponRedemption
+		filter := bson.D{{Key: "_id", Value: redemptionID}, {Key: "status", Value: redemptionRedeemed}}
+		update := bson.D{{Key: "$set", Value: bson.M{"status": redemptionReleased}}}
+		if err := m.redemptions.FindOneAndUpdate(sessCtx, filter, update).Decode(&redemption); err != nil {
+			if errors.Is(err, mongo.ErrNoDocuments) {
+				return nil, nil
+			}
+			return nil, err
+		}
+		_, err := m.col.UpdateOne(sessCtx, bson.D{{Key: "_id", Value: redemption.CouponID}},
+			bson.D{{Key: "$inc", Value: bson.M{"redeemed": -1}}})
+		return nil, err
+	})
+	if err != nil {
+		Logger.ErrorContext(ctx, "Error releasing the redemption", slog.Any("error", err), coupon_repo_source)
+	}
+	return err
+}
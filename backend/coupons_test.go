@@ -0,0 +1,87 @@
+package main
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"go.mongodb.org/mongo-driver/v2/bson"
+)
+
+func TestCouponValidate(t *testing.T) {
+	now := time.Date(2024, 12, 20, 12, 0, 0, 0, time.UTC)
+	past := now.Add(-time.Hour)
+	bad := []*Coupon{
+		{Code: "X", Kind: couponAmountOff, Value: 5},
+		{Code: "SAVE 10", Kind: couponAmountOff, Value: 5},
+		{Code: "SAVE10", Kind: couponPercentOff, Value: 110},
+		{Code: "SAVE10", Kind: couponAmountOff},
+		{Code: "SAVE10", Kind: "free"},
+		{Code: "SAVE10", Kind: couponAmountOff, Value: 5, PerUserLimit: -1},
+		{Code: "SAVE10", Kind: couponAmountOff, Value: 5, ExpiresAt: &past},
+	}
+	for _, coupon := range bad {
+		if coupon.validate(now) == nil {
+			t.Fatalf("expected %+v to fail", coupon)
+		}
+	}
+
+	coupon := &Coupon{Code: " save-10 ", Kind: "Percent_Off", Value: 10, Redeemed: 4}
+	if err := coupon.validate(now); err != nil || coupon.Code != "SAVE-10" || coupon.Redeemed != 0 {
+		t.Fatalf("expected the code upper cased and no redemptions got %+v %v", coupon, err)
+	}
+}
+
+func TestCouponCheck(t *testing.T) {
+	now := time.Date(2024, 12, 20, 12, 0, 0, 0, time.UTC)
+	expires := now.Add(time.Hour)
+	vendor, store := bson.NewObjectID(), bson.NewObjectID()
+	coupon := &Coupon{Code: "SAVE5", Kind: couponAmountOff, Value: 5, VendorID: vendor, StoreID: store,
+		MinBasket: 20, TotalLimit: 2, Redeemed: 1, ExpiresAt: &expires}
+	order := func(vendorID, storeID bson.ObjectID, subtotal float64) *UserOrder {
+		return &UserOrder{Order: Order{StoreID: storeID, Subtotal: subtotal}, VendorID: vendorID}
+	}
+
+	if err := coupon.check(order(vendor, store, 20), now); err != nil {
+		t.Fatalf("expected the coupon to apply got %v", err)
+	}
+	rejected := []struct {
+		order *UserOrder
+		now   time.Time
+	}{
+		{order(vendor, store, 20), expires},
+		{order(vendor, bson.NewObjectID(), 20), now},
+		{order(bson.NewObjectID(), store, 20), now},
+		{order(vendor, store, 19.99), now},
+	}
+	for _, test := range rejected {
+		var couponErr *couponError
+		if err := coupon.check(test.order, test.now); !errors.As(err, &couponErr) {
+			t.Fatalf("expected %+v to be turned down got %v", test.order, err)
+		}
+	}
+	coupon.Redeemed = 2
+	if coupon.check(order(vendor, store, 20), now) == nil {
+		t.Fatal("expected a fully redeemed coupon to be turned down")
+	}
+
+	platform := &Coupon{Code: "WELCOME", Kind: couponPercentOff, Value: 10}
+	if err := platform.check(order(bson.NewObjectID(), bson.NewObjectID(), 1), now); err != nil || platform.issuer() != couponIssuerPlatform {
+		t.Fatalf("expected a platform coupon to apply anywhere got %v", err)
+	}
+}
+
+func TestCouponDiscount(t *testing.T) {
+	if amount := (&Coupon{Kind: couponPercentOff, Value: 15}).discount(33.33); amount != 5 {
+		t.Fatalf("expected 15%% of 33.33 rounded to 5 got %v", amount)
+	}
+	if amount := (&Coupon{Kind: couponAmountOff, Value: 10}).discount(7.5); amount != 7.5 {
+		t.Fatalf("expected the discount capped at the subtotal got %v", amount)
+	}
+
+	order := &Order{Subtotal: 30, TotalPrice: 34, DeliveryFee: 4}
+	applyDiscount(order, &Discount{Code: "SAVE5", Amount: 5})
+	if order.TotalPrice != 29 || order.Discount == nil || order.Subtotal != 30 {
+		t.Fatalf("expected the discount taken off the total got %+v", order)
+	}
+}
@@ -0,0 +1,137 @@
+package main
+
+import (
+	"cmp"
+	"errors"
+	"fmt"
+	"slices"
+	"strings"
+
+	"go.mongodb.org/mongo-driver/v2/bson"
+)
+
+const (
+	deliveryMethodDelivery = "delivery"
+	deliveryMethodPickup   = "pickup"
+)
+
+// deliveryMethod reads the free form method clients send, the web app says Deliver
+func deliveryMethod(method string) string {
+	switch strings.ToLower(strings.TrimSpace(method)) {
+	case "deliver", "delivery":
+		return deliveryMethodDelivery
+	case "pickup", "pick up", "pick-up", "collect":
+		return deliveryMethodPickup
+	}
+	return ""
+}
+
+func (z *DeliveryZone) validate() error {
+	switch {
+	case z.Area != nil && z.MaxMiles != 0:
+		return errors.New("a delivery zone is either an area or a distance band, not both")
+	case z.Area != nil:
+		if err := z.Area.validate(); err != nil {
+			return err
+		}
+	case z.MaxMiles <= z.MinMiles || z.MinMiles < 0:
+		return errors.New("a delivery zone needs an area or max_miles above min_miles")
+	case z.MaxMiles > maxRadiusMiles:
+		return fmt.Errorf("max_miles can not be more than %v", maxRadiusMiles)
+	}
+	if z.Fee < 0 || z.MinOrder < 0 || z.EtaMinutes < 0 {
+		return errors.New("fee, min_order and eta_minutes can not be negative")
+	}
+	if z.ID == bson.NilObjectID {
+		z.ID = bson.NewObjectID()
+	}
+	return nil
+}
+
+// covers is whether the zone of a store at from reaches to
+func (z *DeliveryZone) covers(from, to *GeoJSON) bool {
+	if z.Area != nil {
+		return z.Area.contains(to)
+	}
+	if from.validPoint() != nil {
+		return false
+	}
+	distance := haversineMiles(from, to)
+	return distance >= z.MinMiles && distance < z.MaxMiles
+}
+
+func (s *Store) validate() error {
+	if s.Hours != nil {
+		if err := s.Hours.validate(); err != nil {
+			return err
+		}
+	}
+	for _, zone := range s.DeliveryZones {
+		if err := zone.validate(); err != nil {
+			return err
+		}
+	}
+	for _, template := range s.SlotTemplates {
+		if err := template.validate(); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// deliveryQuote is the cheapest zone of the store that covers the location, then the fastest, nil when the
+// store does not deliver there
+func (s *Store) deliveryQuote(to *GeoJSON, basket float64) *DeliveryQuote {
+	var zones []*DeliveryZone
+	for _, zone := range s.DeliveryZones {
+		if zone.covers(s.Location, to) {
+			zones = append(zones, zone)
+		}
+	}
+	if len(zones) == 0 {
+		return nil
+	}
+	zone := slices.MinFunc(zones, func(a, b *DeliveryZone) int {
+		return cmp.Or(cmp.Compare(a.Fee, b.Fee), cmp.Compare(a.EtaMinutes, b.EtaMinutes))
+	})
+	return &DeliveryQuote{
+		ZoneID:       zone.ID,
+		Fee:          zone.Fee,
+		MinOrder:     zone.MinOrder,
+		EtaMinutes:   zone.EtaMinutes,
+		MeetsMinimum: basket >= zone.MinOrder,
+	}
+}
+
+// checkDelivery prices the order from the store's items, then the delivery from the store's zones, the fee is
+// added to the total. The zone minimum is checked against the priced basket, never the total the client sent.
+// An order the store can not deliver becomes a pickup, a basket below the zone minimum is rejected. Stores
+// without zones keep delivering without a fee
+func checkDelivery(store *Store, order *Order) (fellBack bool, err error) {
+	method := deliveryMethod(order.DeliveryMethod)
+	if method == "" {
+		return false, fmt.Errorf("delivery_method must be %s or %s", deliveryMethodDelivery, deliveryMethodPickup)
+	}
+	if err := priceOrder(store, order); err != nil {
+		return false, err
+	}
+	order.DeliveryMethod, order.DeliveryFee, order.EtaMinutes = method, 0, 0
+	if method == deliveryMethodPickup || len(store.DeliveryZones) == 0 {
+		return false, nil
+	}
+
+	if err := order.DeliveryLocation.validPoint(); err != nil {
+		return false, fmt.Errorf("delivery_location: %w", err)
+	}
+	quote := store.deliveryQuote(order.DeliveryLocation, order.Subtotal)
+	if quote == nil {
+		order.DeliveryMethod = deliveryMethodPickup
+		return true, nil
+	}
+	if !quote.MeetsMinimum {
+		return false, fmt.Errorf("store %s delivers orders of at least %.2f here", store.Name, quote.MinOrder)
+	}
+	order.DeliveryFee, order.EtaMinutes = quote.Fee, quote.EtaMinutes
+	order.TotalPrice = roundCents(order.Subtotal + quote.Fee)
+	return false, nil
+}
@@ -0,0 +1,153 @@
+package main
+
+import (
+	"testing"
+
+	"go.mongodb.org/mongo-driver/v2/bson"
+)
+
+// a square of roughly seven by seven miles around the store
+func testArea() *GeoPolygon {
+	return &GeoPolygon{Type: "Polygon", Coordinates: [][][2]float64{
+		{{-74.05, 40.70}, {-73.95, 40.70}, {-73.95, 40.80}, {-74.05, 40.80}, {-74.05, 40.70}},
+		// a hole in the middle of the north edge
+		{{-74.01, 40.78}, {-73.99, 40.78}, {-73.99, 40.80}, {-74.01, 40.80}, {-74.01, 40.78}},
+	}}
+}
+
+func testDeliveryStore(t *testing.T) *Store {
+	store := &Store{
+		Name:     "Corner",
+		Location: newPoint(40.75, -74.00),
+		DeliveryZones: []*DeliveryZone{
+			{Name: "Near", MaxMiles: 2, Fee: 2, MinOrder: 10, EtaMinutes: 30},
+			{Name: "Far", MinMiles: 2, MaxMiles: 8, Fee: 6, MinOrder: 25, EtaMinutes: 60},
+			{Name: "Promo", Area: testArea(), Fee: 4, MinOrder: 20, EtaMinutes: 45},
+		},
+	}
+	if err := store.validate(); err != nil {
+		t.Fatal(err)
+	}
+	return store
+}
+
+func TestDeliveryMethod(t *testing.T) {
+	for method, expected := range map[string]string{
+		"Deliver": deliveryMethodDelivery, "delivery": deliveryMethodDelivery, " Pickup ": deliveryMethodPickup,
+		"pick up": deliveryMethodPickup, "drone": "",
+	} {
+		if got := deliveryMethod(method); got != expected {
+			t.Fatalf("%q expected %q got %q", method, expected, got)
+		}
+	}
+}
+
+func TestPolygonContains(t *testing.T) {
+	area := testArea()
+	if err := area.validate(); err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		lat, lng float64
+		inside   bool
+	}{
+		{40.75, -74.00, true},
+		{40.79, -74.00, false},
+		{40.79, -74.03, true},
+		{40.85, -74.00, false},
+		{40.75, -73.90, false},
+	}
+	for _, test := range tests {
+		if got := area.contains(newPoint(test.lat, test.lng)); got != test.inside {
+			t.Fatalf("%v,%v expected inside %v", test.lat, test.lng, test.inside)
+		}
+	}
+
+	open := &GeoPolygon{Type: "Polygon", Coordinates: [][][2]float64{{{0, 0}, {1, 0}, {1, 1}, {0, 1}}}}
+	if open.validate() == nil {
+		t.Fatal("expected a ring that is not closed to fail")
+	}
+}
+
+func TestDeliveryZoneValidate(t *testing.T) {
+	bad := []*DeliveryZone{
+		{},
+		{MinMiles: 3, MaxMiles: 2},
+		{MaxMiles: 500},
+		{Area: testArea(), MaxMiles: 2},
+		{MaxMiles: 2, Fee: -1},
+	}
+	for _, zone := range bad {
+		if zone.validate() == nil {
+			t.Fatalf("expected %+v to fail", zone)
+		}
+	}
+	zone := &DeliveryZone{MaxMiles: 2}
+	if err := zone.validate(); err != nil || zone.ID.IsZero() {
+		t.Fatalf("expected the zone to get an id got %+v %v", zone, err)
+	}
+}
+
+func TestDeliveryQuote(t *testing.T) {
+	store := testDeliveryStore(t)
+
+	// inside the near band and the promo area, the near band is cheaper
+	quote := store.deliveryQuote(newPoint(40.76, -74.00), 15)
+	if quote == nil || quote.ZoneID != store.DeliveryZones[0].ID || !quote.MeetsMinimum {
+		t.Fatalf("expected the near zone got %+v", quote)
+	}
+	// out of the near band but inside the promo area which beats the far band
+	quote = store.deliveryQuote(newPoint(40.79, -74.04), 15)
+	if quote == nil || quote.ZoneID != store.DeliveryZones[2].ID || quote.MeetsMinimum {
+		t.Fatalf("expected the promo zone below its minimum got %+v", quote)
+	}
+	if quote := store.deliveryQuote(newPoint(41.5, -74.00), 100); quote != nil {
+		t.Fatalf("expected no zone that far got %+v", quote)
+	}
+}
+
+func TestCheckDelivery(t *testing.T) {
+	store := testDeliveryStore(t)
+	store.Items = []*Item{newTestItem("Milk", 1, "litre", 2.5)}
+	// lines asks for packs of milk, the total the client sends is ignored
+	lines := func(packs int) []*Item {
+		return []*Item{{Ingredient: Ingredient{IngredientID: store.Items[0].IngredientID, Name: "Milk"}, Quantity: packs}}
+	}
+
+	order := &Order{DeliveryMethod: "Deliver", DeliveryLocation: newPoint(40.76, -74.00), Items: lines(5), TotalPrice: 1}
+	if fellBack, err := checkDelivery(store, order); err != nil || fellBack {
+		t.Fatalf("expected the delivery to pass got %v %v", fellBack, err)
+	}
+	if order.DeliveryMethod != deliveryMethodDelivery || order.DeliveryFee != 2 || order.Subtotal != 12.5 ||
+		order.TotalPrice != 14.5 || order.EtaMinutes != 30 {
+		t.Fatalf("expected the near zone fee on the total got %+v", order)
+	}
+
+	order = &Order{DeliveryMethod: "delivery", DeliveryLocation: newPoint(41.5, -74.00), Items: lines(20)}
+	if fellBack, err := checkDelivery(store, order); err != nil || !fellBack || order.DeliveryMethod != deliveryMethodPickup ||
+		order.TotalPrice != 50 {
+		t.Fatalf("expected an out of range order to become a pickup got %+v %v", order, err)
+	}
+
+	order = &Order{DeliveryMethod: "delivery", DeliveryLocation: newPoint(40.76, -74.00), Items: lines(2), TotalPrice: 100}
+	if _, err := checkDelivery(store, order); err == nil {
+		t.Fatal("expected a basket below the minimum to fail whatever total was sent")
+	}
+	order = &Order{DeliveryMethod: "delivery", DeliveryLocation: newPoint(40.76, -74.00),
+		Items: []*Item{{Ingredient: Ingredient{IngredientID: bson.NewObjectID(), Name: "Bread"}, Quantity: 1}}}
+	if _, err := checkDelivery(store, order); err == nil {
+		t.Fatal("expected an item the store does not sell to fail")
+	}
+	if _, err := checkDelivery(store, &Order{DeliveryMethod: "delivery", Items: lines(5)}); err == nil {
+		t.Fatal("expected a delivery without a location to fail")
+	}
+	if _, err := checkDelivery(store, &Order{DeliveryMethod: "drone"}); err == nil {
+		t.Fatal("expected an unknown method to fail")
+	}
+
+	old := &Store{Name: "Old", Items: store.Items}
+	order = &Order{DeliveryMethod: "Deliver", Items: lines(2)}
+	if _, err := checkDelivery(old, order); err != nil || order.DeliveryFee != 0 || order.TotalPrice != 5 {
+		t.Fatalf("expected a store without zones to deliver for free got %+v %v", order, err)
+	}
+}
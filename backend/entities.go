@@ -32,11 +32,21 @@ type RequestVendorOrders struct {
 }
 
 type ReqIngArray struct {
-	Compare []*ReqIng `json:"compare"`
+	Compare  []*ReqIng `json:"compare"`
+	Location *GeoJSON  `json:"location,omitempty"`
+	Radius   float64   `json:"radius,omitempty"`
+	SortBy   string    `json:"sort_by,omitempty"`
+}
+
+type RequestCatalog struct {
+	Ingredients []*CatalogIngredient `json:"ingredients"`
 }
 
 type ComConReq interface {
-	RequestRecipes | RequestCarts | RequestUserOrders | RequestVendorOrders | RequestStores | ReqIngArray
+	RequestRecipes | RequestCarts | RequestUserOrders | RequestVendorOrders | RequestStores | ReqIngArray | OptimizeReq |
+		RequestCatalog | Category | CatalogIngredient | MergeReq | NotificationSettings | SlotHoldReq | Promotion | Coupon | PriceAlert |
+		TaxRule | CommissionReq | PayoutPaidReq | Review | ReviewReply | ReviewStatusReq | IssueReq | IssueMessageReq | IssueResolveReq |
+		Subscription | SubscriptionStatusReq | SkipsReq
 }
 
 type Login struct {
@@ -46,14 +56,56 @@ type Login struct {
 }
 
 type ReqIng struct {
-	Name         string `bson:"name" json:"name"`
-	UnitQuantity int    `bson:"unit_quantity" json:"unit_quantity"`
-	Unit         string `bson:"unit" json:"unit"`
+	CatalogID    bson.ObjectID `bson:"catalog_id,omitempty" json:"catalog_id,omitempty"`
+	Name         string        `bson:"name" json:"name"`
+	UnitQuantity int           `bson:"unit_quantity" json:"unit_quantity"`
+	Unit         string        `bson:"unit" json:"unit"`
 }
 
 type ResIng struct {
 	ID     bson.ObjectID `bson:"_id,omitempty" json:"vendor_id"`
-	Stores []*Store      `bson:"stores" json:"stores"`
+	Stores []*StoreMatch `bson:"stores" json:"stores"`
+}
+
+type StoreMatch struct {
+	*Store      `bson:",inline"`
+	StoreStatus `bson:"-"`
+	// Delivery is set when a location was given and one of the store's zones covers it
+	Delivery    *DeliveryQuote `bson:"-" json:"delivery,omitempty"`
+	Distance    *float64       `bson:"distance,omitempty" json:"distance,omitempty"`
+	Covered     int            `bson:"covered" json:"covered"`
+	BasketTotal float64        `bson:"basket_total" json:"basket_total"`
+	// picks and packs line up with the requested ingredients, packs is how many of the pick cover the request
+	picks []*Item
+	packs []int
+}
+
+type OptimizeReq struct {
+	ReqIngArray  `bson:",inline"`
+	MaxStores    int  `json:"max_stores,omitempty"`
+	MustCoverAll bool `json:"must_cover_all,omitempty"`
+}
+
+type BasketPlan struct {
+	Carts      []*Cart   `json:"carts"`
+	TotalPrice float64   `json:"total_price"`
+	Missing    []*ReqIng `json:"missing"`
+	// Exhaustive is false when there were too many stores to try every combination, the plan is then the best
+	// of the stores kept by pruneCandidates and a cheaper one may exist
+	Exhaustive bool `json:"exhaustive"`
+}
+
+type NearbyStore struct {
+	VendorID    bson.ObjectID `bson:"vendor_id" json:"vendor_id"`
+	*Store      `bson:",inline"`
+	StoreStatus `bson:"-"`
+	Distance    float64 `bson:"distance" json:"distance"`
+}
+
+// StoreStatus is whether a store is open when it is looked up and when it opens next if it is not
+type StoreStatus struct {
+	OpenNow  bool       `json:"open_now"`
+	NextOpen *time.Time `json:"next_open,omitempty"`
 }
 
 type AcceptUserOrderReq struct {
@@ -77,6 +129,47 @@ type Ingredient struct {
 	UnitQuantity int           `bson:"unit_quantity" json:"unit_quantity"`
 	Unit         string        `bson:"unit" json:"unit"`
 	Price        float64       `bson:"price,omitempty" json:"price"`
+	// Density is in grams per millilitre, set on catalog ingredients so mass and volume can be compared
+	Density float64 `bson:"density,omitempty" json:"density,omitempty"`
+	// Aliases are other names the catalog ingredient is sold under, "scallion" for "green onion"
+	Aliases []string `bson:"aliases,omitempty" json:"aliases,omitempty"`
+}
+
+type CatalogQuery struct {
+	Search     string
+	CategoryID bson.ObjectID
+	Attributes []string
+	// AllergenFree drops every ingredient carrying one of these allergens
+	AllergenFree []string
+	Page         int
+	PageSize     int
+}
+
+type CatalogPage struct {
+	Ingredients []*CatalogIngredient `json:"ingredients"`
+	Total       int64                `json:"total"`
+	Page        int                  `json:"page"`
+	PageSize    int                  `json:"page_size"`
+}
+
+type DuplicateGroup struct {
+	Name        string               `json:"name"`
+	Dimension   string               `json:"dimension"`
+	Ingredients []*CatalogIngredient `json:"ingredients"`
+}
+
+type MergeReq struct {
+	SurvivorID bson.ObjectID   `json:"survivor_id"`
+	MergedIDs  []bson.ObjectID `json:"merged_ids"`
+}
+
+type MergePreview struct {
+	Survivor   *CatalogIngredient   `json:"survivor"`
+	Merged     []*CatalogIngredient `json:"merged"`
+	Aliases    []string             `json:"aliases"`
+	StoreItems int64                `json:"store_items"`
+	Recipes    int64                `json:"recipe_items"`
+	Carts      int64                `json:"cart_items"`
 }
 
 type GeoJSON struct {
@@ -84,6 +177,12 @@ type GeoJSON struct {
 	Coordinates []float64 `bson:"coordinates" json:"coordinates"`
 }
 
+// GeoPolygon is a GeoJSON polygon, the first ring is the outline and any others are holes
+type GeoPolygon struct {
+	Type        string         `bson:"type" json:"type"`
+	Coordinates [][][2]float64 `bson:"coordinates" json:"coordinates"`
+}
+
 // -----------------------------------------------------------------------
 //
 // ------------------------Embedding--------------------------------------
@@ -95,8 +194,22 @@ type Order struct {
 	OrderStatus    string        `bson:"order_status" json:"order_status"`
 	TotalPrice     float64       `bson:"total_price" json:"total_price"`
 	Items          []*Item       `bson:"items" json:"items"`
-	CreatedAt      time.Time     `bson:"created_at" json:"created_at"`
-	UpdatedAt      time.Time     `bson:"updated_at" json:"updated_at"`
+	// Subtotal is what the items cost at the store's prices, TotalPrice takes off the discount and adds the fee and tax
+	Subtotal float64 `bson:"subtotal,omitempty" json:"subtotal,omitempty"`
+	// CouponCode is the code the user entered at checkout, Discount is what it took off the subtotal
+	CouponCode string    `bson:"coupon_code,omitempty" json:"coupon_code,omitempty"`
+	Discount   *Discount `bson:"discount,omitempty" json:"discount,omitempty"`
+	// ScheduledFor is when an order placed ahead of time is due, it is always inside the store's hours
+	ScheduledFor *time.Time `bson:"scheduled_for,omitempty" json:"scheduled_for,omitempty"`
+	// DeliveryLocation is where a delivery goes, the fee and estimate come from the zone it falls in
+	DeliveryLocation *GeoJSON    `bson:"delivery_location,omitempty" json:"delivery_location,omitempty"`
+	DeliveryFee      float64     `bson:"delivery_fee,omitempty" json:"delivery_fee,omitempty"`
+	EtaMinutes       int         `bson:"eta_minutes,omitempty" json:"eta_minutes,omitempty"`
+	Slot             *BookedSlot `bson:"slot,omitempty" json:"slot,omitempty"`
+	// Breakdown is how TotalPrice adds up from the lines, fees, discount and tax
+	Breakdown *PriceBreakdown `bson:"breakdown,omitempty" json:"breakdown,omitempty"`
+	CreatedAt time.Time       `bson:"created_at" json:"created_at"`
+	UpdatedAt time.Time       `bson:"updated_at" json:"updated_at"`
 }
 
 type Common struct {
@@ -114,11 +227,32 @@ type Common struct {
 
 type Item struct {
 	Ingredient `bson:",inline"`
-	Quantity   int `bson:"quantity" json:"quantity"`
+	// CatalogID links a store item to the admin catalog ingredient it sells
+	CatalogID bson.ObjectID `bson:"catalog_id,omitempty" json:"catalog_id,omitempty"`
+	Quantity  int           `bson:"quantity" json:"quantity"`
+	// ReorderThreshold is the quantity at or below which the item raises a low stock alert, 0 turns it off
+	ReorderThreshold int `bson:"reorder_threshold,omitempty" json:"reorder_threshold,omitempty"`
+	// Promotion is written by the promotion job while a promotion covers the item, on orders it is the
+	// promotion the item was bought under
+	Promotion *ItemPromotion `bson:"promotion,omitempty" json:"promotion,omitempty"`
+	// Pricing is only set on order items, it is how the line adds up at checkout
+	Pricing *LinePricing `bson:"pricing,omitempty" json:"pricing,omitempty"`
+	// NameKeys is only kept on store items, it is nameKeys of the name for the compare query's index
+	NameKeys   []string   `bson:"name_keys,omitempty" json:"-"`
+	UnitPrice  *UnitPrice `bson:"-" json:"unit_price,omitempty"`
+	MatchType  string     `bson:"-" json:"match_type,omitempty"`
+	Confidence float64    `bson:"-" json:"confidence,omitempty"`
+}
+
+type UnitPrice struct {
+	Price float64 `json:"price"`
+	Per   string  `json:"per"`
 }
 
 type Admin struct {
-	Common      `bson:",inline"`
+	Common `bson:",inline"`
+	// Ingredients is where admins kept their own ingredients before the shared catalog, it is only read to
+	// migrate them
 	Ingredients []*Ingredient `bson:"ingredients" json:"ingredients"`
 }
 
@@ -166,12 +300,667 @@ type Cart struct {
 	Items      []*Item       `bson:"items" json:"items"`
 }
 
+// Category is a node of the catalog tree, Path holds its ancestors from the root down
+type Category struct {
+	ID        bson.ObjectID   `bson:"_id,omitempty" json:"category_id"`
+	Name      string          `bson:"name" json:"name"`
+	ParentID  bson.ObjectID   `bson:"parent_id,omitempty" json:"parent_id,omitempty"`
+	Path      []bson.ObjectID `bson:"path" json:"path"`
+	CreatedBy bson.ObjectID   `bson:"created_by,omitempty" json:"created_by,omitempty"`
+	UpdatedBy bson.ObjectID   `bson:"updated_by,omitempty" json:"updated_by,omitempty"`
+	CreatedAt time.Time       `bson:"created_at" json:"created_at"`
+	UpdatedAt time.Time       `bson:"updated_at" json:"updated_at"`
+}
+
+// CatalogIngredient is an entry of the shared ingredient catalog, CategoryPath is the path of its category
+// followed by the category itself so a whole subtree can be queried at once
+type CatalogIngredient struct {
+	Ingredient `bson:",inline"`
+	// NameKey is the normalized name, unique across the catalog, duplicates waiting to be merged have none
+	NameKey      string          `bson:"name_key,omitempty" json:"-"`
+	CategoryID   bson.ObjectID   `bson:"category_id,omitempty" json:"category_id,omitempty"`
+	CategoryPath []bson.ObjectID `bson:"category_path" json:"category_path"`
+	Attributes   []string        `bson:"attributes" json:"attributes"`
+	Allergens    []string        `bson:"allergens" json:"allergens"`
+	Images       []string        `bson:"images" json:"images"`
+	CreatedBy    bson.ObjectID   `bson:"created_by,omitempty" json:"created_by,omitempty"`
+	UpdatedBy    bson.ObjectID   `bson:"updated_by,omitempty" json:"updated_by,omitempty"`
+	CreatedAt    time.Time       `bson:"created_at" json:"created_at"`
+	UpdatedAt    time.Time       `bson:"updated_at" json:"updated_at"`
+}
+
+// CatalogChange is one entry of the catalog audit log, Changes holds the fields that were written
+type CatalogChange struct {
+	ID       bson.ObjectID `bson:"_id,omitempty" json:"change_id"`
+	AdminID  bson.ObjectID `bson:"admin_id" json:"admin_id"`
+	Action   string        `bson:"action" json:"action"`
+	Target   string        `bson:"target" json:"target"`
+	TargetID bson.ObjectID `bson:"target_id" json:"target_id"`
+	Changes  any           `bson:"changes,omitempty" json:"changes,omitempty"`
+	At       time.Time     `bson:"at" json:"at"`
+}
+
 type Store struct {
 	ID        bson.ObjectID `bson:"_id,omitempty" json:"store_id"`
 	Name      string        `bson:"name" json:"name"`
 	StoreType string        `bson:"store_type" json:"store_type"`
 	Location  *GeoJSON      `bson:"location" json:"location"`
 	Items     []*Item       `bson:"items" json:"items"`
+	// Hours is when the store is open and takes orders, a store without them is always open
+	Hours *StoreHours `bson:"hours,omitempty" json:"hours,omitempty"`
+	// DeliveryZones are where the store delivers, a store without zones is not checked
+	DeliveryZones []*DeliveryZone `bson:"delivery_zones,omitempty" json:"delivery_zones,omitempty"`
+	// SlotTemplates are the weekly delivery and pickup slots users can book, in the time zone of the hours
+	SlotTemplates []*SlotTemplate `bson:"slot_templates,omitempty" json:"slot_templates,omitempty"`
+	// Region is the tax region of the store like US-NY, the tax rules of the region price its orders
+	Region string `bson:"region,omitempty" json:"region,omitempty"`
+	// Address is printed on receipts and invoices, the vendor's address is used when it is empty
+	Address string `bson:"address,omitempty" json:"address,omitempty"`
+	// Rating is kept up to date from the published reviews of the store, vendors can not set it
+	Rating *StoreRating `bson:"rating,omitempty" json:"rating,omitempty"`
+}
+
+type StoreRating struct {
+	Average float64 `bson:"average" json:"average"`
+	Count   int     `bson:"count" json:"count"`
+}
+
+type SlotTemplate struct {
+	Day      int    `bson:"day" json:"day"`
+	Start    string `bson:"start" json:"start"`
+	End      string `bson:"end" json:"end"`
+	Method   string `bson:"method" json:"method"`
+	Capacity int    `bson:"capacity" json:"capacity"`
+}
+
+// Slot is a slot on a date, ID is the store, method and start so every hold of the same slot counts against
+// one document
+type Slot struct {
+	ID        string        `bson:"_id" json:"slot_id"`
+	VendorID  bson.ObjectID `bson:"vendor_id" json:"vendor_id"`
+	StoreID   bson.ObjectID `bson:"store_id" json:"store_id"`
+	Method    string        `bson:"method" json:"method"`
+	Start     time.Time     `bson:"start" json:"start"`
+	End       time.Time     `bson:"end" json:"end"`
+	Capacity  int           `bson:"capacity" json:"capacity"`
+	Used      int           `bson:"used" json:"-"`
+	Available int           `bson:"-" json:"available"`
+}
+
+// SlotHold is a place in a slot, held while the user checks out and booked once the order is placed
+type SlotHold struct {
+	ID        bson.ObjectID `bson:"_id" json:"hold_id"`
+	SlotID    string        `bson:"slot_id" json:"slot_id"`
+	UserID    bson.ObjectID `bson:"user_id" json:"user_id"`
+	VendorID  bson.ObjectID `bson:"vendor_id" json:"vendor_id"`
+	StoreID   bson.ObjectID `bson:"store_id" json:"store_id"`
+	Method    string        `bson:"method" json:"method"`
+	Start     time.Time     `bson:"start" json:"start"`
+	End       time.Time     `bson:"end" json:"end"`
+	Status    string        `bson:"status" json:"status"`
+	OrderID   bson.ObjectID `bson:"order_id,omitempty" json:"order_id,omitempty"`
+	ExpiresAt time.Time     `bson:"expires_at" json:"expires_at"`
+	CreatedAt time.Time     `bson:"created_at" json:"created_at"`
+}
+
+type SlotHoldReq struct {
+	VendorID bson.ObjectID `json:"vendor_id"`
+	StoreID  bson.ObjectID `json:"store_id"`
+	Method   string        `json:"method"`
+	Start    time.Time     `json:"start"`
+}
+
+// BookedSlot is the slot of an order, clients send the hold and the rest is filled in when it is booked
+type BookedSlot struct {
+	HoldID bson.ObjectID `bson:"hold_id" json:"hold_id"`
+	Start  time.Time     `bson:"start" json:"start"`
+	End    time.Time     `bson:"end" json:"end"`
+}
+
+// Promotion is a vendor price rule for a window of time, it covers the items and the catalog categories it
+// names in one store or, without a store, in every store of the vendor
+type Promotion struct {
+	ID       bson.ObjectID `bson:"_id,omitempty" json:"promotion_id"`
+	VendorID bson.ObjectID `bson:"vendor_id" json:"vendor_id"`
+	StoreID  bson.ObjectID `bson:"store_id,omitempty" json:"store_id,omitempty"`
+	Name     string        `bson:"name" json:"name"`
+	Kind     string        `bson:"kind" json:"kind"`
+	// Value is the percentage off or the fixed price of a pack
+	Value float64 `bson:"value,omitempty" json:"value,omitempty"`
+	// Buy and Get make a buy-X-get-Y promotion, every Buy packs come with Get more for free
+	Buy         int             `bson:"buy,omitempty" json:"buy,omitempty"`
+	Get         int             `bson:"get,omitempty" json:"get,omitempty"`
+	ItemIDs     []bson.ObjectID `bson:"item_ids,omitempty" json:"item_ids,omitempty"`
+	CategoryIDs []bson.ObjectID `bson:"category_ids,omitempty" json:"category_ids,omitempty"`
+	StartsAt    time.Time       `bson:"starts_at" json:"starts_at"`
+	EndsAt      time.Time       `bson:"ends_at" json:"ends_at"`
+	Status      string          `bson:"status" json:"status"`
+	CreatedAt   time.Time       `bson:"created_at" json:"created_at"`
+	UpdatedAt   time.Time       `bson:"updated_at" json:"updated_at"`
+}
+
+// ItemPromotion is the promotion a store item is sold under, Price is what one pack costs under it
+type ItemPromotion struct {
+	PromotionID bson.ObjectID `bson:"promotion_id" json:"promotion_id"`
+	Name        string        `bson:"name" json:"name"`
+	Kind        string        `bson:"kind" json:"kind"`
+	Price       float64       `bson:"price" json:"price"`
+	Buy         int           `bson:"buy,omitempty" json:"buy,omitempty"`
+	Get         int           `bson:"get,omitempty" json:"get,omitempty"`
+	StartsAt    time.Time     `bson:"starts_at" json:"starts_at"`
+	EndsAt      time.Time     `bson:"ends_at" json:"ends_at"`
+}
+
+// Coupon is a checkout code, platform coupons are made by admins and have no vendor, vendor coupons are only
+// good at one of the vendor's stores. Limits of 0 are unlimited
+type Coupon struct {
+	ID             bson.ObjectID `bson:"_id,omitempty" json:"coupon_id"`
+	Code           string        `bson:"code" json:"code"`
+	VendorID       bson.ObjectID `bson:"vendor_id,omitempty" json:"vendor_id,omitempty"`
+	StoreID        bson.ObjectID `bson:"store_id,omitempty" json:"store_id,omitempty"`
+	Kind           string        `bson:"kind" json:"kind"`
+	Value          float64       `bson:"value" json:"value"`
+	MinBasket      float64       `bson:"min_basket,omitempty" json:"min_basket,omitempty"`
+	FirstOrderOnly bool          `bson:"first_order_only,omitempty" json:"first_order_only,omitempty"`
+	PerUserLimit   int           `bson:"per_user_limit,omitempty" json:"per_user_limit,omitempty"`
+	TotalLimit     int           `bson:"total_limit,omitempty" json:"total_limit,omitempty"`
+	Redeemed       int           `bson:"redeemed" json:"redeemed"`
+	ExpiresAt      *time.Time    `bson:"expires_at,omitempty" json:"expires_at,omitempty"`
+	CreatedBy      bson.ObjectID `bson:"created_by" json:"created_by"`
+	CreatedAt      time.Time     `bson:"created_at" json:"created_at"`
+}
+
+// CouponRedemption is one use of a coupon, it is released when the order it was for could not be placed
+type CouponRedemption struct {
+	ID       bson.ObjectID `bson:"_id" json:"redemption_id"`
+	CouponID bson.ObjectID `bson:"coupon_id" json:"coupon_id"`
+	Code     string        `bson:"code" json:"code"`
+	UserID   bson.ObjectID `bson:"user_id" json:"user_id"`
+	VendorID bson.ObjectID `bson:"vendor_id" json:"vendor_id"`
+	StoreID  bson.ObjectID `bson:"store_id" json:"store_id"`
+	OrderID  bson.ObjectID `bson:"order_id,omitempty" json:"order_id,omitempty"`
+	Amount   float64       `bson:"amount" json:"amount"`
+	// FirstOrder marks the redemption of a first order coupon, a user can only have one
+	FirstOrder bool      `bson:"first_order,omitempty" json:"first_order,omitempty"`
+	Status     string    `bson:"status" json:"status"`
+	At         time.Time `bson:"at" json:"at"`
+}
+
+// Discount is the coupon on an order, Issuer says whether the platform or the vendor gave it
+type Discount struct {
+	RedemptionID bson.ObjectID `bson:"redemption_id" json:"redemption_id"`
+	CouponID     bson.ObjectID `bson:"coupon_id" json:"coupon_id"`
+	Code         string        `bson:"code" json:"code"`
+	Issuer       string        `bson:"issuer" json:"issuer"`
+	Kind         string        `bson:"kind" json:"kind"`
+	Value        float64       `bson:"value" json:"value"`
+	Amount       float64       `bson:"amount" json:"amount"`
+}
+
+// TaxRule is the tax rate of a region, StoreType and CategoryID narrow it to a kind of store or a catalog
+// category and its subcategories, the most specific rule that matches an order line taxes it
+type TaxRule struct {
+	ID         bson.ObjectID `bson:"_id" json:"rule_id"`
+	Name       string        `bson:"name" json:"name"`
+	Region     string        `bson:"region" json:"region"`
+	StoreType  string        `bson:"store_type,omitempty" json:"store_type,omitempty"`
+	CategoryID bson.ObjectID `bson:"category_id,omitempty" json:"category_id,omitempty"`
+	// Rate is a percentage, 0 makes what the rule matches exempt
+	Rate      float64       `bson:"rate" json:"rate"`
+	CreatedBy bson.ObjectID `bson:"created_by" json:"created_by"`
+	CreatedAt time.Time     `bson:"created_at" json:"created_at"`
+	UpdatedAt time.Time     `bson:"updated_at" json:"updated_at"`
+}
+
+// LinePricing is how an order line adds up, Amount is what its packs cost after promotions and Discount is
+// its share of the order discount, the tax is charged on what is left
+type LinePricing struct {
+	Amount    float64       `bson:"amount" json:"amount"`
+	Discount  float64       `bson:"discount" json:"discount"`
+	TaxRuleID bson.ObjectID `bson:"tax_rule_id,omitempty" json:"tax_rule_id,omitempty"`
+	TaxRate   float64       `bson:"tax_rate" json:"tax_rate"`
+	Tax       float64       `bson:"tax" json:"tax"`
+	Total     float64       `bson:"total" json:"total"`
+}
+
+type PriceBreakdown struct {
+	Subtotal float64 `bson:"subtotal" json:"subtotal"`
+	Discount float64 `bson:"discount" json:"discount"`
+	Fees     []*Fee  `bson:"fees" json:"fees"`
+	Tax      float64 `bson:"tax" json:"tax"`
+	Total    float64 `bson:"total" json:"total"`
+}
+
+type Fee struct {
+	Kind   string  `bson:"kind" json:"kind"`
+	Amount float64 `bson:"amount" json:"amount"`
+}
+
+// Invoice is the number a vendor order was invoiced under, the numbers of a vendor run from 1 without gaps
+type Invoice struct {
+	ID       bson.ObjectID `bson:"_id" json:"invoice_id"`
+	VendorID bson.ObjectID `bson:"vendor_id" json:"vendor_id"`
+	OrderID  bson.ObjectID `bson:"order_id" json:"order_id"`
+	Number   int64         `bson:"number" json:"number"`
+	IssuedAt time.Time     `bson:"issued_at" json:"issued_at"`
+}
+
+// SettlementEntry is an amount of an order the platform owes a vendor, sales are positive and commission and
+// refunds are negative, reversing a commission is positive. StatementID is set once a payout statement covers it
+type SettlementEntry struct {
+	ID          bson.ObjectID `bson:"_id" json:"entry_id"`
+	VendorID    bson.ObjectID `bson:"vendor_id" json:"vendor_id"`
+	StoreID     bson.ObjectID `bson:"store_id" json:"store_id"`
+	OrderID     bson.ObjectID `bson:"order_id" json:"order_id"`
+	Kind        string        `bson:"kind" json:"kind"`
+	Amount      float64       `bson:"amount" json:"amount"`
+	Rate        float64       `bson:"rate,omitempty" json:"rate,omitempty"`
+	StatementID bson.ObjectID `bson:"statement_id,omitempty" json:"statement_id,omitempty"`
+	At          time.Time     `bson:"at" json:"at"`
+}
+
+// PayoutStatement sums the settlement entries of a vendor up to the end of a period, Net is what the platform
+// pays the vendor
+type PayoutStatement struct {
+	ID          bson.ObjectID `bson:"_id" json:"statement_id"`
+	VendorID    bson.ObjectID `bson:"vendor_id" json:"vendor_id"`
+	PeriodStart time.Time     `bson:"period_start" json:"period_start"`
+	PeriodEnd   time.Time     `bson:"period_end" json:"period_end"`
+	Sales       float64       `bson:"sales" json:"sales"`
+	Commission  float64       `bson:"commission" json:"commission"`
+	Refunds     float64       `bson:"refunds" json:"refunds"`
+	Net         float64       `bson:"net" json:"net"`
+	Entries     int           `bson:"entries" json:"entries"`
+	Status      string        `bson:"status" json:"status"`
+	Reference   string        `bson:"reference,omitempty" json:"reference,omitempty"`
+	PaidAt      *time.Time    `bson:"paid_at,omitempty" json:"paid_at,omitempty"`
+	CreatedAt   time.Time     `bson:"created_at" json:"created_at"`
+}
+
+// Payouts is a vendor's settlement account, Pending is what has accrued since the last statement
+type Payouts struct {
+	CommissionRate float64            `json:"commission_rate"`
+	Pending        float64            `json:"pending"`
+	Statements     []*PayoutStatement `json:"statements"`
+}
+
+// CommissionReq sets the commission of a vendor, a nil rate puts the vendor back on the platform rate
+type CommissionReq struct {
+	Rate *float64 `json:"rate"`
+}
+
+type PayoutPaidReq struct {
+	Reference string `json:"reference"`
+}
+
+// Review is a user's rating of a store for one of their delivered orders, an order is reviewed once
+type Review struct {
+	ID        bson.ObjectID `bson:"_id" json:"review_id"`
+	UserID    bson.ObjectID `bson:"user_id" json:"user_id"`
+	UserName  string        `bson:"user_name" json:"user_name"`
+	VendorID  bson.ObjectID `bson:"vendor_id" json:"vendor_id"`
+	StoreID   bson.ObjectID `bson:"store_id" json:"store_id"`
+	OrderID   bson.ObjectID `bson:"order_id" json:"order_id"`
+	Rating    int           `bson:"rating" json:"rating"`
+	Text      string        `bson:"text" json:"text"`
+	Reply     *ReviewReply  `bson:"reply,omitempty" json:"reply,omitempty"`
+	Status    string        `bson:"status" json:"status"`
+	CreatedAt time.Time     `bson:"created_at" json:"created_at"`
+	UpdatedAt time.Time     `bson:"updated_at" json:"updated_at"`
+}
+
+type ReviewReply struct {
+	Text string    `bson:"text" json:"text"`
+	At   time.Time `bson:"at" json:"at"`
+}
+
+// ReviewQuery narrows the reviews listed, zero fields match every review
+type ReviewQuery struct {
+	VendorID bson.ObjectID
+	StoreID  bson.ObjectID
+	Status   string
+	Page     int
+	PageSize int
+}
+
+// ReviewStatusReq is an admin publishing or hiding a review
+type ReviewStatusReq struct {
+	Status string `json:"status"`
+}
+
+// OrderIssue is a problem the user reported with lines of a delivered order, the messages are the conversation
+// between the user, the vendor and an admin about it
+type OrderIssue struct {
+	ID         bson.ObjectID    `bson:"_id" json:"issue_id"`
+	OrderID    bson.ObjectID    `bson:"order_id" json:"order_id"`
+	UserID     bson.ObjectID    `bson:"user_id" json:"user_id"`
+	VendorID   bson.ObjectID    `bson:"vendor_id" json:"vendor_id"`
+	StoreID    bson.ObjectID    `bson:"store_id" json:"store_id"`
+	Lines      []*IssueLine     `bson:"lines" json:"lines"`
+	Status     string           `bson:"status" json:"status"`
+	Resolution *IssueResolution `bson:"resolution,omitempty" json:"resolution,omitempty"`
+	Messages   []*IssueMessage  `bson:"messages" json:"messages"`
+	// RespondBy is when the issue goes to the admin queue if the vendor has not resolved it
+	RespondBy   time.Time  `bson:"respond_by" json:"respond_by"`
+	EscalatedAt *time.Time `bson:"escalated_at,omitempty" json:"escalated_at,omitempty"`
+	CreatedAt   time.Time  `bson:"created_at" json:"created_at"`
+	UpdatedAt   time.Time  `bson:"updated_at" json:"updated_at"`
+}
+
+// IssueLine is an order line the issue is about, Quantity is how many of the ordered units are affected
+type IssueLine struct {
+	IngredientID bson.ObjectID `bson:"ingredient_id" json:"ingredient_id"`
+	Name         string        `bson:"name" json:"name"`
+	Kind         string        `bson:"kind" json:"kind"`
+	Quantity     int           `bson:"quantity" json:"quantity"`
+	// Amount is what the user paid for the affected units, the most a refund gives back for the line
+	Amount float64 `bson:"amount" json:"amount"`
+}
+
+type IssueMessage struct {
+	ID       bson.ObjectID `bson:"_id" json:"message_id"`
+	Author   string        `bson:"author" json:"author"`
+	AuthorID bson.ObjectID `bson:"author_id" json:"author_id"`
+	Text     string        `bson:"text" json:"text"`
+	Photos   []*IssuePhoto `bson:"photos,omitempty" json:"photos,omitempty"`
+	At       time.Time     `bson:"at" json:"at"`
+}
+
+// IssuePhoto is a photo attached to a message, the file is in the blob store under Key
+type IssuePhoto struct {
+	ID          bson.ObjectID `bson:"_id" json:"photo_id"`
+	Key         string        `bson:"key" json:"-"`
+	ContentType string        `bson:"content_type" json:"content_type"`
+	Size        int64         `bson:"size" json:"size"`
+}
+
+// IssueResolution is how the vendor or an admin settled the issue, a refund gives Amount back to the user
+type IssueResolution struct {
+	Kind   string    `bson:"kind" json:"kind"`
+	Amount float64   `bson:"amount,omitempty" json:"amount,omitempty"`
+	By     string    `bson:"by" json:"by"`
+	At     time.Time `bson:"at" json:"at"`
+}
+
+// IssueParty is who reads or writes an issue, users and vendors only reach their own issues
+type IssueParty struct {
+	Role string
+	ID   bson.ObjectID
+}
+
+// IssueQuery narrows the issues listed, zero fields match every issue the party can read
+type IssueQuery struct {
+	Party    *IssueParty
+	OrderID  bson.ObjectID
+	Status   string
+	Page     int
+	PageSize int
+}
+
+// IssueReq is a new issue, with photos it is the data field of a multipart form
+type IssueReq struct {
+	Lines []*IssueLine `json:"lines"`
+	Text  string       `json:"text"`
+}
+
+type IssueMessageReq struct {
+	Text string `json:"text"`
+}
+
+// IssueResolveReq settles an issue with a refund of Amount or a replacement, Text is added to the conversation
+type IssueResolveReq struct {
+	Kind   string  `json:"kind"`
+	Amount float64 `json:"amount"`
+	Text   string  `json:"text"`
+}
+
+// LineChange is how a line of an earlier order differs from what the store sells today, prices are per pack
+type LineChange struct {
+	IngredientID   bson.ObjectID `bson:"ingredient_id" json:"ingredient_id"`
+	Name           string        `bson:"name" json:"name"`
+	Kind           string        `bson:"kind" json:"kind"`
+	Quantity       int           `bson:"quantity" json:"quantity"`
+	NewQuantity    int           `bson:"new_quantity" json:"new_quantity"`
+	OldPrice       float64       `bson:"old_price" json:"old_price"`
+	NewPrice       float64       `bson:"new_price,omitempty" json:"new_price,omitempty"`
+	SubstituteID   bson.ObjectID `bson:"substitute_id,omitempty" json:"substitute_id,omitempty"`
+	SubstituteName string        `bson:"substitute_name,omitempty" json:"substitute_name,omitempty"`
+}
+
+// Subscription places the same order at a store every week or every other week. On an edit the zero fields
+// keep their value
+type Subscription struct {
+	ID               bson.ObjectID `bson:"_id" json:"subscription_id"`
+	UserID           bson.ObjectID `bson:"user_id" json:"user_id"`
+	VendorID         bson.ObjectID `bson:"vendor_id" json:"vendor_id"`
+	StoreID          bson.ObjectID `bson:"store_id" json:"store_id"`
+	Items            []*Item       `bson:"items" json:"items"`
+	DeliveryMethod   string        `bson:"delivery_method" json:"delivery_method"`
+	DeliveryLocation *GeoJSON      `bson:"delivery_location,omitempty" json:"delivery_location,omitempty"`
+	Frequency        string        `bson:"frequency" json:"frequency"`
+	Status           string        `bson:"status" json:"status"`
+	// NextRun is when the next order is placed, the user is reminded subscriptionNotice before
+	NextRun time.Time `bson:"next_run" json:"next_run"`
+	// Skips are upcoming occurrences the user does not want placed
+	Skips []time.Time `bson:"skips,omitempty" json:"skips,omitempty"`
+	// RemindedFor is the occurrence the user was last reminded of
+	RemindedFor *time.Time       `bson:"reminded_for,omitempty" json:"-"`
+	LastRun     *SubscriptionRun `bson:"last_run,omitempty" json:"last_run,omitempty"`
+	Upcoming    []*Occurrence    `bson:"-" json:"upcoming,omitempty"`
+	CreatedAt   time.Time        `bson:"created_at" json:"created_at"`
+	UpdatedAt   time.Time        `bson:"updated_at" json:"updated_at"`
+}
+
+// SubscriptionRun is what happened at the last occurrence, the order placed or why none was
+type SubscriptionRun struct {
+	Occurrence time.Time     `bson:"occurrence" json:"occurrence"`
+	OrderID    bson.ObjectID `bson:"order_id,omitempty" json:"order_id,omitempty"`
+	Skipped    bool          `bson:"skipped,omitempty" json:"skipped,omitempty"`
+	Changes    []*LineChange `bson:"changes,omitempty" json:"changes,omitempty"`
+	Error      string        `bson:"error,omitempty" json:"error,omitempty"`
+	At         time.Time     `bson:"at" json:"at"`
+}
+
+type Occurrence struct {
+	At      time.Time `json:"at"`
+	Skipped bool      `json:"skipped"`
+}
+
+// SubscriptionStatusReq pauses or resumes a subscription
+type SubscriptionStatusReq struct {
+	Status string `json:"status"`
+}
+
+// SkipsReq is every upcoming occurrence the user wants skipped, an occurrence left out is placed again
+type SkipsReq struct {
+	Occurrences []time.Time `json:"occurrences"`
+}
+
+// PricePoint is a price a store item was set to, it holds until the next point of the item
+type PricePoint struct {
+	ID            bson.ObjectID `bson:"_id" json:"point_id"`
+	VendorID      bson.ObjectID `bson:"vendor_id" json:"vendor_id"`
+	StoreID       bson.ObjectID `bson:"store_id" json:"store_id"`
+	ItemID        bson.ObjectID `bson:"item_id" json:"item_id"`
+	CatalogID     bson.ObjectID `bson:"catalog_id,omitempty" json:"catalog_id,omitempty"`
+	Name          string        `bson:"name" json:"name"`
+	Price         float64       `bson:"price" json:"price"`
+	PreviousPrice float64       `bson:"previous_price,omitempty" json:"previous_price,omitempty"`
+	Reason        string        `bson:"reason" json:"reason"`
+	At            time.Time     `bson:"at" json:"at"`
+}
+
+// PriceHistory is the price points of an item in a window with the range they moved in
+type PriceHistory struct {
+	Points  []*PricePoint `json:"points"`
+	Low     float64       `json:"low"`
+	High    float64       `json:"high"`
+	Average float64       `json:"average"`
+}
+
+// PriceAlert asks for a price_drop notification when a catalog ingredient gets cheaper at a store within the
+// radius, prices are compared per base unit so pack sizes do not matter. With a target the drop has to reach it
+type PriceAlert struct {
+	ID          bson.ObjectID `bson:"_id,omitempty" json:"alert_id"`
+	UserID      bson.ObjectID `bson:"user_id" json:"user_id"`
+	CatalogID   bson.ObjectID `bson:"catalog_id" json:"catalog_id"`
+	Name        string        `bson:"name" json:"name"`
+	Location    *GeoJSON      `bson:"location" json:"location"`
+	Radius      float64       `bson:"radius" json:"radius"`
+	TargetPrice float64       `bson:"target_price,omitempty" json:"target_price,omitempty"`
+	// LastPrice is the lowest unit price nearby when the alert was last evaluated, 0 while no store sells it
+	LastPrice float64 `bson:"last_price" json:"last_price"`
+	Per       string  `bson:"per,omitempty" json:"per,omitempty"`
+	// Density comes from the catalog ingredient so packs sold by mass and by volume compare
+	Density        float64    `bson:"density,omitempty" json:"-"`
+	LastNotifiedAt *time.Time `bson:"last_notified_at,omitempty" json:"last_notified_at,omitempty"`
+	CreatedAt      time.Time  `bson:"created_at" json:"created_at"`
+}
+
+// PriceDrop is the data of a price_drop notification
+type PriceDrop struct {
+	AlertID       bson.ObjectID `json:"alert_id"`
+	UserID        bson.ObjectID `json:"-"`
+	CatalogID     bson.ObjectID `json:"catalog_id"`
+	Name          string        `json:"name"`
+	VendorID      bson.ObjectID `json:"vendor_id"`
+	StoreID       bson.ObjectID `json:"store_id"`
+	StoreName     string        `json:"store_name"`
+	Item          *Item         `json:"item"`
+	UnitPrice     *UnitPrice    `json:"unit_price"`
+	PreviousPrice float64       `json:"previous_price,omitempty"`
+}
+
+// DeliveryZone is an area the store delivers to, either a polygon or a band of distances from the store
+type DeliveryZone struct {
+	ID       bson.ObjectID `bson:"_id,omitempty" json:"zone_id"`
+	Name     string        `bson:"name" json:"name"`
+	Area     *GeoPolygon   `bson:"area,omitempty" json:"area,omitempty"`
+	MinMiles float64       `bson:"min_miles,omitempty" json:"min_miles,omitempty"`
+	MaxMiles float64       `bson:"max_miles,omitempty" json:"max_miles,omitempty"`
+	Fee      float64       `bson:"fee" json:"fee"`
+	MinOrder float64       `bson:"min_order" json:"min_order"`
+	// EtaMinutes is how long a delivery in the zone usually takes
+	EtaMinutes int `bson:"eta_minutes" json:"eta_minutes"`
+}
+
+// DeliveryQuote is what delivering to a location costs, MeetsMinimum is false when the basket is below the
+// zone's minimum order
+type DeliveryQuote struct {
+	ZoneID       bson.ObjectID `json:"zone_id"`
+	Fee          float64       `json:"fee"`
+	MinOrder     float64       `json:"min_order"`
+	EtaMinutes   int           `json:"eta_minutes"`
+	MeetsMinimum bool          `json:"meets_minimum"`
+}
+
+type StoreHours struct {
+	// TimeZone is the IANA zone the hours and holidays are in
+	TimeZone string           `bson:"time_zone" json:"time_zone"`
+	Weekly   []*OpeningPeriod `bson:"weekly" json:"weekly"`
+	Holidays []*Holiday       `bson:"holidays" json:"holidays"`
+	// OrderCutoff is how many minutes before closing the store stops taking orders
+	OrderCutoff int `bson:"order_cutoff" json:"order_cutoff_minutes"`
+}
+
+// OpeningPeriod is a day's opening as HH:MM times, a close at or before the open is past midnight
+type OpeningPeriod struct {
+	Day   int    `bson:"day" json:"day"`
+	Open  string `bson:"open" json:"open"`
+	Close string `bson:"close" json:"close"`
+}
+
+type Holiday struct {
+	Date string `bson:"date" json:"date"`
+	Name string `bson:"name" json:"name"`
+}
+
+// ImportRowError is a row of an inventory file that can not be imported, Row is its line in the file
+type ImportRowError struct {
+	Row     int    `json:"row"`
+	Field   string `json:"field,omitempty"`
+	Message string `json:"message"`
+}
+
+type ItemChange struct {
+	Before *Item `json:"before"`
+	After  *Item `json:"after"`
+}
+
+// ImportDiff is what an inventory import does to the items of a store
+type ImportDiff struct {
+	Create    []*Item       `json:"create"`
+	Update    []*ItemChange `json:"update"`
+	Delete    []*Item       `json:"delete"`
+	Unchanged int           `json:"unchanged"`
+}
+
+// StockAlert is raised when a store item falls to its reorder threshold or runs out, it stays open until the
+// item is restocked or its level changes
+type StockAlert struct {
+	ID         bson.ObjectID `bson:"_id,omitempty" json:"alert_id"`
+	VendorID   bson.ObjectID `bson:"vendor_id" json:"vendor_id"`
+	StoreID    bson.ObjectID `bson:"store_id" json:"store_id"`
+	StoreName  string        `bson:"store_name" json:"store_name"`
+	ItemID     bson.ObjectID `bson:"item_id" json:"item_id"`
+	Name       string        `bson:"name" json:"name"`
+	Kind       string        `bson:"kind" json:"kind"`
+	Quantity   int           `bson:"quantity" json:"quantity"`
+	Threshold  int           `bson:"threshold" json:"threshold"`
+	CreatedAt  time.Time     `bson:"created_at" json:"created_at"`
+	ResolvedAt *time.Time    `bson:"resolved_at" json:"resolved_at,omitempty"`
+	NotifiedAt *time.Time    `bson:"notified_at,omitempty" json:"notified_at,omitempty"`
+}
+
+// NotificationSettings is where events for an account are delivered, Kinds limits the events sent and is
+// every kind when empty
+type NotificationSettings struct {
+	OwnerID    bson.ObjectID `bson:"_id" json:"-"`
+	WebhookURL string        `bson:"webhook_url" json:"webhook_url"`
+	Secret     string        `bson:"secret,omitempty" json:"secret,omitempty"`
+	Kinds      []string      `bson:"kinds" json:"kinds"`
+	UpdatedAt  time.Time     `bson:"updated_at" json:"updated_at"`
+}
+
+// LedgerEntry is one change to the quantity of a store item, entries are only ever appended so the quantity of
+// an item is the sum of its deltas
+type LedgerEntry struct {
+	ID        bson.ObjectID `bson:"_id,omitempty" json:"entry_id"`
+	VendorID  bson.ObjectID `bson:"vendor_id" json:"vendor_id"`
+	StoreID   bson.ObjectID `bson:"store_id" json:"store_id"`
+	ItemID    bson.ObjectID `bson:"item_id" json:"item_id"`
+	Name      string        `bson:"name" json:"name"`
+	Delta     int           `bson:"delta" json:"delta"`
+	Reason    string        `bson:"reason" json:"reason"`
+	ActorID   bson.ObjectID `bson:"actor_id" json:"actor_id"`
+	ActorRole string        `bson:"actor_role" json:"actor_role"`
+	// RefID is the order behind an order or return entry
+	RefID bson.ObjectID `bson:"ref_id,omitempty" json:"ref_id,omitempty"`
+	At    time.Time     `bson:"at" json:"at"`
+}
+
+type LedgerQuery struct {
+	ItemID   bson.ObjectID
+	Reason   string
+	From     time.Time
+	To       time.Time
+	Page     int
+	PageSize int
+}
+
+type LedgerPage struct {
+	Entries  []*LedgerEntry `json:"entries"`
+	Total    int64          `json:"total"`
+	Page     int            `json:"page"`
+	PageSize int            `json:"page_size"`
+}
+
+// LedgerMismatch is an item whose quantity is not the sum of its ledger entries
+type LedgerMismatch struct {
+	ItemID      bson.ObjectID `bson:"_id" json:"item_id"`
+	Name        string        `bson:"name" json:"name"`
+	Quantity    int           `bson:"quantity" json:"quantity"`
+	LedgerTotal int           `bson:"ledger_total" json:"ledger_total"`
 }
 
 type DeleteReq struct {
@@ -0,0 +1,140 @@
+package main
+
+import (
+	"errors"
+	"math"
+	"net/url"
+	"strconv"
+
+	"go.mongodb.org/mongo-driver/v2/bson"
+)
+
+const (
+	earthRadiusMiles   = 3958.8
+	defaultRadiusMiles = 10.0
+	maxRadiusMiles     = 100.0
+)
+
+func newPoint(lat, lng float64) *GeoJSON {
+	return &GeoJSON{Type: "Point", Coordinates: []float64{lng, lat}}
+}
+
+func (g *GeoJSON) lat() float64 { return g.Coordinates[1] }
+func (g *GeoJSON) lng() float64 { return g.Coordinates[0] }
+
+func (g *GeoJSON) validPoint() error {
+	switch {
+	case g == nil:
+		return errors.New("location is empty")
+	case g.Type != "Point":
+		return errors.New("location type must be Point")
+	case len(g.Coordinates) != 2:
+		return errors.New("location must have exactly two coordinates [lng, lat]")
+	case g.lat() < -90 || g.lat() > 90:
+		return errors.New("latitude must be between -90 and 90")
+	case g.lng() < -180 || g.lng() > 180:
+		return errors.New("longitude must be between -180 and 180")
+	}
+	return nil
+}
+
+// haversineMiles is the great-circle distance between two points, it matches calculateDistance in the frontend
+func haversineMiles(a, b *GeoJSON) float64 {
+	toRad := func(d float64) float64 { return d * math.Pi / 180 }
+
+	dLat, dLng := toRad(b.lat()-a.lat()), toRad(b.lng()-a.lng())
+	h := math.Sin(dLat/2)*math.Sin(dLat/2) +
+		math.Cos(toRad(a.lat()))*math.Cos(toRad(b.lat()))*math.Sin(dLng/2)*math.Sin(dLng/2)
+	return earthRadiusMiles * 2 * math.Atan2(math.Sqrt(h), math.Sqrt(1-h))
+}
+
+// geoWithinFilter matches documents having at least one point under key inside the circle, it is served by
+// the 2dsphere index on that key
+func geoWithinFilter(key string, center *GeoJSON, radius float64) bson.E {
+	return bson.E{Key: key, Value: bson.M{"$geoWithin": bson.M{
+		"$centerSphere": bson.A{bson.A{center.lng(), center.lat()}, radius / earthRadiusMiles},
+	}}}
+}
+
+func validRadius(radius float64) (float64, error) {
+	switch {
+	case radius == 0:
+		return defaultRadiusMiles, nil
+	case radius < 0:
+		return 0, errors.New("radius cannot be negative")
+	case radius > maxRadiusMiles:
+		return 0, errors.New("radius cannot be more than 100 miles")
+	}
+	return radius, nil
+}
+
+func parseLocationQuery(q url.Values) (*GeoJSON, float64, error) {
+	lat, err := strconv.ParseFloat(q.Get("lat"), 64)
+	if err != nil {
+		return nil, 0, errors.New("lat is missing or invalid")
+	}
+	lng, err := strconv.ParseFloat(q.Get("lng"), 64)
+	if err != nil {
+		return nil, 0, errors.New("lng is missing or invalid")
+	}
+	point := newPoint(lat, lng)
+	if err := point.validPoint(); err != nil {
+		return nil, 0, err
+	}
+
+	var radius float64
+	if r := q.Get("radius"); r != "" {
+		if radius, err = strconv.ParseFloat(r, 64); err != nil {
+			return nil, 0, errors.New("radius is invalid")
+		}
+	}
+	if radius, err = validRadius(radius); err != nil {
+		return nil, 0, err
+	}
+	return point, radius, nil
+}
+
+func (p *GeoPolygon) validate() error {
+	if p.Type != "Polygon" {
+		return errors.New("area type must be Polygon")
+	}
+	if len(p.Coordinates) == 0 {
+		return errors.New("area must have an outline")
+	}
+	for _, ring := range p.Coordinates {
+		if len(ring) < 4 || ring[0] != ring[len(ring)-1] {
+			return errors.New("area rings must be closed with at least four positions")
+		}
+		for _, position := range ring {
+			if position[0] < -180 || position[0] > 180 || position[1] < -90 || position[1] > 90 {
+				return errors.New("area positions must be [lng, lat] inside the valid ranges")
+			}
+		}
+	}
+	return nil
+}
+
+// contains is whether the point is inside the outline and outside every hole, the rings are treated as flat
+// which is close enough for the size of a delivery area
+func (p *GeoPolygon) contains(point *GeoJSON) bool {
+	inRing := func(ring [][2]float64) bool {
+		inside := false
+		x, y := point.lng(), point.lat()
+		for i, j := 0, len(ring)-1; i < len(ring); j, i = i, i+1 {
+			xi, yi, xj, yj := ring[i][0], ring[i][1], ring[j][0], ring[j][1]
+			if (yi > y) != (yj > y) && x < (xj-xi)*(y-yi)/(yj-yi)+xi {
+				inside = !inside
+			}
+		}
+		return inside
+	}
+	if len(p.Coordinates) == 0 || !inRing(p.Coordinates[0]) {
+		return false
+	}
+	for _, hole := range p.Coordinates[1:] {
+		if inRing(hole) {
+			return false
+		}
+	}
+	return true
+}
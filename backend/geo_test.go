@@ -0,0 +1,47 @@
+package main
+
+import (
+	"math"
+	"net/url"
+	"testing"
+)
+
+func TestHaversineMiles(t *testing.T) {
+	newYork, losAngeles := newPoint(40.7128, -74.0060), newPoint(34.0522, -118.2437)
+
+	if d := haversineMiles(newYork, newYork); d != 0 {
+		t.Fatalf("distance to self should be 0 got %f", d)
+	}
+	if d := haversineMiles(newYork, losAngeles); math.Abs(d-2445) > 5 {
+		t.Fatalf("NY to LA should be about 2445 miles got %f", d)
+	}
+	if a, b := haversineMiles(newYork, losAngeles), haversineMiles(losAngeles, newYork); a != b {
+		t.Fatalf("distance is not symmetric %f vs %f", a, b)
+	}
+}
+
+func TestParseLocationQuery(t *testing.T) {
+	tests := []struct {
+		query  string
+		radius float64
+		fail   bool
+	}{
+		{"lat=40.7&lng=-74", defaultRadiusMiles, false},
+		{"lat=40.7&lng=-74&radius=25", 25, false},
+		{"lng=-74", 0, true},
+		{"lat=91&lng=-74", 0, true},
+		{"lat=40.7&lng=-74&radius=-1", 0, true},
+		{"lat=40.7&lng=-74&radius=500", 0, true},
+	}
+
+	for _, test := range tests {
+		q, _ := url.ParseQuery(test.query)
+		_, radius, err := parseLocationQuery(q)
+		if (err != nil) != test.fail {
+			t.Fatalf("%s -> expected failure %t got %v", test.query, test.fail, err)
+		}
+		if radius != test.radius {
+			t.Fatalf("%s -> expected radius %f got %f", test.query, test.radius, radius)
+		}
+	}
+}
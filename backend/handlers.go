@@ -1,12 +1,21 @@
 package main
 
 import (
+	"bytes"
+	"cmp"
 	"context"
+	"encoding/csv"
 	"encoding/json"
 	"errors"
+	"fmt"
 	"io"
 	"log/slog"
+	"mime/multipart"
 	"net/http"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
 
 	"go.mongodb.org/mongo-driver/v2/bson"
 )
@@ -151,6 +160,95 @@ func CreateUserOrders(w http.ResponseWriter, r *http.Request) {
 		sendFailure(ctx, w, "Error in parsing userOrders request body", source)
 		return
 	}
+
+	deferClosed := false
+	if value := r.URL.Query().Get("defer"); value != "" {
+		if deferClosed, err = strconv.ParseBool(value); err != nil {
+			sendFailure(ctx, w, "defer must be true or false", source)
+			return
+		}
+	}
+	now := time.Now()
+	stores := make([]*Store, len(req.Orders))
+	taxRules := make([][]*TaxRule, len(req.Orders))
+	taxCategories := make([]map[bson.ObjectID][]bson.ObjectID, len(req.Orders))
+	for i, order := range req.Orders {
+		store, err := Repos.Vendor.FindStore(ctx, ID{order.VendorID}, ID{order.StoreID})
+		if err != nil {
+			if errors.Is(err, &NoItems{}) {
+				sendFailure(ctx, w, "Store not found for order", source)
+				return
+			}
+			sendFailure(ctx, w, "Failed to fetch the store", source)
+			return
+		}
+		if err := checkOrderWindow(store, &order.Order, now, deferClosed); err != nil {
+			Logger.InfoContext(ctx, "Order outside the store hours", slog.String("storeID", store.ID.Hex()),
+				slog.Any("error", err), source)
+			sendFailure(ctx, w, err.Error(), source)
+			return
+		}
+		fellBack, err := checkDelivery(store, &order.Order)
+		if err != nil {
+			Logger.InfoContext(ctx, "Order can not be priced or delivered", slog.String("storeID", store.ID.Hex()),
+				slog.Any("error", err), source)
+			sendFailure(ctx, w, err.Error(), source)
+			return
+		}
+		if fellBack {
+			Logger.InfoContext(ctx, "Delivery out of range, the order is a pickup", slog.String("storeID", store.ID.Hex()),
+				source)
+		}
+		stores[i] = store
+		if taxRules[i], taxCategories[i], err = Repos.Tax.FindOrderTaxes(ctx, store.Region, order.Items); err != nil {
+			sendFailure(ctx, w, "Failed to fetch the tax rules", source)
+			return
+		}
+	}
+
+	userID, err := getID(r.Context(), source)
+	if err != nil {
+		sendFailure(ctx, w, "Unable to get user ID from context", source)
+		return
+	}
+	for i, order := range req.Orders {
+		if order.Slot == nil {
+			continue
+		}
+		hold, err := Repos.Slot.BookHold(ctx, userID, order.Slot.HoldID, order.StoreID, order.DeliveryMethod)
+		if err != nil {
+			releaseSlotHolds(ctx, userID, req.Orders[:i], source)
+			if errors.Is(err, errNoHold) {
+				sendFailure(ctx, w, fmt.Sprintf("The %s slot hold has expired or does not match the order", order.DeliveryMethod), source)
+				return
+			}
+			sendFailure(ctx, w, "Failed to book the delivery slot", source)
+			return
+		}
+		order.Slot.Start, order.Slot.End = hold.Start, hold.End
+	}
+	for i, order := range req.Orders {
+		order.Discount = nil
+		if order.CouponCode == "" {
+			continue
+		}
+		discount, err := Repos.Coupon.RedeemCoupon(ctx, userID, order, now)
+		if err != nil {
+			releaseCoupons(ctx, req.Orders[:i], source)
+			releaseSlotHolds(ctx, userID, req.Orders, source)
+			var rejected *couponError
+			if errors.As(err, &rejected) {
+				sendFailure(ctx, w, rejected.Error(), source)
+				return
+			}
+			sendFailure(ctx, w, "Failed to redeem the coupon", source)
+			return
+		}
+		applyDiscount(&order.Order, discount)
+	}
+	for i, order := range req.Orders {
+		taxOrder(stores[i], &order.Order, taxRules[i], taxCategories[i])
+	}
 	createCon(ctx, w, r, source, req.Orders)
 }
 
@@ -181,7 +279,13 @@ func VendorComparedItemsValue(w http.ResponseWriter, r *http.Request) {
 	}
 	Logger.InfoContext(ctx, "Decoded the ReqIng Struct successfully", source)
 
-	res, err := Repos.Vendor.FindAllIngredients(ctx, req.Compare)
+	if err := req.validate(); err != nil {
+		Logger.ErrorContext(ctx, "Invalid comparison request", slog.Any("error", err), source)
+		sendFailure(ctx, w, err.Error(), source)
+		return
+	}
+
+	res, err := Repos.Vendor.FindAllIngredients(ctx, req)
 	if err != nil {
 		if errors.Is(err, &NoItems{}) {
 			Logger.ErrorContext(ctx, "No items found", slog.Any("error", err))
@@ -207,6 +311,50 @@ func VendorComparedItemsValue(w http.ResponseWriter, r *http.Request) {
 	sendResponse(ctx, w, http.StatusOK, &okResponseMap, source)
 }
 
+func OptimizeBasket(w http.ResponseWriter, r *http.Request) {
+	ctx, span := Tracer.Start(r.Context(), "OptimizeBasket")
+	defer span.End()
+	source := slog.String("source", "OptimizeBasket")
+
+	Logger.InfoContext(ctx, "Getting the optimize request", source)
+	req, err := decodeStruct[OptimizeReq](ctx, r.Body, source)
+	if err != nil {
+		sendFailure(ctx, w, "Error in parsing optimize request body", source)
+		return
+	}
+
+	if err := req.validate(); err != nil {
+		Logger.ErrorContext(ctx, "Invalid optimize request", slog.Any("error", err), source)
+		sendFailure(ctx, w, err.Error(), source)
+		return
+	}
+
+	res, err := Repos.Vendor.FindAllIngredients(ctx, &req.ReqIngArray)
+	if err != nil {
+		if errors.Is(err, &NoItems{}) {
+			sendFailure(ctx, w, "No items found", source)
+			return
+		}
+		sendFailure(ctx, w, "Error in fetching store items", source)
+		return
+	}
+
+	plan, err := optimizeBasket(res, req)
+	if err != nil {
+		Logger.ErrorContext(ctx, "Unable to build a basket plan", slog.Any("error", err), source)
+		sendFailure(ctx, w, err.Error(), source)
+		return
+	}
+
+	Logger.InfoContext(ctx, "Basket plan built", slog.Int("carts", len(plan.Carts)),
+		slog.Float64("totalPrice", plan.TotalPrice), source)
+	okResponseMap := map[string]any{
+		"success": true,
+		"plan":    plan,
+	}
+	sendResponse(ctx, w, http.StatusOK, &okResponseMap, source)
+}
+
 func CreateStores(w http.ResponseWriter, r *http.Request) {
 	ctx, span := Tracer.Start(r.Context(), "CreateStores")
 	defer span.End()
@@ -218,6 +366,17 @@ func CreateStores(w http.ResponseWriter, r *http.Request) {
 		sendFailure(ctx, w, "Error in parsing Stores request body", source)
 		return
 	}
+	for _, store := range req.Stores {
+		store.Region, store.Rating = normalizeRegion(store.Region), nil
+		if err := store.validate(); err != nil {
+			sendFailure(ctx, w, err.Error(), source)
+			return
+		}
+	}
+	if err := linkToCatalog(ctx, req.Stores, false, source); err != nil {
+		sendFailure(ctx, w, err.Error(), source)
+		return
+	}
 	createCon(ctx, w, r, source, req.Stores)
 }
 
@@ -324,13 +483,7 @@ func AdminGetIngredients(w http.ResponseWriter, r *http.Request) {
 
 	Logger.InfoContext(ctx, "Admin retrieving all ingredients", source)
 
-	id, err := getID(r.Context(), source)
-	if err != nil {
-		sendFailure(ctx, w, "Unable to get admin ID", source)
-		return
-	}
-
-	ingredients, err := Repos.Admin.FindIngredients(ctx, id)
+	ingredients, err := Repos.Catalog.FindAllCatalogIngredients(ctx)
 	if err != nil {
 		Logger.ErrorContext(ctx, "Failed to fetch ingredients", slog.Any("error", err), source)
 		sendFailure(ctx, w, "Failed to fetch ingredients", source)
@@ -428,10 +581,15 @@ func AdminCreateIngredients(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	ids, err := Repos.Admin.CreateIngredients(ctx, id, req.Ingredients)
+	ingredients := make([]*CatalogIngredient, len(req.Ingredients))
+	for i, ingredient := range req.Ingredients {
+		ingredients[i] = &CatalogIngredient{Ingredient: *ingredient}
+	}
+
+	ids, err := Repos.Catalog.CreateCatalogIngredients(ctx, id, ingredients)
 	if err != nil {
 		Logger.ErrorContext(ctx, "Failed to create ingredients", slog.Any("error", err), source)
-		sendFailure(ctx, w, "Failed to create ingredients", source)
+		sendFailure(ctx, w, err.Error(), source)
 		return
 	}
 
@@ -471,10 +629,25 @@ func AdminUpdateIngredients(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	if err := Repos.Admin.UpdateIngredients(ctx, id, req.Ingredients); err != nil {
-		Logger.ErrorContext(ctx, "Failed to update ingredients", slog.Any("error", err), source)
-		sendFailure(ctx, w, "Failed to update ingredients", source)
-		return
+	// ingredients without an id are new ones, as they were when admins kept their own list
+	var added []*CatalogIngredient
+	for _, ingredient := range req.Ingredients {
+		if ingredient.IngredientID == bson.NilObjectID {
+			added = append(added, &CatalogIngredient{Ingredient: *ingredient})
+			continue
+		}
+		if err := Repos.Catalog.UpdateCatalogIngredient(ctx, id, &CatalogIngredient{Ingredient: *ingredient}); err != nil {
+			Logger.ErrorContext(ctx, "Failed to update ingredients", slog.Any("error", err), source)
+			sendFailure(ctx, w, err.Error(), source)
+			return
+		}
+	}
+	if len(added) > 0 {
+		if _, err := Repos.Catalog.CreateCatalogIngredients(ctx, id, added); err != nil {
+			Logger.ErrorContext(ctx, "Failed to add ingredients", slog.Any("error", err), source)
+			sendFailure(ctx, w, err.Error(), source)
+			return
+		}
 	}
 
 	okResponseMap := map[string]any{
@@ -525,10 +698,12 @@ func AdminDeleteIngredients(w http.ResponseWriter, r *http.Request) {
 		ids[i] = &id
 	}
 
-	if err := Repos.Admin.DeleteIngredients(ctx, adminID, ids); err != nil {
-		Logger.ErrorContext(ctx, "Failed to delete ingredients", slog.Any("error", err), source)
-		sendFailure(ctx, w, "Failed to delete ingredients", source)
-		return
+	for _, id := range ids {
+		if err := Repos.Catalog.DeleteCatalogIngredient(ctx, adminID, *id); err != nil {
+			Logger.ErrorContext(ctx, "Failed to delete ingredients", slog.Any("error", err), source)
+			sendFailure(ctx, w, err.Error(), source)
+			return
+		}
 	}
 
 	okResponseMap := map[string]any{
@@ -635,698 +810,3516 @@ func GetItems(w http.ResponseWriter, r *http.Request) {
 	sendResponse(ctx, w, http.StatusOK, &okResponseMap, source)
 }
 
-func GetUserAdminIngredients(w http.ResponseWriter, r *http.Request) {
-	ctx, span := Tracer.Start(r.Context(), "GetUserAdminIngredients")
-	defer span.End()
-
-	sendIngredients(ctx, w, slog.String("source", "GetUserAdminIngredients"))
-}
-
-func GetVendorAdminIngredients(w http.ResponseWriter, r *http.Request) {
-	ctx, span := Tracer.Start(r.Context(), "GetVendorAdminIngredients")
+func GetNearbyStores(w http.ResponseWriter, r *http.Request) {
+	ctx, span := Tracer.Start(r.Context(), "GetNearbyStores")
 	defer span.End()
+	source := slog.String("source", "GetNearbyStores")
 
-	sendIngredients(ctx, w, slog.String("source", "GetVendorAdminIngredients"))
-}
-
-func sendIngredients(ctx context.Context, w http.ResponseWriter, source slog.Attr) {
-	Logger.InfoContext(ctx, "Getting the Admin Ingredients", source)
-	res, err := getAllIngredients(ctx, source)
+	Logger.InfoContext(ctx, "Validating the location query params", source)
+	center, radius, err := parseLocationQuery(r.URL.Query())
 	if err != nil {
-		Logger.ErrorContext(ctx, "Failed to fetch Admin ingredients", slog.Any("error", err), source)
-		sendFailure(ctx, w, "Failed to fetch Admin ingredients", source)
+		Logger.ErrorContext(ctx, "Invalid location query params", slog.Any("error", err), source)
+		sendFailure(ctx, w, err.Error(), source)
 		return
 	}
+	Logger.InfoContext(ctx, "Validated Successfully", source)
 
-	b, err := json.Marshal(res)
+	stores, err := Repos.Vendor.FindNearbyStores(ctx, center, radius, r.URL.Query().Get("type"))
 	if err != nil {
-		Logger.ErrorContext(ctx, "Failed to marshall the ingredients array to string", slog.Any("error", err), source)
-		sendFailure(ctx, w, "Failed to marshall the ingredients array to string", source)
+		if errors.Is(err, &NoItems{}) {
+			sendFailure(ctx, w, "No stores found nearby", source)
+			return
+		}
+		sendFailure(ctx, w, "Error in fetching nearby stores", source)
 		return
 	}
 
 	okResponseMap := map[string]any{
 		"success": true,
-		"message": string(b),
+		"stores":  stores,
 	}
 	sendResponse(ctx, w, http.StatusOK, &okResponseMap, source)
 }
 
-func GetUser(w http.ResponseWriter, r *http.Request) {
-	ctx, span := Tracer.Start(r.Context(), "GetUser")
+// GetStoreSlots lists the store's upcoming slots with the places left, method and days narrow them down
+func GetStoreSlots(w http.ResponseWriter, r *http.Request) {
+	ctx, span := Tracer.Start(r.Context(), "GetStoreSlots")
 	defer span.End()
-	source := slog.String("source", "GetUser")
+	source := slog.String("source", "GetStoreSlots")
 
-	Logger.InfoContext(ctx, "Getting the User", source)
-	id, err := getID(ctx, source)
+	vendorID, err := NewID(ctx, r.PathValue("vendor"))
 	if err != nil {
-		Logger.ErrorContext(ctx, "Unable to get the id from the token", slog.Any("error", err), source)
-		sendFailure(ctx, w, err.Error(), source)
+		sendFailure(ctx, w, "Invalid vendor id", source)
 		return
 	}
-	user, err := Repos.User.FindUserByID(ctx, id)
+	storeID, err := NewID(ctx, r.PathValue("store"))
 	if err != nil {
-		sendFailure(ctx, w, err.Error(), source)
+		sendFailure(ctx, w, "Invalid store id", source)
 		return
 	}
+	method := r.URL.Query().Get("method")
+	if method != "" {
+		if method = deliveryMethod(method); method == "" {
+			sendFailure(ctx, w, "method must be delivery or pickup", source)
+			return
+		}
+	}
+	days := defaultSlotDays
+	if value := r.URL.Query().Get("days"); value != "" {
+		if days, err = strconv.Atoi(value); err != nil || days < 1 || days > maxSlotDays {
+			sendFailure(ctx, w, fmt.Sprintf("days must be between 1 and %d", maxSlotDays), source)
+			return
+		}
+	}
 
-	s, err := json.Marshal(user)
+	store, err := Repos.Vendor.FindStore(ctx, vendorID, storeID)
 	if err != nil {
-		Logger.ErrorContext(ctx, "Error in marshalling to string", slog.Any("error", err), source)
-		sendFailure(ctx, w, "Error in fetching user", source)
+		if errors.Is(err, &NoItems{}) {
+			sendFailure(ctx, w, "Store not found", source)
+			return
+		}
+		sendFailure(ctx, w, "Failed to fetch the store", source)
+		return
+	}
+
+	slots := store.slots(vendorID.value, method, time.Now(), days)
+	keys := make([]string, len(slots))
+	for i, slot := range slots {
+		keys[i] = slot.ID
+	}
+	used, err := Repos.Slot.FindSlotUsage(ctx, keys)
+	if err != nil {
+		sendFailure(ctx, w, "Failed to fetch slot availability", source)
 		return
 	}
+	for _, slot := range slots {
+		slot.Available = max(slot.Capacity-used[slot.ID], 0)
+	}
 
 	okResponseMap := map[string]any{
 		"success": true,
-		"ids":     string(s),
+		"slots":   slots,
 	}
-
 	sendResponse(ctx, w, http.StatusOK, &okResponseMap, source)
 }
 
-func GetRecipes(w http.ResponseWriter, r *http.Request) {
-	ctx, span := Tracer.Start(r.Context(), "GetRecipes")
-	defer span.End()
-	source := slog.String("source", "GetRecipes")
-
-	Logger.InfoContext(ctx, "Getting the Recipes", source)
-	var recipes []*Recipe
-	getCon(ctx, w, r, recipes, source)
-}
-
-func UpdateCarts(w http.ResponseWriter, r *http.Request) {
-	ctx, span := Tracer.Start(r.Context(), "UpdateCarts")
+// HoldSlot keeps a place in a slot for the user while they check out, the order books it
+func HoldSlot(w http.ResponseWriter, r *http.Request) {
+	ctx, span := Tracer.Start(r.Context(), "HoldSlot")
 	defer span.End()
-	source := slog.String("source", "UpdateCarts")
+	source := slog.String("source", "HoldSlot")
 
-	Logger.InfoContext(ctx, "Updating Carts", source)
-	req, err := decodeStruct[RequestCarts](ctx, r.Body, source)
+	userID, err := getID(r.Context(), source)
 	if err != nil {
-		sendFailure(ctx, w, "Error in parsing carts request body", source)
+		sendFailure(ctx, w, "Unable to get user ID from context", source)
+		return
+	}
+	req, err := decodeStruct[SlotHoldReq](ctx, r.Body, source)
+	if err != nil {
+		sendFailure(ctx, w, "Error in parsing slot hold request body", source)
+		return
+	}
+	method := deliveryMethod(req.Method)
+	if method == "" {
+		sendFailure(ctx, w, "method must be delivery or pickup", source)
 		return
 	}
-	createCon(ctx, w, r, source, req.Carts)
-}
 
-func UpdateUserOrders(w http.ResponseWriter, r *http.Request) {
-	ctx, span := Tracer.Start(r.Context(), "UpdateUserOrders")
-	defer span.End()
-	source := slog.String("source", "UpdateUserOrders")
+	store, err := Repos.Vendor.FindStore(ctx, ID{req.VendorID}, ID{req.StoreID})
+	if err != nil {
+		if errors.Is(err, &NoItems{}) {
+			sendFailure(ctx, w, "Store not found", source)
+			return
+		}
+		sendFailure(ctx, w, "Failed to fetch the store", source)
+		return
+	}
+	slot := store.findSlot(req.VendorID, method, req.Start, time.Now())
+	if slot == nil {
+		sendFailure(ctx, w, "The store has no such upcoming slot", source)
+		return
+	}
 
-	Logger.InfoContext(ctx, "Updating UserOrders", source)
-	req, err := decodeStruct[RequestUserOrders](ctx, r.Body, source)
+	hold, err := Repos.Slot.HoldSlot(ctx, userID, slot)
 	if err != nil {
-		sendFailure(ctx, w, "Error in parsing userOrders request body", source)
+		if errors.Is(err, errSlotFull) {
+			sendFailure(ctx, w, "The slot is full", source)
+			return
+		}
+		sendFailure(ctx, w, "Failed to hold the slot", source)
 		return
 	}
-	updateCon(ctx, w, r, source, req.Orders)
+
+	okResponseMap := map[string]any{
+		"success": true,
+		"hold":    hold,
+	}
+	sendResponse(ctx, w, http.StatusCreated, &okResponseMap, source)
 }
 
-func UpdateVendorOrders(w http.ResponseWriter, r *http.Request) {
-	ctx, span := Tracer.Start(r.Context(), "UpdateVendorOrders")
+func ReleaseSlotHold(w http.ResponseWriter, r *http.Request) {
+	ctx, span := Tracer.Start(r.Context(), "ReleaseSlotHold")
 	defer span.End()
-	source := slog.String("source", "UpdateVendorOrders")
+	source := slog.String("source", "ReleaseSlotHold")
 
-	Logger.InfoContext(ctx, "Updating VendorOrders", source)
-	req, err := decodeStruct[RequestVendorOrders](ctx, r.Body, source)
+	userID, err := getID(r.Context(), source)
 	if err != nil {
-		sendFailure(ctx, w, "Error in parsing VendorOrders request body", source)
+		sendFailure(ctx, w, "Unable to get user ID from context", source)
 		return
 	}
-	updateCon(ctx, w, r, source, req.Orders)
+	holdID, err := NewID(ctx, r.PathValue("id"))
+	if err != nil {
+		sendFailure(ctx, w, "Invalid hold id", source)
+		return
+	}
+
+	if err := Repos.Slot.ReleaseHold(ctx, userID, holdID.value); err != nil {
+		if errors.Is(err, &NoItems{}) {
+			sendFailure(ctx, w, "Hold not found", source)
+			return
+		}
+		sendFailure(ctx, w, "Failed to release the hold", source)
+		return
+	}
+
+	okResponseMap := map[string]any{"success": true}
+	sendResponse(ctx, w, http.StatusOK, &okResponseMap, source)
 }
 
-func UpdateStores(w http.ResponseWriter, r *http.Request) {
-	ctx, span := Tracer.Start(r.Context(), "UpdateStores")
+func GetUserAdminIngredients(w http.ResponseWriter, r *http.Request) {
+	ctx, span := Tracer.Start(r.Context(), "GetUserAdminIngredients")
 	defer span.End()
-	source := slog.String("source", "UpdateStores")
 
-	Logger.InfoContext(ctx, "Updating stores ", source)
-	req, err := decodeStruct[RequestStores](ctx, r.Body, source)
-	if err != nil {
-		sendFailure(ctx, w, "Error in parsing Stores request body", source)
-		return
-	}
-	updateCon(ctx, w, r, source, req.Stores)
+	sendIngredients(ctx, w, r, slog.String("source", "GetUserAdminIngredients"))
 }
 
-func AcceptUserOrder(w http.ResponseWriter, r *http.Request) {
-	ctx, span := Tracer.Start(r.Context(), "AcceptUserOrder")
+func GetVendorAdminIngredients(w http.ResponseWriter, r *http.Request) {
+	ctx, span := Tracer.Start(r.Context(), "GetVendorAdminIngredients")
 	defer span.End()
-	source := slog.String("source", "AcceptUserOrder")
 
-	Logger.InfoContext(ctx, "Processing order status update", source)
-	var req AcceptUserOrderReq
-	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
-		Logger.ErrorContext(ctx, "Unable to parse request body", slog.Any("error", err), source)
-		sendFailure(ctx, w, "Error in parsing request body", source)
-		return
-	}
+	sendIngredients(ctx, w, r, slog.String("source", "GetVendorAdminIngredients"))
+}
 
-	Logger.InfoContext(ctx, "Validating request fields", source)
-	switch {
-	case req.UserID == bson.NilObjectID:
-		sendFailure(ctx, w, "User ID is required", source)
-		return
-	case req.OrderID == bson.NilObjectID:
-		sendFailure(ctx, w, "Order ID is required", source)
-		return
-	case req.OrderStatus == "":
-		sendFailure(ctx, w, "Order status is required", source)
+// sendIngredients answers with a page of the catalog, the ingredients stay a json string in message for the
+// clients written against the old flat ingredient list
+func sendIngredients(ctx context.Context, w http.ResponseWriter, r *http.Request, source slog.Attr) {
+	Logger.InfoContext(ctx, "Getting the catalog ingredients", source)
+	query, err := parseCatalogQuery(r.URL.Query())
+	if err != nil {
+		Logger.ErrorContext(ctx, "Invalid catalog query", slog.Any("error", err), source)
+		sendFailure(ctx, w, err.Error(), source)
 		return
 	}
-	Logger.InfoContext(ctx, "Validated Successfully", source)
 
-	vendorID, err := getID(r.Context(), source)
+	page, err := Repos.Catalog.FindCatalog(ctx, query)
 	if err != nil {
-		sendFailure(ctx, w, "Unable to get vendor ID from context", source)
+		Logger.ErrorContext(ctx, "Failed to fetch catalog ingredients", slog.Any("error", err), source)
+		sendFailure(ctx, w, "Failed to fetch catalog ingredients", source)
 		return
 	}
 
-	if err := Repos.Vendor.UpdateUserOrder(ctx, vendorID, &req); err != nil {
-		sendFailure(ctx, w, "Failed to update order status", source)
+	b, err := json.Marshal(page.Ingredients)
+	if err != nil {
+		Logger.ErrorContext(ctx, "Failed to marshall the ingredients array to string", slog.Any("error", err), source)
+		sendFailure(ctx, w, "Failed to marshall the ingredients array to string", source)
 		return
 	}
 
 	okResponseMap := map[string]any{
-		"success": true,
-		"message": "Order status updated successfully",
+		"success":   true,
+		"message":   string(b),
+		"total":     page.Total,
+		"page":      page.Page,
+		"page_size": page.PageSize,
 	}
 	sendResponse(ctx, w, http.StatusOK, &okResponseMap, source)
 }
 
-func UpdateRecipes(w http.ResponseWriter, r *http.Request) {
-	ctx, span := Tracer.Start(r.Context(), "UpdateRecipes")
+func GetCatalog(w http.ResponseWriter, r *http.Request) {
+	ctx, span := Tracer.Start(r.Context(), "GetCatalog")
 	defer span.End()
-	source := slog.String("source", "UpdateRecipes")
+	source := slog.String("source", "GetCatalog")
 
-	Logger.InfoContext(ctx, "Updating recipes", source)
-	req, err := decodeStruct[RequestRecipes](ctx, r.Body, source)
+	Logger.InfoContext(ctx, "Searching the catalog", source)
+	query, err := parseCatalogQuery(r.URL.Query())
 	if err != nil {
-		sendFailure(ctx, w, "Error in parsing recipes request body", source)
+		Logger.ErrorContext(ctx, "Invalid catalog query", slog.Any("error", err), source)
+		sendFailure(ctx, w, err.Error(), source)
 		return
 	}
-	updateCon(ctx, w, r, source, req.Recipes)
+
+	page, err := Repos.Catalog.FindCatalog(ctx, query)
+	if err != nil {
+		sendFailure(ctx, w, "Failed to search the catalog", source)
+		return
+	}
+
+	okResponseMap := map[string]any{
+		"success": true,
+		"catalog": page,
+	}
+	sendResponse(ctx, w, http.StatusOK, &okResponseMap, source)
 }
 
-func DeleteCarts(w http.ResponseWriter, r *http.Request) {
-	ctx, span := Tracer.Start(r.Context(), "DeleteCarts")
+func GetCatalogIngredient(w http.ResponseWriter, r *http.Request) {
+	ctx, span := Tracer.Start(r.Context(), "GetCatalogIngredient")
 	defer span.End()
-	source := slog.String("source", "DeleteCarts")
+	source := slog.String("source", "GetCatalogIngredient")
 
-	Logger.InfoContext(ctx, "Deleting carts ", source)
-	id, err := getID(r.Context(), source)
+	id, err := NewID(ctx, r.PathValue("id"))
 	if err != nil {
-		Logger.ErrorContext(ctx, "Error in getting id from the token", slog.Any("error", err), source)
-		sendFailure(ctx, w, err.Error(), source)
+		sendFailure(ctx, w, "Invalid id", source)
 		return
 	}
 
-	ids, err := decodeToIDS(ctx, r.Body, source)
+	ingredient, err := Repos.Catalog.FindCatalogIngredient(ctx, id)
 	if err != nil {
-		Logger.ErrorContext(ctx, "Error in getting ids from body", slog.Any("error", err), source)
-		sendFailure(ctx, w, err.Error(), source)
+		if errors.Is(err, &NoItems{}) {
+			sendFailure(ctx, w, "Catalog ingredient not found", source)
+			return
+		}
+		sendFailure(ctx, w, "Failed to fetch the catalog ingredient", source)
 		return
 	}
 
-	if err := Repos.User.DeleteCarts(ctx, id, ids); err != nil {
-		Logger.ErrorContext(ctx, "Error in deleting carts", slog.Any("error", err), source)
-		sendFailure(ctx, w, err.Error(), source)
+	okResponseMap := map[string]any{
+		"success":    true,
+		"ingredient": ingredient,
+	}
+	sendResponse(ctx, w, http.StatusOK, &okResponseMap, source)
+}
+
+func GetCategories(w http.ResponseWriter, r *http.Request) {
+	ctx, span := Tracer.Start(r.Context(), "GetCategories")
+	defer span.End()
+	source := slog.String("source", "GetCategories")
+
+	categories, err := Repos.Catalog.FindCategories(ctx)
+	if err != nil {
+		sendFailure(ctx, w, "Failed to fetch categories", source)
 		return
 	}
 
-	sendResponse(ctx, w, http.StatusOK, &map[string]any{"success": true, "message": "carts deleted successfully"}, source)
+	okResponseMap := map[string]any{
+		"success":    true,
+		"categories": categories,
+	}
+	sendResponse(ctx, w, http.StatusOK, &okResponseMap, source)
 }
 
-func DeleteCartItems(w http.ResponseWriter, r *http.Request) {
-	ctx, span := Tracer.Start(r.Context(), "DeleteCartItems")
+func AdminCreateCatalogIngredients(w http.ResponseWriter, r *http.Request) {
+	ctx, span := Tracer.Start(r.Context(), "AdminCreateCatalogIngredients")
 	defer span.End()
-	source := slog.String("source", "DeleteCartItems")
+	source := slog.String("source", "AdminCreateCatalogIngredients")
 
-	Logger.InfoContext(ctx, "Deleting cart items ", source)
-	id, err := getID(r.Context(), source)
+	admin, err := getID(r.Context(), source)
 	if err != nil {
-		Logger.ErrorContext(ctx, "Error in getting id from the token", slog.Any("error", err), source)
-		sendFailure(ctx, w, err.Error(), source)
+		sendFailure(ctx, w, "Unable to get admin ID from context", source)
 		return
 	}
 
-	conId, Ids, err := getDeleteIds(ctx, r.Body, source)
+	Logger.InfoContext(ctx, "Adding catalog ingredients", source)
+	req, err := decodeStruct[RequestCatalog](ctx, r.Body, source)
 	if err != nil {
-		Logger.ErrorContext(ctx, "Error in getting con ids and items ids array from body", slog.Any("error", err), source)
-		sendFailure(ctx, w, err.Error(), source)
+		sendFailure(ctx, w, "Error in parsing request body", source)
+		return
+	}
+	if len(req.Ingredients) == 0 {
+		sendFailure(ctx, w, "No ingredients provided", source)
 		return
 	}
 
-	if err := Repos.User.DeleteCartItems(ctx, id, conId, Ids); err != nil {
-		Logger.ErrorContext(ctx, "Error in deleting cart items", slog.Any("error", err), source)
+	ids, err := Repos.Catalog.CreateCatalogIngredients(ctx, admin, req.Ingredients)
+	if err != nil {
+		Logger.ErrorContext(ctx, "Failed to add catalog ingredients", slog.Any("error", err), source)
 		sendFailure(ctx, w, err.Error(), source)
 		return
 	}
 
-	sendResponse(ctx, w, http.StatusOK, &map[string]any{"success": true, "message": "cart items deleted successfully"}, source)
+	okResponseMap := map[string]any{
+		"success": true,
+		"ids":     getStringIDs(ids),
+	}
+	sendResponse(ctx, w, http.StatusOK, &okResponseMap, source)
 }
 
-func DeleteStores(w http.ResponseWriter, r *http.Request) {
-	ctx, span := Tracer.Start(r.Context(), "DeleteStores")
+func AdminUpdateCatalogIngredient(w http.ResponseWriter, r *http.Request) {
+	ctx, span := Tracer.Start(r.Context(), "AdminUpdateCatalogIngredient")
 	defer span.End()
-	source := slog.String("source", "DeleteStores")
+	source := slog.String("source", "AdminUpdateCatalogIngredient")
 
-	Logger.InfoContext(ctx, "Deleting stores ", source)
-	id, err := getID(r.Context(), source)
+	admin, err := getID(r.Context(), source)
 	if err != nil {
-		Logger.ErrorContext(ctx, "Error in getting id from the token", slog.Any("error", err), source)
-		sendFailure(ctx, w, err.Error(), source)
+		sendFailure(ctx, w, "Unable to get admin ID from context", source)
 		return
 	}
 
-	ids, err := decodeToIDS(ctx, r.Body, source)
+	id, err := NewID(ctx, r.PathValue("id"))
 	if err != nil {
-		Logger.ErrorContext(ctx, "Error in getting ids from body", slog.Any("error", err), source)
-		sendFailure(ctx, w, err.Error(), source)
+		sendFailure(ctx, w, "Invalid id", source)
 		return
 	}
+	req, err := decodeStruct[CatalogIngredient](ctx, r.Body, source)
+	if err != nil {
+		sendFailure(ctx, w, "Error in parsing request body", source)
+		return
+	}
+	req.IngredientID = id.value
 
-	if err := Repos.Vendor.DeleteStores(ctx, id, ids); err != nil {
-		Logger.ErrorContext(ctx, "Error in delete stores", slog.Any("error", err), source)
+	if err := Repos.Catalog.UpdateCatalogIngredient(ctx, admin, req); err != nil {
+		Logger.ErrorContext(ctx, "Failed to update catalog ingredient", slog.Any("error", err), source)
 		sendFailure(ctx, w, err.Error(), source)
 		return
 	}
-
-	sendResponse(ctx, w, http.StatusOK, &map[string]any{"success": true, "message": "stores deleted successfully"}, source)
-
+	sendResponse(ctx, w, http.StatusOK, &map[string]any{"success": true, "message": "Catalog ingredient updated successfully"}, source)
 }
 
-func DeleteStoreItems(w http.ResponseWriter, r *http.Request) {
-	ctx, span := Tracer.Start(r.Context(), "DeleteStoreItems")
+func AdminDeleteCatalogIngredient(w http.ResponseWriter, r *http.Request) {
+	ctx, span := Tracer.Start(r.Context(), "AdminDeleteCatalogIngredient")
 	defer span.End()
-	source := slog.String("source", "DeleteStoreItems")
+	source := slog.String("source", "AdminDeleteCatalogIngredient")
 
-	Logger.InfoContext(ctx, "Deleting Store items ", source)
-	id, err := getID(r.Context(), source)
+	admin, err := getID(r.Context(), source)
 	if err != nil {
-		Logger.ErrorContext(ctx, "Error in getting id from the token", slog.Any("error", err), source)
-		sendFailure(ctx, w, err.Error(), source)
+		sendFailure(ctx, w, "Unable to get admin ID from context", source)
 		return
 	}
 
-	conId, Ids, err := getDeleteIds(ctx, r.Body, source)
+	id, err := NewID(ctx, r.PathValue("id"))
 	if err != nil {
-		Logger.ErrorContext(ctx, "Error in getting con ids and items ids array from body", slog.Any("error", err), source)
-		sendFailure(ctx, w, err.Error(), source)
+		sendFailure(ctx, w, "Invalid id", source)
 		return
 	}
 
-	if err := Repos.Vendor.DeleteStoreItems(ctx, id, conId, Ids); err != nil {
-		Logger.ErrorContext(ctx, "Error in deleting store items", slog.Any("error", err), source)
+	if err := Repos.Catalog.DeleteCatalogIngredient(ctx, admin, id); err != nil {
+		Logger.ErrorContext(ctx, "Failed to delete catalog ingredient", slog.Any("error", err), source)
 		sendFailure(ctx, w, err.Error(), source)
 		return
 	}
+	sendResponse(ctx, w, http.StatusOK, &map[string]any{"success": true, "message": "Catalog ingredient deleted successfully"}, source)
+}
 
-	sendResponse(ctx, w, http.StatusOK, &map[string]any{"success": true, "message": "store items deleted successfully"},
-		source)
+func AdminGetDuplicateIngredients(w http.ResponseWriter, r *http.Request) {
+	ctx, span := Tracer.Start(r.Context(), "AdminGetDuplicateIngredients")
+	defer span.End()
+	source := slog.String("source", "AdminGetDuplicateIngredients")
+
+	groups, err := Repos.Catalog.FindDuplicateIngredients(ctx)
+	if err != nil {
+		sendFailure(ctx, w, "Failed to look for duplicate ingredients", source)
+		return
+	}
+
+	okResponseMap := map[string]any{
+		"success":    true,
+		"duplicates": groups,
+	}
+	sendResponse(ctx, w, http.StatusOK, &okResponseMap, source)
 }
 
-func DeleteRecipes(w http.ResponseWriter, r *http.Request) {
-	ctx, span := Tracer.Start(r.Context(), "DeleteRecipes")
+// AdminGetCatalogChanges returns who changed what in the catalog, for one ingredient or category when the
+// path names one
+func AdminGetCatalogChanges(w http.ResponseWriter, r *http.Request) {
+	ctx, span := Tracer.Start(r.Context(), "AdminGetCatalogChanges")
 	defer span.End()
-	source := slog.String("source", "DeleteRecipes")
+	source := slog.String("source", "AdminGetCatalogChanges")
 
-	Logger.InfoContext(ctx, "Deleting Recipes ", source)
-	id, err := getID(r.Context(), source)
+	var target ID
+	if value := r.PathValue("id"); value != "" {
+		id, err := NewID(ctx, value)
+		if err != nil {
+			sendFailure(ctx, w, "Invalid id", source)
+			return
+		}
+		target = id
+	}
+
+	changes, err := Repos.Catalog.FindCatalogChanges(ctx, target)
 	if err != nil {
-		Logger.ErrorContext(ctx, "Error in getting id from the token", slog.Any("error", err), source)
-		sendFailure(ctx, w, err.Error(), source)
+		sendFailure(ctx, w, "Failed to read the catalog audit log", source)
 		return
 	}
 
-	ids, err := decodeToIDS(ctx, r.Body, source)
+	okResponseMap := map[string]any{
+		"success": true,
+		"changes": changes,
+	}
+	sendResponse(ctx, w, http.StatusOK, &okResponseMap, source)
+}
+
+func AdminPreviewMerge(w http.ResponseWriter, r *http.Request) {
+	ctx, span := Tracer.Start(r.Context(), "AdminPreviewMerge")
+	defer span.End()
+	source := slog.String("source", "AdminPreviewMerge")
+
+	req, err := decodeStruct[MergeReq](ctx, r.Body, source)
 	if err != nil {
-		Logger.ErrorContext(ctx, "Error in getting ids from body", slog.Any("error", err), source)
-		sendFailure(ctx, w, err.Error(), source)
+		sendFailure(ctx, w, "Error in parsing request body", source)
 		return
 	}
 
-	if err := Repos.User.DeleteRecipes(ctx, id, ids); err != nil {
-		Logger.ErrorContext(ctx, "Error in delete recipes", slog.Any("error", err), source)
+	preview, err := Repos.Catalog.PreviewMerge(ctx, req)
+	if err != nil {
+		Logger.ErrorContext(ctx, "Unable to preview the merge", slog.Any("error", err), source)
 		sendFailure(ctx, w, err.Error(), source)
 		return
 	}
 
-	sendResponse(ctx, w, http.StatusOK, &map[string]any{"success": true, "message": "recipes deleted successfully"}, source)
+	okResponseMap := map[string]any{
+		"success": true,
+		"preview": preview,
+	}
+	sendResponse(ctx, w, http.StatusOK, &okResponseMap, source)
 }
 
-func DeleteRecipeItems(w http.ResponseWriter, r *http.Request) {
-	ctx, span := Tracer.Start(r.Context(), "DeleteRecipeItems")
+func AdminMergeIngredients(w http.ResponseWriter, r *http.Request) {
+	ctx, span := Tracer.Start(r.Context(), "AdminMergeIngredients")
 	defer span.End()
-	source := slog.String("source", "DeleteRecipeItems")
+	source := slog.String("source", "AdminMergeIngredients")
 
-	Logger.InfoContext(ctx, "Deleting Recipe items ", source)
-	id, err := getID(r.Context(), source)
+	admin, err := getID(r.Context(), source)
 	if err != nil {
-		Logger.ErrorContext(ctx, "Error in getting id from the token", slog.Any("error", err), source)
-		sendFailure(ctx, w, err.Error(), source)
+		sendFailure(ctx, w, "Unable to get admin ID from context", source)
 		return
 	}
 
-	conId, Ids, err := getDeleteIds(ctx, r.Body, source)
+	req, err := decodeStruct[MergeReq](ctx, r.Body, source)
 	if err != nil {
-		Logger.ErrorContext(ctx, "Error in getting con ids and items ids array from body", slog.Any("error", err), source)
-		sendFailure(ctx, w, err.Error(), source)
+		sendFailure(ctx, w, "Error in parsing request body", source)
 		return
 	}
 
-	if err := Repos.User.DeleteRecipeItems(ctx, id, conId, Ids); err != nil {
-		Logger.ErrorContext(ctx, "Error in deleting recipe items", slog.Any("error", err), source)
+	merged, users, err := Repos.Catalog.MergeIngredients(ctx, admin, req)
+	if err != nil {
+		Logger.ErrorContext(ctx, "Unable to merge ingredients", slog.Any("error", err), source)
 		sendFailure(ctx, w, err.Error(), source)
 		return
 	}
 
-	sendResponse(ctx, w, http.StatusOK, &map[string]any{"success": true, "message": "recipe items deleted successfully"},
-		source)
+	if cached, ok := Repos.User.(*CachedUserRepository); ok {
+		for _, id := range users {
+			user, recipes, carts, _ := getCacheKeys(id)
+			if err := cached.Invalidate(ctx, user, recipes, carts); err != nil {
+				Logger.ErrorContext(ctx, "Unable to invalidate user cache", slog.Any("error", err), source)
+			}
+		}
+	}
+
+	okResponseMap := map[string]any{
+		"success": true,
+		"merged":  merged,
+	}
+	sendResponse(ctx, w, http.StatusOK, &okResponseMap, source)
 }
 
-func DeleteAdmin(w http.ResponseWriter, r *http.Request) {
-	ctx, span := Tracer.Start(r.Context(), "DeleteAdmin")
+func AdminCreateCategory(w http.ResponseWriter, r *http.Request) {
+	ctx, span := Tracer.Start(r.Context(), "AdminCreateCategory")
 	defer span.End()
-	source := slog.String("source", "DeleteAdmin")
+	source := slog.String("source", "AdminCreateCategory")
 
-	id, err := getID(r.Context(), source)
+	admin, err := getID(r.Context(), source)
 	if err != nil {
-		sendFailure(ctx, w, "Oops", source)
+		sendFailure(ctx, w, "Unable to get admin ID from context", source)
 		return
 	}
 
-	if err = Repos.Admin.Delete(ctx, id); err != nil {
+	req, err := decodeStruct[Category](ctx, r.Body, source)
+	if err != nil {
+		sendFailure(ctx, w, "Error in parsing request body", source)
+		return
+	}
+
+	id, err := Repos.Catalog.CreateCategory(ctx, admin, req)
+	if err != nil {
+		Logger.ErrorContext(ctx, "Failed to create category", slog.Any("error", err), source)
 		sendFailure(ctx, w, err.Error(), source)
 		return
 	}
-	sendResponse(ctx, w, http.StatusOK, &map[string]any{"success": true, "message": "Admin deleted successfully"}, source)
 
+	okResponseMap := map[string]any{
+		"success": true,
+		"id":      id.String(),
+	}
+	sendResponse(ctx, w, http.StatusOK, &okResponseMap, source)
 }
 
-func AdminDeleteUser(w http.ResponseWriter, r *http.Request) {
-	ctx, span := Tracer.Start(r.Context(), "AdminDeleteUser")
+func AdminUpdateCategory(w http.ResponseWriter, r *http.Request) {
+	ctx, span := Tracer.Start(r.Context(), "AdminUpdateCategory")
 	defer span.End()
-	source := slog.String("source", "AdminDeleteUser")
+	source := slog.String("source", "AdminUpdateCategory")
+
+	admin, err := getID(r.Context(), source)
+	if err != nil {
+		sendFailure(ctx, w, "Unable to get admin ID from context", source)
+		return
+	}
 
 	id, err := NewID(ctx, r.PathValue("id"))
 	if err != nil {
-		Logger.Error("Unable to convert id req to ID", slog.Any("error", err), source)
 		sendFailure(ctx, w, "Invalid id", source)
 		return
 	}
+	req, err := decodeStruct[Category](ctx, r.Body, source)
+	if err != nil {
+		sendFailure(ctx, w, "Error in parsing request body", source)
+		return
+	}
+	req.ID = id.value
 
-	if err = Repos.Admin.DeleteUser(ctx, id); err != nil {
+	if err := Repos.Catalog.UpdateCategory(ctx, admin, req); err != nil {
+		Logger.ErrorContext(ctx, "Failed to update category", slog.Any("error", err), source)
 		sendFailure(ctx, w, err.Error(), source)
 		return
 	}
-	sendResponse(ctx, w, http.StatusOK, &map[string]any{"success": true, "message": "User deleted successfully"}, source)
-
+	sendResponse(ctx, w, http.StatusOK, &map[string]any{"success": true, "message": "Category updated successfully"}, source)
 }
 
-func AdminDeleteVendor(w http.ResponseWriter, r *http.Request) {
-	ctx, span := Tracer.Start(r.Context(), "AdminDeleteVendor")
+func AdminDeleteCategory(w http.ResponseWriter, r *http.Request) {
+	ctx, span := Tracer.Start(r.Context(), "AdminDeleteCategory")
 	defer span.End()
-	source := slog.String("source", "AdminDeleteVendor")
+	source := slog.String("source", "AdminDeleteCategory")
+
+	admin, err := getID(r.Context(), source)
+	if err != nil {
+		sendFailure(ctx, w, "Unable to get admin ID from context", source)
+		return
+	}
 
 	id, err := NewID(ctx, r.PathValue("id"))
 	if err != nil {
-		Logger.Error("Unable to convert id req to ID", slog.Any("error", err), source)
 		sendFailure(ctx, w, "Invalid id", source)
 		return
 	}
 
-	if err = Repos.Admin.DeleteVendor(ctx, id); err != nil {
+	if err := Repos.Catalog.DeleteCategory(ctx, admin, id); err != nil {
+		Logger.ErrorContext(ctx, "Failed to delete category", slog.Any("error", err), source)
 		sendFailure(ctx, w, err.Error(), source)
 		return
 	}
-	sendResponse(ctx, w, http.StatusOK, &map[string]any{"success": true, "message": "Vendor deleted successfully"}, source)
+	sendResponse(ctx, w, http.StatusOK, &map[string]any{"success": true, "message": "Category deleted successfully"}, source)
 }
 
-func DeleteVendor(w http.ResponseWriter, r *http.Request) {
-	ctx, span := Tracer.Start(r.Context(), "DeleteVendor")
+func GetUser(w http.ResponseWriter, r *http.Request) {
+	ctx, span := Tracer.Start(r.Context(), "GetUser")
 	defer span.End()
-	source := slog.String("source", "DeleteVendor")
+	source := slog.String("source", "GetUser")
 
-	id, err := getID(r.Context(), source)
+	Logger.InfoContext(ctx, "Getting the User", source)
+	id, err := getID(ctx, source)
 	if err != nil {
-		sendFailure(ctx, w, "Oops", source)
+		Logger.ErrorContext(ctx, "Unable to get the id from the token", slog.Any("error", err), source)
+		sendFailure(ctx, w, err.Error(), source)
 		return
 	}
-
-	if err = Repos.Vendor.DeleteVendor(ctx, id); err != nil {
+	user, err := Repos.User.FindUserByID(ctx, id)
+	if err != nil {
 		sendFailure(ctx, w, err.Error(), source)
 		return
 	}
-	sendResponse(ctx, w, http.StatusOK, &map[string]any{"success": true, "message": "Vendor deleted successfully"}, source)
-
-}
-
-func DeleteUser(w http.ResponseWriter, r *http.Request) {
-	ctx, span := Tracer.Start(r.Context(), "DeleteUser")
-	defer span.End()
-	source := slog.String("source", "DeleteUser")
 
-	id, err := getID(r.Context(), source)
+	s, err := json.Marshal(user)
 	if err != nil {
-		sendFailure(ctx, w, "Oops", source)
+		Logger.ErrorContext(ctx, "Error in marshalling to string", slog.Any("error", err), source)
+		sendFailure(ctx, w, "Error in fetching user", source)
 		return
 	}
 
-	if err = Repos.User.DeleteUser(ctx, id); err != nil {
-		sendFailure(ctx, w, err.Error(), source)
-		return
+	okResponseMap := map[string]any{
+		"success": true,
+		"ids":     string(s),
 	}
-	sendResponse(ctx, w, http.StatusOK, &map[string]any{"success": true, "message": "User deleted successfully"}, source)
+
+	sendResponse(ctx, w, http.StatusOK, &okResponseMap, source)
+}
+
+func GetRecipes(w http.ResponseWriter, r *http.Request) {
+	ctx, span := Tracer.Start(r.Context(), "GetRecipes")
+	defer span.End()
+	source := slog.String("source", "GetRecipes")
+
+	Logger.InfoContext(ctx, "Getting the Recipes", source)
+	var recipes []*Recipe
+	getCon(ctx, w, r, recipes, source)
+}
+
+func UpdateCarts(w http.ResponseWriter, r *http.Request) {
+	ctx, span := Tracer.Start(r.Context(), "UpdateCarts")
+	defer span.End()
+	source := slog.String("source", "UpdateCarts")
+
+	Logger.InfoContext(ctx, "Updating Carts", source)
+	req, err := decodeStruct[RequestCarts](ctx, r.Body, source)
+	if err != nil {
+		sendFailure(ctx, w, "Error in parsing carts request body", source)
+		return
+	}
+	createCon(ctx, w, r, source, req.Carts)
+}
+
+func UpdateUserOrders(w http.ResponseWriter, r *http.Request) {
+	ctx, span := Tracer.Start(r.Context(), "UpdateUserOrders")
+	defer span.End()
+	source := slog.String("source", "UpdateUserOrders")
+
+	Logger.InfoContext(ctx, "Updating UserOrders", source)
+	req, err := decodeStruct[RequestUserOrders](ctx, r.Body, source)
+	if err != nil {
+		sendFailure(ctx, w, "Error in parsing userOrders request body", source)
+		return
+	}
+	updateCon(ctx, w, r, source, req.Orders)
+}
+
+func UpdateVendorOrders(w http.ResponseWriter, r *http.Request) {
+	ctx, span := Tracer.Start(r.Context(), "UpdateVendorOrders")
+	defer span.End()
+	source := slog.String("source", "UpdateVendorOrders")
+
+	Logger.InfoContext(ctx, "Updating VendorOrders", source)
+	req, err := decodeStruct[RequestVendorOrders](ctx, r.Body, source)
+	if err != nil {
+		sendFailure(ctx, w, "Error in parsing VendorOrders request body", source)
+		return
+	}
+	updateCon(ctx, w, r, source, req.Orders)
+}
+
+func UpdateStores(w http.ResponseWriter, r *http.Request) {
+	ctx, span := Tracer.Start(r.Context(), "UpdateStores")
+	defer span.End()
+	source := slog.String("source", "UpdateStores")
+
+	Logger.InfoContext(ctx, "Updating stores ", source)
+	req, err := decodeStruct[RequestStores](ctx, r.Body, source)
+	if err != nil {
+		sendFailure(ctx, w, "Error in parsing Stores request body", source)
+		return
+	}
+	for _, store := range req.Stores {
+		store.Region = normalizeRegion(store.Region)
+		if err := store.validate(); err != nil {
+			sendFailure(ctx, w, err.Error(), source)
+			return
+		}
+	}
+	if err := linkToCatalog(ctx, req.Stores, true, source); err != nil {
+		sendFailure(ctx, w, err.Error(), source)
+		return
+	}
+	updateCon(ctx, w, r, source, req.Stores)
+}
+
+// ImportInventory loads the items of a store from a CSV or NDJSON file. With dry_run it only reports the row
+// errors and what would be created, updated and deleted, nothing is written while any row has an error
+func ImportInventory(w http.ResponseWriter, r *http.Request) {
+	ctx, span := Tracer.Start(r.Context(), "ImportInventory")
+	defer span.End()
+	source := slog.String("source", "ImportInventory")
+
+	vendorID, err := getID(r.Context(), source)
+	if err != nil {
+		sendFailure(ctx, w, "Unable to get vendor ID from context", source)
+		return
+	}
+	storeID, err := NewID(ctx, r.PathValue("id"))
+	if err != nil {
+		sendFailure(ctx, w, "Invalid store id", source)
+		return
+	}
+
+	query := r.URL.Query()
+	format, err := importFormat(query.Get("format"), r.Header.Get("Content-Type"))
+	if err != nil {
+		sendFailure(ctx, w, err.Error(), source)
+		return
+	}
+	dryRun := false
+	if value := query.Get("dry_run"); value != "" {
+		if dryRun, err = strconv.ParseBool(value); err != nil {
+			sendFailure(ctx, w, "dry_run must be true or false", source)
+			return
+		}
+	}
+	mode := query.Get("mode")
+	switch mode {
+	case "":
+		mode = importMerge
+	case importMerge, importReplace:
+	default:
+		sendFailure(ctx, w, "mode must be merge or replace", source)
+		return
+	}
+
+	Logger.InfoContext(ctx, "Importing store inventory", slog.String("storeID", storeID.String()),
+		slog.String("format", format), slog.String("mode", mode), slog.Bool("dryRun", dryRun), source)
+
+	rows, rowErrs, err := readInventory(http.MaxBytesReader(w, r.Body, maxImportBytes), format)
+	if err != nil {
+		Logger.ErrorContext(ctx, "Unable to read the inventory file", slog.Any("error", err), source)
+		sendFailure(ctx, w, err.Error(), source)
+		return
+	}
+
+	current, err := Repos.Vendor.FindVendorStore(ctx, vendorID, storeID)
+	if err != nil && !errors.Is(err, &NoItems{}) {
+		sendFailure(ctx, w, "Store not found", source)
+		return
+	}
+	catalog, err := getAllIngredients(ctx, source)
+	if err != nil {
+		sendFailure(ctx, w, "Unable to load the ingredient catalog", source)
+		return
+	}
+
+	diff, diffErrs := diffInventory(current, rows, mode, newIngredientMatcher(catalog))
+	rowErrs = append(rowErrs, diffErrs...)
+	slices.SortStableFunc(rowErrs, func(a, b *ImportRowError) int { return cmp.Compare(a.Row, b.Row) })
+
+	if len(rowErrs) > 0 {
+		Logger.ErrorContext(ctx, "Inventory rows failed validation", slog.Int("count", len(rowErrs)), source)
+		errorResponseMap := map[string]any{
+			"success": false,
+			"error":   fmt.Sprintf("%d rows failed validation", len(rowErrs)),
+			"errors":  rowErrs,
+			"diff":    diff,
+		}
+		sendResponse(ctx, w, http.StatusBadRequest, &errorResponseMap, source)
+		return
+	}
+
+	if !dryRun {
+		if err := Repos.Vendor.ImportStoreItems(ctx, vendorID, storeID, diff); err != nil {
+			Logger.ErrorContext(ctx, "Failed to import store items", slog.Any("error", err), source)
+			sendFailure(ctx, w, "Failed to import store items", source)
+			return
+		}
+	}
+
+	okResponseMap := map[string]any{
+		"success": true,
+		"dry_run": dryRun,
+		"diff":    diff,
+	}
+	sendResponse(ctx, w, http.StatusOK, &okResponseMap, source)
+}
+
+// ExportInventory streams the items of a store as CSV or NDJSON in the format ImportInventory reads
+func ExportInventory(w http.ResponseWriter, r *http.Request) {
+	ctx, span := Tracer.Start(r.Context(), "ExportInventory")
+	defer span.End()
+	source := slog.String("source", "ExportInventory")
+
+	vendorID, err := getID(r.Context(), source)
+	if err != nil {
+		sendFailure(ctx, w, "Unable to get vendor ID from context", source)
+		return
+	}
+	storeID, err := NewID(ctx, r.PathValue("id"))
+	if err != nil {
+		sendFailure(ctx, w, "Invalid store id", source)
+		return
+	}
+	format := r.URL.Query().Get("format")
+	if format == "" {
+		format = formatCSV
+	}
+	if format, err = importFormat(format, ""); err != nil {
+		sendFailure(ctx, w, err.Error(), source)
+		return
+	}
+
+	Logger.InfoContext(ctx, "Exporting store inventory", slog.String("storeID", storeID.String()),
+		slog.String("format", format), source)
+
+	var write func(*inventoryRecord) error
+	var flush func() error
+	started := false
+	start := func() error {
+		started = true
+		contentType := "text/csv"
+		if format == formatNDJSON {
+			contentType = "application/x-ndjson"
+		}
+		w.Header().Set("Content-Type", contentType)
+		w.Header().Set("Content-Disposition", fmt.Sprintf("attachment; filename=\"store-%s.%s\"", storeID.String(), format))
+		w.WriteHeader(http.StatusOK)
+
+		if format == formatNDJSON {
+			encoder := json.NewEncoder(w)
+			write = func(record *inventoryRecord) error { return encoder.Encode(record) }
+			flush = func() error { return nil }
+			return nil
+		}
+		writer := csv.NewWriter(w)
+		write = func(record *inventoryRecord) error { return writer.Write(record.csv()) }
+		flush = func() error { writer.Flush(); return writer.Error() }
+		return writer.Write(inventoryColumns)
+	}
+
+	count := 0
+	err = Repos.Vendor.StreamStoreItems(ctx, vendorID, storeID, func(item *Item) error {
+		if !started {
+			if err := start(); err != nil {
+				return err
+			}
+		}
+		if err := write(newInventoryRecord(item)); err != nil {
+			return err
+		}
+		if count++; count%importBatchSize == 0 {
+			if err := flush(); err != nil {
+				return err
+			}
+			http.NewResponseController(w).Flush()
+		}
+		return nil
+	})
+	if err == nil && !started {
+		err = start()
+	}
+	if err != nil {
+		Logger.ErrorContext(ctx, "Failed to export store items", slog.Any("error", err), source)
+		if !started {
+			sendFailure(ctx, w, "Failed to export store items", source)
+		}
+		return
+	}
+	if err := flush(); err != nil {
+		Logger.ErrorContext(ctx, "Failed to export store items", slog.Any("error", err), source)
+		return
+	}
+	Logger.InfoContext(ctx, "Store inventory exported", slog.Int("count", count), source)
+}
+
+// GetStockAlerts is the low and out of stock alert feed of a store, open=true leaves out resolved alerts
+func GetStockAlerts(w http.ResponseWriter, r *http.Request) {
+	ctx, span := Tracer.Start(r.Context(), "GetStockAlerts")
+	defer span.End()
+	source := slog.String("source", "GetStockAlerts")
+
+	vendorID, err := getID(r.Context(), source)
+	if err != nil {
+		sendFailure(ctx, w, "Unable to get vendor ID from context", source)
+		return
+	}
+	storeID, err := NewID(ctx, r.PathValue("id"))
+	if err != nil {
+		sendFailure(ctx, w, "Invalid store id", source)
+		return
+	}
+	openOnly := false
+	if value := r.URL.Query().Get("open"); value != "" {
+		if openOnly, err = strconv.ParseBool(value); err != nil {
+			sendFailure(ctx, w, "open must be true or false", source)
+			return
+		}
+	}
+
+	alerts, err := Repos.Alert.FindStockAlerts(ctx, vendorID, storeID, openOnly)
+	if err != nil {
+		sendFailure(ctx, w, "Failed to fetch stock alerts", source)
+		return
+	}
+
+	okResponseMap := map[string]any{
+		"success": true,
+		"alerts":  alerts,
+	}
+	sendResponse(ctx, w, http.StatusOK, &okResponseMap, source)
+}
+
+func GetStoreLedger(w http.ResponseWriter, r *http.Request) {
+	ctx, span := Tracer.Start(r.Context(), "GetStoreLedger")
+	defer span.End()
+	source := slog.String("source", "GetStoreLedger")
+
+	vendorID, err := getID(r.Context(), source)
+	if err != nil {
+		sendFailure(ctx, w, "Unable to get vendor ID from context", source)
+		return
+	}
+	storeID, err := NewID(ctx, r.PathValue("id"))
+	if err != nil {
+		sendFailure(ctx, w, "Invalid store id", source)
+		return
+	}
+	query, err := parseLedgerQuery(r.URL.Query())
+	if err != nil {
+		sendFailure(ctx, w, err.Error(), source)
+		return
+	}
+
+	page, err := Repos.Ledger.FindLedger(ctx, vendorID, storeID, query)
+	if err != nil {
+		sendFailure(ctx, w, "Failed to fetch the store ledger", source)
+		return
+	}
+
+	okResponseMap := map[string]any{
+		"success": true,
+		"ledger":  page,
+	}
+	sendResponse(ctx, w, http.StatusOK, &okResponseMap, source)
+}
+
+// CheckStoreLedger recomputes the store's stock from its ledger, consistent is false when any item does not
+// add up
+func CheckStoreLedger(w http.ResponseWriter, r *http.Request) {
+	ctx, span := Tracer.Start(r.Context(), "CheckStoreLedger")
+	defer span.End()
+	source := slog.String("source", "CheckStoreLedger")
+
+	vendorID, err := getID(r.Context(), source)
+	if err != nil {
+		sendFailure(ctx, w, "Unable to get vendor ID from context", source)
+		return
+	}
+	storeID, err := NewID(ctx, r.PathValue("id"))
+	if err != nil {
+		sendFailure(ctx, w, "Invalid store id", source)
+		return
+	}
+
+	mismatches, err := Repos.Ledger.CheckLedger(ctx, vendorID, storeID)
+	if err != nil {
+		if errors.Is(err, &NoItems{}) {
+			sendFailure(ctx, w, "Store not found", source)
+			return
+		}
+		sendFailure(ctx, w, "Failed to check the store ledger", source)
+		return
+	}
+
+	okResponseMap := map[string]any{
+		"success":    true,
+		"consistent": len(mismatches) == 0,
+		"mismatches": mismatches,
+	}
+	sendResponse(ctx, w, http.StatusOK, &okResponseMap, source)
+}
+
+func GetNotificationSettings(w http.ResponseWriter, r *http.Request) {
+	ctx, span := Tracer.Start(r.Context(), "GetNotificationSettings")
+	defer span.End()
+	source := slog.String("source", "GetNotificationSettings")
+
+	id, err := getID(r.Context(), source)
+	if err != nil {
+		sendFailure(ctx, w, "Unable to get ID from context", source)
+		return
+	}
+
+	settings, err := Repos.Notification.FindNotificationSettings(ctx, id)
+	if err != nil {
+		sendFailure(ctx, w, "Failed to fetch notification settings", source)
+		return
+	}
+	if settings == nil {
+		settings = &NotificationSettings{Kinds: []string{}}
+	}
+	// the secret is write only
+	settings.Secret = ""
+
+	okResponseMap := map[string]any{
+		"success":  true,
+		"settings": settings,
+		"kinds":    notificationKinds,
+	}
+	sendResponse(ctx, w, http.StatusOK, &okResponseMap, source)
+}
+
+// UpdateNotificationSettings sets the webhook events are posted to, an empty webhook_url turns delivery off
+func UpdateNotificationSettings(w http.ResponseWriter, r *http.Request) {
+	ctx, span := Tracer.Start(r.Context(), "UpdateNotificationSettings")
+	defer span.End()
+	source := slog.String("source", "UpdateNotificationSettings")
+
+	id, err := getID(r.Context(), source)
+	if err != nil {
+		sendFailure(ctx, w, "Unable to get ID from context", source)
+		return
+	}
+	req, err := decodeStruct[NotificationSettings](ctx, r.Body, source)
+	if err != nil {
+		sendFailure(ctx, w, "Error in parsing request body", source)
+		return
+	}
+
+	if err := Repos.Notification.UpdateNotificationSettings(ctx, id, req); err != nil {
+		Logger.ErrorContext(ctx, "Failed to update notification settings", slog.Any("error", err), source)
+		sendFailure(ctx, w, err.Error(), source)
+		return
+	}
+	sendResponse(ctx, w, http.StatusOK, &map[string]any{"success": true, "message": "Notification settings updated successfully"}, source)
+}
+
+func AcceptUserOrder(w http.ResponseWriter, r *http.Request) {
+	ctx, span := Tracer.Start(r.Context(), "AcceptUserOrder")
+	defer span.End()
+	source := slog.String("source", "AcceptUserOrder")
+
+	Logger.InfoContext(ctx, "Processing order status update", source)
+	var req AcceptUserOrderReq
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		Logger.ErrorContext(ctx, "Unable to parse request body", slog.Any("error", err), source)
+		sendFailure(ctx, w, "Error in parsing request body", source)
+		return
+	}
+
+	Logger.InfoContext(ctx, "Validating request fields", source)
+	switch {
+	case req.UserID == bson.NilObjectID:
+		sendFailure(ctx, w, "User ID is required", source)
+		return
+	case req.OrderID == bson.NilObjectID:
+		sendFailure(ctx, w, "Order ID is required", source)
+		return
+	case req.OrderStatus == "":
+		sendFailure(ctx, w, "Order status is required", source)
+		return
+	}
+	Logger.InfoContext(ctx, "Validated Successfully", source)
+
+	vendorID, err := getID(r.Context(), source)
+	if err != nil {
+		sendFailure(ctx, w, "Unable to get vendor ID from context", source)
+		return
+	}
+
+	if err := Repos.Vendor.UpdateUserOrder(ctx, vendorID, &req); err != nil {
+		sendFailure(ctx, w, "Failed to update order status", source)
+		return
+	}
+	releaseOrderSlots(ctx, "vendor_id", vendorID, []*Order{{ID: req.OrderID, OrderStatus: req.OrderStatus}}, source)
+
+	okResponseMap := map[string]any{
+		"success": true,
+		"message": "Order status updated successfully",
+	}
+	sendResponse(ctx, w, http.StatusOK, &okResponseMap, source)
+}
+
+func UpdateRecipes(w http.ResponseWriter, r *http.Request) {
+	ctx, span := Tracer.Start(r.Context(), "UpdateRecipes")
+	defer span.End()
+	source := slog.String("source", "UpdateRecipes")
+
+	Logger.InfoContext(ctx, "Updating recipes", source)
+	req, err := decodeStruct[RequestRecipes](ctx, r.Body, source)
+	if err != nil {
+		sendFailure(ctx, w, "Error in parsing recipes request body", source)
+		return
+	}
+	updateCon(ctx, w, r, source, req.Recipes)
+}
+
+func DeleteCarts(w http.ResponseWriter, r *http.Request) {
+	ctx, span := Tracer.Start(r.Context(), "DeleteCarts")
+	defer span.End()
+	source := slog.String("source", "DeleteCarts")
+
+	Logger.InfoContext(ctx, "Deleting carts ", source)
+	id, err := getID(r.Context(), source)
+	if err != nil {
+		Logger.ErrorContext(ctx, "Error in getting id from the token", slog.Any("error", err), source)
+		sendFailure(ctx, w, err.Error(), source)
+		return
+	}
+
+	ids, err := decodeToIDS(ctx, r.Body, source)
+	if err != nil {
+		Logger.ErrorContext(ctx, "Error in getting ids from body", slog.Any("error", err), source)
+		sendFailure(ctx, w, err.Error(), source)
+		return
+	}
+
+	if err := Repos.User.DeleteCarts(ctx, id, ids); err != nil {
+		Logger.ErrorContext(ctx, "Error in deleting carts", slog.Any("error", err), source)
+		sendFailure(ctx, w, err.Error(), source)
+		return
+	}
+
+	sendResponse(ctx, w, http.StatusOK, &map[string]any{"success": true, "message": "carts deleted successfully"}, source)
+}
+
+func DeleteCartItems(w http.ResponseWriter, r *http.Request) {
+	ctx, span := Tracer.Start(r.Context(), "DeleteCartItems")
+	defer span.End()
+	source := slog.String("source", "DeleteCartItems")
+
+	Logger.InfoContext(ctx, "Deleting cart items ", source)
+	id, err := getID(r.Context(), source)
+	if err != nil {
+		Logger.ErrorContext(ctx, "Error in getting id from the token", slog.Any("error", err), source)
+		sendFailure(ctx, w, err.Error(), source)
+		return
+	}
+
+	conId, Ids, err := getDeleteIds(ctx, r.Body, source)
+	if err != nil {
+		Logger.ErrorContext(ctx, "Error in getting con ids and items ids array from body", slog.Any("error", err), source)
+		sendFailure(ctx, w, err.Error(), source)
+		return
+	}
+
+	if err := Repos.User.DeleteCartItems(ctx, id, conId, Ids); err != nil {
+		Logger.ErrorContext(ctx, "Error in deleting cart items", slog.Any("error", err), source)
+		sendFailure(ctx, w, err.Error(), source)
+		return
+	}
+
+	sendResponse(ctx, w, http.StatusOK, &map[string]any{"success": true, "message": "cart items deleted successfully"}, source)
+}
+
+func DeleteStores(w http.ResponseWriter, r *http.Request) {
+	ctx, span := Tracer.Start(r.Context(), "DeleteStores")
+	defer span.End()
+	source := slog.String("source", "DeleteStores")
+
+	Logger.InfoContext(ctx, "Deleting stores ", source)
+	id, err := getID(r.Context(), source)
+	if err != nil {
+		Logger.ErrorContext(ctx, "Error in getting id from the token", slog.Any("error", err), source)
+		sendFailure(ctx, w, err.Error(), source)
+		return
+	}
+
+	ids, err := decodeToIDS(ctx, r.Body, source)
+	if err != nil {
+		Logger.ErrorContext(ctx, "Error in getting ids from body", slog.Any("error", err), source)
+		sendFailure(ctx, w, err.Error(), source)
+		return
+	}
+
+	if err := Repos.Vendor.DeleteStores(ctx, id, ids); err != nil {
+		Logger.ErrorContext(ctx, "Error in delete stores", slog.Any("error", err), source)
+		sendFailure(ctx, w, err.Error(), source)
+		return
+	}
+
+	sendResponse(ctx, w, http.StatusOK, &map[string]any{"success": true, "message": "stores deleted successfully"}, source)
+
+}
+
+func DeleteStoreItems(w http.ResponseWriter, r *http.Request) {
+	ctx, span := Tracer.Start(r.Context(), "DeleteStoreItems")
+	defer span.End()
+	source := slog.String("source", "DeleteStoreItems")
+
+	Logger.InfoContext(ctx, "Deleting Store items ", source)
+	id, err := getID(r.Context(), source)
+	if err != nil {
+		Logger.ErrorContext(ctx, "Error in getting id from the token", slog.Any("error", err), source)
+		sendFailure(ctx, w, err.Error(), source)
+		return
+	}
+
+	conId, Ids, err := getDeleteIds(ctx, r.Body, source)
+	if err != nil {
+		Logger.ErrorContext(ctx, "Error in getting con ids and items ids array from body", slog.Any("error", err), source)
+		sendFailure(ctx, w, err.Error(), source)
+		return
+	}
+
+	if err := Repos.Vendor.DeleteStoreItems(ctx, id, conId, Ids); err != nil {
+		Logger.ErrorContext(ctx, "Error in deleting store items", slog.Any("error", err), source)
+		sendFailure(ctx, w, err.Error(), source)
+		return
+	}
+
+	sendResponse(ctx, w, http.StatusOK, &map[string]any{"success": true, "message": "store items deleted successfully"},
+		source)
+}
+
+func DeleteRecipes(w http.ResponseWriter, r *http.Request) {
+	ctx, span := Tracer.Start(r.Context(), "DeleteRecipes")
+	defer span.End()
+	source := slog.String("source", "DeleteRecipes")
+
+	Logger.InfoContext(ctx, "Deleting Recipes ", source)
+	id, err := getID(r.Context(), source)
+	if err != nil {
+		Logger.ErrorContext(ctx, "Error in getting id from the token", slog.Any("error", err), source)
+		sendFailure(ctx, w, err.Error(), source)
+		return
+	}
+
+	ids, err := decodeToIDS(ctx, r.Body, source)
+	if err != nil {
+		Logger.ErrorContext(ctx, "Error in getting ids from body", slog.Any("error", err), source)
+		sendFailure(ctx, w, err.Error(), source)
+		return
+	}
+
+	if err := Repos.User.DeleteRecipes(ctx, id, ids); err != nil {
+		Logger.ErrorContext(ctx, "Error in delete recipes", slog.Any("error", err), source)
+		sendFailure(ctx, w, err.Error(), source)
+		return
+	}
+
+	sendResponse(ctx, w, http.StatusOK, &map[string]any{"success": true, "message": "recipes deleted successfully"}, source)
+}
+
+func DeleteRecipeItems(w http.ResponseWriter, r *http.Request) {
+	ctx, span := Tracer.Start(r.Context(), "DeleteRecipeItems")
+	defer span.End()
+	source := slog.String("source", "DeleteRecipeItems")
+
+	Logger.InfoContext(ctx, "Deleting Recipe items ", source)
+	id, err := getID(r.Context(), source)
+	if err != nil {
+		Logger.ErrorContext(ctx, "Error in getting id from the token", slog.Any("error", err), source)
+		sendFailure(ctx, w, err.Error(), source)
+		return
+	}
+
+	conId, Ids, err := getDeleteIds(ctx, r.Body, source)
+	if err != nil {
+		Logger.ErrorContext(ctx, "Error in getting con ids and items ids array from body", slog.Any("error", err), source)
+		sendFailure(ctx, w, err.Error(), source)
+		return
+	}
+
+	if err := Repos.User.DeleteRecipeItems(ctx, id, conId, Ids); err != nil {
+		Logger.ErrorContext(ctx, "Error in deleting recipe items", slog.Any("error", err), source)
+		sendFailure(ctx, w, err.Error(), source)
+		return
+	}
+
+	sendResponse(ctx, w, http.StatusOK, &map[string]any{"success": true, "message": "recipe items deleted successfully"},
+		source)
+}
+
+func DeleteAdmin(w http.ResponseWriter, r *http.Request) {
+	ctx, span := Tracer.Start(r.Context(), "DeleteAdmin")
+	defer span.End()
+	source := slog.String("source", "DeleteAdmin")
+
+	id, err := getID(r.Context(), source)
+	if err != nil {
+		sendFailure(ctx, w, "Oops", source)
+		return
+	}
+
+	if err = Repos.Admin.Delete(ctx, id); err != nil {
+		sendFailure(ctx, w, err.Error(), source)
+		return
+	}
+	sendResponse(ctx, w, http.StatusOK, &map[string]any{"success": true, "message": "Admin deleted successfully"}, source)
+
+}
+
+func AdminDeleteUser(w http.ResponseWriter, r *http.Request) {
+	ctx, span := Tracer.Start(r.Context(), "AdminDeleteUser")
+	defer span.End()
+	source := slog.String("source", "AdminDeleteUser")
+
+	id, err := NewID(ctx, r.PathValue("id"))
+	if err != nil {
+		Logger.Error("Unable to convert id req to ID", slog.Any("error", err), source)
+		sendFailure(ctx, w, "Invalid id", source)
+		return
+	}
+
+	if err = Repos.Admin.DeleteUser(ctx, id); err != nil {
+		sendFailure(ctx, w, err.Error(), source)
+		return
+	}
+	sendResponse(ctx, w, http.StatusOK, &map[string]any{"success": true, "message": "User deleted successfully"}, source)
+
+}
+
+func AdminDeleteVendor(w http.ResponseWriter, r *http.Request) {
+	ctx, span := Tracer.Start(r.Context(), "AdminDeleteVendor")
+	defer span.End()
+	source := slog.String("source", "AdminDeleteVendor")
+
+	id, err := NewID(ctx, r.PathValue("id"))
+	if err != nil {
+		Logger.Error("Unable to convert id req to ID", slog.Any("error", err), source)
+		sendFailure(ctx, w, "Invalid id", source)
+		return
+	}
+
+	if err = Repos.Admin.DeleteVendor(ctx, id); err != nil {
+		sendFailure(ctx, w, err.Error(), source)
+		return
+	}
+	sendResponse(ctx, w, http.StatusOK, &map[string]any{"success": true, "message": "Vendor deleted successfully"}, source)
+}
+
+func DeleteVendor(w http.ResponseWriter, r *http.Request) {
+	ctx, span := Tracer.Start(r.Context(), "DeleteVendor")
+	defer span.End()
+	source := slog.String("source", "DeleteVendor")
+
+	id, err := getID(r.Context(), source)
+	if err != nil {
+		sendFailure(ctx, w, "Oops", source)
+		return
+	}
+
+	if err = Repos.Vendor.DeleteVendor(ctx, id); err != nil {
+		sendFailure(ctx, w, err.Error(), source)
+		return
+	}
+	sendResponse(ctx, w, http.StatusOK, &map[string]any{"success": true, "message": "Vendor deleted successfully"}, source)
+
+}
+
+func DeleteUser(w http.ResponseWriter, r *http.Request) {
+	ctx, span := Tracer.Start(r.Context(), "DeleteUser")
+	defer span.End()
+	source := slog.String("source", "DeleteUser")
+
+	id, err := getID(r.Context(), source)
+	if err != nil {
+		sendFailure(ctx, w, "Oops", source)
+		return
+	}
+
+	if err = Repos.User.DeleteUser(ctx, id); err != nil {
+		sendFailure(ctx, w, err.Error(), source)
+		return
+	}
+	sendResponse(ctx, w, http.StatusOK, &map[string]any{"success": true, "message": "User deleted successfully"}, source)
 }
 
 func sendFailure(ctx context.Context, w http.ResponseWriter, err string, source slog.Attr) {
 	ctx, span := Tracer.Start(ctx, "SendFailure")
 	defer span.End()
 
-	errorResponseMap := map[string]any{
-		"success": false,
-		"error":   err,
+	errorResponseMap := map[string]any{
+		"success": false,
+		"error":   err,
+	}
+	sendResponse(ctx, w, http.StatusBadRequest, &errorResponseMap, source)
+}
+
+func createCon[C containers](ctx context.Context, w http.ResponseWriter, r *http.Request, source slog.Attr, con C) {
+	var err error
+	var ids []*ID
+
+	defer func() {
+		if err != nil {
+			Logger.ErrorContext(ctx, "Failed to create containers", slog.Any("error", err), source)
+			sendFailure(ctx, w, "Failed to create containers", source)
+		}
+	}()
+
+	id, err := getID(r.Context(), source)
+	if err != nil {
+		return
+	}
+
+	if len(con) == 0 {
+		Logger.ErrorContext(ctx, "No items provided", source)
+		err = errors.New("no items provided")
+		return
+	}
+
+	switch c := any(con).(type) {
+	case []*Cart:
+		ids, err = Repos.User.CreateCarts(ctx, id, c)
+	case []*Recipe:
+		ids, err = Repos.User.CreateRecipes(ctx, id, c)
+	case []*UserOrder:
+		if ids, err = placeUserOrders(ctx, id, c, source); err != nil {
+			releaseSlotHolds(ctx, id, c, source)
+			releaseCoupons(ctx, c, source)
+			return
+		}
+		for i, order := range c {
+			if order.Slot != nil {
+				if slotErr := Repos.Slot.AttachOrder(ctx, order.Slot.HoldID, ids[i].value); slotErr != nil {
+					Logger.ErrorContext(ctx, "Unable to link the order to its slot", slog.Any("error", slotErr), source)
+				}
+			}
+			if order.Discount != nil {
+				if couponErr := Repos.Coupon.AttachRedemption(ctx, order.Discount.RedemptionID, ids[i].value); couponErr != nil {
+					Logger.ErrorContext(ctx, "Unable to link the order to its coupon", slog.Any("error", couponErr), source)
+				}
+			}
+		}
+	case []*Store:
+		ids, err = Repos.Vendor.CreateStores(ctx, id, c)
+	case []*VendorOrder:
+		ids, err = Repos.Vendor.CreateVendorOrders(ctx, id, c)
+	default:
+		Logger.ErrorContext(ctx, "Unable to get the id String fromn context", source)
+		err = errors.New("unknown type")
+		return
+	}
+	if err != nil {
+		return
+	}
+	okResponseMap := map[string]any{
+		"success": true,
+		"ids":     getStringIDs(ids),
+	}
+	sendResponse(ctx, w, http.StatusCreated, &okResponseMap, source)
+}
+
+// placeUserOrders saves the orders on the user and each vendor, the orders already saved are deleted again when
+// one of them fails
+func placeUserOrders(ctx context.Context, id ID, orders []*UserOrder, source slog.Attr) (ids []*ID, err error) {
+	if ids, err = Repos.User.CreateUserOrders(ctx, id, orders); err != nil {
+		Logger.ErrorContext(ctx, "Error in user persistence", slog.Any("error", err), source)
+		return nil, err
+	}
+
+	vg, vi := make(map[bson.ObjectID][]*VendorOrder), make(map[bson.ObjectID][]*ID)
+	for _, v := range orders {
+		vg[v.VendorID] = append(vg[v.VendorID], &VendorOrder{Order: v.Order, UserID: id.value})
+	}
+
+	for v, o := range vg {
+		oids, ordErr := Repos.Vendor.CreateVendorOrders(ctx, ID{v}, o)
+		if ordErr != nil {
+			Logger.ErrorContext(ctx, "Error in Vendor persistence Rolling back created user and vendor orders",
+				slog.Any("error", ordErr), source)
+			err = errors.Join(err, ordErr)
+
+			if userErr := Repos.User.DeleteUserOrders(ctx, id, ids); userErr != nil {
+				Logger.ErrorContext(ctx, "Error in deleting newly created User orders", slog.Any("error", userErr), source)
+				err = errors.Join(err, userErr)
+			}
+
+			for vid, oids := range vi {
+				if venErr := Repos.Vendor.DeleteVendorOrders(ctx, ID{vid}, oids); venErr != nil {
+					Logger.ErrorContext(ctx, "Error in deleting newly created Vendor orders", slog.Any("error", venErr), source)
+					err = errors.Join(err, venErr)
+				}
+			}
+			return nil, err
+		}
+		vi[v] = oids
+	}
+	return ids, nil
+}
+
+func updateCon[C containers](ctx context.Context, w http.ResponseWriter, r *http.Request, source slog.Attr, con C) {
+	var err error
+
+	id, err := getID(r.Context(), source)
+	if err != nil {
+		sendFailure(ctx, w, "Oops", source)
+		return
+	}
+
+	if len(con) == 0 {
+		Logger.ErrorContext(ctx, "No items provided", source)
+		sendFailure(ctx, w, "No items provided", source)
+		return
+	}
+
+	switch c := any(con).(type) {
+	case []*Cart:
+		err = Repos.User.UpdateCarts(ctx, id, c)
+	case []*Recipe:
+		err = Repos.User.UpdateRecipes(ctx, id, c)
+	case []*UserOrder:
+		if err = Repos.User.UpdateUserOrders(ctx, id, c); err == nil {
+			releaseOrderSlots(ctx, "user_id", id, ordersOf(c), source)
+		}
+	case []*Store:
+		err = Repos.Vendor.UpdateStores(ctx, id, c)
+	case []*VendorOrder:
+		if err = Repos.Vendor.UpdateVendorOrders(ctx, id, c); err == nil {
+			releaseOrderSlots(ctx, "vendor_id", id, ordersOf(c), source)
+		}
+	default:
+		Logger.ErrorContext(ctx, "Unable to get the id String fromn context", source)
+		sendFailure(ctx, w, "unknown type", source)
+		return
+	}
+
+	if err != nil {
+		Logger.ErrorContext(ctx, "Failed to update containers", slog.Any("error", err), source)
+		sendFailure(ctx, w, "Failed to update containers", source)
+		return
+	}
+
+	okResponseMap := map[string]any{"success": true}
+	sendResponse(ctx, w, http.StatusCreated, &okResponseMap, source)
+}
+
+func getCon[c containers](ctx context.Context, w http.ResponseWriter, r *http.Request, t c, source slog.Attr) {
+	var err error
+	id, err := getID(r.Context(), source)
+	if err != nil {
+		sendFailure(ctx, w, "Oops", source)
+		return
+	}
+
+	okResponseMap := map[string]any{
+		"success": true,
+	}
+
+	switch a := any(t).(type) {
+	case []*Recipe:
+		a, err = Repos.User.FindRecipes(ctx, id)
+		okResponseMap["value"] = a
+	case []*Cart:
+		a, err = Repos.User.FindCarts(ctx, id)
+		okResponseMap["value"] = a
+	case []*UserOrder:
+		a, err = Repos.User.FindUserOrders(ctx, id)
+		okResponseMap["value"] = a
+	case []*Store:
+		a, err = Repos.Vendor.FindStores(ctx, id)
+		okResponseMap["value"] = a
+	case []*VendorOrder:
+		a, err = Repos.Vendor.FindVendorOrders(ctx, id)
+		okResponseMap["value"] = a
+	default:
+		Logger.ErrorContext(ctx, "Unknown get container constant", source)
+		sendFailure(ctx, w, "unknown get container constant", source)
+		return
+	}
+
+	if err != nil {
+		Logger.ErrorContext(ctx, "Failed to fetch containers", slog.Any("error", err), source)
+		sendFailure(ctx, w, "Failed to fetch containers", source)
+		return
+	}
+	sendResponse(ctx, w, http.StatusOK, &okResponseMap, source)
+}
+
+func decodeStruct[req ComConReq](ctx context.Context, r io.Reader, source slog.Attr) (v *req, err error) {
+	Logger.InfoContext(ctx, "Decode the body to struct", source)
+	if err := json.NewDecoder(r).Decode(&v); err != nil {
+		Logger.ErrorContext(ctx, "Unable to parse request body", slog.Any("error", err), source)
+		return nil, err
+	}
+	Logger.InfoContext(ctx, "Decoded Successfully", source)
+	return
+}
+
+func decodeToIDS(ctx context.Context, r io.Reader, source slog.Attr) (ids []*ID, err error) {
+	Logger.InfoContext(ctx, "Decode the body to arrays of *IDs", source)
+	if err = json.NewDecoder(r).Decode(&ids); err != nil {
+		Logger.ErrorContext(ctx, "Unable to parse request body", slog.Any("error", err), source)
+		return
+	}
+	Logger.InfoContext(ctx, "Decoded Successfully", source)
+	return
+}
+
+func getDeleteIds(ctx context.Context, r io.Reader, source slog.Attr) (ID, []bson.ObjectID, error) {
+	Logger.InfoContext(ctx, "Decode the body to arrays of items IDs and container id", source)
+	var req struct {
+		Id  ID              `json:"id"`
+		Ids []bson.ObjectID `json:"ids"`
+	}
+
+	if err := json.NewDecoder(r).Decode(&req); err != nil {
+		Logger.ErrorContext(ctx, "Unable to parse request body", slog.Any("error", err), source)
+		return req.Id, req.Ids, err
+	}
+
+	Logger.InfoContext(ctx, "Decoded Successfully", source)
+	return req.Id, req.Ids, nil
+}
+
+func getID(ctx context.Context, source slog.Attr) (ID, error) {
+	v, ok := ctx.Value(userIDKey).(string)
+	if !ok {
+		Logger.ErrorContext(ctx, "Unable to get the id String fromn context", source)
+		return ID{bson.NilObjectID}, errors.New("unable to cast to string from ctx")
+	}
+	return NewID(ctx, v)
+}
+
+func makeItemsOne(res []*ResIng) {
+	for _, v := range res {
+		for _, s := range v.Stores {
+			for _, i := range s.Items {
+				i.Quantity = 1
+			}
+		}
+	}
+}
+
+// refreshPromotions writes the vendor's promotions on their items right away, the promotion job catches up on
+// anything that fails here
+func refreshPromotions(ctx context.Context, vendorID ID, source slog.Attr) {
+	if _, err := Repos.Promotion.ApplyVendorPromotions(ctx, vendorID.value, time.Now()); err != nil {
+		Logger.ErrorContext(ctx, "Promotions not applied yet", slog.String("vendorID", vendorID.String()),
+			slog.Any("error", err), source)
+	}
+}
+
+// checkPromotionStore makes sure a promotion for one store names a store of the vendor
+func checkPromotionStore(ctx context.Context, w http.ResponseWriter, vendorID ID, promotion *Promotion, source slog.Attr) bool {
+	if promotion.StoreID.IsZero() {
+		return true
+	}
+	if _, err := Repos.Vendor.FindStore(ctx, vendorID, ID{promotion.StoreID}); err != nil {
+		if errors.Is(err, &NoItems{}) {
+			sendFailure(ctx, w, "Store not found", source)
+			return false
+		}
+		sendFailure(ctx, w, "Failed to fetch the store", source)
+		return false
+	}
+	return true
+}
+
+func CreatePromotion(w http.ResponseWriter, r *http.Request) {
+	ctx, span := Tracer.Start(r.Context(), "CreatePromotion")
+	defer span.End()
+	source := slog.String("source", "CreatePromotion")
+
+	vendorID, err := getID(r.Context(), source)
+	if err != nil {
+		sendFailure(ctx, w, "Unable to get vendor ID from context", source)
+		return
+	}
+	promotion, err := decodeStruct[Promotion](ctx, r.Body, source)
+	if err != nil {
+		sendFailure(ctx, w, "Error in parsing promotion request body", source)
+		return
+	}
+	promotion.VendorID = vendorID.value
+	if err := promotion.validate(time.Now()); err != nil {
+		sendFailure(ctx, w, err.Error(), source)
+		return
+	}
+	if !checkPromotionStore(ctx, w, vendorID, promotion, source) {
+		return
+	}
+
+	if err := Repos.Promotion.CreatePromotion(ctx, promotion); err != nil {
+		sendFailure(ctx, w, "Failed to create the promotion", source)
+		return
+	}
+	refreshPromotions(ctx, vendorID, source)
+
+	okResponseMap := map[string]any{
+		"success":   true,
+		"promotion": promotion,
+	}
+	sendResponse(ctx, w, http.StatusCreated, &okResponseMap, source)
+}
+
+func GetPromotions(w http.ResponseWriter, r *http.Request) {
+	ctx, span := Tracer.Start(r.Context(), "GetPromotions")
+	defer span.End()
+	source := slog.String("source", "GetPromotions")
+
+	vendorID, err := getID(r.Context(), source)
+	if err != nil {
+		sendFailure(ctx, w, "Unable to get vendor ID from context", source)
+		return
+	}
+	status := r.URL.Query().Get("status")
+	switch status {
+	case "", promotionScheduled, promotionActive, promotionEnded:
+	default:
+		sendFailure(ctx, w, "status must be scheduled, active or ended", source)
+		return
+	}
+
+	promotions, err := Repos.Promotion.FindPromotions(ctx, vendorID, status)
+	if err != nil {
+		sendFailure(ctx, w, "Failed to fetch promotions", source)
+		return
+	}
+
+	okResponseMap := map[string]any{
+		"success":    true,
+		"promotions": promotions,
+	}
+	sendResponse(ctx, w, http.StatusOK, &okResponseMap, source)
+}
+
+func UpdatePromotion(w http.ResponseWriter, r *http.Request) {
+	ctx, span := Tracer.Start(r.Context(), "UpdatePromotion")
+	defer span.End()
+	source := slog.String("source", "UpdatePromotion")
+
+	vendorID, err := getID(r.Context(), source)
+	if err != nil {
+		sendFailure(ctx, w, "Unable to get vendor ID from context", source)
+		return
+	}
+	promotionID, err := NewID(ctx, r.PathValue("id"))
+	if err != nil {
+		sendFailure(ctx, w, "Invalid promotion id", source)
+		return
+	}
+	promotion, err := decodeStruct[Promotion](ctx, r.Body, source)
+	if err != nil {
+		sendFailure(ctx, w, "Error in parsing promotion request body", source)
+		return
+	}
+	promotion.ID, promotion.VendorID = promotionID.value, vendorID.value
+	if err := promotion.validate(time.Now()); err != nil {
+		sendFailure(ctx, w, err.Error(), source)
+		return
+	}
+	if !checkPromotionStore(ctx, w, vendorID, promotion, source) {
+		return
+	}
+
+	if err := Repos.Promotion.UpdatePromotion(ctx, promotion); err != nil {
+		if errors.Is(err, &NoItems{}) {
+			sendFailure(ctx, w, "Promotion not found", source)
+			return
+		}
+		sendFailure(ctx, w, "Failed to update the promotion", source)
+		return
+	}
+	refreshPromotions(ctx, vendorID, source)
+
+	okResponseMap := map[string]any{
+		"success":   true,
+		"promotion": promotion,
+	}
+	sendResponse(ctx, w, http.StatusOK, &okResponseMap, source)
+}
+
+func DeletePromotion(w http.ResponseWriter, r *http.Request) {
+	ctx, span := Tracer.Start(r.Context(), "DeletePromotion")
+	defer span.End()
+	source := slog.String("source", "DeletePromotion")
+
+	vendorID, err := getID(r.Context(), source)
+	if err != nil {
+		sendFailure(ctx, w, "Unable to get vendor ID from context", source)
+		return
+	}
+	promotionID, err := NewID(ctx, r.PathValue("id"))
+	if err != nil {
+		sendFailure(ctx, w, "Invalid promotion id", source)
+		return
+	}
+
+	if err := Repos.Promotion.DeletePromotion(ctx, vendorID, promotionID.value); err != nil {
+		if errors.Is(err, &NoItems{}) {
+			sendFailure(ctx, w, "Promotion not found", source)
+			return
+		}
+		sendFailure(ctx, w, "Failed to delete the promotion", source)
+		return
+	}
+	refreshPromotions(ctx, vendorID, source)
+
+	okResponseMap := map[string]any{"success": true}
+	sendResponse(ctx, w, http.StatusOK, &okResponseMap, source)
+}
+
+func AdminCreateCoupon(w http.ResponseWriter, r *http.Request) {
+	ctx, span := Tracer.Start(r.Context(), "AdminCreateCoupon")
+	defer span.End()
+
+	createCoupon(ctx, w, r, false, slog.String("source", "AdminCreateCoupon"))
+}
+
+func VendorCreateCoupon(w http.ResponseWriter, r *http.Request) {
+	ctx, span := Tracer.Start(r.Context(), "VendorCreateCoupon")
+	defer span.End()
+
+	createCoupon(ctx, w, r, true, slog.String("source", "VendorCreateCoupon"))
+}
+
+// createCoupon saves a platform coupon, or a coupon of the calling vendor for one of their stores
+func createCoupon(ctx context.Context, w http.ResponseWriter, r *http.Request, byVendor bool, source slog.Attr) {
+	id, err := getID(r.Context(), source)
+	if err != nil {
+		sendFailure(ctx, w, "Unable to get ID from context", source)
+		return
+	}
+	coupon, err := decodeStruct[Coupon](ctx, r.Body, source)
+	if err != nil {
+		sendFailure(ctx, w, "Error in parsing coupon request body", source)
+		return
+	}
+	if err := coupon.validate(time.Now()); err != nil {
+		sendFailure(ctx, w, err.Error(), source)
+		return
+	}
+	coupon.CreatedBy, coupon.VendorID = id.value, bson.NilObjectID
+	if byVendor {
+		if coupon.StoreID.IsZero() {
+			sendFailure(ctx, w, "A vendor coupon needs a store_id", source)
+			return
+		}
+		if _, err := Repos.Vendor.FindStore(ctx, id, ID{coupon.StoreID}); err != nil {
+			if errors.Is(err, &NoItems{}) {
+				sendFailure(ctx, w, "Store not found", source)
+				return
+			}
+			sendFailure(ctx, w, "Failed to fetch the store", source)
+			return
+		}
+		coupon.VendorID = id.value
+	} else {
+		coupon.StoreID = bson.NilObjectID
+	}
+
+	if err := Repos.Coupon.CreateCoupon(ctx, coupon); err != nil {
+		if errors.Is(err, errCouponExists) {
+			sendFailure(ctx, w, err.Error(), source)
+			return
+		}
+		sendFailure(ctx, w, "Failed to create the coupon", source)
+		return
+	}
+
+	okResponseMap := map[string]any{
+		"success": true,
+		"coupon":  coupon,
+	}
+	sendResponse(ctx, w, http.StatusCreated, &okResponseMap, source)
+}
+
+func AdminGetCoupons(w http.ResponseWriter, r *http.Request) {
+	ctx, span := Tracer.Start(r.Context(), "AdminGetCoupons")
+	defer span.End()
+
+	sendCoupons(ctx, w, bson.NilObjectID, slog.String("source", "AdminGetCoupons"))
+}
+
+func VendorGetCoupons(w http.ResponseWriter, r *http.Request) {
+	ctx, span := Tracer.Start(r.Context(), "VendorGetCoupons")
+	defer span.End()
+	source := slog.String("source", "VendorGetCoupons")
+
+	vendorID, err := getID(r.Context(), source)
+	if err != nil {
+		sendFailure(ctx, w, "Unable to get vendor ID from context", source)
+		return
+	}
+	sendCoupons(ctx, w, vendorID.value, source)
+}
+
+func sendCoupons(ctx context.Context, w http.ResponseWriter, vendorID bson.ObjectID, source slog.Attr) {
+	coupons, err := Repos.Coupon.FindCoupons(ctx, vendorID)
+	if err != nil {
+		sendFailure(ctx, w, "Failed to fetch coupons", source)
+		return
+	}
+
+	okResponseMap := map[string]any{
+		"success": true,
+		"coupons": coupons,
+	}
+	sendResponse(ctx, w, http.StatusOK, &okResponseMap, source)
+}
+
+func AdminDeleteCoupon(w http.ResponseWriter, r *http.Request) {
+	ctx, span := Tracer.Start(r.Context(), "AdminDeleteCoupon")
+	defer span.End()
+
+	deleteCoupon(ctx, w, r, bson.NilObjectID, slog.String("source", "AdminDeleteCoupon"))
+}
+
+func VendorDeleteCoupon(w http.ResponseWriter, r *http.Request) {
+	ctx, span := Tracer.Start(r.Context(), "VendorDeleteCoupon")
+	defer span.End()
+	source := slog.String("source", "VendorDeleteCoupon")
+
+	vendorID, err := getID(r.Context(), source)
+	if err != nil {
+		sendFailure(ctx, w, "Unable to get vendor ID from context", source)
+		return
+	}
+	deleteCoupon(ctx, w, r, vendorID.value, source)
+}
+
+func deleteCoupon(ctx context.Context, w http.ResponseWriter, r *http.Request, vendorID bson.ObjectID, source slog.Attr) {
+	couponID, err := NewID(ctx, r.PathValue("id"))
+	if err != nil {
+		sendFailure(ctx, w, "Invalid coupon id", source)
+		return
+	}
+
+	if err := Repos.Coupon.DeleteCoupon(ctx, vendorID, couponID.value); err != nil {
+		if errors.Is(err, &NoItems{}) {
+			sendFailure(ctx, w, "Coupon not found", source)
+			return
+		}
+		sendFailure(ctx, w, "Failed to delete the coupon", source)
+		return
+	}
+
+	okResponseMap := map[string]any{"success": true}
+	sendResponse(ctx, w, http.StatusOK, &okResponseMap, source)
+}
+
+func GetVendorPriceHistory(w http.ResponseWriter, r *http.Request) {
+	ctx, span := Tracer.Start(r.Context(), "GetVendorPriceHistory")
+	defer span.End()
+	source := slog.String("source", "GetVendorPriceHistory")
+
+	vendorID, err := getID(r.Context(), source)
+	if err != nil {
+		sendFailure(ctx, w, "Unable to get vendor ID from context", source)
+		return
+	}
+	storeID, err := NewID(ctx, r.PathValue("id"))
+	if err != nil {
+		sendFailure(ctx, w, "Invalid store id", source)
+		return
+	}
+	sendPriceHistory(ctx, w, r, vendorID, storeID, source)
+}
+
+func GetPriceHistory(w http.ResponseWriter, r *http.Request) {
+	ctx, span := Tracer.Start(r.Context(), "GetPriceHistory")
+	defer span.End()
+	source := slog.String("source", "GetPriceHistory")
+
+	vendorID, err := NewID(ctx, r.PathValue("vendor"))
+	if err != nil {
+		sendFailure(ctx, w, "Invalid vendor id", source)
+		return
+	}
+	storeID, err := NewID(ctx, r.PathValue("store"))
+	if err != nil {
+		sendFailure(ctx, w, "Invalid store id", source)
+		return
+	}
+	sendPriceHistory(ctx, w, r, vendorID, storeID, source)
+}
+
+// sendPriceHistory answers with the price points of the item in the path, the last 90 days unless from or to
+// are given
+func sendPriceHistory(ctx context.Context, w http.ResponseWriter, r *http.Request, vendorID, storeID ID, source slog.Attr) {
+	itemID, err := NewID(ctx, r.PathValue("item"))
+	if err != nil {
+		sendFailure(ctx, w, "Invalid item id", source)
+		return
+	}
+	from, to, err := parseTimeRange(r.URL.Query())
+	if err != nil {
+		sendFailure(ctx, w, err.Error(), source)
+		return
+	}
+	if from.IsZero() && to.IsZero() {
+		from = time.Now().AddDate(0, 0, -defaultPriceHistoryDays)
+	}
+
+	history, err := Repos.Price.FindPriceHistory(ctx, vendorID, storeID, itemID, from, to)
+	if err != nil {
+		sendFailure(ctx, w, "Failed to fetch the price history", source)
+		return
+	}
+
+	okResponseMap := map[string]any{
+		"success": true,
+		"history": history,
+	}
+	sendResponse(ctx, w, http.StatusOK, &okResponseMap, source)
+}
+
+func CreatePriceAlert(w http.ResponseWriter, r *http.Request) {
+	ctx, span := Tracer.Start(r.Context(), "CreatePriceAlert")
+	defer span.End()
+	source := slog.String("source", "CreatePriceAlert")
+
+	userID, err := getID(r.Context(), source)
+	if err != nil {
+		sendFailure(ctx, w, "Unable to get user ID from context", source)
+		return
+	}
+	alert, err := decodeStruct[PriceAlert](ctx, r.Body, source)
+	if err != nil {
+		sendFailure(ctx, w, "Error in parsing price alert request body", source)
+		return
+	}
+	if err := alert.validate(); err != nil {
+		sendFailure(ctx, w, err.Error(), source)
+		return
+	}
+
+	ingredient, err := Repos.Catalog.FindCatalogIngredient(ctx, ID{alert.CatalogID})
+	if err != nil {
+		if errors.Is(err, &NoItems{}) {
+			sendFailure(ctx, w, "Ingredient not found", source)
+			return
+		}
+		sendFailure(ctx, w, "Failed to fetch the ingredient", source)
+		return
+	}
+	per, ok := basePer(ingredient.Unit)
+	if !ok {
+		sendFailure(ctx, w, "The ingredient has no unit its prices can be compared in", source)
+		return
+	}
+	alert.UserID, alert.Name, alert.Per, alert.Density = userID.value, ingredient.Name, per, ingredient.Density
+	alert.LastPrice, alert.LastNotifiedAt = 0, nil
+
+	if err := Repos.Price.CreatePriceAlert(ctx, alert); err != nil {
+		if errors.Is(err, errTooManyPriceAlerts) {
+			sendFailure(ctx, w, err.Error(), source)
+			return
+		}
+		sendFailure(ctx, w, "Failed to create the price alert", source)
+		return
+	}
+
+	okResponseMap := map[string]any{
+		"success": true,
+		"alert":   alert,
+	}
+	sendResponse(ctx, w, http.StatusCreated, &okResponseMap, source)
+}
+
+func GetPriceAlerts(w http.ResponseWriter, r *http.Request) {
+	ctx, span := Tracer.Start(r.Context(), "GetPriceAlerts")
+	defer span.End()
+	source := slog.String("source", "GetPriceAlerts")
+
+	userID, err := getID(r.Context(), source)
+	if err != nil {
+		sendFailure(ctx, w, "Unable to get user ID from context", source)
+		return
+	}
+
+	alerts, err := Repos.Price.FindPriceAlerts(ctx, userID)
+	if err != nil {
+		sendFailure(ctx, w, "Failed to fetch price alerts", source)
+		return
+	}
+
+	okResponseMap := map[string]any{
+		"success": true,
+		"alerts":  alerts,
+	}
+	sendResponse(ctx, w, http.StatusOK, &okResponseMap, source)
+}
+
+func DeletePriceAlert(w http.ResponseWriter, r *http.Request) {
+	ctx, span := Tracer.Start(r.Context(), "DeletePriceAlert")
+	defer span.End()
+	source := slog.String("source", "DeletePriceAlert")
+
+	userID, err := getID(r.Context(), source)
+	if err != nil {
+		sendFailure(ctx, w, "Unable to get user ID from context", source)
+		return
+	}
+	alertID, err := NewID(ctx, r.PathValue("id"))
+	if err != nil {
+		sendFailure(ctx, w, "Invalid alert id", source)
+		return
+	}
+
+	if err := Repos.Price.DeletePriceAlert(ctx, userID, alertID.value); err != nil {
+		if errors.Is(err, &NoItems{}) {
+			sendFailure(ctx, w, "Price alert not found", source)
+			return
+		}
+		sendFailure(ctx, w, "Failed to delete the price alert", source)
+		return
+	}
+
+	okResponseMap := map[string]any{"success": true}
+	sendResponse(ctx, w, http.StatusOK, &okResponseMap, source)
+}
+
+func AdminCreateTaxRule(w http.ResponseWriter, r *http.Request) {
+	ctx, span := Tracer.Start(r.Context(), "AdminCreateTaxRule")
+	defer span.End()
+	source := slog.String("source", "AdminCreateTaxRule")
+
+	adminID, err := getID(r.Context(), source)
+	if err != nil {
+		sendFailure(ctx, w, "Unable to get admin ID from context", source)
+		return
+	}
+	rule, err := decodeStruct[TaxRule](ctx, r.Body, source)
+	if err != nil {
+		sendFailure(ctx, w, "Error in parsing tax rule request body", source)
+		return
+	}
+	if err := rule.validate(); err != nil {
+		sendFailure(ctx, w, err.Error(), source)
+		return
+	}
+	rule.CreatedBy = adminID.value
+
+	if err := Repos.Tax.CreateTaxRule(ctx, rule); err != nil {
+		if errors.Is(err, errTaxRuleExists) || errors.Is(err, errTaxRuleCategory) {
+			sendFailure(ctx, w, err.Error(), source)
+			return
+		}
+		sendFailure(ctx, w, "Failed to create the tax rule", source)
+		return
+	}
+
+	okResponseMap := map[string]any{
+		"success": true,
+		"rule":    rule,
+	}
+	sendResponse(ctx, w, http.StatusCreated, &okResponseMap, source)
+}
+
+// AdminGetTaxRules lists the tax rules, ?region= narrows them to one region
+func AdminGetTaxRules(w http.ResponseWriter, r *http.Request) {
+	ctx, span := Tracer.Start(r.Context(), "AdminGetTaxRules")
+	defer span.End()
+	source := slog.String("source", "AdminGetTaxRules")
+
+	rules, err := Repos.Tax.FindTaxRules(ctx, normalizeRegion(r.URL.Query().Get("region")))
+	if err != nil {
+		sendFailure(ctx, w, "Failed to fetch the tax rules", source)
+		return
+	}
+
+	okResponseMap := map[string]any{
+		"success": true,
+		"rules":   rules,
+	}
+	sendResponse(ctx, w, http.StatusOK, &okResponseMap, source)
+}
+
+func AdminUpdateTaxRule(w http.ResponseWriter, r *http.Request) {
+	ctx, span := Tracer.Start(r.Context(), "AdminUpdateTaxRule")
+	defer span.End()
+	source := slog.String("source", "AdminUpdateTaxRule")
+
+	ruleID, err := NewID(ctx, r.PathValue("id"))
+	if err != nil {
+		sendFailure(ctx, w, "Invalid tax rule id", source)
+		return
+	}
+	rule, err := decodeStruct[TaxRule](ctx, r.Body, source)
+	if err != nil {
+		sendFailure(ctx, w, "Error in parsing tax rule request body", source)
+		return
+	}
+	rule.ID = ruleID.value
+	if err := rule.validate(); err != nil {
+		sendFailure(ctx, w, err.Error(), source)
+		return
+	}
+
+	if err := Repos.Tax.UpdateTaxRule(ctx, rule); err != nil {
+		if errors.Is(err, &NoItems{}) {
+			sendFailure(ctx, w, "Tax rule not found", source)
+			return
+		}
+		if errors.Is(err, errTaxRuleExists) || errors.Is(err, errTaxRuleCategory) {
+			sendFailure(ctx, w, err.Error(), source)
+			return
+		}
+		sendFailure(ctx, w, "Failed to update the tax rule", source)
+		return
+	}
+
+	okResponseMap := map[string]any{
+		"success": true,
+		"rule":    rule,
+	}
+	sendResponse(ctx, w, http.StatusOK, &okResponseMap, source)
+}
+
+func AdminDeleteTaxRule(w http.ResponseWriter, r *http.Request) {
+	ctx, span := Tracer.Start(r.Context(), "AdminDeleteTaxRule")
+	defer span.End()
+	source := slog.String("source", "AdminDeleteTaxRule")
+
+	ruleID, err := NewID(ctx, r.PathValue("id"))
+	if err != nil {
+		sendFailure(ctx, w, "Invalid tax rule id", source)
+		return
+	}
+	if err := Repos.Tax.DeleteTaxRule(ctx, ruleID.value); err != nil {
+		if errors.Is(err, &NoItems{}) {
+			sendFailure(ctx, w, "Tax rule not found", source)
+			return
+		}
+		sendFailure(ctx, w, "Failed to delete the tax rule", source)
+		return
+	}
+
+	okResponseMap := map[string]any{
+		"success": true,
+	}
+	sendResponse(ctx, w, http.StatusOK, &okResponseMap, source)
+}
+
+// GetOrderReceipt renders the receipt of a delivered order of the user as PDF or HTML, see documentFormat
+func GetOrderReceipt(w http.ResponseWriter, r *http.Request) {
+	ctx, span := Tracer.Start(r.Context(), "GetOrderReceipt")
+	defer span.End()
+	source := slog.String("source", "GetOrderReceipt")
+
+	userID, err := getID(r.Context(), source)
+	if err != nil {
+		sendFailure(ctx, w, "Unable to get user ID from context", source)
+		return
+	}
+	orderID, err := NewID(ctx, r.PathValue("id"))
+	if err != nil {
+		sendFailure(ctx, w, "Invalid order id", source)
+		return
+	}
+	format, err := documentFormat(r.URL.Query().Get("format"), r.Header.Get("Accept"))
+	if err != nil {
+		sendFailure(ctx, w, err.Error(), source)
+		return
+	}
+
+	order, err := Repos.Invoice.FindSoldOrder(ctx, userID, orderID)
+	if err != nil {
+		if errors.Is(err, &NoItems{}) {
+			sendFailure(ctx, w, "Order not found", source)
+			return
+		}
+		sendFailure(ctx, w, "Failed to fetch the order", source)
+		return
+	}
+	if !strings.EqualFold(order.OrderStatus, orderDelivered) {
+		sendFailure(ctx, w, "Receipts are only available for delivered orders", source)
+		return
+	}
+	seller, err := Repos.Invoice.FindSeller(ctx, ID{order.VendorID}, ID{order.StoreID})
+	if err != nil {
+		sendFailure(ctx, w, "Failed to fetch the store", source)
+		return
+	}
+
+	doc := newOrderDocument("Receipt", order.ID.Hex(), time.Now(), seller, &order.Order, order.PaymentStatus)
+	sendDocument(ctx, w, doc, format, "receipt-"+order.ID.Hex(), source)
+}
+
+// GetOrderInvoice renders the invoice of a delivered order of the vendor, the order is numbered on the first
+// request and keeps its number
+func GetOrderInvoice(w http.ResponseWriter, r *http.Request) {
+	ctx, span := Tracer.Start(r.Context(), "GetOrderInvoice")
+	defer span.End()
+	source := slog.String("source", "GetOrderInvoice")
+
+	vendorID, err := getID(r.Context(), source)
+	if err != nil {
+		sendFailure(ctx, w, "Unable to get vendor ID from context", source)
+		return
+	}
+	orderID, err := NewID(ctx, r.PathValue("id"))
+	if err != nil {
+		sendFailure(ctx, w, "Invalid order id", source)
+		return
+	}
+	format, err := documentFormat(r.URL.Query().Get("format"), r.Header.Get("Accept"))
+	if err != nil {
+		sendFailure(ctx, w, err.Error(), source)
+		return
+	}
+
+	order, err := Repos.Invoice.FindVendorOrder(ctx, vendorID, orderID)
+	if err != nil {
+		if errors.Is(err, &NoItems{}) {
+			sendFailure(ctx, w, "Order not found", source)
+			return
+		}
+		sendFailure(ctx, w, "Failed to fetch the order", source)
+		return
+	}
+	if !strings.EqualFold(order.OrderStatus, orderDelivered) {
+		sendFailure(ctx, w, "Invoices are only available for delivered orders", source)
+		return
+	}
+	paymentStatus := ""
+	if userOrder, err := Repos.Invoice.FindUserOrder(ctx, ID{order.UserID}, orderID); err == nil {
+		paymentStatus = userOrder.PaymentStatus
+	} else if !errors.Is(err, &NoItems{}) {
+		sendFailure(ctx, w, "Failed to fetch the order", source)
+		return
+	}
+	seller, err := Repos.Invoice.FindSeller(ctx, vendorID, ID{order.StoreID})
+	if err != nil {
+		sendFailure(ctx, w, "Failed to fetch the store", source)
+		return
+	}
+	invoice, err := Repos.Invoice.IssueInvoice(ctx, vendorID.value, orderID.value, time.Now())
+	if err != nil {
+		sendFailure(ctx, w, "Failed to issue the invoice", source)
+		return
+	}
+
+	doc := newOrderDocument("Invoice", invoice.code(), invoice.IssuedAt, seller, &order.Order, paymentStatus)
+	sendDocument(ctx, w, doc, format, strings.ToLower(invoice.code()), source)
+}
+
+// sendDocument writes the rendered document as a download named after the file
+func sendDocument(ctx context.Context, w http.ResponseWriter, doc *orderDocument, format, file string, source slog.Attr) {
+	var buf bytes.Buffer
+	if err := doc.render(&buf, format); err != nil {
+		Logger.ErrorContext(ctx, "Unable to render the document", slog.Any("error", err), source)
+		sendFailure(ctx, w, "Failed to render the document", source)
+		return
+	}
+	contentType := "application/pdf"
+	if format == documentHTML {
+		contentType = "text/html; charset=utf-8"
+	}
+	w.Header().Set("Content-Type", contentType)
+	w.Header().Set("Content-Disposition", fmt.Sprintf("attachment; filename=\"%s.%s\"", file, format))
+	w.WriteHeader(http.StatusOK)
+	if _, err := w.Write(buf.Bytes()); err != nil {
+		Logger.ErrorContext(ctx, "Unable to send the document", slog.Any("error", err), source)
+	}
+}
+
+// GetPayouts is the vendor's commission, what has accrued since their last statement and their statements
+func GetPayouts(w http.ResponseWriter, r *http.Request) {
+	ctx, span := Tracer.Start(r.Context(), "GetPayouts")
+	defer span.End()
+	source := slog.String("source", "GetPayouts")
+
+	vendorID, err := getID(r.Context(), source)
+	if err != nil {
+		sendFailure(ctx, w, "Unable to get vendor ID from context", source)
+		return
+	}
+	payouts, err := Repos.Settlement.FindPayouts(ctx, vendorID)
+	if err != nil {
+		sendFailure(ctx, w, "Failed to fetch the payouts", source)
+		return
+	}
+
+	okResponseMap := map[string]any{
+		"success": true,
+		"payouts": payouts,
+	}
+	sendResponse(ctx, w, http.StatusOK, &okResponseMap, source)
+}
+
+func GetPayoutStatement(w http.ResponseWriter, r *http.Request) {
+	ctx, span := Tracer.Start(r.Context(), "GetPayoutStatement")
+	defer span.End()
+	source := slog.String("source", "GetPayoutStatement")
+
+	vendorID, err := getID(r.Context(), source)
+	if err != nil {
+		sendFailure(ctx, w, "Unable to get vendor ID from context", source)
+		return
+	}
+	sendPayoutStatement(ctx, w, r, vendorID.value, source)
+}
+
+func AdminGetPayoutStatement(w http.ResponseWriter, r *http.Request) {
+	ctx, span := Tracer.Start(r.Context(), "AdminGetPayoutStatement")
+	defer span.End()
+
+	sendPayoutStatement(ctx, w, r, bson.NilObjectID, slog.String("source", "AdminGetPayoutStatement"))
+}
+
+// sendPayoutStatement sends the statement of the path with its entries, a nil vendor reads any vendor's
+func sendPayoutStatement(ctx context.Context, w http.ResponseWriter, r *http.Request, vendorID bson.ObjectID, source slog.Attr) {
+	statementID, err := NewID(ctx, r.PathValue("id"))
+	if err != nil {
+		sendFailure(ctx, w, "Invalid statement id", source)
+		return
+	}
+	statement, entries, err := Repos.Settlement.FindStatement(ctx, vendorID, statementID.value)
+	if err != nil {
+		if errors.Is(err, &NoItems{}) {
+			sendFailure(ctx, w, "Statement not found", source)
+			return
+		}
+		sendFailure(ctx, w, "Failed to fetch the statement", source)
+		return
+	}
+
+	okResponseMap := map[string]any{
+		"success":   true,
+		"statement": statement,
+		"entries":   entries,
+	}
+	sendResponse(ctx, w, http.StatusOK, &okResponseMap, source)
+}
+
+// AdminGetPayouts lists the statements of every vendor, ?status=due is what still has to be paid out
+func AdminGetPayouts(w http.ResponseWriter, r *http.Request) {
+	ctx, span := Tracer.Start(r.Context(), "AdminGetPayouts")
+	defer span.End()
+	source := slog.String("source", "AdminGetPayouts")
+
+	status := r.URL.Query().Get("status")
+	switch status {
+	case "", statementDue, statementPaid:
+	default:
+		sendFailure(ctx, w, "status must be due or paid", source)
+		return
+	}
+	statements, err := Repos.Settlement.FindStatements(ctx, status)
+	if err != nil {
+		sendFailure(ctx, w, "Failed to fetch the statements", source)
+		return
+	}
+
+	okResponseMap := map[string]any{
+		"success":    true,
+		"statements": statements,
+	}
+	sendResponse(ctx, w, http.StatusOK, &okResponseMap, source)
+}
+
+func AdminMarkPayoutPaid(w http.ResponseWriter, r *http.Request) {
+	ctx, span := Tracer.Start(r.Context(), "AdminMarkPayoutPaid")
+	defer span.End()
+	source := slog.String("source", "AdminMarkPayoutPaid")
+
+	statementID, err := NewID(ctx, r.PathValue("id"))
+	if err != nil {
+		sendFailure(ctx, w, "Invalid statement id", source)
+		return
+	}
+	req, err := decodeStruct[PayoutPaidReq](ctx, r.Body, source)
+	if err != nil {
+		sendFailure(ctx, w, "Error in parsing payout request body", source)
+		return
+	}
+	if req.Reference = strings.TrimSpace(req.Reference); req.Reference == "" {
+		sendFailure(ctx, w, "A payout needs the reference of the transfer", source)
+		return
+	}
+
+	statement, err := Repos.Settlement.MarkStatementPaid(ctx, statementID.value, req.Reference, time.Now())
+	if err != nil {
+		switch {
+		case errors.Is(err, &NoItems{}):
+			sendFailure(ctx, w, "Statement not found", source)
+		case errors.Is(err, errStatementPaid):
+			sendFailure(ctx, w, err.Error(), source)
+		default:
+			sendFailure(ctx, w, "Failed to mark the statement paid", source)
+		}
+		return
+	}
+
+	okResponseMap := map[string]any{
+		"success":   true,
+		"statement": statement,
+	}
+	sendResponse(ctx, w, http.StatusOK, &okResponseMap, source)
+}
+
+// AdminSetCommission sets the commission of the vendor of the path, a null rate puts them back on the platform
+// rate
+func AdminSetCommission(w http.ResponseWriter, r *http.Request) {
+	ctx, span := Tracer.Start(r.Context(), "AdminSetCommission")
+	defer span.End()
+	source := slog.String("source", "AdminSetCommission")
+
+	vendorID, err := NewID(ctx, r.PathValue("id"))
+	if err != nil {
+		sendFailure(ctx, w, "Invalid vendor id", source)
+		return
+	}
+	req, err := decodeStruct[CommissionReq](ctx, r.Body, source)
+	if err != nil {
+		sendFailure(ctx, w, "Error in parsing commission request body", source)
+		return
+	}
+	if req.Rate != nil {
+		if err := validCommission(*req.Rate); err != nil {
+			sendFailure(ctx, w, err.Error(), source)
+			return
+		}
+	}
+	if _, err := Repos.Vendor.FindVendorByID(ctx, vendorID); err != nil {
+		sendFailure(ctx, w, "Vendor not found", source)
+		return
+	}
+	if err := Repos.Settlement.SetCommissionRate(ctx, vendorID, req.Rate); err != nil {
+		sendFailure(ctx, w, "Failed to set the commission", source)
+		return
+	}
+
+	okResponseMap := map[string]any{
+		"success": true,
+	}
+	sendResponse(ctx, w, http.StatusOK, &okResponseMap, source)
+}
+
+// CreateReview rates the store of a delivered order of the user, each order can be reviewed once
+func CreateReview(w http.ResponseWriter, r *http.Request) {
+	ctx, span := Tracer.Start(r.Context(), "CreateReview")
+	defer span.End()
+	source := slog.String("source", "CreateReview")
+
+	userID, err := getID(r.Context(), source)
+	if err != nil {
+		sendFailure(ctx, w, "Unable to get user ID from context", source)
+		return
+	}
+	orderID, err := NewID(ctx, r.PathValue("id"))
+	if err != nil {
+		sendFailure(ctx, w, "Invalid order id", source)
+		return
+	}
+	review, err := decodeStruct[Review](ctx, r.Body, source)
+	if err != nil {
+		sendFailure(ctx, w, "Error in parsing review request body", source)
+		return
+	}
+	if err := review.validate(); err != nil {
+		sendFailure(ctx, w, err.Error(), source)
+		return
+	}
+
+	order, err := Repos.Invoice.FindSoldOrder(ctx, userID, orderID)
+	if err != nil {
+		if errors.Is(err, &NoItems{}) {
+			sendFailure(ctx, w, "Order not found", source)
+			return
+		}
+		sendFailure(ctx, w, "Failed to fetch the order", source)
+		return
+	}
+	if !strings.EqualFold(order.OrderStatus, orderDelivered) {
+		sendFailure(ctx, w, "Only delivered orders can be reviewed", source)
+		return
+	}
+	user, err := Repos.User.FindUserByID(ctx, userID)
+	if err != nil {
+		sendFailure(ctx, w, "Failed to fetch the user", source)
+		return
+	}
+
+	review.UserID, review.UserName, review.VendorID, review.StoreID, review.OrderID, review.Reply =
+		userID.value, user.Name, order.VendorID, order.StoreID, order.ID, nil
+	if err := Repos.Review.CreateReview(ctx, review); err != nil {
+		if errors.Is(err, errReviewExists) {
+			sendFailure(ctx, w, err.Error(), source)
+			return
+		}
+		sendFailure(ctx, w, "Failed to create the review", source)
+		return
+	}
+	refreshStoreRating(ctx, review, source)
+
+	okResponseMap := map[string]any{
+		"success": true,
+		"review":  review,
+	}
+	sendResponse(ctx, w, http.StatusCreated, &okResponseMap, source)
+}
+
+// refreshStoreRating updates the rating of the review's store, the review is already saved so a failure is
+// only logged and the next review fixes the rating
+func refreshStoreRating(ctx context.Context, review *Review, source slog.Attr) {
+	if err := Repos.Review.RefreshStoreRating(ctx, review.VendorID, review.StoreID); err != nil {
+		Logger.ErrorContext(ctx, "Unable to refresh the store rating", slog.String("storeID", review.StoreID.Hex()),
+			slog.Any("error", err), source)
+	}
+}
+
+// GetStoreReviews lists the published reviews of a store, newest first
+func GetStoreReviews(w http.ResponseWriter, r *http.Request) {
+	ctx, span := Tracer.Start(r.Context(), "GetStoreReviews")
+	defer span.End()
+	source := slog.String("source", "GetStoreReviews")
+
+	vendorID, err := NewID(ctx, r.PathValue("vendor"))
+	if err != nil {
+		sendFailure(ctx, w, "Invalid vendor id", source)
+		return
+	}
+	storeID, err := NewID(ctx, r.PathValue("store"))
+	if err != nil {
+		sendFailure(ctx, w, "Invalid store id", source)
+		return
+	}
+	query, err := parseReviewQuery(r.URL.Query(), false)
+	if err != nil {
+		sendFailure(ctx, w, err.Error(), source)
+		return
+	}
+	query.VendorID, query.StoreID = vendorID.value, storeID.value
+	sendReviews(ctx, w, query, source)
+}
+
+// GetVendorReviews lists the reviews of the vendor's stores, ?store= narrows them to one store
+func GetVendorReviews(w http.ResponseWriter, r *http.Request) {
+	ctx, span := Tracer.Start(r.Context(), "GetVendorReviews")
+	defer span.End()
+	source := slog.String("source", "GetVendorReviews")
+
+	vendorID, err := getID(r.Context(), source)
+	if err != nil {
+		sendFailure(ctx, w, "Unable to get vendor ID from context", source)
+		return
+	}
+	query, err := parseReviewQuery(r.URL.Query(), false)
+	if err != nil {
+		sendFailure(ctx, w, err.Error(), source)
+		return
+	}
+	query.VendorID = vendorID.value
+	if store := r.URL.Query().Get("store"); store != "" {
+		storeID, err := NewID(ctx, store)
+		if err != nil {
+			sendFailure(ctx, w, "Invalid store id", source)
+			return
+		}
+		query.StoreID = storeID.value
+	}
+	sendReviews(ctx, w, query, source)
+}
+
+// AdminGetReviews lists the reviews of every store for moderation, ?status= narrows them
+func AdminGetReviews(w http.ResponseWriter, r *http.Request) {
+	ctx, span := Tracer.Start(r.Context(), "AdminGetReviews")
+	defer span.End()
+	source := slog.String("source", "AdminGetReviews")
+
+	query, err := parseReviewQuery(r.URL.Query(), true)
+	if err != nil {
+		sendFailure(ctx, w, err.Error(), source)
+		return
+	}
+	sendReviews(ctx, w, query, source)
+}
+
+func sendReviews(ctx context.Context, w http.ResponseWriter, query *ReviewQuery, source slog.Attr) {
+	reviews, err := Repos.Review.FindReviews(ctx, query)
+	if err != nil {
+		sendFailure(ctx, w, "Failed to fetch the reviews", source)
+		return
+	}
+
+	okResponseMap := map[string]any{
+		"success":   true,
+		"reviews":   reviews,
+		"page":      query.Page,
+		"page_size": query.PageSize,
+	}
+	sendResponse(ctx, w, http.StatusOK, &okResponseMap, source)
+}
+
+func ReplyToReview(w http.ResponseWriter, r *http.Request) {
+	ctx, span := Tracer.Start(r.Context(), "ReplyToReview")
+	defer span.End()
+	source := slog.String("source", "ReplyToReview")
+
+	vendorID, err := getID(r.Context(), source)
+	if err != nil {
+		sendFailure(ctx, w, "Unable to get vendor ID from context", source)
+		return
+	}
+	reviewID, err := NewID(ctx, r.PathValue("id"))
+	if err != nil {
+		sendFailure(ctx, w, "Invalid review id", source)
+		return
+	}
+	reply, err := decodeStruct[ReviewReply](ctx, r.Body, source)
+	if err != nil {
+		sendFailure(ctx, w, "Error in parsing reply request body", source)
+		return
+	}
+	if err := reply.validate(); err != nil {
+		sendFailure(ctx, w, err.Error(), source)
+		return
+	}
+
+	review, err := Repos.Review.ReplyToReview(ctx, vendorID, reviewID.value, reply)
+	if err != nil {
+		if errors.Is(err, &NoItems{}) {
+			sendFailure(ctx, w, "Review not found", source)
+			return
+		}
+		sendFailure(ctx, w, "Failed to reply to the review", source)
+		return
+	}
+
+	okResponseMap := map[string]any{
+		"success": true,
+		"review":  review,
+	}
+	sendResponse(ctx, w, http.StatusOK, &okResponseMap, source)
+}
+
+func AdminSetReviewStatus(w http.ResponseWriter, r *http.Request) {
+	ctx, span := Tracer.Start(r.Context(), "AdminSetReviewStatus")
+	defer span.End()
+	source := slog.String("source", "AdminSetReviewStatus")
+
+	reviewID, err := NewID(ctx, r.PathValue("id"))
+	if err != nil {
+		sendFailure(ctx, w, "Invalid review id", source)
+		return
 	}
-	sendResponse(ctx, w, http.StatusBadRequest, &errorResponseMap, source)
+	req, err := decodeStruct[ReviewStatusReq](ctx, r.Body, source)
+	if err != nil {
+		sendFailure(ctx, w, "Error in parsing review status request body", source)
+		return
+	}
+	if req.Status != reviewPublished && req.Status != reviewHidden {
+		sendFailure(ctx, w, fmt.Sprintf("status must be %s or %s", reviewPublished, reviewHidden), source)
+		return
+	}
+
+	review, err := Repos.Review.SetReviewStatus(ctx, reviewID.value, req.Status)
+	if err != nil {
+		if errors.Is(err, &NoItems{}) {
+			sendFailure(ctx, w, "Review not found", source)
+			return
+		}
+		sendFailure(ctx, w, "Failed to moderate the review", source)
+		return
+	}
+	refreshStoreRating(ctx, review, source)
+
+	okResponseMap := map[string]any{
+		"success": true,
+		"review":  review,
+	}
+	sendResponse(ctx, w, http.StatusOK, &okResponseMap, source)
 }
 
-func createCon[C containers](ctx context.Context, w http.ResponseWriter, r *http.Request, source slog.Attr, con C) {
-	var err error
-	var ids []*ID
+func AdminDeleteReview(w http.ResponseWriter, r *http.Request) {
+	ctx, span := Tracer.Start(r.Context(), "AdminDeleteReview")
+	defer span.End()
+	source := slog.String("source", "AdminDeleteReview")
 
-	defer func() {
+	reviewID, err := NewID(ctx, r.PathValue("id"))
+	if err != nil {
+		sendFailure(ctx, w, "Invalid review id", source)
+		return
+	}
+	review, err := Repos.Review.DeleteReview(ctx, reviewID.value)
+	if err != nil {
+		if errors.Is(err, &NoItems{}) {
+			sendFailure(ctx, w, "Review not found", source)
+			return
+		}
+		sendFailure(ctx, w, "Failed to delete the review", source)
+		return
+	}
+	refreshStoreRating(ctx, review, source)
+
+	okResponseMap := map[string]any{
+		"success": true,
+	}
+	sendResponse(ctx, w, http.StatusOK, &okResponseMap, source)
+}
+
+// issueParty is the caller of an issue route, users, vendors and admins share the routes and reach the issues
+// their role allows
+func issueParty(ctx context.Context, source slog.Attr) (*IssueParty, error) {
+	id, err := getID(ctx, source)
+	if err != nil {
+		return nil, err
+	}
+	role, ok := ctx.Value(userRoleKey).(string)
+	if !ok {
+		return nil, errors.New("missing role claim")
+	}
+	return &IssueParty{Role: role, ID: id.value}, nil
+}
+
+// decodeIssueForm reads a JSON body, or a multipart form whose data field is the JSON and whose photos fields
+// are the photos attached to it
+func decodeIssueForm[req ComConReq](ctx context.Context, w http.ResponseWriter, r *http.Request, source slog.Attr) (*req, []*multipart.FileHeader, error) {
+	r.Body = http.MaxBytesReader(w, r.Body, maxIssueUpload)
+	if !strings.HasPrefix(r.Header.Get("Content-Type"), "multipart/form-data") {
+		v, err := decodeStruct[req](ctx, r.Body, source)
+		return v, nil, err
+	}
+	if err := r.ParseMultipartForm(1 << 20); err != nil {
+		Logger.ErrorContext(ctx, "Unable to parse the multipart form", slog.Any("error", err), source)
+		return nil, nil, err
+	}
+	files := r.MultipartForm.File["photos"]
+	data := r.FormValue("data")
+	if data == "" {
+		return new(req), files, nil
+	}
+	v, err := decodeStruct[req](ctx, strings.NewReader(data), source)
+	return v, files, err
+}
+
+// CreateOrderIssue reports missing or damaged lines of a delivered order of the user, see decodeIssueForm for
+// attaching photos
+func CreateOrderIssue(w http.ResponseWriter, r *http.Request) {
+	ctx, span := Tracer.Start(r.Context(), "CreateOrderIssue")
+	defer span.End()
+	source := slog.String("source", "CreateOrderIssue")
+
+	userID, err := getID(r.Context(), source)
+	if err != nil {
+		sendFailure(ctx, w, "Unable to get user ID from context", source)
+		return
+	}
+	orderID, err := NewID(ctx, r.PathValue("id"))
+	if err != nil {
+		sendFailure(ctx, w, "Invalid order id", source)
+		return
+	}
+	req, files, err := decodeIssueForm[IssueReq](ctx, w, r, source)
+	if err != nil {
+		sendFailure(ctx, w, "Error in parsing issue request body", source)
+		return
+	}
+	if err := checkIssuePhotos(files); err != nil {
+		sendFailure(ctx, w, err.Error(), source)
+		return
+	}
+
+	order, err := Repos.Invoice.FindSoldOrder(ctx, userID, orderID)
+	if err != nil {
+		if errors.Is(err, &NoItems{}) {
+			sendFailure(ctx, w, "Order not found", source)
+			return
+		}
+		sendFailure(ctx, w, "Failed to fetch the order", source)
+		return
+	}
+	if !strings.EqualFold(order.OrderStatus, orderDelivered) {
+		sendFailure(ctx, w, "Issues can only be reported for delivered orders", source)
+		return
+	}
+	if err := newIssueLines(&order.Order, req.Lines); err != nil {
+		sendFailure(ctx, w, err.Error(), source)
+		return
+	}
+
+	issue := &OrderIssue{ID: bson.NewObjectID(), OrderID: order.ID, UserID: userID.value, VendorID: order.VendorID,
+		StoreID: order.StoreID, Lines: req.Lines}
+	var photos []*IssuePhoto
+	if strings.TrimSpace(req.Text) != "" || len(files) > 0 {
+		text, err := issueText(req.Text, len(files))
+		if err != nil {
+			sendFailure(ctx, w, err.Error(), source)
+			return
+		}
+		if photos, err = storeIssuePhotos(ctx, issue.ID, files); err != nil {
+			sendPhotoFailure(ctx, w, err, source)
+			return
+		}
+		issue.Messages = []*IssueMessage{{ID: bson.NewObjectID(), Author: "user", AuthorID: userID.value, Text: text,
+			Photos: photos, At: time.Now()}}
+	}
+	if err := Repos.Issue.CreateIssue(ctx, issue); err != nil {
+		deleteIssuePhotos(ctx, photos)
+		if errors.Is(err, errIssueReported) {
+			sendFailure(ctx, w, err.Error(), source)
+			return
+		}
+		sendFailure(ctx, w, "Failed to report the issue", source)
+		return
+	}
+	notifyIssue(ctx, issue, issueEventReported, "user")
+
+	okResponseMap := map[string]any{
+		"success": true,
+		"issue":   issue,
+	}
+	sendResponse(ctx, w, http.StatusCreated, &okResponseMap, source)
+}
+
+func sendPhotoFailure(ctx context.Context, w http.ResponseWriter, err error, source slog.Attr) {
+	if errors.Is(err, errIssuePhoto) {
+		sendFailure(ctx, w, err.Error(), source)
+		return
+	}
+	Logger.ErrorContext(ctx, "Unable to store the photos", slog.Any("error", err), source)
+	sendFailure(ctx, w, "Failed to store the photos", source)
+}
+
+// GetIssues lists the issues the caller can reach, ?status=escalated is the admin queue and ?order= narrows
+// them to one order
+func GetIssues(w http.ResponseWriter, r *http.Request) {
+	ctx, span := Tracer.Start(r.Context(), "GetIssues")
+	defer span.End()
+	source := slog.String("source", "GetIssues")
+
+	party, err := issueParty(r.Context(), source)
+	if err != nil {
+		sendFailure(ctx, w, "Unable to get ID from context", source)
+		return
+	}
+	query, err := parseIssueQuery(r.URL.Query())
+	if err != nil {
+		sendFailure(ctx, w, err.Error(), source)
+		return
+	}
+	query.Party = party
+	if order := r.URL.Query().Get("order"); order != "" {
+		orderID, err := NewID(ctx, order)
+		if err != nil {
+			sendFailure(ctx, w, "Invalid order id", source)
+			return
+		}
+		query.OrderID = orderID.value
+	}
+	sendIssues(ctx, w, query, source)
+}
+
+// GetOrderIssues lists the issues of the order of the path with their conversations
+func GetOrderIssues(w http.ResponseWriter, r *http.Request) {
+	ctx, span := Tracer.Start(r.Context(), "GetOrderIssues")
+	defer span.End()
+	source := slog.String("source", "GetOrderIssues")
+
+	party, err := issueParty(r.Context(), source)
+	if err != nil {
+		sendFailure(ctx, w, "Unable to get ID from context", source)
+		return
+	}
+	orderID, err := NewID(ctx, r.PathValue("id"))
+	if err != nil {
+		sendFailure(ctx, w, "Invalid order id", source)
+		return
+	}
+	query, err := parseIssueQuery(r.URL.Query())
+	if err != nil {
+		sendFailure(ctx, w, err.Error(), source)
+		return
+	}
+	query.Party, query.OrderID = party, orderID.value
+	sendIssues(ctx, w, query, source)
+}
+
+func sendIssues(ctx context.Context, w http.ResponseWriter, query *IssueQuery, source slog.Attr) {
+	issues, err := Repos.Issue.FindIssues(ctx, query)
+	if err != nil {
+		sendFailure(ctx, w, "Failed to fetch the issues", source)
+		return
+	}
+
+	okResponseMap := map[string]any{
+		"success":   true,
+		"issues":    issues,
+		"page":      query.Page,
+		"page_size": query.PageSize,
+	}
+	sendResponse(ctx, w, http.StatusOK, &okResponseMap, source)
+}
+
+func GetIssue(w http.ResponseWriter, r *http.Request) {
+	ctx, span := Tracer.Start(r.Context(), "GetIssue")
+	defer span.End()
+	source := slog.String("source", "GetIssue")
+
+	party, err := issueParty(r.Context(), source)
+	if err != nil {
+		sendFailure(ctx, w, "Unable to get ID from context", source)
+		return
+	}
+	issueID, err := NewID(ctx, r.PathValue("id"))
+	if err != nil {
+		sendFailure(ctx, w, "Invalid issue id", source)
+		return
+	}
+	issue, err := Repos.Issue.FindIssue(ctx, party, issueID.value)
+	if err != nil {
+		if errors.Is(err, &NoItems{}) {
+			sendFailure(ctx, w, "Issue not found", source)
+			return
+		}
+		sendFailure(ctx, w, "Failed to fetch the issue", source)
+		return
+	}
+
+	okResponseMap := map[string]any{
+		"success": true,
+		"issue":   issue,
+	}
+	sendResponse(ctx, w, http.StatusOK, &okResponseMap, source)
+}
+
+// AddIssueMessage adds the caller's message to the conversation of the issue, see decodeIssueForm for
+// attaching photos
+func AddIssueMessage(w http.ResponseWriter, r *http.Request) {
+	ctx, span := Tracer.Start(r.Context(), "AddIssueMessage")
+	defer span.End()
+	source := slog.String("source", "AddIssueMessage")
+
+	party, err := issueParty(r.Context(), source)
+	if err != nil {
+		sendFailure(ctx, w, "Unable to get ID from context", source)
+		return
+	}
+	issueID, err := NewID(ctx, r.PathValue("id"))
+	if err != nil {
+		sendFailure(ctx, w, "Invalid issue id", source)
+		return
+	}
+	req, files, err := decodeIssueForm[IssueMessageReq](ctx, w, r, source)
+	if err != nil {
+		sendFailure(ctx, w, "Error in parsing message request body", source)
+		return
+	}
+	if err := checkIssuePhotos(files); err != nil {
+		sendFailure(ctx, w, err.Error(), source)
+		return
+	}
+	text, err := issueText(req.Text, len(files))
+	if err != nil {
+		sendFailure(ctx, w, err.Error(), source)
+		return
+	}
+
+	// the issue is read first so photos are only stored under an issue the caller can reach
+	if _, err := Repos.Issue.FindIssue(ctx, party, issueID.value); err != nil {
+		if errors.Is(err, &NoItems{}) {
+			sendFailure(ctx, w, "Issue not found", source)
+			return
+		}
+		sendFailure(ctx, w, "Failed to fetch the issue", source)
+		return
+	}
+	photos, err := storeIssuePhotos(ctx, issueID.value, files)
+	if err != nil {
+		sendPhotoFailure(ctx, w, err, source)
+		return
+	}
+	issue, err := Repos.Issue.AddIssueMessage(ctx, party, issueID.value, &IssueMessage{Text: text, Photos: photos})
+	if err != nil {
+		deleteIssuePhotos(ctx, photos)
+		sendFailure(ctx, w, "Failed to add the message", source)
+		return
+	}
+	notifyIssue(ctx, issue, issueEventMessage, party.Role)
+
+	okResponseMap := map[string]any{
+		"success": true,
+		"issue":   issue,
+	}
+	sendResponse(ctx, w, http.StatusCreated, &okResponseMap, source)
+}
+
+// ResolveIssue settles an issue with a partial or full refund or a replacement, vendors resolve open issues and
+// admins also the ones escalated to them
+func ResolveIssue(w http.ResponseWriter, r *http.Request) {
+	ctx, span := Tracer.Start(r.Context(), "ResolveIssue")
+	defer span.End()
+	source := slog.String("source", "ResolveIssue")
+
+	party, err := issueParty(r.Context(), source)
+	if err != nil {
+		sendFailure(ctx, w, "Unable to get ID from context", source)
+		return
+	}
+	issueID, err := NewID(ctx, r.PathValue("id"))
+	if err != nil {
+		sendFailure(ctx, w, "Invalid issue id", source)
+		return
+	}
+	req, err := decodeStruct[IssueResolveReq](ctx, r.Body, source)
+	if err != nil {
+		sendFailure(ctx, w, "Error in parsing resolution request body", source)
+		return
+	}
+
+	issue, err := Repos.Issue.FindIssue(ctx, party, issueID.value)
+	if err != nil {
+		if errors.Is(err, &NoItems{}) {
+			sendFailure(ctx, w, "Issue not found", source)
+			return
+		}
+		sendFailure(ctx, w, "Failed to fetch the issue", source)
+		return
+	}
+	resolution, err := req.resolution(issue, party.Role, time.Now())
+	if err != nil {
+		sendFailure(ctx, w, err.Error(), source)
+		return
+	}
+	var message *IssueMessage
+	if strings.TrimSpace(req.Text) != "" {
+		text, err := issueText(req.Text, 0)
 		if err != nil {
-			Logger.ErrorContext(ctx, "Failed to create containers", slog.Any("error", err), source)
-			sendFailure(ctx, w, "Failed to create containers", source)
+			sendFailure(ctx, w, err.Error(), source)
+			return
 		}
-	}()
+		message = &IssueMessage{Text: text}
+	}
 
-	id, err := getID(r.Context(), source)
+	issue, err = Repos.Issue.ResolveIssue(ctx, party, issueID.value, resolution, message)
 	if err != nil {
+		switch {
+		case errors.Is(err, &NoItems{}):
+			sendFailure(ctx, w, "Issue not found", source)
+		case errors.Is(err, errIssueResolved), errors.Is(err, errIssueEscalated):
+			sendFailure(ctx, w, err.Error(), source)
+		default:
+			sendFailure(ctx, w, "Failed to resolve the issue", source)
+		}
 		return
 	}
+	notifyIssue(ctx, issue, issueResolved, party.Role)
 
-	if len(con) == 0 {
-		Logger.ErrorContext(ctx, "No items provided", source)
-		err = errors.New("no items provided")
-		return
+	okResponseMap := map[string]any{
+		"success": true,
+		"issue":   issue,
 	}
+	sendResponse(ctx, w, http.StatusOK, &okResponseMap, source)
+}
 
-	switch c := any(con).(type) {
-	case []*Cart:
-		ids, err = Repos.User.CreateCarts(ctx, id, c)
-	case []*Recipe:
-		ids, err = Repos.User.CreateRecipes(ctx, id, c)
-	case []*UserOrder:
-		if ids, err = Repos.User.CreateUserOrders(ctx, id, c); err != nil {
-			Logger.ErrorContext(ctx, "Error in user persistence", slog.Any("error", err), source)
+// GetIssuePhoto sends a photo attached to the conversation of the issue
+func GetIssuePhoto(w http.ResponseWriter, r *http.Request) {
+	ctx, span := Tracer.Start(r.Context(), "GetIssuePhoto")
+	defer span.End()
+	source := slog.String("source", "GetIssuePhoto")
+
+	party, err := issueParty(r.Context(), source)
+	if err != nil {
+		sendFailure(ctx, w, "Unable to get ID from context", source)
+		return
+	}
+	issueID, err := NewID(ctx, r.PathValue("id"))
+	if err != nil {
+		sendFailure(ctx, w, "Invalid issue id", source)
+		return
+	}
+	photoID, err := NewID(ctx, r.PathValue("photo"))
+	if err != nil {
+		sendFailure(ctx, w, "Invalid photo id", source)
+		return
+	}
+	issue, err := Repos.Issue.FindIssue(ctx, party, issueID.value)
+	if err != nil {
+		if errors.Is(err, &NoItems{}) {
+			sendFailure(ctx, w, "Issue not found", source)
 			return
 		}
-
-		vg, vi := make(map[bson.ObjectID][]*VendorOrder), make(map[bson.ObjectID][]*ID)
-		for _, v := range c {
-			vg[v.VendorID] = append(vg[v.VendorID], &VendorOrder{Order: v.Order, UserID: id.value})
+		sendFailure(ctx, w, "Failed to fetch the issue", source)
+		return
+	}
+	photo := issue.photo(photoID.value)
+	if photo == nil {
+		sendFailure(ctx, w, "Photo not found", source)
+		return
+	}
+	blob, err := Blobs.Get(ctx, photo.Key)
+	if err != nil {
+		if errors.Is(err, &NoItems{}) {
+			sendFailure(ctx, w, "Photo not found", source)
+			return
 		}
+		sendFailure(ctx, w, "Failed to fetch the photo", source)
+		return
+	}
+	defer blob.Close()
 
-		for v, o := range vg {
-			oids, ordErr := Repos.Vendor.CreateVendorOrders(ctx, ID{v}, o)
-			if ordErr != nil {
-				Logger.ErrorContext(ctx, "Error in Vendor persistence Rolling back created user and vendor orders",
-					slog.Any("error", ordErr), source)
-				err = errors.Join(err, ordErr)
+	w.Header().Set("Content-Type", photo.ContentType)
+	w.Header().Set("Content-Length", strconv.FormatInt(photo.Size, 10))
+	w.WriteHeader(http.StatusOK)
+	if _, err := io.Copy(w, blob); err != nil {
+		Logger.ErrorContext(ctx, "Unable to send the photo", slog.Any("error", err), source)
+	}
+}
 
-				if userErr := Repos.User.DeleteUserOrders(ctx, id, ids); userErr != nil {
-					Logger.ErrorContext(ctx, "Error in deleting newly created User orders", slog.Any("error", userErr), source)
-					err = errors.Join(err, userErr)
-				}
+// ReorderOrder puts the items of a past order in a new cart, priced against what the store has today. The
+// changes flag every line that was repriced, cut to the stock left, substituted or left out
+func ReorderOrder(w http.ResponseWriter, r *http.Request) {
+	ctx, span := Tracer.Start(r.Context(), "ReorderOrder")
+	defer span.End()
+	source := slog.String("source", "ReorderOrder")
 
-				for vid, oids := range vi {
-					if venErr := Repos.Vendor.DeleteVendorOrders(ctx, ID{vid}, oids); venErr != nil {
-						Logger.ErrorContext(ctx, "Error in deleting newly created Vendor orders", slog.Any("error", venErr), source)
-						err = errors.Join(err, venErr)
-					}
-				}
-				break
-			}
-			vi[v] = oids
+	userID, err := getID(r.Context(), source)
+	if err != nil {
+		sendFailure(ctx, w, "Unable to get user ID from context", source)
+		return
+	}
+	orderID, err := NewID(ctx, r.PathValue("id"))
+	if err != nil {
+		sendFailure(ctx, w, "Invalid order id", source)
+		return
+	}
+	order, err := Repos.Invoice.FindUserOrder(ctx, userID, orderID)
+	if err != nil {
+		if errors.Is(err, &NoItems{}) {
+			sendFailure(ctx, w, "Order not found", source)
+			return
 		}
-	case []*Store:
-		ids, err = Repos.Vendor.CreateStores(ctx, id, c)
-	case []*VendorOrder:
-		ids, err = Repos.Vendor.CreateVendorOrders(ctx, id, c)
-	default:
-		Logger.ErrorContext(ctx, "Unable to get the id String fromn context", source)
-		err = errors.New("unknown type")
+		sendFailure(ctx, w, "Failed to fetch the order", source)
+		return
+	}
+	store, err := Repos.Vendor.FindStore(ctx, ID{order.VendorID}, ID{order.StoreID})
+	if err != nil {
+		if errors.Is(err, &NoItems{}) {
+			sendFailure(ctx, w, "The store of the order no longer exists", source)
+			return
+		}
+		sendFailure(ctx, w, "Failed to fetch the store", source)
+		return
+	}
+
+	cart, changes := reorderCart(order, store)
+	if len(cart.Items) == 0 {
+		sendFailure(ctx, w, "None of the items of the order are available", source)
 		return
 	}
+	ids, err := Repos.User.CreateCarts(ctx, userID, []*Cart{cart})
 	if err != nil {
+		sendFailure(ctx, w, "Failed to create the cart", source)
 		return
 	}
+	cart.ID = ids[0].value
+
 	okResponseMap := map[string]any{
 		"success": true,
-		"ids":     getStringIDs(ids),
+		"cart":    cart,
+		"changes": changes,
 	}
 	sendResponse(ctx, w, http.StatusCreated, &okResponseMap, source)
 }
 
-func updateCon[C containers](ctx context.Context, w http.ResponseWriter, r *http.Request, source slog.Attr, con C) {
-	var err error
+// CreateSubscription starts a weekly or biweekly order of the items at a store, the first one is placed at
+// next_run
+func CreateSubscription(w http.ResponseWriter, r *http.Request) {
+	ctx, span := Tracer.Start(r.Context(), "CreateSubscription")
+	defer span.End()
+	source := slog.String("source", "CreateSubscription")
 
-	id, err := getID(r.Context(), source)
+	userID, err := getID(r.Context(), source)
 	if err != nil {
-		sendFailure(ctx, w, "Oops", source)
+		sendFailure(ctx, w, "Unable to get user ID from context", source)
 		return
 	}
-
-	if len(con) == 0 {
-		Logger.ErrorContext(ctx, "No items provided", source)
-		sendFailure(ctx, w, "No items provided", source)
+	req, err := decodeStruct[Subscription](ctx, r.Body, source)
+	if err != nil {
+		sendFailure(ctx, w, "Error in parsing subscription request body", source)
 		return
 	}
-
-	switch c := any(con).(type) {
-	case []*Cart:
-		err = Repos.User.UpdateCarts(ctx, id, c)
-	case []*Recipe:
-		err = Repos.User.UpdateRecipes(ctx, id, c)
-	case []*UserOrder:
-		err = Repos.User.UpdateUserOrders(ctx, id, c)
-	case []*Store:
-		err = Repos.Vendor.UpdateStores(ctx, id, c)
-	case []*VendorOrder:
-		err = Repos.Vendor.UpdateVendorOrders(ctx, id, c)
-	default:
-		Logger.ErrorContext(ctx, "Unable to get the id String fromn context", source)
-		sendFailure(ctx, w, "unknown type", source)
+	if req.NextRun.IsZero() {
+		sendFailure(ctx, w, "next_run is required", source)
 		return
 	}
-
+	sub := &Subscription{UserID: userID.value, VendorID: req.VendorID, StoreID: req.StoreID, Items: req.Items,
+		DeliveryMethod: req.DeliveryMethod, DeliveryLocation: req.DeliveryLocation, Frequency: req.Frequency,
+		Status: subscriptionActive, NextRun: req.NextRun.UTC()}
+	if err := sub.validate(sub.NextRun, time.Now()); err != nil {
+		sendFailure(ctx, w, err.Error(), source)
+		return
+	}
+	store, err := Repos.Vendor.FindStore(ctx, ID{sub.VendorID}, ID{sub.StoreID})
 	if err != nil {
-		Logger.ErrorContext(ctx, "Failed to update containers", slog.Any("error", err), source)
-		sendFailure(ctx, w, "Failed to update containers", source)
+		if errors.Is(err, &NoItems{}) {
+			sendFailure(ctx, w, "Store not found", source)
+			return
+		}
+		sendFailure(ctx, w, "Failed to fetch the store", source)
+		return
+	}
+	if err := subscriptionLines(store, sub.Items); err != nil {
+		sendFailure(ctx, w, err.Error(), source)
+		return
+	}
+	if err := Repos.Subscription.CreateSubscription(ctx, sub); err != nil {
+		sendFailure(ctx, w, "Failed to create the subscription", source)
 		return
 	}
+	sub.Upcoming = sub.upcoming()
 
-	okResponseMap := map[string]any{"success": true}
+	okResponseMap := map[string]any{
+		"success":      true,
+		"subscription": sub,
+	}
 	sendResponse(ctx, w, http.StatusCreated, &okResponseMap, source)
 }
 
-func getCon[c containers](ctx context.Context, w http.ResponseWriter, r *http.Request, t c, source slog.Attr) {
-	var err error
-	id, err := getID(r.Context(), source)
+// GetSubscriptions lists the user's subscriptions with their upcoming occurrences
+func GetSubscriptions(w http.ResponseWriter, r *http.Request) {
+	ctx, span := Tracer.Start(r.Context(), "GetSubscriptions")
+	defer span.End()
+	source := slog.String("source", "GetSubscriptions")
+
+	userID, err := getID(r.Context(), source)
 	if err != nil {
-		sendFailure(ctx, w, "Oops", source)
+		sendFailure(ctx, w, "Unable to get user ID from context", source)
 		return
 	}
+	subs, err := Repos.Subscription.FindSubscriptions(ctx, userID)
+	if err != nil {
+		sendFailure(ctx, w, "Failed to fetch the subscriptions", source)
+		return
+	}
+	for _, sub := range subs {
+		if sub.Status == subscriptionActive {
+			sub.Upcoming = sub.upcoming()
+		}
+	}
 
 	okResponseMap := map[string]any{
-		"success": true,
+		"success":       true,
+		"subscriptions": subs,
 	}
+	sendResponse(ctx, w, http.StatusOK, &okResponseMap, source)
+}
 
-	switch a := any(t).(type) {
-	case []*Recipe:
-		a, err = Repos.User.FindRecipes(ctx, id)
-		okResponseMap["value"] = a
-	case []*Cart:
-		a, err = Repos.User.FindCarts(ctx, id)
-		okResponseMap["value"] = a
-	case []*UserOrder:
-		a, err = Repos.User.FindUserOrders(ctx, id)
-		okResponseMap["value"] = a
-	case []*Store:
-		a, err = Repos.Vendor.FindStores(ctx, id)
-		okResponseMap["value"] = a
-	case []*VendorOrder:
-		a, err = Repos.Vendor.FindVendorOrders(ctx, id)
-		okResponseMap["value"] = a
-	default:
-		Logger.ErrorContext(ctx, "Unknown get container constant", source)
-		sendFailure(ctx, w, "unknown get container constant", source)
-		return
+// findSubscription is the subscription of the path for the user, failures are sent
+func findSubscription(ctx context.Context, w http.ResponseWriter, r *http.Request, source slog.Attr) (*Subscription, bool) {
+	userID, err := getID(r.Context(), source)
+	if err != nil {
+		sendFailure(ctx, w, "Unable to get user ID from context", source)
+		return nil, false
 	}
-
+	subID, err := NewID(ctx, r.PathValue("id"))
 	if err != nil {
-		Logger.ErrorContext(ctx, "Failed to fetch containers", slog.Any("error", err), source)
-		sendFailure(ctx, w, "Failed to fetch containers", source)
-		return
+		sendFailure(ctx, w, "Invalid subscription id", source)
+		return nil, false
 	}
-	sendResponse(ctx, w, http.StatusOK, &okResponseMap, source)
+	sub, err := Repos.Subscription.FindSubscription(ctx, userID, subID.value)
+	if err != nil {
+		if errors.Is(err, &NoItems{}) {
+			sendFailure(ctx, w, "Subscription not found", source)
+			return nil, false
+		}
+		sendFailure(ctx, w, "Failed to fetch the subscription", source)
+		return nil, false
+	}
+	return sub, true
 }
 
-func decodeStruct[req ComConReq](ctx context.Context, r io.Reader, source slog.Attr) (v *req, err error) {
-	Logger.InfoContext(ctx, "Decode the body to struct", source)
-	if err := json.NewDecoder(r).Decode(&v); err != nil {
-		Logger.ErrorContext(ctx, "Unable to parse request body", slog.Any("error", err), source)
-		return nil, err
+// saveSubscription stores the changes to a subscription read at seen and sends it back with its upcoming
+// occurrences
+func saveSubscription(ctx context.Context, w http.ResponseWriter, sub *Subscription, seen time.Time, source slog.Attr) {
+	if err := Repos.Subscription.UpdateSubscription(ctx, sub, seen); err != nil {
+		switch {
+		case errors.Is(err, errSubscriptionChanged):
+			sendFailure(ctx, w, err.Error(), source)
+		case errors.Is(err, &NoItems{}):
+			sendFailure(ctx, w, "Subscription not found", source)
+		default:
+			sendFailure(ctx, w, "Failed to update the subscription", source)
+		}
+		return
 	}
-	Logger.InfoContext(ctx, "Decoded Successfully", source)
-	return
+	if sub.Status == subscriptionActive {
+		sub.Upcoming = sub.upcoming()
+	}
+
+	okResponseMap := map[string]any{
+		"success":      true,
+		"subscription": sub,
+	}
+	sendResponse(ctx, w, http.StatusOK, &okResponseMap, source)
 }
 
-func decodeToIDS(ctx context.Context, r io.Reader, source slog.Attr) (ids []*ID, err error) {
-	Logger.InfoContext(ctx, "Decode the body to arrays of *IDs", source)
-	if err = json.NewDecoder(r).Decode(&ids); err != nil {
-		Logger.ErrorContext(ctx, "Unable to parse request body", slog.Any("error", err), source)
+// UpdateSubscription edits the items, delivery, frequency or next occurrence of a subscription, the fields
+// left out keep their value. Moving next_run clears the skipped occurrences
+func UpdateSubscription(w http.ResponseWriter, r *http.Request) {
+	ctx, span := Tracer.Start(r.Context(), "UpdateSubscription")
+	defer span.End()
+	source := slog.String("source", "UpdateSubscription")
+
+	sub, ok := findSubscription(ctx, w, r, source)
+	if !ok {
 		return
 	}
-	Logger.InfoContext(ctx, "Decoded Successfully", source)
-	return
+	req, err := decodeStruct[Subscription](ctx, r.Body, source)
+	if err != nil {
+		sendFailure(ctx, w, "Error in parsing subscription request body", source)
+		return
+	}
+	if req.Items != nil {
+		store, err := Repos.Vendor.FindStore(ctx, ID{sub.VendorID}, ID{sub.StoreID})
+		if err != nil {
+			if errors.Is(err, &NoItems{}) {
+				sendFailure(ctx, w, "Store not found", source)
+				return
+			}
+			sendFailure(ctx, w, "Failed to fetch the store", source)
+			return
+		}
+		if err := subscriptionLines(store, req.Items); err != nil {
+			sendFailure(ctx, w, err.Error(), source)
+			return
+		}
+	}
+	seen := sub.UpdatedAt
+	sub.edit(req)
+	if err := sub.validate(req.NextRun, time.Now()); err != nil {
+		sendFailure(ctx, w, err.Error(), source)
+		return
+	}
+	saveSubscription(ctx, w, sub, seen, source)
 }
 
-func getDeleteIds(ctx context.Context, r io.Reader, source slog.Attr) (ID, []bson.ObjectID, error) {
-	Logger.InfoContext(ctx, "Decode the body to arrays of items IDs and container id", source)
-	var req struct {
-		Id  ID              `json:"id"`
-		Ids []bson.ObjectID `json:"ids"`
-	}
+// SetSubscriptionStatus pauses or resumes a subscription, a resumed one carries on at its first occurrence
+// still ahead
+func SetSubscriptionStatus(w http.ResponseWriter, r *http.Request) {
+	ctx, span := Tracer.Start(r.Context(), "SetSubscriptionStatus")
+	defer span.End()
+	source := slog.String("source", "SetSubscriptionStatus")
 
-	if err := json.NewDecoder(r).Decode(&req); err != nil {
-		Logger.ErrorContext(ctx, "Unable to parse request body", slog.Any("error", err), source)
-		return req.Id, req.Ids, err
+	sub, ok := findSubscription(ctx, w, r, source)
+	if !ok {
+		return
 	}
-
-	Logger.InfoContext(ctx, "Decoded Successfully", source)
-	return req.Id, req.Ids, nil
+	req, err := decodeStruct[SubscriptionStatusReq](ctx, r.Body, source)
+	if err != nil {
+		sendFailure(ctx, w, "Error in parsing status request body", source)
+		return
+	}
+	seen, now := sub.UpdatedAt, time.Now()
+	switch req.Status {
+	case subscriptionPaused:
+	case subscriptionActive:
+		if sub.Status != subscriptionActive && !sub.NextRun.After(now) {
+			sub.NextRun, sub.Skips = sub.advance(now)
+		}
+	default:
+		sendFailure(ctx, w, fmt.Sprintf("status must be %s or %s", subscriptionActive, subscriptionPaused), source)
+		return
+	}
+	sub.Status = req.Status
+	saveSubscription(ctx, w, sub, seen, source)
 }
 
-func getID(ctx context.Context, source slog.Attr) (ID, error) {
-	v, ok := ctx.Value(userIDKey).(string)
+// SetSubscriptionSkips sets which of the upcoming occurrences are not placed
+func SetSubscriptionSkips(w http.ResponseWriter, r *http.Request) {
+	ctx, span := Tracer.Start(r.Context(), "SetSubscriptionSkips")
+	defer span.End()
+	source := slog.String("source", "SetSubscriptionSkips")
+
+	sub, ok := findSubscription(ctx, w, r, source)
 	if !ok {
-		Logger.ErrorContext(ctx, "Unable to get the id String fromn context", source)
-		return ID{bson.NilObjectID}, errors.New("unable to cast to string from ctx")
+		return
 	}
-	return NewID(ctx, v)
+	req, err := decodeStruct[SkipsReq](ctx, r.Body, source)
+	if err != nil {
+		sendFailure(ctx, w, "Error in parsing skips request body", source)
+		return
+	}
+	if sub.Status != subscriptionActive {
+		sendFailure(ctx, w, "Only active subscriptions have upcoming occurrences", source)
+		return
+	}
+	seen := sub.UpdatedAt
+	if err := sub.setSkips(req.Occurrences); err != nil {
+		sendFailure(ctx, w, err.Error(), source)
+		return
+	}
+	saveSubscription(ctx, w, sub, seen, source)
 }
 
-func makeItemsOne(res []*ResIng) {
-	for _, v := range res {
-		for _, s := range v.Stores {
-			for _, i := range s.Items {
-				i.Quantity = 1
-			}
+func DeleteSubscription(w http.ResponseWriter, r *http.Request) {
+	ctx, span := Tracer.Start(r.Context(), "DeleteSubscription")
+	defer span.End()
+	source := slog.String("source", "DeleteSubscription")
+
+	userID, err := getID(r.Context(), source)
+	if err != nil {
+		sendFailure(ctx, w, "Unable to get user ID from context", source)
+		return
+	}
+	subID, err := NewID(ctx, r.PathValue("id"))
+	if err != nil {
+		sendFailure(ctx, w, "Invalid subscription id", source)
+		return
+	}
+	if err := Repos.Subscription.DeleteSubscription(ctx, userID, subID.value); err != nil {
+		if errors.Is(err, &NoItems{}) {
+			sendFailure(ctx, w, "Subscription not found", source)
+			return
 		}
+		sendFailure(ctx, w, "Failed to delete the subscription", source)
+		return
 	}
+
+	okResponseMap := map[string]any{"success": true}
+	sendResponse(ctx, w, http.StatusOK, &okResponseMap, source)
 }
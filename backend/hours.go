@@ -0,0 +1,209 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	holidayLayout = "2006-01-02"
+	minutesPerDay = 24 * 60
+
+	// hoursHorizon is how many days ahead the next opening is looked for, a store closed for longer has none
+	hoursHorizon = 366
+)
+
+type interval struct{ start, end time.Time }
+
+// parseClock reads a HH:MM time of day as minutes after midnight, 24:00 is the end of the day
+func parseClock(s string) (int, error) {
+	hour, minute, ok := strings.Cut(s, ":")
+	h, herr := strconv.Atoi(hour)
+	m, merr := strconv.Atoi(minute)
+	if !ok || len(hour) != 2 || len(minute) != 2 || herr != nil || merr != nil || h < 0 || m < 0 || m > 59 ||
+		h*60+m > minutesPerDay {
+		return 0, fmt.Errorf("%q is not a HH:MM time", s)
+	}
+	return h*60 + m, nil
+}
+
+func (h *StoreHours) validate() error {
+	if h.TimeZone == "" {
+		h.TimeZone = "UTC"
+	}
+	if _, err := time.LoadLocation(h.TimeZone); err != nil {
+		return fmt.Errorf("unknown time zone %q", h.TimeZone)
+	}
+	for _, period := range h.Weekly {
+		if period.Day < int(time.Sunday) || period.Day > int(time.Saturday) {
+			return errors.New("day must be 0 for sunday through 6 for saturday")
+		}
+		open, err := parseClock(period.Open)
+		if err != nil {
+			return err
+		}
+		closes, err := parseClock(period.Close)
+		if err != nil {
+			return err
+		}
+		if open == closes || open == minutesPerDay {
+			return fmt.Errorf("%s to %s is not an opening period, use 00:00 to 24:00 for a whole day", period.Open, period.Close)
+		}
+	}
+	for _, holiday := range h.Holidays {
+		if _, err := time.Parse(holidayLayout, holiday.Date); err != nil {
+			return fmt.Errorf("holiday date %q must be YYYY-MM-DD", holiday.Date)
+		}
+	}
+	if h.OrderCutoff < 0 || h.OrderCutoff > minutesPerDay {
+		return fmt.Errorf("order_cutoff_minutes must be between 0 and %d", minutesPerDay)
+	}
+	if h.Weekly == nil {
+		h.Weekly = []*OpeningPeriod{}
+	}
+	if h.Holidays == nil {
+		h.Holidays = []*Holiday{}
+	}
+	return nil
+}
+
+// periods are the opening intervals of the days from the day before from up to days after it, a period that
+// closes past midnight ends the next day and periods that touch are joined. A holiday closes the periods that
+// start on it
+func (h *StoreHours) periods(from time.Time, days int) []interval {
+	loc, err := time.LoadLocation(h.TimeZone)
+	if err != nil {
+		loc = time.UTC
+	}
+	closed := make(map[string]bool, len(h.Holidays))
+	for _, holiday := range h.Holidays {
+		closed[holiday.Date] = true
+	}
+
+	local := from.In(loc)
+	var periods []interval
+	for d := -1; d <= days; d++ {
+		day := time.Date(local.Year(), local.Month(), local.Day()+d, 0, 0, 0, 0, loc)
+		if closed[day.Format(holidayLayout)] {
+			continue
+		}
+		for _, period := range h.Weekly {
+			if period.Day != int(day.Weekday()) {
+				continue
+			}
+			open, _ := parseClock(period.Open)
+			closes, _ := parseClock(period.Close)
+			if closes <= open {
+				closes += minutesPerDay
+			}
+			periods = append(periods, interval{
+				start: time.Date(day.Year(), day.Month(), day.Day(), 0, open, 0, 0, loc),
+				end:   time.Date(day.Year(), day.Month(), day.Day(), 0, closes, 0, 0, loc),
+			})
+		}
+	}
+
+	slices.SortFunc(periods, func(a, b interval) int { return a.start.Compare(b.start) })
+	var joined []interval
+	for _, period := range periods {
+		if last := len(joined) - 1; last >= 0 && !period.start.After(joined[last].end) {
+			if period.end.After(joined[last].end) {
+				joined[last].end = period.end
+			}
+			continue
+		}
+		joined = append(joined, period)
+	}
+	return joined
+}
+
+// openAt is whether the store is open at t, a store without hours is always open
+func (h *StoreHours) openAt(t time.Time) bool {
+	if h == nil {
+		return true
+	}
+	for _, period := range h.periods(t, 1) {
+		if !t.Before(period.start) && t.Before(period.end) {
+			return true
+		}
+	}
+	return false
+}
+
+// nextOpen is when the closed store opens next, nil while it is open or when it does not open again soon
+func (h *StoreHours) nextOpen(t time.Time) *time.Time {
+	if h.openAt(t) {
+		return nil
+	}
+	for _, period := range h.periods(t, hoursHorizon) {
+		if period.start.After(t) {
+			return &period.start
+		}
+	}
+	return nil
+}
+
+// nextAccepting is the first time from t on that the store takes orders, it stops taking them the cut-off
+// before it closes
+func (h *StoreHours) nextAccepting(t time.Time) *time.Time {
+	if h == nil {
+		return &t
+	}
+	cutoff := time.Duration(h.OrderCutoff) * time.Minute
+	for _, period := range h.periods(t, hoursHorizon) {
+		at := period.start
+		if t.After(at) {
+			at = t
+		}
+		if at.Before(period.end.Add(-cutoff)) {
+			return &at
+		}
+	}
+	return nil
+}
+
+func (h *StoreHours) acceptingAt(t time.Time) bool {
+	next := h.nextAccepting(t)
+	return next != nil && next.Equal(t)
+}
+
+func (s *Store) status(now time.Time) StoreStatus {
+	return StoreStatus{OpenNow: s.Hours.openAt(now), NextOpen: s.Hours.nextOpen(now)}
+}
+
+// checkOrderWindow makes sure the store takes the order when it is due. An order for later has to fall in an
+// acceptance window, an order for now that misses one is rejected unless deferClosed moves it to the next
+// window
+func checkOrderWindow(store *Store, order *Order, now time.Time, deferClosed bool) error {
+	if order.ScheduledFor != nil {
+		if !order.ScheduledFor.After(now) {
+			return errors.New("scheduled_for must be in the future")
+		}
+		if !store.Hours.acceptingAt(*order.ScheduledFor) {
+			return fmt.Errorf("store %s does not take orders at %s%s", store.Name,
+				order.ScheduledFor.Format(time.RFC3339), nextAcceptingHint(store.Hours, *order.ScheduledFor))
+		}
+		return nil
+	}
+	if store.Hours.acceptingAt(now) {
+		return nil
+	}
+	if deferClosed {
+		if next := store.Hours.nextAccepting(now); next != nil {
+			order.ScheduledFor = next
+			return nil
+		}
+	}
+	return fmt.Errorf("store %s is not taking orders now%s", store.Name, nextAcceptingHint(store.Hours, now))
+}
+
+func nextAcceptingHint(h *StoreHours, t time.Time) string {
+	if next := h.nextAccepting(t); next != nil {
+		return ", it takes orders again at " + next.Format(time.RFC3339)
+	}
+	return ""
+}
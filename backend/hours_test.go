@@ -0,0 +1,150 @@
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+func testHours(t *testing.T) *StoreHours {
+	hours := &StoreHours{
+		TimeZone: "America/New_York",
+		Weekly: []*OpeningPeriod{
+			{Day: 1, Open: "09:00", Close: "17:00"},
+			{Day: 5, Open: "18:00", Close: "24:00"},
+			{Day: 6, Open: "00:00", Close: "02:00"},
+		},
+		Holidays:    []*Holiday{{Date: "2024-12-25", Name: "Christmas"}},
+		OrderCutoff: 30,
+	}
+	if err := hours.validate(); err != nil {
+		t.Fatal(err)
+	}
+	return hours
+}
+
+func newYork(t *testing.T, value string) time.Time {
+	loc, err := time.LoadLocation("America/New_York")
+	if err != nil {
+		t.Skip("no time zone database")
+	}
+	at, err := time.ParseInLocation("2006-01-02 15:04", value, loc)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return at
+}
+
+func TestParseClock(t *testing.T) {
+	for value, expected := range map[string]int{"00:00": 0, "09:30": 570, "24:00": 1440} {
+		if got, err := parseClock(value); err != nil || got != expected {
+			t.Fatalf("%s expected %d got %d %v", value, expected, got, err)
+		}
+	}
+	for _, value := range []string{"9:30", "24:01", "12:60", "noon", "-1:00"} {
+		if _, err := parseClock(value); err == nil {
+			t.Fatalf("expected %q to fail", value)
+		}
+	}
+}
+
+func TestStoreHoursValidate(t *testing.T) {
+	bad := []*StoreHours{
+		{TimeZone: "Mars/Olympus"},
+		{Weekly: []*OpeningPeriod{{Day: 7, Open: "09:00", Close: "17:00"}}},
+		{Weekly: []*OpeningPeriod{{Day: 1, Open: "09:00", Close: "09:00"}}},
+		{Holidays: []*Holiday{{Date: "25/12/2024"}}},
+		{OrderCutoff: -5},
+	}
+	for _, hours := range bad {
+		if err := hours.validate(); err == nil {
+			t.Fatalf("expected %+v to fail", hours)
+		}
+	}
+	hours := &StoreHours{}
+	if err := hours.validate(); err != nil || hours.TimeZone != "UTC" || hours.Weekly == nil {
+		t.Fatalf("expected the defaults got %+v %v", hours, err)
+	}
+}
+
+func TestOpenAt(t *testing.T) {
+	hours := testHours(t)
+	tests := []struct {
+		at   string
+		open bool
+	}{
+		// 2024-12-16 is a monday
+		{"2024-12-16 08:59", false},
+		{"2024-12-16 09:00", true},
+		{"2024-12-16 16:59", true},
+		{"2024-12-16 17:00", false},
+		// friday evening runs into saturday
+		{"2024-12-20 23:30", true},
+		{"2024-12-21 01:30", true},
+		{"2024-12-21 02:00", false},
+		// the holiday only closes its own day
+		{"2024-12-23 10:00", true},
+	}
+	for _, test := range tests {
+		if got := hours.openAt(newYork(t, test.at)); got != test.open {
+			t.Fatalf("%s expected open %v", test.at, test.open)
+		}
+	}
+
+	var always *StoreHours
+	if !always.openAt(time.Now()) || always.nextOpen(time.Now()) != nil {
+		t.Fatal("expected a store without hours to always be open")
+	}
+}
+
+func TestNextOpen(t *testing.T) {
+	hours := testHours(t)
+	hours.Weekly = append(hours.Weekly, &OpeningPeriod{Day: 3, Open: "09:00", Close: "17:00"})
+
+	if next := hours.nextOpen(newYork(t, "2024-12-16 12:00")); next != nil {
+		t.Fatalf("expected no next opening while open got %v", next)
+	}
+	// wednesday the 25th is a holiday so the store next opens friday evening
+	next := hours.nextOpen(newYork(t, "2024-12-24 12:00"))
+	if expected := newYork(t, "2024-12-27 18:00"); next == nil || !next.Equal(expected) {
+		t.Fatalf("expected %v got %v", expected, next)
+	}
+
+	closed := &StoreHours{TimeZone: "UTC", Weekly: []*OpeningPeriod{}}
+	if closed.nextOpen(time.Now()) != nil {
+		t.Fatal("expected a store without opening periods to never open")
+	}
+}
+
+func TestCheckOrderWindow(t *testing.T) {
+	store := &Store{Name: "Corner", Hours: testHours(t)}
+
+	if err := checkOrderWindow(store, &Order{}, newYork(t, "2024-12-16 12:00"), false); err != nil {
+		t.Fatalf("expected an order while open to pass got %v", err)
+	}
+	// the cut-off stops orders half an hour before closing
+	if err := checkOrderWindow(store, &Order{}, newYork(t, "2024-12-16 16:45"), false); err == nil {
+		t.Fatal("expected an order after the cut-off to fail")
+	}
+
+	order := &Order{}
+	if err := checkOrderWindow(store, order, newYork(t, "2024-12-16 16:45"), true); err != nil {
+		t.Fatal(err)
+	}
+	if expected := newYork(t, "2024-12-20 18:00"); order.ScheduledFor == nil || !order.ScheduledFor.Equal(expected) {
+		t.Fatalf("expected the order deferred to %v got %v", expected, order.ScheduledFor)
+	}
+
+	// the friday and saturday periods join so the cut-off is before 02:00
+	late := newYork(t, "2024-12-21 01:00")
+	if err := checkOrderWindow(store, &Order{ScheduledFor: &late}, newYork(t, "2024-12-16 12:00"), false); err != nil {
+		t.Fatalf("expected a late friday order to pass got %v", err)
+	}
+	past := newYork(t, "2024-12-16 10:00")
+	if err := checkOrderWindow(store, &Order{ScheduledFor: &past}, newYork(t, "2024-12-16 12:00"), false); err == nil {
+		t.Fatal("expected an order scheduled in the past to fail")
+	}
+	sunday := newYork(t, "2024-12-22 12:00")
+	if err := checkOrderWindow(store, &Order{ScheduledFor: &sunday}, newYork(t, "2024-12-16 12:00"), false); err == nil {
+		t.Fatal("expected an order scheduled on a closed day to fail")
+	}
+}
@@ -0,0 +1,523 @@
+package main
+
+import (
+	"bufio"
+	"cmp"
+	"context"
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"mime"
+	"slices"
+	"strconv"
+	"strings"
+
+	"go.mongodb.org/mongo-driver/v2/bson"
+	"go.mongodb.org/mongo-driver/v2/mongo"
+	"go.mongodb.org/mongo-driver/v2/mongo/options"
+)
+
+const (
+	formatCSV    = "csv"
+	formatNDJSON = "ndjson"
+
+	// importMerge leaves items missing from the file alone, importReplace deletes them
+	importMerge   = "merge"
+	importReplace = "replace"
+
+	maxImportBytes  = 8 << 20
+	maxImportRows   = 10000
+	importBatchSize = 500
+)
+
+// inventoryColumns is the header of an exported CSV, an import needs every column but the ids and the reorder
+// threshold
+var inventoryColumns = []string{"ingredient_id", "catalog_id", "name", "unit", "unit_quantity", "price", "quantity",
+	"reorder_threshold"}
+
+var requiredColumns = inventoryColumns[2:7]
+
+// inventoryRecord is one item of an inventory file, it is the NDJSON line and the CSV row
+type inventoryRecord struct {
+	IngredientID string  `json:"ingredient_id,omitempty"`
+	CatalogID    string  `json:"catalog_id,omitempty"`
+	Name         string  `json:"name"`
+	Unit         string  `json:"unit"`
+	UnitQuantity int     `json:"unit_quantity"`
+	Price        float64 `json:"price"`
+	Quantity     int     `json:"quantity"`
+	// ReorderThreshold is optional, a file without it keeps the thresholds the items have
+	ReorderThreshold *int `json:"reorder_threshold,omitempty"`
+}
+
+type importRow struct {
+	line int
+	item *Item
+	// keepThreshold is set when the row has no reorder threshold, the item keeps the one it has
+	keepThreshold bool
+}
+
+func newInventoryRecord(item *Item) *inventoryRecord {
+	record := &inventoryRecord{
+		IngredientID: item.IngredientID.Hex(),
+		Name:         item.Name,
+		Unit:         item.Unit,
+		UnitQuantity: item.UnitQuantity,
+		Price:        item.Price,
+		Quantity:     item.Quantity,
+	}
+	if item.ReorderThreshold != 0 {
+		record.ReorderThreshold = &item.ReorderThreshold
+	}
+	if item.CatalogID != bson.NilObjectID {
+		record.CatalogID = item.CatalogID.Hex()
+	}
+	return record
+}
+
+func (record *inventoryRecord) csv() []string {
+	threshold := ""
+	if record.ReorderThreshold != nil {
+		threshold = strconv.Itoa(*record.ReorderThreshold)
+	}
+	return []string{
+		record.IngredientID,
+		record.CatalogID,
+		record.Name,
+		record.Unit,
+		strconv.Itoa(record.UnitQuantity),
+		strconv.FormatFloat(record.Price, 'f', -1, 64),
+		strconv.Itoa(record.Quantity),
+		threshold,
+	}
+}
+
+// item turns the record into a store item, ids that are not valid are reported against their column
+func (record *inventoryRecord) item(line int) (*Item, []*ImportRowError) {
+	item := &Item{Quantity: record.Quantity}
+	item.Name = strings.TrimSpace(record.Name)
+	item.Unit = strings.TrimSpace(record.Unit)
+	item.UnitQuantity = record.UnitQuantity
+	item.Price = record.Price
+	if record.ReorderThreshold != nil {
+		item.ReorderThreshold = *record.ReorderThreshold
+	}
+
+	var errs []*ImportRowError
+	for _, id := range []struct {
+		field, value string
+		id           *bson.ObjectID
+	}{
+		{"ingredient_id", record.IngredientID, &item.IngredientID},
+		{"catalog_id", record.CatalogID, &item.CatalogID},
+	} {
+		value := strings.TrimSpace(id.value)
+		if value == "" {
+			continue
+		}
+		parsed, err := bson.ObjectIDFromHex(value)
+		if err != nil {
+			errs = append(errs, &ImportRowError{Row: line, Field: id.field, Message: "not a valid id"})
+			continue
+		}
+		*id.id = parsed
+	}
+	return item, errs
+}
+
+// importFormat is the format query parameter, or the one the content type names
+func importFormat(format, contentType string) (string, error) {
+	if format == "" {
+		mediaType, _, _ := mime.ParseMediaType(contentType)
+		switch mediaType {
+		case "text/csv":
+			format = formatCSV
+		case "application/x-ndjson", "application/ndjson", "application/jsonl":
+			format = formatNDJSON
+		}
+	}
+	switch format {
+	case formatCSV, formatNDJSON:
+		return format, nil
+	case "":
+		return "", errors.New("send the file as text/csv or application/x-ndjson, or set format to csv or ndjson")
+	}
+	return "", fmt.Errorf("unknown format %q, it must be csv or ndjson", format)
+}
+
+// readInventory parses an inventory file, rows that can not be read are returned as row errors so the vendor
+// sees all of them at once, the error is for files that can not be read at all
+func readInventory(r io.Reader, format string) ([]*importRow, []*ImportRowError, error) {
+	var rows []*importRow
+	var errs []*ImportRowError
+	add := func(line int, record *inventoryRecord) error {
+		if len(rows)+len(errs) >= maxImportRows {
+			return fmt.Errorf("an import can not have more than %d rows", maxImportRows)
+		}
+		item, rowErrs := record.item(line)
+		if len(rowErrs) > 0 {
+			errs = append(errs, rowErrs...)
+			return nil
+		}
+		rows = append(rows, &importRow{line, item, record.ReorderThreshold == nil})
+		return nil
+	}
+
+	switch format {
+	case formatCSV:
+		if err := readCSV(r, add, &errs); err != nil {
+			return nil, nil, err
+		}
+	case formatNDJSON:
+		scanner := bufio.NewScanner(r)
+		scanner.Buffer(make([]byte, 64*1024), 1<<20)
+		for line := 1; scanner.Scan(); line++ {
+			text := strings.TrimSpace(scanner.Text())
+			if text == "" {
+				continue
+			}
+			var record inventoryRecord
+			if err := json.Unmarshal([]byte(text), &record); err != nil {
+				errs = append(errs, &ImportRowError{Row: line, Message: err.Error()})
+				continue
+			}
+			if err := add(line, &record); err != nil {
+				return nil, nil, err
+			}
+		}
+		if err := scanner.Err(); err != nil {
+			return nil, nil, err
+		}
+	default:
+		return nil, nil, fmt.Errorf("unknown format %q", format)
+	}
+	return rows, errs, nil
+}
+
+func readCSV(r io.Reader, add func(int, *inventoryRecord) error, errs *[]*ImportRowError) error {
+	reader := csv.NewReader(r)
+	reader.FieldsPerRecord = -1
+	reader.TrimLeadingSpace = true
+
+	header, err := reader.Read()
+	if err != nil {
+		return errors.New("the file needs a header row")
+	}
+	columns := make(map[string]int, len(header))
+	for i, name := range header {
+		columns[strings.ToLower(strings.TrimSpace(name))] = i
+	}
+	for _, name := range requiredColumns {
+		if _, ok := columns[name]; !ok {
+			return fmt.Errorf("the header is missing the %s column", name)
+		}
+	}
+
+	for {
+		fields, err := reader.Read()
+		if errors.Is(err, io.EOF) {
+			return nil
+		}
+		var parseErr *csv.ParseError
+		if errors.As(err, &parseErr) {
+			*errs = append(*errs, &ImportRowError{Row: parseErr.Line, Message: parseErr.Err.Error()})
+			continue
+		} else if err != nil {
+			return err
+		}
+
+		line, _ := reader.FieldPos(0)
+		if len(fields) != len(header) {
+			*errs = append(*errs, &ImportRowError{Row: line,
+				Message: fmt.Sprintf("has %d columns, the header has %d", len(fields), len(header))})
+			continue
+		}
+		value := func(name string) string {
+			if i, ok := columns[name]; ok {
+				return strings.TrimSpace(fields[i])
+			}
+			return ""
+		}
+
+		record := &inventoryRecord{
+			IngredientID: value("ingredient_id"),
+			CatalogID:    value("catalog_id"),
+			Name:         value("name"),
+			Unit:         value("unit"),
+		}
+		failed := false
+		if threshold := value("reorder_threshold"); threshold != "" {
+			n, err := strconv.Atoi(threshold)
+			if err != nil {
+				*errs = append(*errs, &ImportRowError{Row: line, Field: "reorder_threshold", Message: "not a number"})
+				failed = true
+			}
+			record.ReorderThreshold = &n
+		}
+		for _, number := range []struct {
+			name  string
+			value any
+		}{{"unit_quantity", &record.UnitQuantity}, {"price", &record.Price}, {"quantity", &record.Quantity}} {
+			var err error
+			switch n := number.value.(type) {
+			case *int:
+				*n, err = strconv.Atoi(value(number.name))
+			case *float64:
+				*n, err = strconv.ParseFloat(value(number.name), 64)
+			}
+			if err != nil {
+				*errs = append(*errs, &ImportRowError{Row: line, Field: number.name, Message: "not a number"})
+				failed = true
+			}
+		}
+		if failed {
+			continue
+		}
+		if err := add(line, record); err != nil {
+			return err
+		}
+	}
+}
+
+// inventoryKey identifies an item without its id, items for the same catalog ingredient in the same pack size
+// are the same item. Items saved before they were linked to the catalog are keyed by what their name resolves to
+func (m *ingredientMatcher) inventoryKey(item *Item) string {
+	name := "name:" + normalizeName(item.Name)
+	if item.CatalogID != bson.NilObjectID {
+		name = item.CatalogID.Hex()
+	} else if entry := m.resolve(item.Name); entry != nil {
+		name = entry.IngredientID.Hex()
+	}
+	return fmt.Sprintf("%s|%s|%d", name, canonicalUnit(item.Unit), item.UnitQuantity)
+}
+
+func sameItem(a, b *Item) bool {
+	return a.Name == b.Name && a.Unit == b.Unit && a.UnitQuantity == b.UnitQuantity && a.Price == b.Price &&
+		a.Quantity == b.Quantity && a.CatalogID == b.CatalogID && a.ReorderThreshold == b.ReorderThreshold
+}
+
+func (row *importRow) validate() *ImportRowError {
+	item := row.item
+	switch {
+	case item.Name == "" && item.CatalogID == bson.NilObjectID:
+		return &ImportRowError{Row: row.line, Field: "name", Message: "name is required"}
+	case item.Unit == "":
+		return &ImportRowError{Row: row.line, Field: "unit", Message: "unit is required"}
+	case item.UnitQuantity <= 0:
+		return &ImportRowError{Row: row.line, Field: "unit_quantity", Message: "unit_quantity must be more than 0"}
+	case item.Price <= 0:
+		return &ImportRowError{Row: row.line, Field: "price", Message: "price must be more than 0"}
+	case item.Quantity < 0:
+		return &ImportRowError{Row: row.line, Field: "quantity", Message: "quantity can not be negative"}
+	case item.ReorderThreshold < 0:
+		return &ImportRowError{Row: row.line, Field: "reorder_threshold", Message: "reorder_threshold can not be negative"}
+	}
+	return nil
+}
+
+// diffInventory works out what importing the rows does to the current items of a store. Rows name the item
+// they change by ingredient_id, as an export does, or else by catalog ingredient and pack size. Every row is
+// linked to the catalog like a store item sent through the API, with replace the items no row names are deleted
+func diffInventory(current []*Item, rows []*importRow, mode string, matcher *ingredientMatcher) (*ImportDiff, []*ImportRowError) {
+	byID := make(map[bson.ObjectID]*Item, len(current))
+	byKey := make(map[string]*Item, len(current))
+	for _, item := range current {
+		byID[item.IngredientID] = item
+		if key := matcher.inventoryKey(item); byKey[key] == nil {
+			byKey[key] = item
+		}
+	}
+
+	diff := &ImportDiff{Create: []*Item{}, Update: []*ItemChange{}, Delete: []*Item{}}
+	var errs []*ImportRowError
+	claimed := make(map[bson.ObjectID]int)
+	created := make(map[string]int)
+	for _, row := range rows {
+		if err := row.validate(); err != nil {
+			errs = append(errs, err)
+			continue
+		}
+		item := row.item
+		if err := matcher.linkItem(item, false); err != nil {
+			errs = append(errs, &ImportRowError{Row: row.line, Message: err.Error()})
+			continue
+		}
+
+		key := matcher.inventoryKey(item)
+		before := byKey[key]
+		if item.IngredientID != bson.NilObjectID {
+			if before = byID[item.IngredientID]; before == nil {
+				errs = append(errs, &ImportRowError{Row: row.line, Field: "ingredient_id", Message: "not an item of this store"})
+				continue
+			}
+		}
+
+		if before == nil {
+			if line, ok := created[key]; ok {
+				errs = append(errs, &ImportRowError{Row: row.line, Message: fmt.Sprintf("the same item as row %d", line)})
+				continue
+			}
+			created[key] = row.line
+			diff.Create = append(diff.Create, item)
+			continue
+		}
+		if line, ok := claimed[before.IngredientID]; ok {
+			errs = append(errs, &ImportRowError{Row: row.line, Message: fmt.Sprintf("the same item as row %d", line)})
+			continue
+		}
+		claimed[before.IngredientID] = row.line
+
+		item.IngredientID = before.IngredientID
+		if row.keepThreshold {
+			item.ReorderThreshold = before.ReorderThreshold
+		}
+		if sameItem(before, item) {
+			diff.Unchanged++
+		} else {
+			diff.Update = append(diff.Update, &ItemChange{Before: before, After: item})
+		}
+	}
+
+	if mode == importReplace {
+		for _, item := range current {
+			if _, ok := claimed[item.IngredientID]; !ok {
+				diff.Delete = append(diff.Delete, item)
+			}
+		}
+	}
+	slices.SortStableFunc(errs, func(a, b *ImportRowError) int { return cmp.Compare(a.Row, b.Row) })
+	return diff, errs
+}
+
+// ImportStoreItems applies an import diff to a store in one transaction, the writes go out in batches so a
+// large import does not turn into one huge update
+func (m MongoVendorRepository) ImportStoreItems(ctx context.Context, vendorID, storeID ID, diff *ImportDiff) error {
+	ctx, span := Tracer.Start(ctx, "ImportStoreItems")
+	defer span.End()
+
+	Logger.InfoContext(ctx, "Importing store items", slog.String("vendorID", vendorID.String()),
+		slog.String("storeID", storeID.String()), slog.Int("create", len(diff.Create)),
+		slog.Int("update", len(diff.Update)), slog.Int("delete", len(diff.Delete)), vendor_repo_source)
+
+	filter := bson.D{{Key: "_id", Value: vendorID.value}}
+	storeFilter := bson.M{"s._id": storeID.value}
+	var models []mongo.WriteModel
+
+	// a store saved without items has a null items array that $push would fail on
+	models = append(models, mongo.NewUpdateOneModel().
+		SetFilter(bson.D{{Key: "_id", Value: vendorID.value}, {Key: "stores", Value: bson.D{{Key: "$elemMatch",
+			Value: bson.D{{Key: "_id", Value: storeID.value}, {Key: "items", Value: nil}}}}}}).
+		SetUpdate(bson.D{{Key: "$set", Value: bson.M{"stores.$.items": []*Item{}}}}))
+
+	for chunk := range slices.Chunk(diff.Delete, importBatchSize) {
+		ids := make([]bson.ObjectID, len(chunk))
+		for i, item := range chunk {
+			ids[i] = item.IngredientID
+		}
+		models = append(models, mongo.NewUpdateOneModel().SetFilter(filter).
+			SetUpdate(bson.D{{Key: "$pull", Value: bson.M{
+				"stores.$[s].items": bson.M{"ingredient_id": bson.M{"$in": ids}},
+			}}}).
+			SetArrayFilters([]any{storeFilter}))
+	}
+
+	for _, change := range diff.Update {
+		item := change.After
+		set := bson.M{
+			"stores.$[s].items.$[i].name":              item.Name,
+			"stores.$[s].items.$[i].name_keys":         nameKeys(item.Name),
+			"stores.$[s].items.$[i].unit":              item.Unit,
+			"stores.$[s].items.$[i].unit_quantity":     item.UnitQuantity,
+			"stores.$[s].items.$[i].price":             item.Price,
+			"stores.$[s].items.$[i].quantity":          item.Quantity,
+			"stores.$[s].items.$[i].catalog_id":        item.CatalogID,
+			"stores.$[s].items.$[i].reorder_threshold": item.ReorderThreshold,
+		}
+		models = append(models, mongo.NewUpdateOneModel().SetFilter(filter).
+			SetUpdate(bson.D{{Key: "$set", Value: set}}).
+			SetArrayFilters([]any{storeFilter, bson.M{"i.ingredient_id": item.IngredientID}}))
+	}
+
+	for chunk := range slices.Chunk(diff.Create, importBatchSize) {
+		for _, item := range chunk {
+			item.IngredientID, item.NameKeys = bson.NewObjectID(), nameKeys(item.Name)
+		}
+		models = append(models, mongo.NewUpdateOneModel().SetFilter(filter).
+			SetUpdate(bson.D{{Key: "$push", Value: bson.M{"stores.$[s].items": bson.M{"$each": chunk}}}}).
+			SetArrayFilters([]any{storeFilter}))
+	}
+
+	session, err := MongoClient.StartSession(sesOp)
+	if err != nil {
+		Logger.ErrorContext(ctx, "Unable to create a session", slog.Any("error", err), vendor_repo_source)
+		return err
+	}
+	defer session.EndSession(ctx)
+
+	_, err = session.WithTransaction(ctx, func(sessCtx context.Context) (any, error) {
+		before, err := m.snapshotStock(sessCtx, vendorID)
+		if err != nil {
+			return nil, err
+		}
+		for batch := range slices.Chunk(models, importBatchSize) {
+			if _, err := m.col.BulkWrite(sessCtx, batch, options.BulkWrite().SetOrdered(true)); err != nil {
+				Logger.ErrorContext(sessCtx, "Error writing an import batch", slog.Any("error", err), vendor_repo_source)
+				return nil, err
+			}
+		}
+		return nil, m.recordLedger(sessCtx, vendorID, before, ledgerImport, bson.NilObjectID)
+	})
+	if err != nil {
+		return err
+	}
+
+	Logger.InfoContext(ctx, "Store items imported", slog.String("storeID", storeID.String()), vendor_repo_source)
+	return nil
+}
+
+// StreamStoreItems calls fn with every item of the store as it comes off the cursor so an export never holds
+// the whole inventory in memory
+func (m MongoVendorRepository) StreamStoreItems(ctx context.Context, vendorID, storeID ID, fn func(*Item) error) error {
+	ctx, span := Tracer.Start(ctx, "StreamStoreItems")
+	defer span.End()
+
+	Logger.InfoContext(ctx, "Streaming store items", slog.String("vendorID", vendorID.String()),
+		slog.String("storeID", storeID.String()), vendor_repo_source)
+
+	filter := bson.D{{Key: "_id", Value: vendorID.value}, {Key: "stores._id", Value: storeID.value}}
+	if count, err := m.col.CountDocuments(ctx, filter); err != nil {
+		Logger.ErrorContext(ctx, "Error finding the store", slog.Any("error", err), vendor_repo_source)
+		return err
+	} else if count == 0 {
+		return fmt.Errorf("store with ID %s not found for vendor %s", storeID.String(), vendorID.String())
+	}
+
+	pipeline := mongo.Pipeline{
+		{{Key: "$match", Value: filter}},
+		{{Key: "$unwind", Value: "$stores"}},
+		{{Key: "$match", Value: bson.D{{Key: "stores._id", Value: storeID.value}}}},
+		{{Key: "$unwind", Value: "$stores.items"}},
+		{{Key: "$replaceRoot", Value: bson.D{{Key: "newRoot", Value: "$stores.items"}}}},
+	}
+	cursor, err := m.col.Aggregate(ctx, pipeline)
+	if err != nil {
+		Logger.ErrorContext(ctx, "Error reading store items", slog.Any("error", err), vendor_repo_source)
+		return err
+	}
+	defer cursor.Close(ctx)
+
+	for cursor.Next(ctx) {
+		var item Item
+		if err := cursor.Decode(&item); err != nil {
+			Logger.ErrorContext(ctx, "Error decoding store item", slog.Any("error", err), vendor_repo_source)
+			return err
+		}
+		if err := fn(&item); err != nil {
+			return err
+		}
+	}
+	return cursor.Err()
+}
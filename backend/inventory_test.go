@@ -0,0 +1,148 @@
+package main
+
+import (
+	"bytes"
+	"encoding/csv"
+	"strings"
+	"testing"
+
+	"go.mongodb.org/mongo-driver/v2/bson"
+)
+
+func TestReadInventory(t *testing.T) {
+	csvFile := "name,unit,unit_quantity,price,quantity\n" +
+		"Milk,l,1,1.5,20\n" +
+		"Flour,kg,one,2,5\n" +
+		"Eggs,each,12\n" +
+		"\n" +
+		"Butter,g,250,3.2,0\n"
+	rows, errs, err := readInventory(strings.NewReader(csvFile), formatCSV)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(rows) != 2 || rows[0].line != 2 || rows[1].line != 6 || rows[1].item.Name != "Butter" {
+		t.Fatalf("expected the milk and butter rows got %+v", rows)
+	}
+	if len(errs) != 2 || errs[0].Row != 3 || errs[0].Field != "unit_quantity" || errs[1].Row != 4 {
+		t.Fatalf("expected errors on rows 3 and 4 got %+v", errs)
+	}
+
+	if _, _, err := readInventory(strings.NewReader("name,unit,price\nMilk,l,1\n"), formatCSV); err == nil {
+		t.Fatal("expected a header without unit_quantity and quantity to fail")
+	}
+
+	ndjson := `{"name":"Milk","unit":"l","unit_quantity":1,"price":1.5,"quantity":20}` + "\n" +
+		`{"name":"Flour","unit":"kg","unit_quantity":"1"}` + "\n" +
+		`{"ingredient_id":"nope","name":"Eggs","unit":"each","unit_quantity":12,"price":3,"quantity":1}` + "\n"
+	rows, errs, err = readInventory(strings.NewReader(ndjson), formatNDJSON)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(rows) != 1 || rows[0].item.Quantity != 20 {
+		t.Fatalf("expected the milk row got %+v", rows)
+	}
+	if len(errs) != 2 || errs[0].Row != 2 || errs[1].Row != 3 || errs[1].Field != "ingredient_id" {
+		t.Fatalf("expected errors on rows 2 and 3 got %+v", errs)
+	}
+}
+
+func TestImportFormat(t *testing.T) {
+	tests := []struct {
+		format, contentType, expected string
+	}{
+		{"", "text/csv; charset=utf-8", formatCSV},
+		{"", "application/x-ndjson", formatNDJSON},
+		{"ndjson", "text/csv", formatNDJSON},
+		{"", "application/json", ""},
+		{"xml", "", ""},
+	}
+	for _, test := range tests {
+		format, err := importFormat(test.format, test.contentType)
+		if (err == nil) != (test.expected != "") || format != test.expected {
+			t.Fatalf("%q %q -> expected %q got %q %v", test.format, test.contentType, test.expected, format, err)
+		}
+	}
+}
+
+func TestDiffInventory(t *testing.T) {
+	milk := &Ingredient{IngredientID: bson.NewObjectID(), Name: "Milk", Unit: "l"}
+	flour := &Ingredient{IngredientID: bson.NewObjectID(), Name: "Flour", Unit: "kg"}
+	eggs := &Ingredient{IngredientID: bson.NewObjectID(), Name: "Eggs", Unit: "each"}
+	matcher := newIngredientMatcher([]*Ingredient{milk, flour, eggs})
+
+	storeMilk := newTestItem("Milk", 1, "l", 1.5)
+	storeMilk.CatalogID, storeMilk.Quantity = milk.IngredientID, 10
+	// saved before the catalog link, found by its name
+	storeFlour := newTestItem("Flour", 1, "kg", 2)
+	storeFlour.Quantity = 5
+	storeEggs := newTestItem("Eggs", 12, "each", 3)
+	storeEggs.CatalogID = eggs.IngredientID
+	current := []*Item{storeMilk, storeFlour, storeEggs}
+
+	row := func(line int, item *Item) *importRow { return &importRow{line, item, true} }
+	rows := func() []*importRow {
+		return []*importRow{
+			row(2, &Item{Ingredient: Ingredient{Name: "Milk", Unit: "l", UnitQuantity: 1, Price: 1.5}, Quantity: 10}),
+			row(3, &Item{Ingredient: Ingredient{Name: "Flour", Unit: "kg", UnitQuantity: 1, Price: 2.5}, Quantity: 5}),
+			row(4, &Item{Ingredient: Ingredient{Name: "Flour", Unit: "kg", UnitQuantity: 2, Price: 4}, Quantity: 1}),
+		}
+	}
+
+	diff, errs := diffInventory(current, rows(), importMerge, matcher)
+	if len(errs) != 0 {
+		t.Fatalf("expected no errors got %+v", errs)
+	}
+	if diff.Unchanged != 1 || len(diff.Update) != 1 || len(diff.Create) != 1 || len(diff.Delete) != 0 {
+		t.Fatalf("expected 1 unchanged, 1 update and 1 create got %+v", diff)
+	}
+	if change := diff.Update[0]; change.Before != storeFlour || change.After.IngredientID != storeFlour.IngredientID ||
+		change.After.CatalogID != flour.IngredientID {
+		t.Fatalf("expected the flour update to keep its id and link the catalog got %+v", change.After)
+	}
+
+	diff, _ = diffInventory(current, rows(), importReplace, matcher)
+	if len(diff.Delete) != 1 || diff.Delete[0] != storeEggs {
+		t.Fatalf("expected replace to delete the eggs got %+v", diff.Delete)
+	}
+
+	bad := []*importRow{
+		row(2, &Item{Ingredient: Ingredient{IngredientID: storeEggs.IngredientID, Name: "Eggs", Unit: "each", UnitQuantity: 12, Price: 3.5}}),
+		row(3, &Item{Ingredient: Ingredient{Name: "Eggs", Unit: "each", UnitQuantity: 12, Price: 3}}),
+		row(4, &Item{Ingredient: Ingredient{Name: "Caviar", Unit: "g", UnitQuantity: 50, Price: 90}}),
+		row(5, &Item{Ingredient: Ingredient{IngredientID: bson.NewObjectID(), Name: "Milk", Unit: "l", UnitQuantity: 1, Price: 1}}),
+		row(6, &Item{Ingredient: Ingredient{Name: "Milk", Unit: "l", UnitQuantity: 1, Price: 1}, Quantity: -1}),
+		row(7, &Item{Ingredient: Ingredient{Name: "Milk", Unit: "kg", UnitQuantity: 1, Price: 1}}),
+	}
+	diff, errs = diffInventory(current, bad, importMerge, matcher)
+	if len(diff.Update) != 1 {
+		t.Fatalf("expected the first eggs row to update got %+v", diff)
+	}
+	lines := []int{3, 4, 5, 6, 7}
+	if len(errs) != len(lines) {
+		t.Fatalf("expected errors on rows %v got %+v", lines, errs)
+	}
+	for i, err := range errs {
+		if err.Row != lines[i] {
+			t.Fatalf("expected an error on row %d got %+v", lines[i], err)
+		}
+	}
+}
+
+func TestInventoryRoundTrip(t *testing.T) {
+	item := newTestItem("Milk", 1, "l", 1.25)
+	item.CatalogID, item.Quantity, item.ReorderThreshold = bson.NewObjectID(), 7, 3
+
+	var buf bytes.Buffer
+	writer := csv.NewWriter(&buf)
+	writer.Write(inventoryColumns)
+	writer.Write(newInventoryRecord(item).csv())
+	writer.Flush()
+
+	rows, errs, err := readInventory(&buf, formatCSV)
+	if err != nil || len(errs) != 0 || len(rows) != 1 {
+		t.Fatalf("expected one row got %+v %+v %v", rows, errs, err)
+	}
+	if got := rows[0].item; got.IngredientID != item.IngredientID || !sameItem(got, item) {
+		t.Fatalf("expected %+v got %+v", item, got)
+	}
+}
@@ -0,0 +1,377 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"html/template"
+	"io"
+	"log/slog"
+	"mime"
+	"strings"
+	"time"
+
+	"go.mongodb.org/mongo-driver/v2/bson"
+	"go.mongodb.org/mongo-driver/v2/mongo"
+	"go.mongodb.org/mongo-driver/v2/mongo/options"
+)
+
+const (
+	orderDelivered = "delivered"
+
+	documentPDF  = "pdf"
+	documentHTML = "html"
+)
+
+var invoice_repo_source = slog.Any("source", "InvoiceRepository")
+
+// code is the invoice number as it is printed
+func (i *Invoice) code() string {
+	return fmt.Sprintf("INV-%06d", i.Number)
+}
+
+// documentFormat is pdf or html from the format query, or from the Accept header when there is none, pdf by
+// default
+func documentFormat(format, accept string) (string, error) {
+	if format == "" {
+		for _, value := range strings.Split(accept, ",") {
+			mediaType, _, _ := mime.ParseMediaType(strings.TrimSpace(value))
+			switch mediaType {
+			case "application/pdf":
+				return documentPDF, nil
+			case "text/html":
+				return documentHTML, nil
+			}
+		}
+		return documentPDF, nil
+	}
+	switch format = strings.ToLower(format); format {
+	case documentPDF, documentHTML:
+		return format, nil
+	}
+	return "", fmt.Errorf("unknown format %q, it must be pdf or html", format)
+}
+
+// orderDocument is a receipt or an invoice, both are rendered from the order as it is stored
+type orderDocument struct {
+	Title         string
+	Number        string
+	IssuedAt      time.Time
+	Vendor        string
+	StoreName     string
+	StoreAddress  string
+	Order         *Order
+	PaymentStatus string
+}
+
+type documentLine struct {
+	Name      string
+	Quantity  int
+	UnitPrice float64
+	Amount    float64
+	Discount  float64
+	TaxRate   float64
+	Tax       float64
+	Total     float64
+}
+
+// newOrderDocument fills the seller from the vendor and the one store FindSeller read, a store without its own
+// address is at the vendor's
+func newOrderDocument(title, number string, issuedAt time.Time, seller *Vendor, order *Order, paymentStatus string) *orderDocument {
+	doc := &orderDocument{Title: title, Number: number, IssuedAt: issuedAt, Vendor: seller.Name,
+		StoreAddress: seller.Address, Order: order, PaymentStatus: paymentStatus}
+	if len(seller.Stores) == 1 {
+		doc.StoreName = seller.Stores[0].Name
+		if seller.Stores[0].Address != "" {
+			doc.StoreAddress = seller.Stores[0].Address
+		}
+	}
+	if doc.PaymentStatus == "" {
+		doc.PaymentStatus = "unknown"
+	}
+	return doc
+}
+
+// Lines are the order items with what they were charged, orders placed before line pricing only have the
+// amount
+func (d *orderDocument) Lines() []*documentLine {
+	lines := make([]*documentLine, 0, len(d.Order.Items))
+	for _, item := range d.Order.Items {
+		line := &documentLine{Name: item.Name, Quantity: item.Quantity, UnitPrice: item.unitPrice()}
+		if pricing := item.Pricing; pricing != nil {
+			line.Amount, line.Discount, line.TaxRate, line.Tax, line.Total =
+				pricing.Amount, pricing.Discount, pricing.TaxRate, pricing.Tax, pricing.Total
+		} else {
+			line.Amount = item.linePrice(item.Quantity)
+			line.Total = line.Amount
+		}
+		lines = append(lines, line)
+	}
+	return lines
+}
+
+// Totals is the order breakdown, built from the order fields for orders placed before it was stored
+func (d *orderDocument) Totals() *PriceBreakdown {
+	if d.Order.Breakdown != nil {
+		return d.Order.Breakdown
+	}
+	totals := &PriceBreakdown{Subtotal: d.Order.Subtotal, Fees: []*Fee{}, Total: d.Order.TotalPrice}
+	if totals.Subtotal == 0 {
+		for _, line := range d.Lines() {
+			totals.Subtotal += line.Amount
+		}
+		totals.Subtotal = roundCents(totals.Subtotal)
+	}
+	if d.Order.Discount != nil {
+		totals.Discount = d.Order.Discount.Amount
+	}
+	if d.Order.DeliveryFee > 0 {
+		totals.Fees = append(totals.Fees, &Fee{Kind: feeDelivery, Amount: d.Order.DeliveryFee})
+	}
+	return totals
+}
+
+// text is the document as lines of fixed width columns for the PDF
+func (d *orderDocument) text() []string {
+	money := func(amount float64) string { return fmt.Sprintf("%.2f", amount) }
+	row := func(name, quantity, price, discount, rate, tax, total string) string {
+		return fmt.Sprintf("%-28s %4s %9s %9s %6s %8s %10s", name, quantity, price, discount, rate, tax, total)
+	}
+	rule := strings.Repeat("-", 80)
+
+	lines := []string{
+		fmt.Sprintf("%s %s", strings.ToUpper(d.Title), d.Number),
+		"",
+		d.Vendor,
+		d.StoreName,
+		d.StoreAddress,
+		"",
+		fmt.Sprintf("Order:    %s", d.Order.ID.Hex()),
+		fmt.Sprintf("Placed:   %s", d.Order.CreatedAt.Format(time.DateTime)),
+		fmt.Sprintf("Issued:   %s", d.IssuedAt.Format(time.DateTime)),
+		fmt.Sprintf("Delivery: %s", d.Order.DeliveryMethod),
+		fmt.Sprintf("Payment:  %s", d.PaymentStatus),
+		"",
+		row("Item", "Qty", "Price", "Discount", "Tax %", "Tax", "Total"),
+		rule,
+	}
+	for _, line := range d.Lines() {
+		name := []rune(line.Name)
+		if len(name) > 28 {
+			name = append(name[:27], '~')
+		}
+		lines = append(lines, row(string(name), fmt.Sprint(line.Quantity), money(line.UnitPrice), money(line.Discount),
+			fmt.Sprintf("%g", line.TaxRate), money(line.Tax), money(line.Total)))
+	}
+	lines = append(lines, rule)
+
+	totals := d.Totals()
+	total := func(label string, amount float64) string { return fmt.Sprintf("%69s %10s", label, money(amount)) }
+	lines = append(lines, total("Subtotal", totals.Subtotal))
+	if totals.Discount > 0 {
+		lines = append(lines, total("Discount", -totals.Discount))
+	}
+	for _, fee := range totals.Fees {
+		lines = append(lines, total(strings.ToUpper(fee.Kind[:1])+fee.Kind[1:]+" fee", fee.Amount))
+	}
+	lines = append(lines, total("Tax", totals.Tax), total("Total", totals.Total))
+	return lines
+}
+
+var documentTemplate = template.Must(template.New("document").Funcs(template.FuncMap{
+	"money": func(amount float64) string { return fmt.Sprintf("%.2f", amount) },
+	"when":  func(t time.Time) string { return t.Format(time.DateTime) },
+}).Parse(`<!DOCTYPE html>
+<html>
+<head>
+<meta charset="utf-8">
+<title>{{.Title}} {{.Number}}</title>
+<style>
+body { font-family: sans-serif; margin: 2em; }
+table { border-collapse: collapse; width: 100%; }
+th, td { padding: 4px 8px; border-bottom: 1px solid #ddd; }
+td.n, th.n { text-align: right; }
+</style>
+</head>
+<body>
+<h1>{{.Title}} {{.Number}}</h1>
+<p>{{.Vendor}}<br>{{.StoreName}}<br>{{.StoreAddress}}</p>
+<p>Order {{.Order.ID.Hex}}<br>Placed {{when .Order.CreatedAt}}<br>Issued {{when .IssuedAt}}<br>
+Delivery {{.Order.DeliveryMethod}}<br>Payment {{.PaymentStatus}}</p>
+<table>
+<tr><th>Item</th><th class="n">Qty</th><th class="n">Price</th><th class="n">Discount</th><th class="n">Tax %</th><th class="n">Tax</th><th class="n">Total</th></tr>
+{{range .Lines}}<tr><td>{{.Name}}</td><td class="n">{{.Quantity}}</td><td class="n">{{money .UnitPrice}}</td><td class="n">{{money .Discount}}</td><td class="n">{{.TaxRate}}</td><td class="n">{{money .Tax}}</td><td class="n">{{money .Total}}</td></tr>
+{{end}}{{with .Totals}}<tr><td colspan="6" class="n">Subtotal</td><td class="n">{{money .Subtotal}}</td></tr>
+{{if .Discount}}<tr><td colspan="6" class="n">Discount</td><td class="n">-{{money .Discount}}</td></tr>
+{{end}}{{range .Fees}}<tr><td colspan="6" class="n">{{.Kind}} fee</td><td class="n">{{money .Amount}}</td></tr>
+{{end}}<tr><td colspan="6" class="n">Tax</td><td class="n">{{money .Tax}}</td></tr>
+<tr><th colspan="6" class="n">Total</th><th class="n">{{money .Total}}</th></tr>
+{{end}}</table>
+</body>
+</html>
+`))
+
+// render writes the document in the format, pdf or html
+func (d *orderDocument) render(w io.Writer, format string) error {
+	if format == documentHTML {
+		return documentTemplate.Execute(w, d)
+	}
+	_, err := w.Write(textPDF(d.text()))
+	return err
+}
+
+// InvoiceRepository reads the orders receipts and invoices are rendered from and numbers the invoices of each
+// vendor
+type InvoiceRepository interface {
+	FindUserOrder(context.Context, ID, ID) (*UserOrder, error)
+	FindVendorOrder(context.Context, ID, ID) (*VendorOrder, error)
+	FindSoldOrder(context.Context, ID, ID) (*UserOrder, error)
+	FindSeller(context.Context, ID, ID) (*Vendor, error)
+	IssueInvoice(context.Context, bson.ObjectID, bson.ObjectID, time.Time) (*Invoice, error)
+}
+
+type MongoInvoiceRepository struct {
+	col      *mongo.Collection
+	counters *mongo.Collection
+	user     *mongo.Collection
+	vendor   *mongo.Collection
+}
+
+func newMongoInvoiceRepository(client *mongo.Client, dbName string) InvoiceRepository {
+	db := client.Database(dbName)
+	return &MongoInvoiceRepository{col: db.Collection("invoices"), counters: db.Collection("invoice_counters"),
+		user: db.Collection("user"), vendor: db.Collection("vendor")}
+}
+
+// findOrder reads one order of the user or vendor, NoItems when they have no such order
+func findOrder[O UserOrder | VendorOrder](ctx context.Context, col *mongo.Collection, ownerID, orderID ID) (*O, error) {
+	filter := bson.D{{Key: "_id", Value: ownerID.value}}
+	projection := bson.D{{Key: "orders", Value: bson.D{{Key: "$elemMatch", Value: bson.D{{Key: "_id", Value: orderID.value}}}}}}
+	var owner struct {
+		Orders []*O `bson:"orders"`
+	}
+	if err := col.FindOne(ctx, filter, options.FindOne().SetProjection(projection)).Decode(&owner); err != nil {
+		if errors.Is(err, mongo.ErrNoDocuments) {
+			return nil, &NoItems{}
+		}
+		Logger.ErrorContext(ctx, "Error finding order", slog.String("orderID", orderID.String()), slog.Any("error", err),
+			invoice_repo_source)
+		return nil, err
+	}
+	if len(owner.Orders) != 1 {
+		return nil, &NoItems{}
+	}
+	return owner.Orders[0], nil
+}
+
+func (m MongoInvoiceRepository) FindUserOrder(ctx context.Context, userID, orderID ID) (*UserOrder, error) {
+	ctx, span := Tracer.Start(ctx, "FindUserOrder")
+	defer span.End()
+
+	return findOrder[UserOrder](ctx, m.user, userID, orderID)
+}
+
+func (m MongoInvoiceRepository) FindVendorOrder(ctx context.Context, vendorID, orderID ID) (*VendorOrder, error) {
+	ctx, span := Tracer.Start(ctx, "FindVendorOrder")
+	defer span.End()
+
+	return findOrder[VendorOrder](ctx, m.vendor, vendorID, orderID)
+}
+
+// FindSoldOrder is the user's order as the vendor has it. Users can write the status of their own copy, so
+// anything that depends on the order being delivered reads the vendor's
+func (m MongoInvoiceRepository) FindSoldOrder(ctx context.Context, userID, orderID ID) (*UserOrder, error) {
+	ctx, span := Tracer.Start(ctx, "FindSoldOrder")
+	defer span.End()
+
+	order, err := findOrder[UserOrder](ctx, m.user, userID, orderID)
+	if err != nil {
+		return nil, err
+	}
+	sold, err := findOrder[VendorOrder](ctx, m.vendor, ID{order.VendorID}, orderID)
+	if err != nil {
+		return nil, err
+	}
+	if sold.UserID != userID.value {
+		return nil, &NoItems{}
+	}
+	order.Order = sold.Order
+	return order, nil
+}
+
+// FindSeller reads the vendor's name and address with only the store of the order
+func (m MongoInvoiceRepository) FindSeller(ctx context.Context, vendorID, storeID ID) (*Vendor, error) {
+	ctx, span := Tracer.Start(ctx, "FindSeller")
+	defer span.End()
+
+	projection := bson.D{
+		{Key: "name", Value: 1},
+		{Key: "address", Value: 1},
+		{Key: "stores", Value: bson.D{{Key: "$elemMatch", Value: bson.D{{Key: "_id", Value: storeID.value}}}}},
+	}
+	var vendor Vendor
+	err := m.vendor.FindOne(ctx, bson.D{{Key: "_id", Value: vendorID.value}}, options.FindOne().SetProjection(projection)).Decode(&vendor)
+	if err != nil {
+		if errors.Is(err, mongo.ErrNoDocuments) {
+			return nil, &NoItems{}
+		}
+		Logger.ErrorContext(ctx, "Error finding seller", slog.String("vendorID", vendorID.String()), slog.Any("error", err),
+			invoice_repo_source)
+		return nil, err
+	}
+	return &vendor, nil
+}
+
+// IssueInvoice is the invoice of the vendor's order, the first call numbers it after the vendor's last invoice.
+// The count and the invoice are written in one transaction so numbers have no gaps and an order is only
+// numbered once
+func (m MongoInvoiceRepository) IssueInvoice(ctx context.Context, vendorID, orderID bson.ObjectID, now time.Time) (*Invoice, error) {
+	ctx, span := Tracer.Start(ctx, "IssueInvoice")
+	defer span.End()
+
+	session, err := MongoClient.StartSession(sesOp)
+	if err != nil {
+		Logger.ErrorContext(ctx, "Unable to create a session", slog.Any("error", err), invoice_repo_source)
+		return nil, err
+	}
+	defer session.EndSession(ctx)
+
+	invoice, err := session.WithTransaction(ctx, func(sessCtx context.Context) (any, error) {
+		var existing Invoice
+		err := m.col.FindOne(sessCtx, bson.D{{Key: "vendor_id", Value: vendorID}, {Key: "order_id", Value: orderID}}).Decode(&existing)
+		if err == nil {
+			return &existing, nil
+		}
+		if !errors.Is(err, mongo.ErrNoDocuments) {
+			Logger.ErrorContext(sessCtx, "Error finding invoice", slog.Any("error", err), invoice_repo_source)
+			return nil, err
+		}
+
+		var counter struct {
+			Last int64 `bson:"last"`
+		}
+		opts := options.FindOneAndUpdate().SetUpsert(true).SetReturnDocument(options.After)
+		err = m.counters.FindOneAndUpdate(sessCtx, bson.D{{Key: "_id", Value: vendorID}},
+			bson.D{{Key: "$inc", Value: bson.M{"last": 1}}}, opts).Decode(&counter)
+		if err != nil {
+			Logger.ErrorContext(sessCtx, "Error counting invoices", slog.Any("error", err), invoice_repo_source)
+			return nil, err
+		}
+
+		invoice := &Invoice{ID: bson.NewObjectID(), VendorID: vendorID, OrderID: orderID, Number: counter.Last, IssuedAt: now}
+		if _, err := m.col.InsertOne(sessCtx, invoice); err != nil {
+			Logger.ErrorContext(sessCtx, "Error saving invoice", slog.Any("error", err), invoice_repo_source)
+			return nil, err
+		}
+		return invoice, nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	issued := invoice.(*Invoice)
+	if issued.IssuedAt.Equal(now) {
+		Logger.InfoContext(ctx, "Invoice issued", slog.String("vendorID", vendorID.Hex()), slog.String("orderID", orderID.Hex()),
+			slog.String("invoice", issued.code()), invoice_repo_source)
+	}
+	return issued, nil
+}
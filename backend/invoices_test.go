@@ -0,0 +1,104 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"go.mongodb.org/mongo-driver/v2/bson"
+)
+
+func TestDocumentFormat(t *testing.T) {
+	cases := []struct {
+		format, accept, want string
+	}{
+		{"", "", documentPDF},
+		{"", "text/html,application/xhtml+xml", documentHTML},
+		{"", "application/pdf", documentPDF},
+		{"HTML", "application/pdf", documentHTML},
+	}
+	for _, c := range cases {
+		if got, err := documentFormat(c.format, c.accept); err != nil || got != c.want {
+			t.Fatalf("%q %q: expected %s got %s %v", c.format, c.accept, c.want, got, err)
+		}
+	}
+	if _, err := documentFormat("docx", ""); err == nil {
+		t.Fatal("expected an unknown format to fail")
+	}
+}
+
+func TestTextPDF(t *testing.T) {
+	lines := make([]string, pdfLinesOnPage+5)
+	for i := range lines {
+		lines[i] = fmt.Sprintf("line (%d) café €", i)
+	}
+	pdf := textPDF(lines)
+	if !bytes.HasPrefix(pdf, []byte("%PDF-1.4\n")) || !bytes.HasSuffix(pdf, []byte("%%EOF\n")) {
+		t.Fatal("expected a PDF header and trailer")
+	}
+	if !bytes.Contains(pdf, []byte("/Count 2")) {
+		t.Fatal("expected the lines to need two pages")
+	}
+	if !bytes.Contains(pdf, []byte(`(line \(0\) caf\351 ?) Tj`)) {
+		t.Fatal("expected the text escaped for the PDF")
+	}
+
+	// every xref entry points at its object
+	start := bytes.LastIndex(pdf, []byte("startxref\n"))
+	xref, _ := strconv.Atoi(strings.Fields(string(pdf[start+len("startxref\n"):]))[0])
+	entries := regexp.MustCompile(`(\d{10}) 00000 n`).FindAllSubmatch(pdf[xref:], -1)
+	if len(entries) != 3+2*2 {
+		t.Fatalf("expected 7 objects got %d", len(entries))
+	}
+	for i, entry := range entries {
+		offset, _ := strconv.Atoi(string(entry[1]))
+		if !bytes.HasPrefix(pdf[offset:], []byte(fmt.Sprintf("%d 0 obj", i+1))) {
+			t.Fatalf("xref entry %d does not point at its object", i+1)
+		}
+	}
+}
+
+func TestOrderDocument(t *testing.T) {
+	store := &Store{ID: bson.NewObjectID(), Name: "Corner"}
+	seller := &Vendor{Common: Common{Name: "Fresh Foods", Address: "1 Main St"}, Stores: []*Store{store}}
+	milk := newTestItem("Milk", 1, "litre", 4)
+	milk.Quantity = 2
+	order := &Order{ID: bson.NewObjectID(), TotalPrice: 11, DeliveryFee: 3, Items: []*Item{milk}}
+
+	doc := newOrderDocument("Receipt", order.ID.Hex(), time.Now(), seller, order, "")
+	if doc.StoreName != "Corner" || doc.StoreAddress != "1 Main St" || doc.PaymentStatus != "unknown" {
+		t.Fatalf("unexpected seller %+v", doc)
+	}
+	totals := doc.Totals()
+	if totals.Subtotal != 8 || len(totals.Fees) != 1 || totals.Total != 11 {
+		t.Fatalf("expected the totals of an order without a breakdown got %+v", totals)
+	}
+	text := strings.Join(doc.text(), "\n")
+	for _, want := range []string{"RECEIPT", "Fresh Foods", "Milk", "Delivery fee", "11.00"} {
+		if !strings.Contains(text, want) {
+			t.Fatalf("expected %q in\n%s", want, text)
+		}
+	}
+
+	store.Address = "2 Side St"
+	milk.Name = "<b>Milk</b>"
+	milk.Pricing = &LinePricing{Amount: 8, Discount: 1, TaxRate: 10, Tax: 0.7, Total: 7.7}
+	order.Breakdown = &PriceBreakdown{Subtotal: 8, Discount: 1, Fees: []*Fee{}, Tax: 0.7, Total: 7.7}
+	doc = newOrderDocument("Invoice", "INV-000001", time.Now(), seller, order, "success")
+	var html bytes.Buffer
+	if err := doc.render(&html, documentHTML); err != nil {
+		t.Fatal(err)
+	}
+	for _, want := range []string{"Invoice INV-000001", "2 Side St", "&lt;b&gt;Milk&lt;/b&gt;", "0.70", "7.70"} {
+		if !strings.Contains(html.String(), want) {
+			t.Fatalf("expected %q in\n%s", want, html.String())
+		}
+	}
+	if code := (&Invoice{Number: 42}).code(); code != "INV-000042" {
+		t.Fatalf("unexpected invoice number %s", code)
+	}
+}
@@ -0,0 +1,547 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"mime/multipart"
+	"net/http"
+	"net/url"
+	"slices"
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	"go.mongodb.org/mongo-driver/v2/bson"
+	"go.mongodb.org/mongo-driver/v2/mongo"
+	"go.mongodb.org/mongo-driver/v2/mongo/options"
+)
+
+const (
+	issueOpen      = "open"
+	issueEscalated = "escalated"
+	issueResolved  = "resolved"
+
+	issueMissing   = "missing"
+	issueDamaged   = "damaged"
+	issueWrongItem = "wrong_item"
+	issueQuality   = "quality"
+
+	resolutionRefund      = "refund"
+	resolutionReplacement = "replacement"
+
+	alertOrderIssue    = "order_issue"
+	issueEventReported = "reported"
+	issueEventMessage  = "message"
+
+	// issueResponseWindow is how long the vendor has to resolve an issue before it goes to the admin queue
+	issueResponseWindow     = 48 * time.Hour
+	issueEscalationInterval = 15 * time.Minute
+
+	maxIssueText         = 2000
+	maxIssuePhotos       = 5
+	maxIssuePhotoSize    = 5 << 20
+	maxIssueUpload       = maxIssuePhotos*maxIssuePhotoSize + 1<<20
+	defaultIssuePageSize = 20
+	maxIssuePageSize     = 100
+)
+
+var (
+	issue_repo_source = slog.Any("source", "IssueRepository")
+
+	issueKinds      = []string{issueMissing, issueDamaged, issueWrongItem, issueQuality}
+	issuePhotoTypes = []string{"image/jpeg", "image/png", "image/webp"}
+
+	errIssueReported  = errors.New("a line of the order is already part of an issue")
+	errIssueResolved  = errors.New("the issue has already been resolved")
+	errIssueEscalated = errors.New("the issue has been escalated to an admin")
+	errIssuePhoto     = errors.New("photos must be JPEG, PNG or WebP images")
+)
+
+// issueEvent is the notification sent to the user and the vendor when an issue changes
+type issueEvent struct {
+	Event string      `json:"event"`
+	Issue *OrderIssue `json:"issue"`
+}
+
+// linePaid is what the user paid for an order line, orders placed before line pricing fall back to the price
+func linePaid(item *Item) float64 {
+	if item.Pricing != nil {
+		return item.Pricing.Total
+	}
+	return item.linePrice(item.Quantity)
+}
+
+// newIssueLines checks the reported lines against the order, fills in their names and what the affected units
+// cost, a line without a quantity is the whole line
+func newIssueLines(order *Order, lines []*IssueLine) error {
+	if len(lines) == 0 {
+		return errors.New("an issue needs at least one line of the order")
+	}
+	seen := make(map[bson.ObjectID]bool, len(lines))
+	for _, line := range lines {
+		if !slices.Contains(issueKinds, line.Kind) {
+			return fmt.Errorf("kind must be one of %s", strings.Join(issueKinds, ", "))
+		}
+		if seen[line.IngredientID] {
+			return errors.New("each line of the order can be reported once")
+		}
+		seen[line.IngredientID] = true
+
+		i := slices.IndexFunc(order.Items, func(item *Item) bool { return item.IngredientID == line.IngredientID })
+		if i < 0 {
+			return fmt.Errorf("ingredient %s is not on the order", line.IngredientID.Hex())
+		}
+		item := order.Items[i]
+		if line.Quantity == 0 {
+			line.Quantity = item.Quantity
+		}
+		if line.Quantity < 0 || line.Quantity > item.Quantity {
+			return fmt.Errorf("quantity of %s must be from 1 to %d", item.Name, item.Quantity)
+		}
+		line.Name, line.Amount = item.Name, 0
+		if item.Quantity > 0 {
+			line.Amount = roundCents(linePaid(item) * float64(line.Quantity) / float64(item.Quantity))
+		}
+	}
+	return nil
+}
+
+// refundable is the most a refund of the issue can give back
+func (i *OrderIssue) refundable() float64 {
+	total := 0.0
+	for _, line := range i.Lines {
+		total += line.Amount
+	}
+	return roundCents(total)
+}
+
+// photo finds a photo attached to any message of the issue
+func (i *OrderIssue) photo(photoID bson.ObjectID) *IssuePhoto {
+	for _, message := range i.Messages {
+		for _, photo := range message.Photos {
+			if photo.ID == photoID {
+				return photo
+			}
+		}
+	}
+	return nil
+}
+
+// issueText trims a message, it may only be empty when photos say what the problem is
+func issueText(text string, photos int) (string, error) {
+	text = strings.TrimSpace(text)
+	if text == "" && photos == 0 {
+		return "", errors.New("a message needs text or photos")
+	}
+	if utf8.RuneCountInString(text) > maxIssueText {
+		return "", fmt.Errorf("a message can be at most %d characters", maxIssueText)
+	}
+	return text, nil
+}
+
+// resolution checks the request against the issue, a refund gives back part or all of what the lines cost
+func (req *IssueResolveReq) resolution(issue *OrderIssue, by string, now time.Time) (*IssueResolution, error) {
+	resolution := &IssueResolution{Kind: req.Kind, By: by, At: now}
+	switch req.Kind {
+	case resolutionRefund:
+		refundable := issue.refundable()
+		if req.Amount <= 0 || roundCents(req.Amount) > refundable {
+			return nil, fmt.Errorf("a refund must be more than 0 and at most %.2f", refundable)
+		}
+		resolution.Amount = roundCents(req.Amount)
+	case resolutionReplacement:
+		if req.Amount != 0 {
+			return nil, errors.New("a replacement has no amount")
+		}
+	default:
+		return nil, fmt.Errorf("kind must be %s or %s", resolutionRefund, resolutionReplacement)
+	}
+	return resolution, nil
+}
+
+// refundEntries take a refund off what the vendor is owed for the order, the platform gives back the commission
+// on the refunded share of the sale. Nothing is recorded for an order that never accrued
+func refundEntries(issue *OrderIssue, entries []*SettlementEntry, amount float64, now time.Time) []*SettlementEntry {
+	sale, owed, charged, held := 0.0, 0.0, 0.0, 0.0
+	for _, entry := range entries {
+		switch entry.Kind {
+		case settlementSale:
+			sale += entry.Amount
+			owed += entry.Amount
+		case settlementRefund:
+			owed += entry.Amount
+		case settlementCommission:
+			held -= entry.Amount
+			if entry.Amount < 0 {
+				charged -= entry.Amount
+			}
+		}
+	}
+	amount = roundCents(min(amount, owed))
+	if sale <= 0 || amount <= 0 {
+		return nil
+	}
+	entry := func(kind string, amount float64) *SettlementEntry {
+		return &SettlementEntry{ID: bson.NewObjectID(), VendorID: issue.VendorID, StoreID: issue.StoreID,
+			OrderID: issue.OrderID, Kind: kind, Amount: amount, At: now}
+	}
+	refund := []*SettlementEntry{entry(settlementRefund, -amount)}
+	if back := roundCents(min(held, charged*amount/sale)); back > 0 {
+		refund = append(refund, entry(settlementCommission, back))
+	}
+	return refund
+}
+
+// parseIssueQuery reads status, page and page_size
+func parseIssueQuery(q url.Values) (*IssueQuery, error) {
+	query := &IssueQuery{Status: q.Get("status"), Page: 1, PageSize: defaultIssuePageSize}
+	switch query.Status {
+	case "", issueOpen, issueEscalated, issueResolved:
+	default:
+		return nil, fmt.Errorf("status must be %s, %s or %s", issueOpen, issueEscalated, issueResolved)
+	}
+	if err := parsePaging(q, &query.Page, &query.PageSize, maxIssuePageSize); err != nil {
+		return nil, err
+	}
+	return query, nil
+}
+
+// filter limits users and vendors to their own issues, admins reach every issue
+func (p *IssueParty) filter() bson.D {
+	switch p.Role {
+	case "user":
+		return bson.D{{Key: "user_id", Value: p.ID}}
+	case "vendor":
+		return bson.D{{Key: "vendor_id", Value: p.ID}}
+	}
+	return bson.D{}
+}
+
+// resolvable is the statuses the party may resolve an issue from, once escalated only an admin can
+func (p *IssueParty) resolvable() []string {
+	switch p.Role {
+	case "vendor":
+		return []string{issueOpen}
+	case "admin":
+		return []string{issueOpen, issueEscalated}
+	}
+	return nil
+}
+
+func checkIssuePhotos(files []*multipart.FileHeader) error {
+	if len(files) > maxIssuePhotos {
+		return fmt.Errorf("a message can have at most %d photos", maxIssuePhotos)
+	}
+	for _, file := range files {
+		if file.Size > maxIssuePhotoSize {
+			return fmt.Errorf("a photo can be at most %d MB", maxIssuePhotoSize>>20)
+		}
+	}
+	return nil
+}
+
+// storeIssuePhotos puts the uploaded photos in the blob store under the issue, the content is sniffed so only
+// images are kept whatever the client claimed. Photos already stored are removed when one fails
+func storeIssuePhotos(ctx context.Context, issueID bson.ObjectID, files []*multipart.FileHeader) ([]*IssuePhoto, error) {
+	photos := make([]*IssuePhoto, 0, len(files))
+	for _, file := range files {
+		photo, err := storeIssuePhoto(ctx, issueID, file)
+		if err != nil {
+			deleteIssuePhotos(ctx, photos)
+			return nil, err
+		}
+		photos = append(photos, photo)
+	}
+	return photos, nil
+}
+
+func storeIssuePhoto(ctx context.Context, issueID bson.ObjectID, file *multipart.FileHeader) (*IssuePhoto, error) {
+	f, err := file.Open()
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	head := make([]byte, 512)
+	n, err := io.ReadFull(f, head)
+	if err != nil && !errors.Is(err, io.ErrUnexpectedEOF) {
+		return nil, errIssuePhoto
+	}
+	contentType := http.DetectContentType(head[:n])
+	if !slices.Contains(issuePhotoTypes, contentType) {
+		return nil, errIssuePhoto
+	}
+	if _, err := f.Seek(0, io.SeekStart); err != nil {
+		return nil, err
+	}
+
+	photo := &IssuePhoto{ID: bson.NewObjectID(), ContentType: contentType}
+	photo.Key = fmt.Sprintf("issues/%s/%s", issueID.Hex(), photo.ID.Hex())
+	if photo.Size, err = Blobs.Put(ctx, photo.Key, io.LimitReader(f, maxIssuePhotoSize)); err != nil {
+		return nil, err
+	}
+	return photo, nil
+}
+
+// deleteIssuePhotos removes photos whose message was never saved, a failure only leaves an unused blob
+func deleteIssuePhotos(ctx context.Context, photos []*IssuePhoto) {
+	for _, photo := range photos {
+		if err := Blobs.Delete(ctx, photo.Key); err != nil {
+			Logger.ErrorContext(ctx, "Unable to delete an unused photo", slog.String("key", photo.Key),
+				slog.Any("error", err), issue_repo_source)
+		}
+	}
+}
+
+// notifyIssue tells the user and the vendor about the event, except the one who caused it
+func notifyIssue(ctx context.Context, issue *OrderIssue, event, from string) {
+	for role, owner := range map[string]bson.ObjectID{"user": issue.UserID, "vendor": issue.VendorID} {
+		if role == from {
+			continue
+		}
+		if _, err := notify(ctx, ID{owner}, alertOrderIssue, &issueEvent{Event: event, Issue: issue}); err != nil {
+			Logger.ErrorContext(ctx, "Unable to notify about the issue", slog.String("issueID", issue.ID.Hex()),
+				slog.String("role", role), slog.Any("error", err), issue_repo_source)
+		}
+	}
+}
+
+// escalateIssues is the escalation job, issues the vendor let run past their deadline go to the admin queue
+func escalateIssues(ctx context.Context) error {
+	escalated := 0
+	for {
+		issue, err := Repos.Issue.EscalateIssue(ctx, time.Now())
+		if errors.Is(err, &NoItems{}) {
+			break
+		}
+		if err != nil {
+			return err
+		}
+		escalated++
+		notifyIssue(ctx, issue, issueEscalated, "")
+	}
+	if escalated > 0 {
+		Logger.InfoContext(ctx, "Order issues escalated", slog.Int("issues", escalated), issue_repo_source)
+	}
+	return nil
+}
+
+// IssueRepository keeps the issues users report with their orders and the conversation about each one
+type IssueRepository interface {
+	CreateIssue(context.Context, *OrderIssue) error
+	FindIssue(context.Context, *IssueParty, bson.ObjectID) (*OrderIssue, error)
+	FindIssues(context.Context, *IssueQuery) ([]*OrderIssue, error)
+	AddIssueMessage(context.Context, *IssueParty, bson.ObjectID, *IssueMessage) (*OrderIssue, error)
+	ResolveIssue(context.Context, *IssueParty, bson.ObjectID, *IssueResolution, *IssueMessage) (*OrderIssue, error)
+	EscalateIssue(context.Context, time.Time) (*OrderIssue, error)
+}
+
+type MongoIssueRepository struct {
+	col         *mongo.Collection
+	settlements *mongo.Collection
+}
+
+func newMongoIssueRepository(client *mongo.Client, dbName string) IssueRepository {
+	db := client.Database(dbName)
+	return &MongoIssueRepository{col: db.Collection("issues"), settlements: db.Collection("settlements")}
+}
+
+// CreateIssue saves a new open issue, errIssueReported when one of its lines is already part of another issue
+func (m MongoIssueRepository) CreateIssue(ctx context.Context, issue *OrderIssue) error {
+	ctx, span := Tracer.Start(ctx, "CreateIssue")
+	defer span.End()
+
+	if issue.ID.IsZero() {
+		issue.ID = bson.NewObjectID()
+	}
+	issue.Status, issue.Resolution, issue.EscalatedAt = issueOpen, nil, nil
+	issue.CreatedAt = time.Now()
+	issue.UpdatedAt, issue.RespondBy = issue.CreatedAt, issue.CreatedAt.Add(issueResponseWindow)
+	if issue.Messages == nil {
+		issue.Messages = []*IssueMessage{}
+	}
+	if _, err := m.col.InsertOne(ctx, issue); err != nil {
+		if mongo.IsDuplicateKeyError(err) {
+			return errIssueReported
+		}
+		Logger.ErrorContext(ctx, "Error creating issue", slog.Any("error", err), issue_repo_source)
+		return err
+	}
+	Logger.InfoContext(ctx, "Order issue reported", slog.String("issueID", issue.ID.Hex()),
+		slog.String("orderID", issue.OrderID.Hex()), slog.Int("lines", len(issue.Lines)), issue_repo_source)
+	return nil
+}
+
+func (m MongoIssueRepository) FindIssue(ctx context.Context, party *IssueParty, issueID bson.ObjectID) (*OrderIssue, error) {
+	ctx, span := Tracer.Start(ctx, "FindIssue")
+	defer span.End()
+
+	var issue OrderIssue
+	filter := append(bson.D{{Key: "_id", Value: issueID}}, party.filter()...)
+	if err := m.col.FindOne(ctx, filter).Decode(&issue); err != nil {
+		if errors.Is(err, mongo.ErrNoDocuments) {
+			return nil, &NoItems{}
+		}
+		Logger.ErrorContext(ctx, "Error finding issue", slog.Any("error", err), issue_repo_source)
+		return nil, err
+	}
+	return &issue, nil
+}
+
+// FindIssues lists the issues the query matches newest first, admins work their queue oldest first
+func (m MongoIssueRepository) FindIssues(ctx context.Context, query *IssueQuery) ([]*OrderIssue, error) {
+	ctx, span := Tracer.Start(ctx, "FindIssues")
+	defer span.End()
+
+	filter := query.Party.filter()
+	if !query.OrderID.IsZero() {
+		filter = append(filter, bson.E{Key: "order_id", Value: query.OrderID})
+	}
+	if query.Status != "" {
+		filter = append(filter, bson.E{Key: "status", Value: query.Status})
+	}
+	order := -1
+	if query.Party.Role == "admin" {
+		order = 1
+	}
+	opts := options.Find().
+		SetSort(bson.D{{Key: "created_at", Value: order}}).
+		SetSkip(int64((query.Page - 1) * query.PageSize)).
+		SetLimit(int64(query.PageSize))
+	cursor, err := m.col.Find(ctx, filter, opts)
+	if err != nil {
+		Logger.ErrorContext(ctx, "Error finding issues", slog.Any("error", err), issue_repo_source)
+		return nil, err
+	}
+	issues := []*OrderIssue{}
+	if err := cursor.All(ctx, &issues); err != nil {
+		Logger.ErrorContext(ctx, "Error decoding issues", slog.Any("error", err), issue_repo_source)
+		return nil, err
+	}
+	return issues, nil
+}
+
+// AddIssueMessage adds the message to the conversation, the conversation stays open after the issue is resolved
+func (m MongoIssueRepository) AddIssueMessage(ctx context.Context, party *IssueParty, issueID bson.ObjectID, message *IssueMessage) (*OrderIssue, error) {
+	ctx, span := Tracer.Start(ctx, "AddIssueMessage")
+	defer span.End()
+
+	message.ID, message.Author, message.AuthorID, message.At = bson.NewObjectID(), party.Role, party.ID, time.Now()
+	filter := append(bson.D{{Key: "_id", Value: issueID}}, party.filter()...)
+	update := bson.D{
+		{Key: "$push", Value: bson.M{"messages": message}},
+		{Key: "$set", Value: bson.M{"updated_at": message.At}},
+	}
+	var issue OrderIssue
+	opts := options.FindOneAndUpdate().SetReturnDocument(options.After)
+	if err := m.col.FindOneAndUpdate(ctx, filter, update, opts).Decode(&issue); err != nil {
+		if errors.Is(err, mongo.ErrNoDocuments) {
+			return nil, &NoItems{}
+		}
+		Logger.ErrorContext(ctx, "Error adding issue message", slog.Any("error", err), issue_repo_source)
+		return nil, err
+	}
+	return &issue, nil
+}
+
+// ResolveIssue settles the issue with the message added to the conversation, a refund is taken off what the
+// vendor is owed for the order in the same transaction. It is errIssueResolved or errIssueEscalated when the
+// party can no longer resolve the issue
+func (m MongoIssueRepository) ResolveIssue(ctx context.Context, party *IssueParty, issueID bson.ObjectID, resolution *IssueResolution, message *IssueMessage) (*OrderIssue, error) {
+	ctx, span := Tracer.Start(ctx, "ResolveIssue")
+	defer span.End()
+
+	session, err := MongoClient.StartSession(sesOp)
+	if err != nil {
+		Logger.ErrorContext(ctx, "Unable to create a session", slog.Any("error", err), issue_repo_source)
+		return nil, err
+	}
+	defer session.EndSession(ctx)
+
+	set := bson.M{"status": issueResolved, "resolution": resolution, "updated_at": resolution.At}
+	update := bson.D{{Key: "$set", Value: set}}
+	if message != nil {
+		message.ID, message.Author, message.AuthorID, message.At = bson.NewObjectID(), party.Role, party.ID, resolution.At
+		update = append(update, bson.E{Key: "$push", Value: bson.M{"messages": message}})
+	}
+	owned := append(bson.D{{Key: "_id", Value: issueID}}, party.filter()...)
+	filter := append(slices.Clone(owned), bson.E{Key: "status", Value: bson.D{{Key: "$in", Value: party.resolvable()}}})
+
+	result, err := session.WithTransaction(ctx, func(sessCtx context.Context) (any, error) {
+		var issue OrderIssue
+		opts := options.FindOneAndUpdate().SetReturnDocument(options.After)
+		if err := m.col.FindOneAndUpdate(sessCtx, filter, update, opts).Decode(&issue); err != nil {
+			if errors.Is(err, mongo.ErrNoDocuments) {
+				return nil, m.unresolvable(sessCtx, owned)
+			}
+			return nil, err
+		}
+		if resolution.Kind != resolutionRefund {
+			return &issue, nil
+		}
+
+		cursor, err := m.settlements.Find(sessCtx, bson.D{{Key: "vendor_id", Value: issue.VendorID}, {Key: "order_id", Value: issue.OrderID}})
+		if err != nil {
+			return nil, err
+		}
+		var accrued []*SettlementEntry
+		if err := cursor.All(sessCtx, &accrued); err != nil {
+			return nil, err
+		}
+		if entries := refundEntries(&issue, accrued, resolution.Amount, resolution.At); len(entries) > 0 {
+			if _, err := m.settlements.InsertMany(sessCtx, entries); err != nil {
+				return nil, err
+			}
+		}
+		return &issue, nil
+	})
+	if err != nil {
+		if !errors.Is(err, &NoItems{}) && !errors.Is(err, errIssueResolved) && !errors.Is(err, errIssueEscalated) {
+			Logger.ErrorContext(ctx, "Error resolving issue", slog.Any("error", err), issue_repo_source)
+		}
+		return nil, err
+	}
+	issue := result.(*OrderIssue)
+	Logger.InfoContext(ctx, "Order issue resolved", slog.String("issueID", issueID.Hex()), slog.String("kind", resolution.Kind),
+		slog.Float64("amount", resolution.Amount), slog.String("by", party.Role), issue_repo_source)
+	return issue, nil
+}
+
+// unresolvable is why an issue the party reaches could not be resolved
+func (m MongoIssueRepository) unresolvable(ctx context.Context, owned bson.D) error {
+	var issue struct {
+		Status string `bson:"status"`
+	}
+	if err := m.col.FindOne(ctx, owned).Decode(&issue); err != nil {
+		if errors.Is(err, mongo.ErrNoDocuments) {
+			return &NoItems{}
+		}
+		return err
+	}
+	if issue.Status == issueEscalated {
+		return errIssueEscalated
+	}
+	return errIssueResolved
+}
+
+// EscalateIssue moves the open issue longest past its deadline to the admin queue, NoItems when none is left
+func (m MongoIssueRepository) EscalateIssue(ctx context.Context, now time.Time) (*OrderIssue, error) {
+	ctx, span := Tracer.Start(ctx, "EscalateIssue")
+	defer span.End()
+
+	filter := bson.D{{Key: "status", Value: issueOpen}, {Key: "respond_by", Value: bson.D{{Key: "$lte", Value: now}}}}
+	update := bson.D{{Key: "$set", Value: bson.M{"status": issueEscalated, "escalated_at": now, "updated_at": now}}}
+	opts := options.FindOneAndUpdate().SetSort(bson.D{{Key: "respond_by", Value: 1}}).SetReturnDocument(options.After)
+	var issue OrderIssue
+	if err := m.col.FindOneAndUpdate(ctx, filter, update, opts).Decode(&issue); err != nil {
+		if errors.Is(err, mongo.ErrNoDocuments) {
+			return nil, &NoItems{}
+		}
+		Logger.ErrorContext(ctx, "Error escalating issue", slog.Any("error", err), issue_repo_source)
+		return nil, err
+	}
+	return &issue, nil
+}
@@ -0,0 +1,127 @@
+package main
+
+import (
+	"testing"
+	"time"
+
+	"go.mongodb.org/mongo-driver/v2/bson"
+)
+
+func TestNewIssueLines(t *testing.T) {
+	milk, eggs := newTestItem("Milk", 1, "litre", 4), newTestItem("Eggs", 12, "piece", 3)
+	milk.Quantity, eggs.Quantity = 2, 4
+	eggs.Pricing = &LinePricing{Amount: 12, Discount: 2, Tax: 1, Total: 11}
+	order := &Order{Items: []*Item{milk, eggs}}
+
+	lines := []*IssueLine{
+		{IngredientID: milk.IngredientID, Kind: issueMissing},
+		{IngredientID: eggs.IngredientID, Kind: issueDamaged, Quantity: 1},
+	}
+	if err := newIssueLines(order, lines); err != nil {
+		t.Fatal(err)
+	}
+	if lines[0].Name != "Milk" || lines[0].Quantity != 2 || lines[0].Amount != 8 {
+		t.Fatalf("expected the whole milk line got %+v", lines[0])
+	}
+	if lines[1].Amount != 2.75 {
+		t.Fatalf("expected a quarter of what was paid for the eggs got %v", lines[1].Amount)
+	}
+	if refundable := (&OrderIssue{Lines: lines}).refundable(); refundable != 10.75 {
+		t.Fatalf("expected 10.75 refundable got %v", refundable)
+	}
+
+	bad := [][]*IssueLine{
+		nil,
+		{{IngredientID: milk.IngredientID, Kind: "late"}},
+		{{IngredientID: bson.NewObjectID(), Kind: issueMissing}},
+		{{IngredientID: milk.IngredientID, Kind: issueMissing, Quantity: 3}},
+		{{IngredientID: milk.IngredientID, Kind: issueMissing}, {IngredientID: milk.IngredientID, Kind: issueDamaged}},
+	}
+	for i, lines := range bad {
+		if err := newIssueLines(order, lines); err == nil {
+			t.Fatalf("case %d: expected the lines to be rejected", i)
+		}
+	}
+}
+
+func TestIssueResolution(t *testing.T) {
+	issue := &OrderIssue{Lines: []*IssueLine{{Amount: 8}, {Amount: 2.75}}}
+	now := time.Now()
+
+	resolution, err := (&IssueResolveReq{Kind: resolutionRefund, Amount: 5.004}).resolution(issue, "vendor", now)
+	if err != nil || resolution.Amount != 5 || resolution.By != "vendor" {
+		t.Fatalf("expected a partial refund got %+v %v", resolution, err)
+	}
+	if _, err := (&IssueResolveReq{Kind: resolutionReplacement}).resolution(issue, "admin", now); err != nil {
+		t.Fatal(err)
+	}
+	for _, req := range []*IssueResolveReq{
+		{Kind: resolutionRefund, Amount: 10.76},
+		{Kind: resolutionRefund},
+		{Kind: resolutionReplacement, Amount: 1},
+		{Kind: "voucher"},
+	} {
+		if _, err := req.resolution(issue, "vendor", now); err == nil {
+			t.Fatalf("expected %+v to be rejected", req)
+		}
+	}
+}
+
+func TestRefundEntries(t *testing.T) {
+	now := time.Now()
+	vendorID := bson.NewObjectID()
+	order := &Order{ID: bson.NewObjectID(), StoreID: bson.NewObjectID(), Subtotal: 100, TotalPrice: 100}
+	issue := &OrderIssue{VendorID: vendorID, StoreID: order.StoreID, OrderID: order.ID}
+	accrued := accrualEntries(vendorID, order, 10, now)
+
+	refund := refundEntries(issue, accrued, 25, now)
+	if len(refund) != 2 || refund[0].Kind != settlementRefund || refund[0].Amount != -25 ||
+		refund[1].Kind != settlementCommission || refund[1].Amount != 2.5 {
+		t.Fatalf("expected the refund and a quarter of the commission back got %+v", refund)
+	}
+
+	// the order later leaving delivered reverses only what is left
+	accrued = append(accrued, refund...)
+	total := 0.0
+	for _, entry := range append(accrued, reversalEntries(order, accrued, now)...) {
+		total += entry.Amount
+	}
+	if roundCents(total) != 0 {
+		t.Fatalf("expected the order to net to zero got %v", total)
+	}
+
+	if refund := refundEntries(issue, accrued[:4], 500, now); len(refund) != 2 || refund[0].Amount != -75 || refund[1].Amount != 7.5 {
+		t.Fatalf("expected the refund capped at what is still owed got %+v", refund)
+	}
+	if refund := refundEntries(issue, nil, 10, now); refund != nil {
+		t.Fatal("expected nothing for an order that never accrued")
+	}
+}
+
+func TestIssueText(t *testing.T) {
+	if text, err := issueText("  box was crushed ", 0); err != nil || text != "box was crushed" {
+		t.Fatalf("unexpected %q %v", text, err)
+	}
+	if _, err := issueText(" ", 1); err != nil {
+		t.Fatal("expected photos alone to be a message")
+	}
+	if _, err := issueText(" ", 0); err == nil {
+		t.Fatal("expected an empty message to be rejected")
+	}
+}
+
+func TestIssueParty(t *testing.T) {
+	id := bson.NewObjectID()
+	if filter := (&IssueParty{Role: "vendor", ID: id}).filter(); len(filter) != 1 || filter[0].Key != "vendor_id" {
+		t.Fatalf("expected vendors limited to their issues got %v", filter)
+	}
+	if filter := (&IssueParty{Role: "admin", ID: id}).filter(); len(filter) != 0 {
+		t.Fatalf("expected admins to reach every issue got %v", filter)
+	}
+	if statuses := (&IssueParty{Role: "vendor"}).resolvable(); len(statuses) != 1 || statuses[0] != issueOpen {
+		t.Fatalf("expected vendors to resolve open issues only got %v", statuses)
+	}
+	if statuses := (&IssueParty{Role: "user"}).resolvable(); statuses != nil {
+		t.Fatalf("expected users to resolve nothing got %v", statuses)
+	}
+}
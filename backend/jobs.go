@@ -0,0 +1,67 @@
+package main
+
+import (
+	"context"
+	"log/slog"
+	"sync"
+	"time"
+)
+
+var jobs_source = slog.Any("source", "jobs")
+
+// job is work the server repeats in the background, every instance of the server runs the schedule but a redis
+// lock lets only one of them run each tick
+type job struct {
+	name  string
+	every time.Duration
+	run   func(context.Context) error
+}
+
+// startJobs runs every job on its own ticker until ctx is done, the returned func waits for running jobs to
+// finish so the databases are not closed under them
+func startJobs(ctx context.Context, jobs ...job) (wait func()) {
+	var wg sync.WaitGroup
+	for _, j := range jobs {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			ticker := time.NewTicker(j.every)
+			defer ticker.Stop()
+
+			Logger.InfoContext(ctx, "Background job scheduled", slog.String("job", j.name),
+				slog.Duration("every", j.every), jobs_source)
+			for {
+				select {
+				case <-ctx.Done():
+					return
+				case <-ticker.C:
+					j.tick(ctx)
+				}
+			}
+		}()
+	}
+	return wg.Wait
+}
+
+func (j job) tick(ctx context.Context) {
+	ctx, span := Tracer.Start(ctx, "job "+j.name)
+	defer span.End()
+
+	// the lock expires a little before the next tick so a crashed instance never blocks the job for long
+	acquired, err := RedisClient.SetNX(ctx, "job:"+j.name, time.Now().Unix(), j.every*9/10).Result()
+	if err != nil {
+		Logger.ErrorContext(ctx, "Unable to take the job lock", slog.String("job", j.name), slog.Any("error", err), jobs_source)
+		return
+	}
+	if !acquired {
+		return
+	}
+
+	start := time.Now()
+	if err := j.run(ctx); err != nil {
+		Logger.ErrorContext(ctx, "Background job failed", slog.String("job", j.name), slog.Any("error", err), jobs_source)
+		return
+	}
+	Logger.InfoContext(ctx, "Background job finished", slog.String("job", j.name),
+		slog.Duration("took", time.Since(start)), jobs_source)
+}
@@ -0,0 +1,446 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/url"
+	"slices"
+	"strings"
+	"time"
+
+	"go.mongodb.org/mongo-driver/v2/bson"
+	"go.mongodb.org/mongo-driver/v2/mongo"
+	"go.mongodb.org/mongo-driver/v2/mongo/options"
+)
+
+const (
+	ledgerOrder   = "order"
+	ledgerAdjust  = "adjust"
+	ledgerImport  = "import"
+	ledgerReturn  = "return"
+	ledgerOpening = "opening"
+
+	defaultLedgerPageSize = 50
+	maxLedgerPageSize     = 500
+)
+
+var (
+	ledger_repo_source = slog.Any("source", "LedgerRepository")
+
+	ledgerReasons = []string{ledgerOrder, ledgerAdjust, ledgerImport, ledgerReturn, ledgerOpening}
+)
+
+type stockKey struct{ store, item bson.ObjectID }
+
+type stockCount struct {
+	name     string
+	quantity int
+	price    float64
+	catalog  bson.ObjectID
+}
+
+// stockSnapshot is the quantity and price of every item in a vendor's stores
+type stockSnapshot map[stockKey]stockCount
+
+func newStockSnapshot(stores []*Store) stockSnapshot {
+	snapshot := stockSnapshot{}
+	for _, store := range stores {
+		for _, item := range store.Items {
+			// items saved before they got ids can not be told apart
+			if item.IngredientID == bson.NilObjectID {
+				continue
+			}
+			snapshot[stockKey{store.ID, item.IngredientID}] = stockCount{item.Name, item.Quantity, item.Price, item.CatalogID}
+		}
+	}
+	return snapshot
+}
+
+// ledgerMovements is an entry for every item whose quantity differs between the snapshots, an item that is gone
+// moved all of its quantity out and a new item moved all of it in
+func ledgerMovements(before, after stockSnapshot) []*LedgerEntry {
+	var entries []*LedgerEntry
+	add := func(k stockKey, name string, delta int) {
+		if delta != 0 {
+			entries = append(entries, &LedgerEntry{StoreID: k.store, ItemID: k.item, Name: name, Delta: delta})
+		}
+	}
+	for k, count := range after {
+		add(k, count.name, count.quantity-before[k].quantity)
+	}
+	for k, count := range before {
+		if _, ok := after[k]; !ok {
+			add(k, count.name, -count.quantity)
+		}
+	}
+	slices.SortFunc(entries, func(a, b *LedgerEntry) int {
+		if c := bytes.Compare(a.StoreID[:], b.StoreID[:]); c != 0 {
+			return c
+		}
+		return bytes.Compare(a.ItemID[:], b.ItemID[:])
+	})
+	return entries
+}
+
+// ledgerActor is who made the request behind ctx, work the server does on its own is recorded as the system
+func ledgerActor(ctx context.Context) (bson.ObjectID, string) {
+	role, _ := ctx.Value(userRoleKey).(string)
+	hex, _ := ctx.Value(userIDKey).(string)
+	id, err := bson.ObjectIDFromHex(hex)
+	if err != nil || role == "" {
+		return bson.NilObjectID, "system"
+	}
+	return id, role
+}
+
+func (m MongoVendorRepository) snapshotStock(ctx context.Context, vendorID ID) (stockSnapshot, error) {
+	var vendor struct {
+		Stores []*Store `bson:"stores"`
+	}
+	projection := bson.D{
+		{Key: "stores._id", Value: 1},
+		{Key: "stores.items.ingredient_id", Value: 1},
+		{Key: "stores.items.name", Value: 1},
+		{Key: "stores.items.quantity", Value: 1},
+		{Key: "stores.items.price", Value: 1},
+		{Key: "stores.items.catalog_id", Value: 1},
+	}
+	err := m.col.FindOne(ctx, bson.D{{Key: "_id", Value: vendorID.value}}, options.FindOne().SetProjection(projection)).
+		Decode(&vendor)
+	if err != nil && !errors.Is(err, mongo.ErrNoDocuments) {
+		Logger.ErrorContext(ctx, "Error reading store stock", slog.String("vendorID", vendorID.String()),
+			slog.Any("error", err), vendor_repo_source)
+		return nil, err
+	}
+	return newStockSnapshot(vendor.Stores), nil
+}
+
+// recordLedger appends what changed in the vendor's stores since before to the ledger and the new prices to the
+// price history, it runs inside the transaction that made the change so a change is never saved without them
+func (m MongoVendorRepository) recordLedger(sessCtx context.Context, vendorID ID, before stockSnapshot, reason string,
+	ref bson.ObjectID) error {
+	after, err := m.snapshotStock(sessCtx, vendorID)
+	if err != nil {
+		return err
+	}
+	now := time.Now()
+	if err := m.recordPrices(sessCtx, vendorID, priceChanges(before, after), reason, now); err != nil {
+		return err
+	}
+	entries := ledgerMovements(before, after)
+	if len(entries) == 0 {
+		return nil
+	}
+
+	actor, role := ledgerActor(sessCtx)
+	docs := make([]any, len(entries))
+	for i, entry := range entries {
+		entry.ID, entry.VendorID, entry.Reason, entry.RefID = bson.NewObjectID(), vendorID.value, reason, ref
+		entry.ActorID, entry.ActorRole, entry.At = actor, role, now
+		docs[i] = entry
+	}
+	if _, err := m.ledger.InsertMany(sessCtx, docs); err != nil {
+		Logger.ErrorContext(sessCtx, "Error recording stock movements", slog.String("vendorID", vendorID.String()),
+			slog.Any("error", err), vendor_repo_source)
+		return err
+	}
+	Logger.InfoContext(sessCtx, "Stock movements recorded", slog.String("vendorID", vendorID.String()),
+		slog.String("reason", reason), slog.Int("entries", len(entries)), vendor_repo_source)
+	return nil
+}
+
+// withLedger runs write in a transaction and records the stock it moved under reason
+func (m MongoVendorRepository) withLedger(ctx context.Context, vendorID ID, reason string,
+	write func(context.Context) error) error {
+	session, err := MongoClient.StartSession(sesOp)
+	if err != nil {
+		Logger.ErrorContext(ctx, "Unable to create a session", slog.Any("error", err), vendor_repo_source)
+		return err
+	}
+	defer session.EndSession(ctx)
+
+	_, err = session.WithTransaction(ctx, func(sessCtx context.Context) (any, error) {
+		before, err := m.snapshotStock(sessCtx, vendorID)
+		if err != nil {
+			return nil, err
+		}
+		if err := write(sessCtx); err != nil {
+			return nil, err
+		}
+		return nil, m.recordLedger(sessCtx, vendorID, before, reason, bson.NilObjectID)
+	})
+	return err
+}
+
+func parseLedgerQuery(q url.Values) (*LedgerQuery, error) {
+	query := &LedgerQuery{Reason: q.Get("reason"), Page: 1, PageSize: defaultLedgerPageSize}
+
+	if item := q.Get("item"); item != "" {
+		id, err := bson.ObjectIDFromHex(item)
+		if err != nil {
+			return nil, errors.New("item must be a valid id")
+		}
+		query.ItemID = id
+	}
+	if query.Reason != "" && !slices.Contains(ledgerReasons, query.Reason) {
+		return nil, fmt.Errorf("reason must be one of %s", strings.Join(ledgerReasons, ", "))
+	}
+	var err error
+	if query.From, query.To, err = parseTimeRange(q); err != nil {
+		return nil, err
+	}
+	if err := parsePaging(q, &query.Page, &query.PageSize, maxLedgerPageSize); err != nil {
+		return nil, err
+	}
+	return query, nil
+}
+
+func (query *LedgerQuery) filter(vendorID, storeID ID) bson.D {
+	filter := bson.D{{Key: "vendor_id", Value: vendorID.value}, {Key: "store_id", Value: storeID.value}}
+	if query.ItemID != bson.NilObjectID {
+		filter = append(filter, bson.E{Key: "item_id", Value: query.ItemID})
+	}
+	if query.Reason != "" {
+		filter = append(filter, bson.E{Key: "reason", Value: query.Reason})
+	}
+	at := bson.D{}
+	if !query.From.IsZero() {
+		at = append(at, bson.E{Key: "$gte", Value: query.From})
+	}
+	if !query.To.IsZero() {
+		at = append(at, bson.E{Key: "$lt", Value: query.To})
+	}
+	if len(at) > 0 {
+		filter = append(filter, bson.E{Key: "at", Value: at})
+	}
+	return filter
+}
+
+// ledgerTotal is the sum of the ledger entries of an item
+type ledgerTotal struct {
+	ItemID bson.ObjectID `bson:"_id"`
+	Name   string        `bson:"name"`
+	Total  int           `bson:"total"`
+}
+
+// ledgerMismatches are the items whose quantity is not the sum of their entries, an item removed from the store
+// has a quantity of zero
+func ledgerMismatches(items []*Item, totals []*ledgerTotal) []*LedgerMismatch {
+	byItem := make(map[bson.ObjectID]*ledgerTotal, len(totals))
+	for _, total := range totals {
+		byItem[total.ItemID] = total
+	}
+
+	mismatches := []*LedgerMismatch{}
+	seen := make(map[bson.ObjectID]bool, len(items))
+	for _, item := range items {
+		if item.IngredientID == bson.NilObjectID {
+			continue
+		}
+		seen[item.IngredientID] = true
+		var sum int
+		if total, ok := byItem[item.IngredientID]; ok {
+			sum = total.Total
+		}
+		if sum != item.Quantity {
+			mismatches = append(mismatches, &LedgerMismatch{
+				ItemID: item.IngredientID, Name: item.Name, Quantity: item.Quantity, LedgerTotal: sum,
+			})
+		}
+	}
+	for _, total := range totals {
+		if !seen[total.ItemID] && total.Total != 0 {
+			mismatches = append(mismatches, &LedgerMismatch{ItemID: total.ItemID, Name: total.Name, LedgerTotal: total.Total})
+		}
+	}
+	return mismatches
+}
+
+// openingEntries are the entries that give every item without any an opening balance of its quantity
+func openingEntries(items []*stockItem, recorded map[stockKey]bool, now time.Time) []*LedgerEntry {
+	var entries []*LedgerEntry
+	for _, stock := range items {
+		item := stock.Item
+		if item.IngredientID == bson.NilObjectID || item.Quantity == 0 || recorded[stockKey{stock.StoreID, item.IngredientID}] {
+			continue
+		}
+		entries = append(entries, &LedgerEntry{
+			VendorID:  stock.VendorID,
+			StoreID:   stock.StoreID,
+			ItemID:    item.IngredientID,
+			Name:      item.Name,
+			Delta:     item.Quantity,
+			Reason:    ledgerOpening,
+			ActorRole: "system",
+			At:        now,
+		})
+	}
+	return entries
+}
+
+// LedgerRepository reads the inventory ledger, entries are written by the vendor repository along with the
+// change they record and are never updated or deleted
+type LedgerRepository interface {
+	FindLedger(context.Context, ID, ID, *LedgerQuery) (*LedgerPage, error)
+	CheckLedger(context.Context, ID, ID) ([]*LedgerMismatch, error)
+	OpenLedger(context.Context) (int, error)
+}
+
+type MongoLedgerRepository struct {
+	col    *mongo.Collection
+	vendor *mongo.Collection
+}
+
+func newMongoLedgerRepository(client *mongo.Client, dbName string) LedgerRepository {
+	db := client.Database(dbName)
+	return &MongoLedgerRepository{col: db.Collection("inventory_ledger"), vendor: db.Collection("vendor")}
+}
+
+// FindLedger is a page of the store's ledger, newest first
+func (m MongoLedgerRepository) FindLedger(ctx context.Context, vendorID, storeID ID, query *LedgerQuery) (*LedgerPage, error) {
+	ctx, span := Tracer.Start(ctx, "FindLedger")
+	defer span.End()
+	Logger.InfoContext(ctx, "Reading the store ledger", slog.String("storeID", storeID.String()), slog.Any("query", query),
+		ledger_repo_source)
+
+	filter := query.filter(vendorID, storeID)
+	total, err := m.col.CountDocuments(ctx, filter)
+	if err != nil {
+		Logger.ErrorContext(ctx, "Error counting ledger entries", slog.Any("error", err), ledger_repo_source)
+		return nil, err
+	}
+
+	opts := options.Find().
+		SetSort(bson.D{{Key: "at", Value: -1}, {Key: "_id", Value: -1}}).
+		SetSkip(int64((query.Page - 1) * query.PageSize)).
+		SetLimit(int64(query.PageSize))
+	cursor, err := m.col.Find(ctx, filter, opts)
+	if err != nil {
+		Logger.ErrorContext(ctx, "Error reading the ledger", slog.Any("error", err), ledger_repo_source)
+		return nil, err
+	}
+
+	page := &LedgerPage{Entries: []*LedgerEntry{}, Total: total, Page: query.Page, PageSize: query.PageSize}
+	if err := cursor.All(ctx, &page.Entries); err != nil {
+		Logger.ErrorContext(ctx, "Error decoding ledger entries", slog.Any("error", err), ledger_repo_source)
+		return nil, err
+	}
+	return page, nil
+}
+
+// CheckLedger recomputes every item of the store from its ledger and returns the ones that do not add up
+func (m MongoLedgerRepository) CheckLedger(ctx context.Context, vendorID, storeID ID) ([]*LedgerMismatch, error) {
+	ctx, span := Tracer.Start(ctx, "CheckLedger")
+	defer span.End()
+
+	var vendor Vendor
+	filter := bson.D{{Key: "_id", Value: vendorID.value}}
+	projection := bson.D{{Key: "stores", Value: bson.D{{Key: "$elemMatch", Value: bson.D{{Key: "_id", Value: storeID.value}}}}}}
+	if err := m.vendor.FindOne(ctx, filter, options.FindOne().SetProjection(projection)).Decode(&vendor); err != nil {
+		if errors.Is(err, mongo.ErrNoDocuments) {
+			return nil, &NoItems{}
+		}
+		Logger.ErrorContext(ctx, "Error reading the store", slog.Any("error", err), ledger_repo_source)
+		return nil, err
+	}
+	if len(vendor.Stores) != 1 {
+		return nil, &NoItems{}
+	}
+
+	pipeline := mongo.Pipeline{
+		{{Key: "$match", Value: bson.D{{Key: "vendor_id", Value: vendorID.value}, {Key: "store_id", Value: storeID.value}}}},
+		{{Key: "$sort", Value: bson.D{{Key: "at", Value: 1}}}},
+		{{Key: "$group", Value: bson.D{
+			{Key: "_id", Value: "$item_id"},
+			{Key: "name", Value: bson.D{{Key: "$last", Value: "$name"}}},
+			{Key: "total", Value: bson.D{{Key: "$sum", Value: "$delta"}}},
+		}}},
+		{{Key: "$sort", Value: bson.D{{Key: "_id", Value: 1}}}},
+	}
+	cursor, err := m.col.Aggregate(ctx, pipeline)
+	if err != nil {
+		Logger.ErrorContext(ctx, "Error summing the ledger", slog.Any("error", err), ledger_repo_source)
+		return nil, err
+	}
+	var totals []*ledgerTotal
+	if err := cursor.All(ctx, &totals); err != nil {
+		Logger.ErrorContext(ctx, "Error decoding ledger totals", slog.Any("error", err), ledger_repo_source)
+		return nil, err
+	}
+
+	mismatches := ledgerMismatches(vendor.Stores[0].Items, totals)
+	if len(mismatches) > 0 {
+		Logger.WarnContext(ctx, "Store stock does not match its ledger", slog.String("storeID", storeID.String()),
+			slog.Int("items", len(mismatches)), ledger_repo_source)
+	}
+	return mismatches, nil
+}
+
+// OpenLedger records an opening balance for every item that has stock but no entries, stores that existed
+// before the ledger start from what they held then. Running it again adds nothing
+func (m MongoLedgerRepository) OpenLedger(ctx context.Context) (int, error) {
+	ctx, span := Tracer.Start(ctx, "OpenLedger")
+	defer span.End()
+
+	pipeline := mongo.Pipeline{
+		{{Key: "$unwind", Value: "$stores"}},
+		{{Key: "$unwind", Value: "$stores.items"}},
+		{{Key: "$project", Value: bson.D{
+			{Key: "_id", Value: 0},
+			{Key: "vendor_id", Value: "$_id"},
+			{Key: "store_id", Value: "$stores._id"},
+			{Key: "item", Value: "$stores.items"},
+		}}},
+	}
+	cursor, err := m.vendor.Aggregate(ctx, pipeline)
+	if err != nil {
+		Logger.ErrorContext(ctx, "Error reading store stock", slog.Any("error", err), ledger_repo_source)
+		return 0, err
+	}
+	var items []*stockItem
+	if err := cursor.All(ctx, &items); err != nil {
+		Logger.ErrorContext(ctx, "Error decoding store stock", slog.Any("error", err), ledger_repo_source)
+		return 0, err
+	}
+
+	cursor, err = m.col.Aggregate(ctx, mongo.Pipeline{
+		{{Key: "$group", Value: bson.D{{Key: "_id", Value: bson.D{{Key: "store", Value: "$store_id"}, {Key: "item", Value: "$item_id"}}}}}},
+	})
+	if err != nil {
+		Logger.ErrorContext(ctx, "Error reading the ledger", slog.Any("error", err), ledger_repo_source)
+		return 0, err
+	}
+	var keys []struct {
+		Key struct {
+			Store bson.ObjectID `bson:"store"`
+			Item  bson.ObjectID `bson:"item"`
+		} `bson:"_id"`
+	}
+	if err := cursor.All(ctx, &keys); err != nil {
+		Logger.ErrorContext(ctx, "Error decoding ledger items", slog.Any("error", err), ledger_repo_source)
+		return 0, err
+	}
+	recorded := make(map[stockKey]bool, len(keys))
+	for _, k := range keys {
+		recorded[stockKey{k.Key.Store, k.Key.Item}] = true
+	}
+
+	entries := openingEntries(items, recorded, time.Now())
+	if len(entries) == 0 {
+		return 0, nil
+	}
+	docs := make([]any, len(entries))
+	for i, entry := range entries {
+		entry.ID = bson.NewObjectID()
+		docs[i] = entry
+	}
+	if _, err := m.col.InsertMany(ctx, docs); err != nil {
+		Logger.ErrorContext(ctx, "Error opening the ledger", slog.Any("error", err), ledger_repo_source)
+		return 0, err
+	}
+	Logger.InfoContext(ctx, "Opened the ledger of existing stock", slog.Int("entries", len(entries)), ledger_repo_source)
+	return len(entries), nil
+}
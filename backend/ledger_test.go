@@ -0,0 +1,117 @@
+package main
+
+import (
+	"net/url"
+	"testing"
+	"time"
+
+	"go.mongodb.org/mongo-driver/v2/bson"
+)
+
+func TestLedgerMovements(t *testing.T) {
+	store := bson.NewObjectID()
+	milk, flour, eggs, salt := bson.NewObjectID(), bson.NewObjectID(), bson.NewObjectID(), bson.NewObjectID()
+	before := stockSnapshot{
+		{store, milk}:  {name: "Milk", quantity: 10},
+		{store, flour}: {name: "Flour", quantity: 5},
+		{store, eggs}:  {name: "Eggs", quantity: 12},
+	}
+	after := stockSnapshot{
+		{store, milk}:  {name: "Milk", quantity: 7},
+		{store, flour}: {name: "Flour", quantity: 5},
+		{store, salt}:  {name: "Salt", quantity: 3},
+	}
+
+	deltas := map[bson.ObjectID]int{}
+	for _, entry := range ledgerMovements(before, after) {
+		deltas[entry.ItemID] = entry.Delta
+	}
+	expected := map[bson.ObjectID]int{milk: -3, eggs: -12, salt: 3}
+	if len(deltas) != len(expected) {
+		t.Fatalf("expected %v got %v", expected, deltas)
+	}
+	for item, delta := range expected {
+		if deltas[item] != delta {
+			t.Fatalf("expected %v got %v", expected, deltas)
+		}
+	}
+
+	stores := []*Store{{ID: store, Items: []*Item{newTestItem("Milk", 1, "l", 1), {Quantity: 4}}}}
+	stores[0].Items[0].Quantity = 7
+	if snapshot := newStockSnapshot(stores); len(snapshot) != 1 {
+		t.Fatalf("expected items without ids to be left out got %v", snapshot)
+	}
+}
+
+func TestLedgerMismatches(t *testing.T) {
+	milk := newTestItem("Milk", 1, "l", 1)
+	milk.Quantity = 8
+	flour := newTestItem("Flour", 1, "kg", 2)
+	flour.Quantity = 5
+	gone := bson.NewObjectID()
+	totals := []*ledgerTotal{
+		{ItemID: milk.IngredientID, Name: "Milk", Total: 8},
+		{ItemID: flour.IngredientID, Name: "Flour", Total: 6},
+		{ItemID: gone, Name: "Eggs", Total: 2},
+	}
+
+	mismatches := ledgerMismatches([]*Item{milk, flour}, totals)
+	if len(mismatches) != 2 {
+		t.Fatalf("expected the flour and eggs to mismatch got %+v", mismatches)
+	}
+	if m := mismatches[0]; m.ItemID != flour.IngredientID || m.Quantity != 5 || m.LedgerTotal != 6 {
+		t.Fatalf("expected flour 5 against 6 got %+v", m)
+	}
+	if m := mismatches[1]; m.ItemID != gone || m.Quantity != 0 || m.LedgerTotal != 2 {
+		t.Fatalf("expected the removed eggs 0 against 2 got %+v", m)
+	}
+}
+
+func TestOpeningEntries(t *testing.T) {
+	vendor, store := bson.NewObjectID(), bson.NewObjectID()
+	milk := newTestItem("Milk", 1, "l", 1)
+	milk.Quantity = 4
+	flour := newTestItem("Flour", 1, "kg", 2)
+	flour.Quantity = 5
+	empty := newTestItem("Salt", 1, "kg", 1)
+	empty.Quantity = 0
+	items := []*stockItem{
+		{VendorID: vendor, StoreID: store, Item: milk},
+		{VendorID: vendor, StoreID: store, Item: flour},
+		{VendorID: vendor, StoreID: store, Item: empty},
+	}
+
+	entries := openingEntries(items, map[stockKey]bool{{store, flour.IngredientID}: true}, time.Now())
+	if len(entries) != 1 || entries[0].ItemID != milk.IngredientID || entries[0].Delta != 4 || entries[0].Reason != ledgerOpening {
+		t.Fatalf("expected one opening entry for the milk got %+v", entries)
+	}
+}
+
+func TestParseLedgerQuery(t *testing.T) {
+	item := bson.NewObjectID()
+	query, err := parseLedgerQuery(url.Values{
+		"item": {item.Hex()}, "reason": {ledgerOrder}, "from": {"2024-01-01T00:00:00Z"}, "page": {"2"},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if query.ItemID != item || query.Reason != ledgerOrder || query.From.Year() != 2024 || query.Page != 2 ||
+		query.PageSize != defaultLedgerPageSize {
+		t.Fatalf("unexpected query %+v", query)
+	}
+	if len(query.filter(ID{bson.NewObjectID()}, ID{bson.NewObjectID()})) != 5 {
+		t.Fatalf("expected vendor, store, item, reason and time filters")
+	}
+
+	for _, bad := range []url.Values{
+		{"item": {"nope"}},
+		{"reason": {"theft"}},
+		{"from": {"yesterday"}},
+		{"from": {"2024-02-01T00:00:00Z"}, "to": {"2024-01-01T00:00:00Z"}},
+		{"page_size": {"1000"}},
+	} {
+		if _, err := parseLedgerQuery(bad); err == nil {
+			t.Fatalf("expected %v to fail", bad)
+		}
+	}
+}
@@ -25,7 +25,7 @@ func run() (err error) {
 	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt)
 	defer stop()
 
-	log.Printf("Initing resources Otel, Mongo and Redis\n")
+	log.Printf("Initing resources Otel, Mongo, Redis and the blob store\n")
 	otelShutDown, err := initOtelSDK(ctx)
 	if err != nil {
 		log.Printf("Error in initing otel -> %v\n", err)
@@ -44,6 +44,11 @@ func run() (err error) {
 		return
 	}
 
+	if err = initBlobStore(); err != nil {
+		log.Printf("Error in initing the blob store -> %v\n", err)
+		return
+	}
+
 	log.Printf("Inited Successfully\n")
 	defer func() {
 		log.Printf("Cleaning up resources\n")
@@ -64,6 +69,22 @@ func run() (err error) {
 
 	log.Printf("Inited Successfully\n")
 
+	log.Printf("Starting background jobs\n")
+	jobsCtx, stopJobs := context.WithCancel(ctx)
+	waitJobs := startJobs(jobsCtx,
+		job{name: "stock_alerts", every: stockAlertInterval, run: evaluateStock},
+		job{name: "slot_holds", every: slotReleaseInterval, run: releaseExpiredHolds},
+		job{name: "promotions", every: promotionInterval, run: applyPromotions},
+		job{name: "price_alerts", every: priceAlertInterval, run: evaluatePriceAlerts},
+		job{name: "payout_statements", every: settlementInterval, run: closeStatements},
+		job{name: "issue_escalations", every: issueEscalationInterval, run: escalateIssues},
+		job{name: "subscriptions", every: subscriptionInterval, run: runSubscriptions},
+	)
+	defer func() {
+		stopJobs()
+		waitJobs()
+	}()
+
 	log.Printf("Starting Server\n")
 	port := os.Getenv("PORT")
 	if port == "" {
@@ -115,36 +136,98 @@ func newHTTPHandler() http.Handler {
 	//
 	//-------------Admin-Specific-----------------------------
 	handleFunc("POST /admin/ingredients", mid(admin(http.HandlerFunc(AdminCreateIngredients))))
+	handleFunc("POST /admin/catalog", mid(admin(http.HandlerFunc(AdminCreateCatalogIngredients))))
+	handleFunc("POST /admin/categories", mid(admin(http.HandlerFunc(AdminCreateCategory))))
+	handleFunc("POST /admin/coupons", mid(admin(http.HandlerFunc(AdminCreateCoupon))))
+	handleFunc("POST /admin/tax-rules", mid(admin(http.HandlerFunc(AdminCreateTaxRule))))
+	handleFunc("POST /admin/catalog/merge/preview", mid(admin(http.HandlerFunc(AdminPreviewMerge))))
+	handleFunc("POST /admin/catalog/merge", mid(admin(http.HandlerFunc(AdminMergeIngredients))))
+	handleFunc("POST /admin/issues/{id}/messages", mid(admin(http.HandlerFunc(AddIssueMessage))))
 
 	handleFunc("GET /admin/users", mid(admin(http.HandlerFunc(AdminGetUsers))))
 	handleFunc("GET /admin/vendors", mid(admin(http.HandlerFunc(AdminGetVendors))))
 	handleFunc("GET /admin/stores", mid(admin(http.HandlerFunc(AdminGetStores))))
 	handleFunc("GET /admin/ingredients", mid(admin(http.HandlerFunc(AdminGetIngredients))))
+	handleFunc("GET /admin/catalog", mid(admin(http.HandlerFunc(GetCatalog))))
+	handleFunc("GET /admin/catalog/{id}", mid(admin(http.HandlerFunc(GetCatalogIngredient))))
+	handleFunc("GET /admin/catalog/duplicates", mid(admin(http.HandlerFunc(AdminGetDuplicateIngredients))))
+	handleFunc("GET /admin/catalog/audit", mid(admin(http.HandlerFunc(AdminGetCatalogChanges))))
+	handleFunc("GET /admin/catalog/{id}/audit", mid(admin(http.HandlerFunc(AdminGetCatalogChanges))))
+	handleFunc("GET /admin/categories", mid(admin(http.HandlerFunc(GetCategories))))
+	handleFunc("GET /admin/coupons", mid(admin(http.HandlerFunc(AdminGetCoupons))))
+	handleFunc("GET /admin/tax-rules", mid(admin(http.HandlerFunc(AdminGetTaxRules))))
+	handleFunc("GET /admin/payouts", mid(admin(http.HandlerFunc(AdminGetPayouts))))
+	handleFunc("GET /admin/payouts/{id}", mid(admin(http.HandlerFunc(AdminGetPayoutStatement))))
+	handleFunc("GET /admin/reviews", mid(admin(http.HandlerFunc(AdminGetReviews))))
+	handleFunc("GET /admin/issues", mid(admin(http.HandlerFunc(GetIssues))))
+	handleFunc("GET /admin/issues/{id}", mid(admin(http.HandlerFunc(GetIssue))))
+	handleFunc("GET /admin/issues/{id}/{photo}", mid(admin(http.HandlerFunc(GetIssuePhoto))))
 
 	handleFunc("PUT /admin", mid(admin(http.HandlerFunc(UpdateUser))))
 	handleFunc("PUT /admin/ingredients", mid(admin(http.HandlerFunc(AdminUpdateIngredients))))
+	handleFunc("PUT /admin/catalog/{id}", mid(admin(http.HandlerFunc(AdminUpdateCatalogIngredient))))
+	handleFunc("PUT /admin/categories/{id}", mid(admin(http.HandlerFunc(AdminUpdateCategory))))
+	handleFunc("PUT /admin/tax-rules/{id}", mid(admin(http.HandlerFunc(AdminUpdateTaxRule))))
+	handleFunc("PUT /admin/payouts/{id}/paid", mid(admin(http.HandlerFunc(AdminMarkPayoutPaid))))
+	handleFunc("PUT /admin/vendors/{id}/commission", mid(admin(http.HandlerFunc(AdminSetCommission))))
+	handleFunc("PUT /admin/reviews/{id}/status", mid(admin(http.HandlerFunc(AdminSetReviewStatus))))
+	handleFunc("PUT /admin/issues/{id}/resolution", mid(admin(http.HandlerFunc(ResolveIssue))))
 
 	handleFunc("DELETE /admin", mid(admin(http.HandlerFunc(DeleteAdmin))))
 	handleFunc("DELETE /admin/user/{id}", mid(admin(http.HandlerFunc(AdminDeleteUser))))
 	handleFunc("DELETE /admin/vendor/{id}", mid(admin(http.HandlerFunc(AdminDeleteVendor))))
 	handleFunc("DELETE /admin/ingredients", mid(admin(http.HandlerFunc(AdminDeleteIngredients))))
+	handleFunc("DELETE /admin/catalog/{id}", mid(admin(http.HandlerFunc(AdminDeleteCatalogIngredient))))
+	handleFunc("DELETE /admin/categories/{id}", mid(admin(http.HandlerFunc(AdminDeleteCategory))))
+	handleFunc("DELETE /admin/coupons/{id}", mid(admin(http.HandlerFunc(AdminDeleteCoupon))))
+	handleFunc("DELETE /admin/tax-rules/{id}", mid(admin(http.HandlerFunc(AdminDeleteTaxRule))))
+	handleFunc("DELETE /admin/reviews/{id}", mid(admin(http.HandlerFunc(AdminDeleteReview))))
 	//--------------------------------------------------------
 	//
 	//-------------Vendor-Specific-----------------------------
 	handleFunc("POST /vendor/stores", mid(vendor(http.HandlerFunc(CreateStores))))
+	handleFunc("POST /vendor/stores/{id}/import", mid(vendor(http.HandlerFunc(ImportInventory))))
 	handleFunc("POST /vendor/orders", mid(vendor(http.HandlerFunc(CreateVendorOrders))))
+	handleFunc("POST /vendor/promotions", mid(vendor(http.HandlerFunc(CreatePromotion))))
+	handleFunc("POST /vendor/coupons", mid(vendor(http.HandlerFunc(VendorCreateCoupon))))
+	handleFunc("POST /vendor/issues/{id}/messages", mid(vendor(http.HandlerFunc(AddIssueMessage))))
 
 	handleFunc("GET /vendor/stores", mid(vendor(http.HandlerFunc(GetStores))))
+	handleFunc("GET /vendor/stores/{id}/export", mid(vendor(http.HandlerFunc(ExportInventory))))
+	handleFunc("GET /vendor/stores/{id}/alerts", mid(vendor(http.HandlerFunc(GetStockAlerts))))
+	handleFunc("GET /vendor/stores/{id}/ledger", mid(vendor(http.HandlerFunc(GetStoreLedger))))
+	handleFunc("GET /vendor/stores/{id}/ledger/check", mid(vendor(http.HandlerFunc(CheckStoreLedger))))
+	handleFunc("GET /vendor/stores/{id}/items/{item}/prices", mid(vendor(http.HandlerFunc(GetVendorPriceHistory))))
+	handleFunc("GET /vendor/notifications", mid(vendor(http.HandlerFunc(GetNotificationSettings))))
+	handleFunc("GET /vendor/promotions", mid(vendor(http.HandlerFunc(GetPromotions))))
+	handleFunc("GET /vendor/coupons", mid(vendor(http.HandlerFunc(VendorGetCoupons))))
 	handleFunc("GET /vendor/orders", mid(vendor(http.HandlerFunc(GetVendorOrders))))
+	handleFunc("GET /vendor/orders/{id}/invoice", mid(vendor(http.HandlerFunc(GetOrderInvoice))))
+	handleFunc("GET /vendor/payouts", mid(vendor(http.HandlerFunc(GetPayouts))))
+	handleFunc("GET /vendor/payouts/{id}", mid(vendor(http.HandlerFunc(GetPayoutStatement))))
+	handleFunc("GET /vendor/reviews", mid(vendor(http.HandlerFunc(GetVendorReviews))))
+	handleFunc("GET /vendor/orders/{id}/issues", mid(vendor(http.HandlerFunc(GetOrderIssues))))
+	handleFunc("GET /vendor/issues", mid(vendor(http.HandlerFunc(GetIssues))))
+	handleFunc("GET /vendor/issues/{id}", mid(vendor(http.HandlerFunc(GetIssue))))
+	handleFunc("GET /vendor/issues/{id}/{photo}", mid(vendor(http.HandlerFunc(GetIssuePhoto))))
 	handleFunc("GET /vendor/ingredients", mid(vendor(http.HandlerFunc(GetVendorAdminIngredients))))
+	handleFunc("GET /vendor/catalog", mid(vendor(http.HandlerFunc(GetCatalog))))
+	handleFunc("GET /vendor/catalog/{id}", mid(vendor(http.HandlerFunc(GetCatalogIngredient))))
+	handleFunc("GET /vendor/categories", mid(vendor(http.HandlerFunc(GetCategories))))
 
 	handleFunc("PUT /vendor/stores", mid(vendor(http.HandlerFunc(UpdateStores))))
+	handleFunc("PUT /vendor/notifications", mid(vendor(http.HandlerFunc(UpdateNotificationSettings))))
+	handleFunc("PUT /vendor/promotions/{id}", mid(vendor(http.HandlerFunc(UpdatePromotion))))
+	handleFunc("PUT /vendor/reviews/{id}/reply", mid(vendor(http.HandlerFunc(ReplyToReview))))
+	handleFunc("PUT /vendor/issues/{id}/resolution", mid(vendor(http.HandlerFunc(ResolveIssue))))
 	handleFunc("PUT /vendor/userorder/accept", mid(vendor(http.HandlerFunc(AcceptUserOrder))))
 	handleFunc("PUT /vendor/orders", mid(vendor(http.HandlerFunc(UpdateVendorOrders))))
 	handleFunc("PUT /vendor/order/{id}", mid(vendor(http.HandlerFunc(UpdateVendorOrders))))
 	handleFunc("PUT /vendor", mid(vendor(http.HandlerFunc(UpdateUser))))
 
 	handleFunc("DELETE /vendor/stores", mid(vendor(http.HandlerFunc(DeleteStores))))
+	handleFunc("DELETE /vendor/promotions/{id}", mid(vendor(http.HandlerFunc(DeletePromotion))))
+	handleFunc("DELETE /vendor/coupons/{id}", mid(vendor(http.HandlerFunc(VendorDeleteCoupon))))
 	handleFunc("DELETE /vendor/store/items/", mid(vendor(http.HandlerFunc(DeleteStoreItems))))
 	handleFunc("DELETE /vendor", mid(vendor(http.HandlerFunc(DeleteVendor))))
 	//---------------------------------------------------------
@@ -154,18 +237,47 @@ func newHTTPHandler() http.Handler {
 	handleFunc("POST /user/carts", mid(user(http.HandlerFunc(CreateCarts))))
 	handleFunc("POST /user/orders", mid(user(http.HandlerFunc(CreateUserOrders))))
 	handleFunc("POST /user/items/compare", mid(user(http.HandlerFunc(VendorComparedItemsValue))))
+	handleFunc("POST /user/items/optimize", mid(user(http.HandlerFunc(OptimizeBasket))))
+	handleFunc("POST /user/orders/{id}/issues", mid(user(http.HandlerFunc(CreateOrderIssue))))
+	handleFunc("POST /user/issues/{id}/messages", mid(user(http.HandlerFunc(AddIssueMessage))))
+	handleFunc("POST /user/orders/{id}/reorder", mid(user(http.HandlerFunc(ReorderOrder))))
+	handleFunc("POST /user/subscriptions", mid(user(http.HandlerFunc(CreateSubscription))))
 
 	handleFunc("GET /user", mid(user(http.HandlerFunc(GetUser))))
 	handleFunc("GET /user/recipes", mid(user(http.HandlerFunc(GetRecipes))))
 	handleFunc("GET /user/carts", mid(user(http.HandlerFunc(GetCarts))))
 	handleFunc("GET /user/orders", mid(user(http.HandlerFunc(GetUserOrders))))
+	handleFunc("GET /user/orders/{id}/receipt", mid(user(http.HandlerFunc(GetOrderReceipt))))
+	handleFunc("GET /user/orders/{id}/issues", mid(user(http.HandlerFunc(GetOrderIssues))))
+	handleFunc("GET /user/issues", mid(user(http.HandlerFunc(GetIssues))))
+	handleFunc("GET /user/issues/{id}", mid(user(http.HandlerFunc(GetIssue))))
+	handleFunc("GET /user/issues/{id}/{photo}", mid(user(http.HandlerFunc(GetIssuePhoto))))
 	handleFunc("GET /user/ingredients", mid(user(http.HandlerFunc(GetUserAdminIngredients))))
+	handleFunc("GET /user/catalog", mid(user(http.HandlerFunc(GetCatalog))))
+	handleFunc("GET /user/catalog/{id}", mid(user(http.HandlerFunc(GetCatalogIngredient))))
+	handleFunc("GET /user/categories", mid(user(http.HandlerFunc(GetCategories))))
+	handleFunc("GET /user/stores/nearby", mid(user(http.HandlerFunc(GetNearbyStores))))
+	handleFunc("GET /user/stores/{vendor}/{store}/slots", mid(user(http.HandlerFunc(GetStoreSlots))))
+	handleFunc("GET /user/stores/{vendor}/{store}/items/{item}/prices", mid(user(http.HandlerFunc(GetPriceHistory))))
+	handleFunc("GET /user/stores/{vendor}/{store}/reviews", mid(user(http.HandlerFunc(GetStoreReviews))))
+	handleFunc("GET /user/price-alerts", mid(user(http.HandlerFunc(GetPriceAlerts))))
+	handleFunc("GET /user/notifications", mid(user(http.HandlerFunc(GetNotificationSettings))))
+	handleFunc("GET /user/subscriptions", mid(user(http.HandlerFunc(GetSubscriptions))))
+	handleFunc("POST /user/slots/holds", mid(user(http.HandlerFunc(HoldSlot))))
+	handleFunc("POST /user/price-alerts", mid(user(http.HandlerFunc(CreatePriceAlert))))
+	handleFunc("DELETE /user/slots/holds/{id}", mid(user(http.HandlerFunc(ReleaseSlotHold))))
+	handleFunc("DELETE /user/price-alerts/{id}", mid(user(http.HandlerFunc(DeletePriceAlert))))
+	handleFunc("DELETE /user/subscriptions/{id}", mid(user(http.HandlerFunc(DeleteSubscription))))
 	handleFunc("GET /vendor/{vid}/store/{sid}/items", mid(user(http.HandlerFunc(GetItems))))
 
 	handleFunc("PUT /user", mid(user(http.HandlerFunc(UpdateUser))))
 	handleFunc("PUT /user/recipes", mid(user(http.HandlerFunc(UpdateRecipes))))
 	handleFunc("PUT /user/carts", mid(user(http.HandlerFunc(UpdateCarts))))
 	handleFunc("PUT /user/orders", mid(user(http.HandlerFunc(UpdateUserOrders))))
+	handleFunc("PUT /user/notifications", mid(user(http.HandlerFunc(UpdateNotificationSettings))))
+	handleFunc("PUT /user/subscriptions/{id}", mid(user(http.HandlerFunc(UpdateSubscription))))
+	handleFunc("PUT /user/subscriptions/{id}/status", mid(user(http.HandlerFunc(SetSubscriptionStatus))))
+	handleFunc("PUT /user/subscriptions/{id}/skips", mid(user(http.HandlerFunc(SetSubscriptionSkips))))
 
 	handleFunc("DELETE /user/recipes", mid(user(http.HandlerFunc(DeleteRecipes))))
 	handleFunc("DELETE /user/recipe/items", mid(user(http.HandlerFunc(DeleteRecipeItems))))
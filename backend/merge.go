@@ -0,0 +1,300 @@
+package main
+
+import (
+	"bytes"
+	"cmp"
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"slices"
+	"time"
+
+	"go.mongodb.org/mongo-driver/v2/bson"
+	"go.mongodb.org/mongo-driver/v2/mongo"
+	"go.mongodb.org/mongo-driver/v2/mongo/options"
+)
+
+// itemRef is an items array that points at catalog ingredients through one of fields
+type itemRef struct {
+	col    *mongo.Collection
+	array  string
+	fields []string
+}
+
+// itemRefs lists every place a merge has to rewrite, recipes and carts copy the catalog id into ingredient_id
+// while store items link to it through catalog_id
+func (m MongoCatalogRepository) itemRefs() (stores, recipes, carts itemRef) {
+	return itemRef{m.vendor, "stores", []string{"catalog_id"}},
+		itemRef{m.user, "saved_recipes", []string{"ingredient_id", "catalog_id"}},
+		itemRef{m.user, "carts", []string{"ingredient_id", "catalog_id"}}
+}
+
+func (ref itemRef) filter(ids []bson.ObjectID) bson.D {
+	var or bson.A
+	for _, field := range ref.fields {
+		or = append(or, bson.D{{Key: ref.array + ".items." + field, Value: bson.D{{Key: "$in", Value: ids}}}})
+	}
+	return bson.D{{Key: "$or", Value: or}}
+}
+
+func (ref itemRef) count(ctx context.Context, ids []bson.ObjectID) (int64, error) {
+	pipeline := mongo.Pipeline{
+		{{Key: "$match", Value: ref.filter(ids)}},
+		{{Key: "$unwind", Value: "$" + ref.array}},
+		{{Key: "$unwind", Value: "$" + ref.array + ".items"}},
+		{{Key: "$match", Value: ref.filter(ids)}},
+		{{Key: "$count", Value: "n"}},
+	}
+	cursor, err := ref.col.Aggregate(ctx, pipeline)
+	if err != nil {
+		return 0, err
+	}
+	var counts []struct {
+		N int64 `bson:"n"`
+	}
+	if err := cursor.All(ctx, &counts); err != nil || len(counts) == 0 {
+		return 0, err
+	}
+	return counts[0].N, nil
+}
+
+func (ref itemRef) rewrite(ctx context.Context, ids []bson.ObjectID, survivor bson.ObjectID) error {
+	for _, field := range ref.fields {
+		filter := bson.D{{Key: ref.array + ".items." + field, Value: bson.D{{Key: "$in", Value: ids}}}}
+		// only containers holding a reference are walked so ones without an items array never fail the update
+		update := bson.D{{Key: "$set", Value: bson.M{ref.array + ".$[c].items.$[i]." + field: survivor}}}
+		opts := options.UpdateMany().SetArrayFilters([]any{
+			bson.M{"c.items." + field: bson.M{"$in": ids}},
+			bson.M{"i." + field: bson.M{"$in": ids}},
+		})
+		if _, err := ref.col.UpdateMany(ctx, filter, update, opts); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// duplicateGroups puts catalog ingredients with the same normalized name, or a name that is another entry's
+// alias, and a unit of the same dimension together, only groups of two or more are returned
+func duplicateGroups(entries []*CatalogIngredient) []*DuplicateGroup {
+	matcher := newIngredientMatcher(nil)
+	for _, entry := range entries {
+		matcher.add(&entry.Ingredient)
+	}
+
+	var groups []*DuplicateGroup
+	byKey := make(map[[2]string]*DuplicateGroup)
+	for _, entry := range entries {
+		name := matcher.canonical(normalizeName(entry.Name))
+		dim := "unit " + canonicalUnit(entry.Unit)
+		if def, ok := lookupUnit(entry.Unit); ok {
+			dim = string(def.dim)
+		}
+
+		key := [2]string{name, dim}
+		group, ok := byKey[key]
+		if !ok {
+			group = &DuplicateGroup{Name: name, Dimension: dim}
+			byKey[key] = group
+			groups = append(groups, group)
+		}
+		group.Ingredients = append(group.Ingredients, entry)
+	}
+
+	groups = slices.DeleteFunc(groups, func(g *DuplicateGroup) bool { return len(g.Ingredients) < 2 })
+	slices.SortFunc(groups, func(a, b *DuplicateGroup) int { return cmp.Compare(a.Name, b.Name) })
+	return groups
+}
+
+// mergeAliases is the alias list of the survivor once the merged ingredients are folded into it, their names
+// and aliases are kept so old spellings still resolve
+func mergeAliases(survivor *CatalogIngredient, merged []*CatalogIngredient) []string {
+	own := normalizeName(survivor.Name)
+	seen := map[string]bool{own: true}
+	aliases := []string{}
+	add := func(name string) {
+		if key := normalizeName(name); key != "" && !seen[key] {
+			seen[key] = true
+			aliases = append(aliases, name)
+		}
+	}
+
+	for _, alias := range survivor.Aliases {
+		add(alias)
+	}
+	for _, entry := range merged {
+		add(entry.Name)
+		for _, alias := range entry.Aliases {
+			add(alias)
+		}
+	}
+	return aliases
+}
+
+func (req *MergeReq) validate() error {
+	switch {
+	case req.SurvivorID == bson.NilObjectID:
+		return errors.New("survivor_id is required")
+	case len(req.MergedIDs) == 0:
+		return errors.New("merged_ids can not be empty")
+	case slices.Contains(req.MergedIDs, req.SurvivorID):
+		return errors.New("the survivor can not be merged into itself")
+	}
+	slices.SortFunc(req.MergedIDs, func(a, b bson.ObjectID) int { return bytes.Compare(a[:], b[:]) })
+	req.MergedIDs = slices.Compact(req.MergedIDs)
+	return nil
+}
+
+func (m MongoCatalogRepository) findCatalogEntries(ctx context.Context, filter bson.D,
+	opts ...options.Lister[options.FindOptions]) ([]*CatalogIngredient, error) {
+	cursor, err := m.col.Find(ctx, filter, opts...)
+	if err != nil {
+		Logger.ErrorContext(ctx, "Error reading the catalog", slog.Any("error", err), catalog_repo_source)
+		return nil, err
+	}
+	entries := []*CatalogIngredient{}
+	if err := cursor.All(ctx, &entries); err != nil {
+		Logger.ErrorContext(ctx, "Error decoding the catalog", slog.Any("error", err), catalog_repo_source)
+		return nil, err
+	}
+	return entries, nil
+}
+
+func (m MongoCatalogRepository) FindDuplicateIngredients(ctx context.Context) ([]*DuplicateGroup, error) {
+	ctx, span := Tracer.Start(ctx, "FindDuplicateIngredients")
+	defer span.End()
+	Logger.InfoContext(ctx, "Looking for duplicate catalog ingredients", catalog_repo_source)
+
+	entries, err := m.findCatalogEntries(ctx, bson.D{})
+	if err != nil {
+		return nil, err
+	}
+	groups := duplicateGroups(entries)
+	Logger.InfoContext(ctx, "Duplicate groups found", slog.Int("count", len(groups)), catalog_repo_source)
+	return groups, nil
+}
+
+// PreviewMerge reports what MergeIngredients would do without writing anything
+func (m MongoCatalogRepository) PreviewMerge(ctx context.Context, req *MergeReq) (*MergePreview, error) {
+	ctx, span := Tracer.Start(ctx, "PreviewMerge")
+	defer span.End()
+	Logger.InfoContext(ctx, "Previewing catalog merge", slog.Any("merge", req), catalog_repo_source)
+
+	if err := req.validate(); err != nil {
+		return nil, err
+	}
+
+	ids := append([]bson.ObjectID{req.SurvivorID}, req.MergedIDs...)
+	entries, err := m.findCatalogEntries(ctx, bson.D{{Key: "ingredient_id", Value: bson.D{{Key: "$in", Value: ids}}}})
+	if err != nil {
+		return nil, err
+	}
+
+	preview := &MergePreview{Merged: []*CatalogIngredient{}}
+	for _, entry := range entries {
+		if entry.IngredientID == req.SurvivorID {
+			preview.Survivor = entry
+		} else {
+			preview.Merged = append(preview.Merged, entry)
+		}
+	}
+	if preview.Survivor == nil || len(preview.Merged) != len(req.MergedIDs) {
+		return nil, errors.New("the survivor and every merged ingredient have to be in the catalog")
+	}
+	for _, entry := range preview.Merged {
+		if !unitAllowed(&preview.Survivor.Ingredient, entry.Unit) {
+			return nil, fmt.Errorf("%q in %q can not be merged into %q in %q", entry.Name, entry.Unit,
+				preview.Survivor.Name, preview.Survivor.Unit)
+		}
+	}
+	preview.Aliases = mergeAliases(preview.Survivor, preview.Merged)
+
+	stores, recipes, carts := m.itemRefs()
+	for _, c := range []struct {
+		ref   itemRef
+		count *int64
+	}{{stores, &preview.StoreItems}, {recipes, &preview.Recipes}, {carts, &preview.Carts}} {
+		if *c.count, err = c.ref.count(ctx, req.MergedIDs); err != nil {
+			Logger.ErrorContext(ctx, "Error counting references", slog.String("array", c.ref.array),
+				slog.Any("error", err), catalog_repo_source)
+			return nil, err
+		}
+	}
+	return preview, nil
+}
+
+// MergeIngredients folds the merged ingredients into the survivor in one transaction, every store item,
+// recipe and cart pointing at them is moved to the survivor, their names become aliases and they are deleted.
+// It returns the users whose recipes or carts changed so their cache can be dropped
+func (m MongoCatalogRepository) MergeIngredients(ctx context.Context, admin ID, req *MergeReq) (*MergePreview, []ID, error) {
+	ctx, span := Tracer.Start(ctx, "MergeIngredients")
+	defer span.End()
+
+	preview, err := m.PreviewMerge(ctx, req)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	stores, recipes, carts := m.itemRefs()
+	var userIDs []bson.ObjectID
+	userFilter := bson.D{{Key: "$or", Value: bson.A{recipes.filter(req.MergedIDs), carts.filter(req.MergedIDs)}}}
+	if err := m.user.Distinct(ctx, "_id", userFilter).Decode(&userIDs); err != nil {
+		Logger.ErrorContext(ctx, "Error finding affected users", slog.Any("error", err), catalog_repo_source)
+		return nil, nil, err
+	}
+
+	session, err := MongoClient.StartSession(sesOp)
+	if err != nil {
+		Logger.ErrorContext(ctx, "Unable to create a session", slog.Any("error", err), catalog_repo_source)
+		return nil, nil, err
+	}
+	defer session.EndSession(ctx)
+
+	_, err = session.WithTransaction(ctx, func(sessCtx context.Context) (any, error) {
+		for _, ref := range []itemRef{stores, recipes, carts} {
+			if err := ref.rewrite(sessCtx, req.MergedIDs, req.SurvivorID); err != nil {
+				Logger.ErrorContext(sessCtx, "Error rewriting references", slog.String("array", ref.array),
+					slog.Any("error", err), catalog_repo_source)
+				return nil, err
+			}
+		}
+
+		filter := bson.D{{Key: "ingredient_id", Value: bson.D{{Key: "$in", Value: req.MergedIDs}}}}
+		if _, err := m.col.DeleteMany(sessCtx, filter); err != nil {
+			Logger.ErrorContext(sessCtx, "Error deleting merged ingredients", slog.Any("error", err), catalog_repo_source)
+			return nil, err
+		}
+
+		set := bson.M{"aliases": preview.Aliases, "updated_at": time.Now(), "updated_by": admin.value}
+		// a survivor that was itself a duplicate takes the name key once the merged entries let go of it
+		if key := normalizeName(preview.Survivor.Name); preview.Survivor.NameKey == "" {
+			count, err := m.col.CountDocuments(sessCtx, bson.D{{Key: "name_key", Value: key}})
+			if err != nil {
+				return nil, err
+			}
+			if count == 0 {
+				set["name_key"] = key
+			}
+		}
+		update := bson.D{{Key: "$set", Value: set}}
+		if _, err := m.col.UpdateOne(sessCtx, bson.D{{Key: "ingredient_id", Value: req.SurvivorID}}, update); err != nil {
+			Logger.ErrorContext(sessCtx, "Error updating the survivor", slog.Any("error", err), catalog_repo_source)
+			return nil, err
+		}
+		return nil, nil
+	})
+	if err != nil {
+		return nil, nil, err
+	}
+
+	users := make([]ID, len(userIDs))
+	for i, id := range userIDs {
+		users[i] = ID{id}
+	}
+	preview.Survivor.Aliases = preview.Aliases
+	m.record(ctx, admin, "merge", "ingredient", req.SurvivorID, req)
+	Logger.InfoContext(ctx, "Catalog ingredients merged", slog.String("survivor", req.SurvivorID.Hex()),
+		slog.Int("merged", len(preview.Merged)), catalog_repo_source)
+	return preview, users, nil
+}
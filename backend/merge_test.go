@@ -0,0 +1,57 @@
+package main
+
+import (
+	"slices"
+	"testing"
+
+	"go.mongodb.org/mongo-driver/v2/bson"
+)
+
+func newTestCatalogEntry(name, unit string, aliases ...string) *CatalogIngredient {
+	return &CatalogIngredient{Ingredient: Ingredient{IngredientID: bson.NewObjectID(), Name: name, Unit: unit, Aliases: aliases}}
+}
+
+func TestDuplicateGroups(t *testing.T) {
+	entries := []*CatalogIngredient{
+		newTestCatalogEntry("Tomato", "kg"),
+		newTestCatalogEntry("tomatoes", "g"),
+		newTestCatalogEntry("TOMATO", "each"),
+		newTestCatalogEntry("Green Onion", "each", "scallion"),
+		newTestCatalogEntry("Scallions", "dozen"),
+		newTestCatalogEntry("Milk", "l"),
+	}
+
+	groups := duplicateGroups(entries)
+	if len(groups) != 2 {
+		t.Fatalf("expected 2 groups got %d", len(groups))
+	}
+	if g := groups[0]; g.Name != "green onion" || g.Dimension != string(count) || len(g.Ingredients) != 2 {
+		t.Fatalf("expected the green onion group first got %+v", g)
+	}
+	if g := groups[1]; g.Name != "tomato" || g.Dimension != string(mass) || len(g.Ingredients) != 2 {
+		t.Fatalf("expected tomatoes by mass got %+v", g)
+	}
+}
+
+func TestMergeAliases(t *testing.T) {
+	survivor := newTestCatalogEntry("Tomato", "kg", "Love apple")
+	merged := []*CatalogIngredient{newTestCatalogEntry("Tomatoes", "g"), newTestCatalogEntry("Roma", "kg", "love apples", "Plum tomato")}
+
+	got := mergeAliases(survivor, merged)
+	if want := []string{"Love apple", "Roma", "Plum tomato"}; !slices.Equal(got, want) {
+		t.Fatalf("expected %v got %v", want, got)
+	}
+}
+
+func TestMergeReqValidate(t *testing.T) {
+	survivor, a, b := bson.NewObjectID(), bson.NewObjectID(), bson.NewObjectID()
+	req := &MergeReq{SurvivorID: survivor, MergedIDs: []bson.ObjectID{a, b, a}}
+	if err := req.validate(); err != nil || len(req.MergedIDs) != 2 {
+		t.Fatalf("expected the duplicate id dropped got %v %v", req.MergedIDs, err)
+	}
+	for _, bad := range []*MergeReq{{MergedIDs: []bson.ObjectID{a}}, {SurvivorID: survivor}, {SurvivorID: a, MergedIDs: []bson.ObjectID{a}}} {
+		if bad.validate() == nil {
+			t.Fatalf("expected %+v to be rejected", bad)
+		}
+	}
+}
@@ -8,6 +8,7 @@ import (
 	"sync"
 	"time"
 
+	"go.mongodb.org/mongo-driver/v2/bson"
 	"go.mongodb.org/mongo-driver/v2/mongo"
 	"go.mongodb.org/mongo-driver/v2/mongo/options"
 )
@@ -132,3 +133,111 @@ func initDB(c context.Context) (shutdown func(ctx context.Context) error, err er
 	Logger.InfoContext(ctx, "Connected Successfully to mongoDB", slog.String("Ping", "Success"), mongo_source)
 	return
 }
+
+func initIndexes(c context.Context, client *mongo.Client) error {
+	ctx, span := Tracer.Start(c, "initIndexes")
+	defer span.End()
+
+	db := client.Database(os.Getenv("DB_NAME"))
+	indexes := []struct {
+		collection string
+		models     []mongo.IndexModel
+	}{
+		{"vendor", []mongo.IndexModel{
+			{Keys: bson.D{{Key: "stores.location", Value: "2dsphere"}}},
+			{Keys: bson.D{{Key: "stores.items.name_keys", Value: 1}}},
+			{Keys: bson.D{{Key: "stores.items.catalog_id", Value: 1}}},
+		}},
+		{"catalog", []mongo.IndexModel{
+			{Keys: bson.D{{Key: "ingredient_id", Value: 1}}, Options: options.Index().SetUnique(true)},
+			{Keys: bson.D{{Key: "name", Value: 1}}},
+			{Keys: bson.D{{Key: "name_key", Value: 1}}, Options: options.Index().SetUnique(true).
+				SetPartialFilterExpression(bson.D{{Key: "name_key", Value: bson.D{{Key: "$exists", Value: true}}}})},
+			{Keys: bson.D{{Key: "aliases", Value: 1}}},
+			{Keys: bson.D{{Key: "category_path", Value: 1}}},
+		}},
+		{"categories", []mongo.IndexModel{
+			{Keys: bson.D{{Key: "parent_id", Value: 1}}},
+			{Keys: bson.D{{Key: "path", Value: 1}}},
+		}},
+		{"stock_alerts", []mongo.IndexModel{
+			{Keys: bson.D{{Key: "vendor_id", Value: 1}, {Key: "store_id", Value: 1}, {Key: "created_at", Value: -1}}},
+			{Keys: bson.D{{Key: "resolved_at", Value: 1}}},
+		}},
+		{"slot_holds", []mongo.IndexModel{
+			{Keys: bson.D{{Key: "status", Value: 1}, {Key: "expires_at", Value: 1}}},
+			{Keys: bson.D{{Key: "order_id", Value: 1}}},
+		}},
+		{"promotions", []mongo.IndexModel{
+			{Keys: bson.D{{Key: "status", Value: 1}}},
+			{Keys: bson.D{{Key: "vendor_id", Value: 1}, {Key: "starts_at", Value: -1}}},
+		}},
+		{"coupons", []mongo.IndexModel{
+			{Keys: bson.D{{Key: "code", Value: 1}}, Options: options.Index().SetUnique(true)},
+			{Keys: bson.D{{Key: "vendor_id", Value: 1}, {Key: "created_at", Value: -1}}},
+		}},
+		{"coupon_redemptions", []mongo.IndexModel{
+			{Keys: bson.D{{Key: "coupon_id", Value: 1}, {Key: "user_id", Value: 1}, {Key: "status", Value: 1}}},
+			// a user holds one first order redemption at a time, across every first order coupon
+			{Keys: bson.D{{Key: "user_id", Value: 1}}, Options: options.Index().SetUnique(true).SetName("first_order_redemption").
+				SetPartialFilterExpression(bson.D{{Key: "first_order", Value: true}, {Key: "status", Value: redemptionRedeemed}})},
+		}},
+		{"tax_rules", []mongo.IndexModel{
+			{Keys: bson.D{{Key: "region", Value: 1}, {Key: "store_type", Value: 1}, {Key: "category_id", Value: 1}},
+				Options: options.Index().SetUnique(true)},
+		}},
+		{"invoices", []mongo.IndexModel{
+			{Keys: bson.D{{Key: "vendor_id", Value: 1}, {Key: "order_id", Value: 1}}, Options: options.Index().SetUnique(true)},
+			{Keys: bson.D{{Key: "vendor_id", Value: 1}, {Key: "number", Value: 1}}, Options: options.Index().SetUnique(true)},
+		}},
+		{"settlements", []mongo.IndexModel{
+			{Keys: bson.D{{Key: "vendor_id", Value: 1}, {Key: "order_id", Value: 1}}},
+			{Keys: bson.D{{Key: "statement_id", Value: 1}, {Key: "at", Value: 1}}},
+		}},
+		{"payout_statements", []mongo.IndexModel{
+			{Keys: bson.D{{Key: "vendor_id", Value: 1}, {Key: "period_end", Value: -1}}},
+			{Keys: bson.D{{Key: "status", Value: 1}, {Key: "period_end", Value: 1}}},
+		}},
+		{"reviews", []mongo.IndexModel{
+			{Keys: bson.D{{Key: "order_id", Value: 1}}, Options: options.Index().SetUnique(true)},
+			{Keys: bson.D{{Key: "vendor_id", Value: 1}, {Key: "store_id", Value: 1}, {Key: "status", Value: 1}, {Key: "created_at", Value: -1}}},
+			{Keys: bson.D{{Key: "status", Value: 1}, {Key: "created_at", Value: -1}}},
+		}},
+		{"issues", []mongo.IndexModel{
+			{Keys: bson.D{{Key: "order_id", Value: 1}, {Key: "lines.ingredient_id", Value: 1}}, Options: options.Index().SetUnique(true)},
+			{Keys: bson.D{{Key: "user_id", Value: 1}, {Key: "created_at", Value: -1}}},
+			{Keys: bson.D{{Key: "vendor_id", Value: 1}, {Key: "status", Value: 1}, {Key: "created_at", Value: -1}}},
+			{Keys: bson.D{{Key: "status", Value: 1}, {Key: "respond_by", Value: 1}}},
+		}},
+		{"subscriptions", []mongo.IndexModel{
+			{Keys: bson.D{{Key: "user_id", Value: 1}, {Key: "created_at", Value: 1}}},
+			{Keys: bson.D{{Key: "status", Value: 1}, {Key: "next_run", Value: 1}}},
+		}},
+		{"price_history", []mongo.IndexModel{
+			{Keys: bson.D{{Key: "vendor_id", Value: 1}, {Key: "store_id", Value: 1}, {Key: "item_id", Value: 1}, {Key: "at", Value: 1}}},
+		}},
+		{"price_alerts", []mongo.IndexModel{
+			{Keys: bson.D{{Key: "user_id", Value: 1}, {Key: "created_at", Value: -1}}},
+		}},
+		{"inventory_ledger", []mongo.IndexModel{
+			{Keys: bson.D{{Key: "vendor_id", Value: 1}, {Key: "store_id", Value: 1}, {Key: "at", Value: -1}}},
+			{Keys: bson.D{{Key: "vendor_id", Value: 1}, {Key: "store_id", Value: 1}, {Key: "item_id", Value: 1}, {Key: "at", Value: -1}}},
+		}},
+		{"catalog_audit", []mongo.IndexModel{
+			{Keys: bson.D{{Key: "target_id", Value: 1}, {Key: "at", Value: -1}}},
+			{Keys: bson.D{{Key: "at", Value: -1}}},
+		}},
+	}
+
+	for _, index := range indexes {
+		names, err := db.Collection(index.collection).Indexes().CreateMany(ctx, index.models)
+		if err != nil {
+			Logger.ErrorContext(ctx, "Unable to create indexes", slog.String("collection", index.collection),
+				slog.Any("error", err), mongo_source)
+			return err
+		}
+		Logger.InfoContext(ctx, "Indexes are in place", slog.String("collection", index.collection),
+			slog.Any("indexes", names), mongo_source)
+	}
+	return nil
+}
@@ -0,0 +1,117 @@
+package main
+
+import (
+	"math"
+	"slices"
+	"strings"
+	"unicode"
+)
+
+const (
+	matchCatalog    = "catalog"
+	matchExact      = "exact"
+	matchNormalized = "normalized"
+	matchSynonym    = "synonym"
+	matchFuzzy      = "fuzzy"
+
+	// fuzzy matches below this similarity are treated as different ingredients
+	fuzzyThreshold = 0.8
+	// fuzzy confidence is scaled so it always ranks below a synonym
+	fuzzyWeight = 0.85
+)
+
+var matchConfidence = map[string]float64{
+	matchCatalog:    1,
+	matchExact:      1,
+	matchNormalized: 0.95,
+	matchSynonym:    0.9,
+}
+
+// normalizeName lower cases, drops punctuation and singularizes, "Roma  Tomatoes" becomes "roma tomato"
+func normalizeName(name string) string {
+	words := strings.FieldsFunc(strings.ToLower(name), func(r rune) bool {
+		return !unicode.IsLetter(r) && !unicode.IsNumber(r)
+	})
+	for i, word := range words {
+		words[i] = singular(word)
+	}
+	return strings.Join(words, " ")
+}
+
+func singular(word string) string {
+	switch {
+	case len(word) <= 3:
+		return word
+	case strings.HasSuffix(word, "ies"):
+		return word[:len(word)-3] + "y"
+	case strings.HasSuffix(word, "oes"), strings.HasSuffix(word, "ches"), strings.HasSuffix(word, "shes"),
+		strings.HasSuffix(word, "xes"), strings.HasSuffix(word, "sses"):
+		return word[:len(word)-2]
+	case strings.HasSuffix(word, "ss"), strings.HasSuffix(word, "us"):
+		return word
+	case strings.HasSuffix(word, "s"):
+		return word[:len(word)-1]
+	}
+	return word
+}
+
+// nameKeys are the normalized words of a name, stored on items so compare can look them up by prefix
+func nameKeys(name string) []string {
+	return strings.Fields(normalizeName(name))
+}
+
+// wordStem keeps enough of a normalized word to find its plural and simple misspellings
+func wordStem(word string) string {
+	r := []rune(word)
+	if len(r) <= 4 {
+		return word
+	}
+	return string(r[:max(4, len(r)-1)])
+}
+
+// similarity scores two normalized names from 0 to 1 by edit distance or word containment
+func similarity(a, b string, ingredient func(word string) bool) float64 {
+	if a == "" || b == "" {
+		return 0
+	}
+	ra, rb := []rune(a), []rune(b)
+	score := 1 - float64(levenshtein(ra, rb))/float64(max(len(ra), len(rb)))
+
+	small, large := strings.Fields(a), strings.Fields(b)
+	if len(small) > len(large) {
+		small, large = large, small
+	}
+	if small[len(small)-1] != large[len(large)-1] {
+		return score
+	}
+	for _, word := range large {
+		if !slices.Contains(small, word) && ingredient(word) {
+			return score
+		}
+	}
+	for _, word := range small {
+		if !slices.Contains(large, word) {
+			return score
+		}
+	}
+	return math.Max(score, fuzzyThreshold+(1-fuzzyThreshold)*float64(len(small))/float64(len(large)))
+}
+
+func levenshtein(a, b []rune) int {
+	prev, curr := make([]int, len(b)+1), make([]int, len(b)+1)
+	for j := range prev {
+		prev[j] = j
+	}
+	for i := 1; i <= len(a); i++ {
+		curr[0] = i
+		for j := 1; j <= len(b); j++ {
+			cost := 1
+			if a[i-1] == b[j-1] {
+				cost = 0
+			}
+			curr[j] = min(prev[j]+1, curr[j-1]+1, prev[j-1]+cost)
+		}
+		prev, curr = curr, prev
+	}
+	return prev[len(b)]
+}
@@ -0,0 +1,55 @@
+package main
+
+import "testing"
+
+func TestNormalizeName(t *testing.T) {
+	tests := map[string]string{
+		"Tomatoes":          "tomato",
+		"  Roma   Tomatoes": "roma tomato",
+		"Berries":           "berry",
+		"Peaches":           "peach",
+		"Couscous":          "couscous",
+		"Egg, large":        "egg large",
+	}
+	for name, want := range tests {
+		if got := normalizeName(name); got != want {
+			t.Fatalf("%q -> expected %q got %q", name, want, got)
+		}
+	}
+}
+
+func TestMatchName(t *testing.T) {
+	matcher := newIngredientMatcher([]*Ingredient{
+		{Name: "Green Onion", Aliases: []string{"Scallions", "spring onion"}},
+		{Name: "Peanuts"},
+	})
+
+	tests := []struct {
+		item, req string
+		matchType string
+	}{
+		{"Tomato", "Tomato", matchExact},
+		{"Tomatoes", "tomato", matchNormalized},
+		{"scallion", "Green onions", matchSynonym},
+		{"Spring Onions", "scallions", matchSynonym},
+		{"roma tomato", "Tomatoes", matchFuzzy},
+		{"tomatoe", "tomato", matchFuzzy},
+		{"Potato", "Tomato", ""},
+		{"Milk", "Oat milk powder", ""},
+		{"Peanut Butter", "butter", ""},
+		{"Milk Chocolate", "milk", ""},
+		{"milk", "Milk Chocolate", ""},
+		{"Free Range Eggs", "eggs", matchFuzzy},
+		{"Whole Milk", "milk", matchFuzzy},
+	}
+
+	for _, test := range tests {
+		matchType, confidence, ok := matcher.matchName(test.item, test.req)
+		if ok != (test.matchType != "") || matchType != test.matchType {
+			t.Fatalf("%q vs %q -> expected %q got %q", test.item, test.req, test.matchType, matchType)
+		}
+		if ok && (confidence <= 0 || confidence > 1 || (matchType == matchFuzzy && confidence >= matchConfidence[matchSynonym])) {
+			t.Fatalf("%q vs %q -> confidence %f out of range for %s", test.item, test.req, confidence, matchType)
+		}
+	}
+}
@@ -0,0 +1,206 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net"
+	"net/http"
+	"net/netip"
+	"net/url"
+	"slices"
+	"strings"
+	"syscall"
+	"time"
+
+	"go.mongodb.org/mongo-driver/v2/bson"
+	"go.mongodb.org/mongo-driver/v2/mongo"
+	"go.mongodb.org/mongo-driver/v2/mongo/options"
+	"go.opentelemetry.io/contrib/instrumentation/net/http/otelhttp"
+)
+
+var (
+	notification_repo_source = slog.Any("source", "NotificationRepository")
+
+	// notificationKinds are the events an account can have delivered
+	notificationKinds = []string{alertLowStock, alertOutOfStock, alertPriceDrop, alertOrderIssue, alertSubscription}
+
+	webhookClient = newWebhookClient(publicAddr)
+
+	errWebhookAddress = errors.New("webhook_url must point to a public address")
+
+	// sharedAddrSpace is the carrier grade NAT range, private to the provider's network
+	sharedAddrSpace = netip.MustParsePrefix("100.64.0.0/10")
+)
+
+// publicAddr is false for the addresses a webhook must not reach, the server itself and the networks it sits in
+func publicAddr(addr netip.Addr) bool {
+	addr = addr.Unmap()
+	return addr.IsGlobalUnicast() && !addr.IsPrivate() && !sharedAddrSpace.Contains(addr)
+}
+
+// newWebhookClient checks every address it connects to against allow once the host is resolved, so a name
+// that resolves to an internal address is refused as well. Redirects are not followed, the webhook has to
+// answer itself
+func newWebhookClient(allow func(netip.Addr) bool) *http.Client {
+	dialer := &net.Dialer{Timeout: 5 * time.Second, Control: func(_, address string, _ syscall.RawConn) error {
+		host, _, err := net.SplitHostPort(address)
+		if err != nil {
+			return err
+		}
+		if addr, err := netip.ParseAddr(host); err != nil || !allow(addr) {
+			return errWebhookAddress
+		}
+		return nil
+	}}
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	transport.Proxy, transport.DialContext = nil, dialer.DialContext
+	return &http.Client{
+		Timeout:   5 * time.Second,
+		Transport: otelhttp.NewTransport(transport),
+		CheckRedirect: func(*http.Request, []*http.Request) error {
+			return http.ErrUseLastResponse
+		},
+	}
+}
+
+// Event is the body posted to a webhook
+type Event struct {
+	Kind string    `json:"kind"`
+	At   time.Time `json:"at"`
+	Data any       `json:"data"`
+}
+
+type NotificationRepository interface {
+	FindNotificationSettings(context.Context, ID) (*NotificationSettings, error)
+	UpdateNotificationSettings(context.Context, ID, *NotificationSettings) error
+}
+
+type MongoNotificationRepository struct{ col *mongo.Collection }
+
+func newMongoNotificationRepository(client *mongo.Client, dbName string) NotificationRepository {
+	return &MongoNotificationRepository{col: client.Database(dbName).Collection("notification_settings")}
+}
+
+func (s *NotificationSettings) validate() error {
+	if s.WebhookURL != "" {
+		u, err := url.Parse(s.WebhookURL)
+		if err != nil || (u.Scheme != "https" && u.Scheme != "http") || u.Host == "" {
+			return errors.New("webhook_url must be an http or https url")
+		}
+		host := strings.TrimSuffix(strings.ToLower(u.Hostname()), ".")
+		if host == "localhost" || strings.HasSuffix(host, ".localhost") {
+			return errWebhookAddress
+		}
+		if addr, err := netip.ParseAddr(host); err == nil && !publicAddr(addr) {
+			return errWebhookAddress
+		}
+	}
+	for _, kind := range s.Kinds {
+		if !slices.Contains(notificationKinds, kind) {
+			return fmt.Errorf("unknown notification kind %q", kind)
+		}
+	}
+	if s.Kinds == nil {
+		s.Kinds = []string{}
+	}
+	return nil
+}
+
+func (s *NotificationSettings) wants(kind string) bool {
+	return s != nil && s.WebhookURL != "" && (len(s.Kinds) == 0 || slices.Contains(s.Kinds, kind))
+}
+
+// FindNotificationSettings returns nil settings for an account that never set them up
+func (m MongoNotificationRepository) FindNotificationSettings(ctx context.Context, owner ID) (*NotificationSettings, error) {
+	ctx, span := Tracer.Start(ctx, "FindNotificationSettings")
+	defer span.End()
+
+	var settings NotificationSettings
+	if err := m.col.FindOne(ctx, bson.D{{Key: "_id", Value: owner.value}}).Decode(&settings); err != nil {
+		if errors.Is(err, mongo.ErrNoDocuments) {
+			return nil, nil
+		}
+		Logger.ErrorContext(ctx, "Error finding notification settings", slog.String("ownerID", owner.String()),
+			slog.Any("error", err), notification_repo_source)
+		return nil, err
+	}
+	return &settings, nil
+}
+
+func (m MongoNotificationRepository) UpdateNotificationSettings(ctx context.Context, owner ID, settings *NotificationSettings) error {
+	ctx, span := Tracer.Start(ctx, "UpdateNotificationSettings")
+	defer span.End()
+
+	Logger.InfoContext(ctx, "Updating notification settings", slog.String("ownerID", owner.String()), notification_repo_source)
+	if err := settings.validate(); err != nil {
+		return err
+	}
+	settings.OwnerID, settings.UpdatedAt = owner.value, time.Now()
+
+	opts := options.Replace().SetUpsert(true)
+	if _, err := m.col.ReplaceOne(ctx, bson.D{{Key: "_id", Value: owner.value}}, settings, opts); err != nil {
+		Logger.ErrorContext(ctx, "Error saving notification settings", slog.Any("error", err), notification_repo_source)
+		return err
+	}
+	return nil
+}
+
+// notify delivers the event to the account's webhook, it is false when the account does not want the event
+func notify(ctx context.Context, owner ID, kind string, data any) (bool, error) {
+	settings, err := Repos.Notification.FindNotificationSettings(ctx, owner)
+	if err != nil {
+		return false, err
+	}
+	if !settings.wants(kind) {
+		return false, nil
+	}
+	return true, deliver(ctx, settings, &Event{Kind: kind, At: time.Now(), Data: data})
+}
+
+// deliver posts the event to the webhook, with a secret the body is signed so the receiver can check it came
+// from us
+func deliver(ctx context.Context, settings *NotificationSettings, event *Event) error {
+	ctx, span := Tracer.Start(ctx, "deliver")
+	defer span.End()
+
+	body, err := json.Marshal(event)
+	if err != nil {
+		return err
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, settings.WebhookURL, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("X-Ordelo-Event", event.Kind)
+	if settings.Secret != "" {
+		req.Header.Set("X-Ordelo-Signature", "sha256="+signPayload(settings.Secret, body))
+	}
+
+	res, err := webhookClient.Do(req)
+	if err != nil {
+		Logger.ErrorContext(ctx, "Webhook delivery failed", slog.String("kind", event.Kind), slog.Any("error", err),
+			notification_repo_source)
+		return err
+	}
+	defer res.Body.Close()
+	if res.StatusCode < 200 || res.StatusCode > 299 {
+		Logger.ErrorContext(ctx, "Webhook refused the event", slog.String("kind", event.Kind),
+			slog.Int("status", res.StatusCode), notification_repo_source)
+		return fmt.Errorf("webhook answered %s", res.Status)
+	}
+	return nil
+}
+
+func signPayload(secret string, body []byte) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write(body)
+	return hex.EncodeToString(mac.Sum(nil))
+}
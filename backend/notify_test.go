@@ -0,0 +1,107 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/netip"
+	"testing"
+)
+
+func TestNotificationSettings(t *testing.T) {
+	tests := []struct {
+		settings NotificationSettings
+		ok       bool
+	}{
+		{NotificationSettings{WebhookURL: "https://example.com/hook"}, true},
+		{NotificationSettings{WebhookURL: "ftp://example.com/hook"}, false},
+		{NotificationSettings{WebhookURL: "example.com/hook"}, false},
+		{NotificationSettings{WebhookURL: "http://169.254.169.254/latest/meta-data"}, false},
+		{NotificationSettings{WebhookURL: "http://localhost:8080/hook"}, false},
+		{NotificationSettings{WebhookURL: "http://10.0.0.7/hook"}, false},
+		{NotificationSettings{WebhookURL: "http://[::1]/hook"}, false},
+		{NotificationSettings{WebhookURL: "http://[::ffff:192.168.1.1]/hook"}, false},
+		{NotificationSettings{WebhookURL: "http://93.184.216.34/hook"}, true},
+		{NotificationSettings{Kinds: []string{alertOutOfStock}}, true},
+		{NotificationSettings{Kinds: []string{"weather"}}, false},
+	}
+	for i, test := range tests {
+		if err := test.settings.validate(); (err == nil) != test.ok {
+			t.Fatalf("case %d -> expected ok %v got %v", i, test.ok, err)
+		}
+	}
+
+	var none *NotificationSettings
+	if none.wants(alertLowStock) {
+		t.Fatal("expected no settings to want nothing")
+	}
+	settings := &NotificationSettings{WebhookURL: "https://example.com", Kinds: []string{alertOutOfStock}}
+	if settings.wants(alertLowStock) || !settings.wants(alertOutOfStock) {
+		t.Fatal("expected only out of stock events to be wanted")
+	}
+}
+
+// allowLoopback lets deliver reach the test servers, which listen on loopback
+func allowLoopback(t *testing.T) {
+	client := webhookClient
+	webhookClient = newWebhookClient(func(addr netip.Addr) bool { return addr.IsLoopback() })
+	t.Cleanup(func() { webhookClient = client })
+}
+
+func TestDeliver(t *testing.T) {
+	allowLoopback(t)
+	var event Event
+	var signature string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		signature = r.Header.Get("X-Ordelo-Signature")
+		if signature != "sha256="+signPayload("secret", body) {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		json.Unmarshal(body, &event)
+	}))
+	defer server.Close()
+
+	settings := &NotificationSettings{WebhookURL: server.URL, Secret: "secret"}
+	if err := deliver(context.Background(), settings, &Event{Kind: alertLowStock, Data: []string{"milk"}}); err != nil {
+		t.Fatal(err)
+	}
+	if event.Kind != alertLowStock {
+		t.Fatalf("expected a low stock event got %+v", event)
+	}
+
+	settings.Secret = "wrong"
+	if err := deliver(context.Background(), settings, &Event{Kind: alertLowStock}); err == nil {
+		t.Fatal("expected a refused webhook to fail")
+	}
+}
+
+func TestWebhookClient(t *testing.T) {
+	hits := 0
+	target := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) { hits++ }))
+	defer target.Close()
+	redirect := httptest.NewServer(http.RedirectHandler(target.URL, http.StatusTemporaryRedirect))
+	defer redirect.Close()
+
+	settings := &NotificationSettings{WebhookURL: target.URL}
+	if err := deliver(context.Background(), settings, &Event{Kind: alertLowStock}); !errors.Is(err, errWebhookAddress) {
+		t.Fatalf("expected loopback to be refused got %v", err)
+	}
+
+	allowLoopback(t)
+	settings.WebhookURL = redirect.URL
+	if err := deliver(context.Background(), settings, &Event{Kind: alertLowStock}); err == nil || hits != 0 {
+		t.Fatalf("expected the redirect not to be followed got %v after %d hits", err, hits)
+	}
+
+	for addr, public := range map[string]bool{"8.8.8.8": true, "2606:4700::1111": true, "127.0.0.1": false,
+		"169.254.169.254": false, "172.16.3.4": false, "100.64.0.1": false, "fd00::1": false, "0.0.0.0": false} {
+		if publicAddr(netip.MustParseAddr(addr)) != public {
+			t.Fatalf("%s: expected public %v", addr, public)
+		}
+	}
+}
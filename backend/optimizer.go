@@ -0,0 +1,213 @@
+package main
+
+import (
+	"cmp"
+	"errors"
+	"math"
+	"slices"
+
+	"go.mongodb.org/mongo-driver/v2/bson"
+)
+
+const (
+	defaultMaxStores = 3
+	maxMaxStores     = 5
+	// keeps the subset enumeration at C(20, 5) = 15504 plans in the worst case
+	maxCandidateStores = 20
+)
+
+var errCannotCoverAll = errors.New("no combination of stores within the constraints covers every ingredient")
+
+func (req *OptimizeReq) validate() error {
+	if err := req.ReqIngArray.validate(); err != nil {
+		return err
+	}
+	switch {
+	case req.MaxStores == 0:
+		req.MaxStores = defaultMaxStores
+	case req.MaxStores < 0 || req.MaxStores > maxMaxStores:
+		return errors.New("max_stores must be between 1 and 5")
+	}
+	return nil
+}
+
+type candidateStore struct {
+	vendorID bson.ObjectID
+	match    *StoreMatch
+	// costs[i] is what covering the ith requested ingredient costs in this store, +Inf when it is not carried
+	costs []float64
+}
+
+type basketChoice struct {
+	stores  []int
+	assign  []int
+	covered int
+	cost    float64
+}
+
+// optimizeBasket finds the set of at most req.MaxStores stores that covers the most ingredients for the least
+// money, every ingredient is bought from the cheapest store of the set that carries it. The set is the best
+// there is when the plan is exhaustive, otherwise it is the best among the stores pruneCandidates kept
+func optimizeBasket(results []*ResIng, req *OptimizeReq) (*BasketPlan, error) {
+	candidates, exhaustive := pruneCandidates(newCandidates(results, req.Compare))
+	if len(candidates) == 0 {
+		return nil, &NoItems{}
+	}
+
+	var best *basketChoice
+	subset := make([]int, 0, req.MaxStores)
+	var walk func(start int)
+	walk = func(start int) {
+		if len(subset) > 0 {
+			if choice := evaluateSubset(candidates, subset, len(req.Compare)); best == nil || betterChoice(choice, best) {
+				best = choice
+			}
+		}
+		if len(subset) == req.MaxStores {
+			return
+		}
+		for i := start; i < len(candidates); i++ {
+			subset = append(subset, i)
+			walk(i + 1)
+			subset = subset[:len(subset)-1]
+		}
+	}
+	walk(0)
+
+	if req.MustCoverAll && best.covered < len(req.Compare) {
+		return nil, errCannotCoverAll
+	}
+	plan := buildPlan(candidates, best, req.Compare)
+	plan.Exhaustive = exhaustive
+	return plan, nil
+}
+
+func newCandidates(results []*ResIng, reqIngs []*ReqIng) []*candidateStore {
+	var candidates []*candidateStore
+	for _, res := range results {
+		for _, match := range res.Stores {
+			costs := make([]float64, len(reqIngs))
+			for i := range reqIngs {
+				costs[i] = math.Inf(1)
+				if i < len(match.picks) && match.picks[i] != nil {
+					costs[i] = match.picks[i].linePrice(match.packs[i])
+				}
+			}
+			candidates = append(candidates, &candidateStore{vendorID: res.ID, match: match, costs: costs})
+		}
+	}
+	return candidates
+}
+
+// pruneCandidates drops every store that another store beats or equals on every ingredient, such a store can
+// never make a plan cheaper, so what is left still holds the best plan and exhaustive is true. When more than
+// maxCandidateStores are left it keeps the cheapest store of every ingredient and then the ones covering the
+// most, a cheap store that covers little can be dropped then and exhaustive is false
+func pruneCandidates(candidates []*candidateStore) (kept []*candidateStore, exhaustive bool) {
+	dominates := func(a, b *candidateStore) bool {
+		strictly := false
+		for i := range a.costs {
+			if a.costs[i] > b.costs[i] {
+				return false
+			}
+			if a.costs[i] < b.costs[i] {
+				strictly = true
+			}
+		}
+		return strictly
+	}
+
+	for i, c := range candidates {
+		dominated := false
+		for j, other := range candidates {
+			// identical stores keep only the first one
+			if i != j && (dominates(other, c) || (j < i && slices.Equal(other.costs, c.costs))) {
+				dominated = true
+				break
+			}
+		}
+		if !dominated {
+			kept = append(kept, c)
+		}
+	}
+
+	slices.SortStableFunc(kept, func(a, b *candidateStore) int {
+		return compareStoreMatches(sortByCoverage)(a.match, b.match)
+	})
+	if len(kept) <= maxCandidateStores {
+		return kept, true
+	}
+
+	var cheapest []*candidateStore
+	for i := range kept[0].costs {
+		best := slices.MinFunc(kept, func(a, b *candidateStore) int { return cmp.Compare(a.costs[i], b.costs[i]) })
+		if !math.IsInf(best.costs[i], 1) && !slices.Contains(cheapest, best) {
+			cheapest = append(cheapest, best)
+		}
+	}
+	for _, c := range kept {
+		if len(cheapest) >= maxCandidateStores {
+			break
+		}
+		if !slices.Contains(cheapest, c) {
+			cheapest = append(cheapest, c)
+		}
+	}
+	return cheapest[:min(len(cheapest), maxCandidateStores)], false
+}
+
+func evaluateSubset(candidates []*candidateStore, subset []int, n int) *basketChoice {
+	choice := &basketChoice{stores: slices.Clone(subset), assign: make([]int, n)}
+	for i := range n {
+		choice.assign[i] = -1
+		cheapest := math.Inf(1)
+		for _, c := range subset {
+			if cost := candidates[c].costs[i]; cost < cheapest {
+				cheapest, choice.assign[i] = cost, c
+			}
+		}
+		if choice.assign[i] != -1 {
+			choice.covered++
+			choice.cost += cheapest
+		}
+	}
+	return choice
+}
+
+// betterChoice prefers more coverage, then a lower cost and then fewer stores to visit
+func betterChoice(a, b *basketChoice) bool {
+	if a.covered != b.covered {
+		return a.covered > b.covered
+	}
+	if c := cmp.Compare(a.cost, b.cost); c != 0 {
+		return c < 0
+	}
+	return len(a.stores) < len(b.stores)
+}
+
+func buildPlan(candidates []*candidateStore, choice *basketChoice, reqIngs []*ReqIng) *BasketPlan {
+	plan := &BasketPlan{Carts: []*Cart{}, Missing: []*ReqIng{}}
+	carts := make(map[int]*Cart)
+
+	for i, c := range choice.assign {
+		if c == -1 {
+			plan.Missing = append(plan.Missing, reqIngs[i])
+			continue
+		}
+
+		cart, ok := carts[c]
+		if !ok {
+			cart = &Cart{VendorID: candidates[c].vendorID, StoreID: candidates[c].match.ID, Items: []*Item{}}
+			carts[c] = cart
+			plan.Carts = append(plan.Carts, cart)
+		}
+
+		item := createMatchItem(candidates[c].match.picks[i])
+		item.Quantity = candidates[c].match.packs[i]
+		cart.Items = append(cart.Items, item)
+		cart.TotalPrice += candidates[c].costs[i]
+	}
+
+	plan.TotalPrice = choice.cost
+	return plan
+}
@@ -0,0 +1,104 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"testing"
+
+	"go.mongodb.org/mongo-driver/v2/bson"
+)
+
+func TestOptimizeBasket(t *testing.T) {
+	stores := []*Store{
+		{ID: bson.NewObjectID(), Items: []*Item{newTestItem("Milk", 1, "litre", 2), newTestItem("Eggs", 12, "count", 10)}},
+		{ID: bson.NewObjectID(), Items: []*Item{newTestItem("Milk", 1, "litre", 5), newTestItem("Eggs", 12, "count", 3)}},
+		{ID: bson.NewObjectID(), Items: []*Item{newTestItem("Flour", 1, "kg", 4)}},
+		{ID: bson.NewObjectID(), Items: []*Item{newTestItem("Flour", 1, "kg", 6)}},
+	}
+	compare := []*ReqIng{
+		{Name: "Milk", UnitQuantity: 1, Unit: "litre"},
+		{Name: "Eggs", UnitQuantity: 12, Unit: "count"},
+		{Name: "Flour", UnitQuantity: 1, Unit: "kg"},
+	}
+
+	newResults := func() []*ResIng {
+		var res []*ResIng
+		for _, store := range stores {
+			match := newIngredientMatcher(nil).matchStore(store, &ReqIngArray{Compare: compare})
+			res = append(res, &ResIng{ID: bson.NewObjectID(), Stores: []*StoreMatch{match}})
+		}
+		return res
+	}
+
+	tests := []struct {
+		maxStores    int
+		mustCoverAll bool
+		carts        int
+		total        float64
+		missing      int
+	}{
+		{1, false, 1, 8, 1},
+		{2, true, 2, 12, 0},
+		{3, true, 3, 9, 0},
+		{5, true, 3, 9, 0},
+	}
+
+	for _, test := range tests {
+		req := &OptimizeReq{ReqIngArray: ReqIngArray{Compare: compare}, MaxStores: test.maxStores, MustCoverAll: test.mustCoverAll}
+		plan, err := optimizeBasket(newResults(), req)
+		if err != nil {
+			t.Fatalf("max stores %d -> %v", test.maxStores, err)
+		}
+		if !plan.Exhaustive || len(plan.Carts) != test.carts || plan.TotalPrice != test.total || len(plan.Missing) != test.missing {
+			t.Fatalf("max stores %d -> expected %d carts %f total %d missing got %d carts %f total %d missing",
+				test.maxStores, test.carts, test.total, test.missing, len(plan.Carts), plan.TotalPrice, len(plan.Missing))
+		}
+
+		var sum float64
+		for _, cart := range plan.Carts {
+			sum += cart.TotalPrice
+		}
+		if sum != plan.TotalPrice {
+			t.Fatalf("max stores %d -> cart totals %f do not add up to %f", test.maxStores, sum, plan.TotalPrice)
+		}
+	}
+
+	req := &OptimizeReq{ReqIngArray: ReqIngArray{Compare: compare}, MaxStores: 1, MustCoverAll: true}
+	if _, err := optimizeBasket(newResults(), req); !errors.Is(err, errCannotCoverAll) {
+		t.Fatalf("expected errCannotCoverAll got %v", err)
+	}
+}
+
+func TestOptimizeBasketManyStores(t *testing.T) {
+	compare := []*ReqIng{
+		{Name: "Apple", UnitQuantity: 1, Unit: "kg"},
+		{Name: "Bread", UnitQuantity: 1, Unit: "kg"},
+		{Name: "Cheese", UnitQuantity: 1, Unit: "kg"},
+	}
+	var stores []*Store
+	for i := range maxCandidateStores + 5 {
+		stores = append(stores, &Store{ID: bson.NewObjectID(), Name: fmt.Sprintf("store %d", i), Items: []*Item{
+			newTestItem("Apple", 1, "kg", float64(10+i)), newTestItem("Bread", 1, "kg", float64(40-i)),
+			newTestItem("Cheese", 1, "kg", 40)}})
+	}
+	// covers the least so the coverage order puts it last, it is still part of the cheapest plan
+	cheese := &Store{ID: bson.NewObjectID(), Items: []*Item{newTestItem("Cheese", 1, "kg", 1)}}
+	stores = append(stores, cheese)
+
+	var res []*ResIng
+	for _, store := range stores {
+		match := newIngredientMatcher(nil).matchStore(store, &ReqIngArray{Compare: compare})
+		res = append(res, &ResIng{ID: bson.NewObjectID(), Stores: []*StoreMatch{match}})
+	}
+	req := &OptimizeReq{ReqIngArray: ReqIngArray{Compare: compare}, MaxStores: 2, MustCoverAll: true}
+	plan, err := optimizeBasket(res, req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if plan.Exhaustive {
+		t.Fatal("expected a pruned search not to be exhaustive")
+	}
+	if plan.TotalPrice != 51 || len(plan.Carts) != 2 || (plan.Carts[0].StoreID != cheese.ID && plan.Carts[1].StoreID != cheese.ID) {
+		t.Fatalf("expected the cheese store in a 51 plan got %v with %d carts", plan.TotalPrice, len(plan.Carts))
+	}
+}
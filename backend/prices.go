@@ -0,0 +1,455 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"math"
+	"net/url"
+	"slices"
+	"time"
+
+	"go.mongodb.org/mongo-driver/v2/bson"
+	"go.mongodb.org/mongo-driver/v2/mongo"
+	"go.mongodb.org/mongo-driver/v2/mongo/options"
+)
+
+const (
+	alertPriceDrop = "price_drop"
+
+	priceAlertInterval      = 15 * time.Minute
+	defaultPriceHistoryDays = 90
+	maxPricePoints          = 1000
+	maxPriceAlerts          = 50
+)
+
+var (
+	price_repo_source = slog.Any("source", "PriceRepository")
+
+	errTooManyPriceAlerts = fmt.Errorf("a user can have at most %d price alerts", maxPriceAlerts)
+)
+
+// priceChanges is a point for every item whose price differs between the snapshots, an item that is new starts
+// its history with the price it was created at
+func priceChanges(before, after stockSnapshot) []*PricePoint {
+	var points []*PricePoint
+	for k, count := range after {
+		previous, existed := before[k]
+		if existed && previous.price == count.price {
+			continue
+		}
+		points = append(points, &PricePoint{
+			StoreID:       k.store,
+			ItemID:        k.item,
+			CatalogID:     count.catalog,
+			Name:          count.name,
+			Price:         count.price,
+			PreviousPrice: previous.price,
+		})
+	}
+	slices.SortFunc(points, func(a, b *PricePoint) int {
+		if c := bytes.Compare(a.StoreID[:], b.StoreID[:]); c != 0 {
+			return c
+		}
+		return bytes.Compare(a.ItemID[:], b.ItemID[:])
+	})
+	return points
+}
+
+func (m MongoVendorRepository) recordPrices(sessCtx context.Context, vendorID ID, points []*PricePoint, reason string,
+	now time.Time) error {
+	if len(points) == 0 {
+		return nil
+	}
+	docs := make([]any, len(points))
+	for i, point := range points {
+		point.ID, point.VendorID, point.Reason, point.At = bson.NewObjectID(), vendorID.value, reason, now
+		docs[i] = point
+	}
+	if _, err := m.prices.InsertMany(sessCtx, docs); err != nil {
+		Logger.ErrorContext(sessCtx, "Error recording price changes", slog.String("vendorID", vendorID.String()),
+			slog.Any("error", err), vendor_repo_source)
+		return err
+	}
+	return nil
+}
+
+// parseTimeRange reads the from and to query parameters, either can be left out
+func parseTimeRange(q url.Values) (from, to time.Time, err error) {
+	for key, value := range map[string]*time.Time{"from": &from, "to": &to} {
+		if s := q.Get(key); s != "" {
+			t, err := time.Parse(time.RFC3339, s)
+			if err != nil {
+				return from, to, fmt.Errorf("%s must be an RFC 3339 time", key)
+			}
+			*value = t
+		}
+	}
+	if !from.IsZero() && !to.IsZero() && to.Before(from) {
+		return from, to, errors.New("to can not be before from")
+	}
+	return from, to, nil
+}
+
+func newPriceHistory(points []*PricePoint) *PriceHistory {
+	history := &PriceHistory{Points: points}
+	if len(points) == 0 {
+		history.Points = []*PricePoint{}
+		return history
+	}
+	history.Low, history.High = math.Inf(1), math.Inf(-1)
+	sum := 0.0
+	for _, point := range points {
+		history.Low, history.High = min(history.Low, point.Price), max(history.High, point.Price)
+		sum += point.Price
+	}
+	history.Average = roundCents(sum / float64(len(points)))
+	return history
+}
+
+// basePer is the base unit prices of the unit are quoted per, kg, l or each
+func basePer(unit string) (string, bool) {
+	def, ok := lookupUnit(unit)
+	if !ok {
+		return "", false
+	}
+	return baseUnits[def.dim].name, true
+}
+
+// quotePer is what the pack costs per base unit per, packs measured in the other of mass and volume convert with
+// the density
+func quotePer(price float64, quantity int, unit, per string, density float64) (float64, bool) {
+	pack, ok := toAmount(float64(quantity), unit)
+	if !ok {
+		return 0, false
+	}
+	for dim, base := range baseUnits {
+		if base.name != per {
+			continue
+		}
+		value, ok := pack.in(dim, density)
+		if !ok || value <= 0 {
+			return 0, false
+		}
+		return math.Round(price/value*base.factor*100) / 100, true
+	}
+	return 0, false
+}
+
+func (a *PriceAlert) validate() error {
+	switch {
+	case a.CatalogID.IsZero():
+		return errors.New("a price alert needs a catalog_id")
+	case a.TargetPrice < 0:
+		return errors.New("target_price can not be negative")
+	}
+	if err := a.Location.validPoint(); err != nil {
+		return fmt.Errorf("location: %w", err)
+	}
+	radius, err := validRadius(a.Radius)
+	if err != nil {
+		return err
+	}
+	a.Radius = radius
+	return nil
+}
+
+// cheapestNearby is the item linked to the alert's ingredient with the lowest unit price at a store within the
+// radius, promotions that ended have to be dropped from the stores first
+func cheapestNearby(vendors []*Vendor, alert *PriceAlert) *PriceDrop {
+	var best *PriceDrop
+	for _, vendor := range vendors {
+		for _, store := range vendor.Stores {
+			if store.Location.validPoint() != nil || haversineMiles(alert.Location, store.Location) > alert.Radius {
+				continue
+			}
+			for _, item := range store.Items {
+				if item == nil || item.CatalogID != alert.CatalogID || item.Quantity <= 0 {
+					continue
+				}
+				price, ok := quotePer(item.unitPrice(), item.UnitQuantity, item.Unit, alert.Per, alert.Density)
+				if !ok || (best != nil && price >= best.UnitPrice.Price) {
+					continue
+				}
+				best = &PriceDrop{
+					AlertID:   alert.ID,
+					UserID:    alert.UserID,
+					CatalogID: alert.CatalogID,
+					Name:      alert.Name,
+					VendorID:  vendor.ID,
+					StoreID:   store.ID,
+					StoreName: store.Name,
+					Item:      item,
+					UnitPrice: &UnitPrice{Price: price, Per: alert.Per},
+				}
+			}
+		}
+	}
+	return best
+}
+
+// evaluate moves the alert to the best price nearby and returns the drop to notify about, a drop is a price
+// below the last one that reaches the target. With a target the first price seen at or under it counts too
+func (a *PriceAlert) evaluate(best *PriceDrop) *PriceDrop {
+	if best == nil {
+		a.LastPrice = 0
+		return nil
+	}
+	price := best.UnitPrice.Price
+	reached := a.TargetPrice == 0 || price <= a.TargetPrice
+	dropped := (a.LastPrice > 0 && price < a.LastPrice) || (a.LastPrice == 0 && a.TargetPrice > 0)
+	best.PreviousPrice, a.LastPrice = a.LastPrice, price
+	if reached && dropped {
+		return best
+	}
+	return nil
+}
+
+// evaluatePriceAlerts is the price alert job, every drop is sent to the user who asked for it. A drop that
+// could not be delivered keeps the alert at its old price, so it is found again on the next run
+func evaluatePriceAlerts(ctx context.Context) error {
+	drops, err := Repos.Price.EvaluatePriceAlerts(ctx)
+	if err != nil {
+		return err
+	}
+
+	var notified []*PriceDrop
+	for _, drop := range drops {
+		sent, err := notify(ctx, ID{drop.UserID}, alertPriceDrop, drop)
+		if err != nil {
+			Logger.ErrorContext(ctx, "Unable to notify user", slog.String("userID", drop.UserID.Hex()),
+				slog.Any("error", err), price_repo_source)
+			continue
+		}
+		if sent {
+			notified = append(notified, drop)
+		}
+	}
+	if len(notified) > 0 {
+		return Repos.Price.MarkPriceAlertsNotified(ctx, notified)
+	}
+	return nil
+}
+
+// PriceRepository reads the price history the vendor repository records and keeps the users' price alerts
+type PriceRepository interface {
+	FindPriceHistory(context.Context, ID, ID, ID, time.Time, time.Time) (*PriceHistory, error)
+	CreatePriceAlert(context.Context, *PriceAlert) error
+	FindPriceAlerts(context.Context, ID) ([]*PriceAlert, error)
+	DeletePriceAlert(context.Context, ID, bson.ObjectID) error
+	EvaluatePriceAlerts(context.Context) ([]*PriceDrop, error)
+	MarkPriceAlertsNotified(context.Context, []*PriceDrop) error
+}
+
+type MongoPriceRepository struct {
+	col    *mongo.Collection
+	alerts *mongo.Collection
+	vendor *mongo.Collection
+}
+
+func newMongoPriceRepository(client *mongo.Client, dbName string) PriceRepository {
+	db := client.Database(dbName)
+	return &MongoPriceRepository{col: db.Collection("price_history"), alerts: db.Collection("price_alerts"),
+		vendor: db.Collection("vendor")}
+}
+
+// FindPriceHistory is the price points of the store item between from and to, oldest first
+func (m MongoPriceRepository) FindPriceHistory(ctx context.Context, vendorID, storeID, itemID ID, from, to time.Time) (*PriceHistory, error) {
+	ctx, span := Tracer.Start(ctx, "FindPriceHistory")
+	defer span.End()
+
+	at := bson.D{}
+	if !from.IsZero() {
+		at = append(at, bson.E{Key: "$gte", Value: from})
+	}
+	if !to.IsZero() {
+		at = append(at, bson.E{Key: "$lt", Value: to})
+	}
+	filter := bson.D{
+		{Key: "vendor_id", Value: vendorID.value},
+		{Key: "store_id", Value: storeID.value},
+		{Key: "item_id", Value: itemID.value},
+	}
+	if len(at) > 0 {
+		filter = append(filter, bson.E{Key: "at", Value: at})
+	}
+
+	opts := options.Find().SetSort(bson.D{{Key: "at", Value: 1}, {Key: "_id", Value: 1}}).SetLimit(maxPricePoints)
+	cursor, err := m.col.Find(ctx, filter, opts)
+	if err != nil {
+		Logger.ErrorContext(ctx, "Error reading the price history", slog.Any("error", err), price_repo_source)
+		return nil, err
+	}
+	var points []*PricePoint
+	if err := cursor.All(ctx, &points); err != nil {
+		Logger.ErrorContext(ctx, "Error decoding price points", slog.Any("error", err), price_repo_source)
+		return nil, err
+	}
+	return newPriceHistory(points), nil
+}
+
+// CreatePriceAlert saves the alert with the price nearby right now, so only later drops are notified
+func (m MongoPriceRepository) CreatePriceAlert(ctx context.Context, alert *PriceAlert) error {
+	ctx, span := Tracer.Start(ctx, "CreatePriceAlert")
+	defer span.End()
+
+	count, err := m.alerts.CountDocuments(ctx, bson.D{{Key: "user_id", Value: alert.UserID}})
+	if err != nil {
+		Logger.ErrorContext(ctx, "Error counting price alerts", slog.Any("error", err), price_repo_source)
+		return err
+	}
+	if count >= maxPriceAlerts {
+		return errTooManyPriceAlerts
+	}
+
+	best, err := m.cheapest(ctx, alert)
+	if err != nil {
+		return err
+	}
+	if best != nil {
+		alert.LastPrice = best.UnitPrice.Price
+	}
+	alert.ID, alert.CreatedAt = bson.NewObjectID(), time.Now()
+	if _, err := m.alerts.InsertOne(ctx, alert); err != nil {
+		Logger.ErrorContext(ctx, "Error creating price alert", slog.Any("error", err), price_repo_source)
+		return err
+	}
+	return nil
+}
+
+func (m MongoPriceRepository) FindPriceAlerts(ctx context.Context, userID ID) ([]*PriceAlert, error) {
+	ctx, span := Tracer.Start(ctx, "FindPriceAlerts")
+	defer span.End()
+
+	cursor, err := m.alerts.Find(ctx, bson.D{{Key: "user_id", Value: userID.value}},
+		options.Find().SetSort(bson.D{{Key: "created_at", Value: -1}}))
+	if err != nil {
+		Logger.ErrorContext(ctx, "Error finding price alerts", slog.Any("error", err), price_repo_source)
+		return nil, err
+	}
+	alerts := []*PriceAlert{}
+	if err := cursor.All(ctx, &alerts); err != nil {
+		Logger.ErrorContext(ctx, "Error decoding price alerts", slog.Any("error", err), price_repo_source)
+		return nil, err
+	}
+	return alerts, nil
+}
+
+func (m MongoPriceRepository) DeletePriceAlert(ctx context.Context, userID ID, alertID bson.ObjectID) error {
+	ctx, span := Tracer.Start(ctx, "DeletePriceAlert")
+	defer span.End()
+
+	result, err := m.alerts.DeleteOne(ctx, bson.D{{Key: "_id", Value: alertID}, {Key: "user_id", Value: userID.value}})
+	if err != nil {
+		Logger.ErrorContext(ctx, "Error deleting price alert", slog.Any("error", err), price_repo_source)
+		return err
+	}
+	if result.DeletedCount == 0 {
+		return &NoItems{}
+	}
+	return nil
+}
+
+// EvaluatePriceAlerts checks every alert against the prices nearby now and returns the drops to notify about.
+// It saves the price it saw for the alerts without a drop, the price of a drop is only saved by
+// MarkPriceAlertsNotified once the user was told
+func (m MongoPriceRepository) EvaluatePriceAlerts(ctx context.Context) ([]*PriceDrop, error) {
+	ctx, span := Tracer.Start(ctx, "EvaluatePriceAlerts")
+	defer span.End()
+
+	cursor, err := m.alerts.Find(ctx, bson.D{})
+	if err != nil {
+		Logger.ErrorContext(ctx, "Error finding price alerts", slog.Any("error", err), price_repo_source)
+		return nil, err
+	}
+	defer cursor.Close(ctx)
+
+	var drops []*PriceDrop
+	var models []mongo.WriteModel
+	for cursor.Next(ctx) {
+		var alert PriceAlert
+		if err := cursor.Decode(&alert); err != nil {
+			Logger.ErrorContext(ctx, "Error decoding price alert", slog.Any("error", err), price_repo_source)
+			continue
+		}
+		best, err := m.cheapest(ctx, &alert)
+		if err != nil {
+			return nil, err
+		}
+		last := alert.LastPrice
+		if drop := alert.evaluate(best); drop != nil {
+			drops = append(drops, drop)
+		} else if alert.LastPrice != last {
+			models = append(models, mongo.NewUpdateOneModel().
+				SetFilter(bson.D{{Key: "_id", Value: alert.ID}}).
+				SetUpdate(bson.D{{Key: "$set", Value: bson.M{"last_price": alert.LastPrice}}}))
+		}
+	}
+	if err := cursor.Err(); err != nil {
+		Logger.ErrorContext(ctx, "Error iterating price alerts", slog.Any("error", err), price_repo_source)
+		return nil, err
+	}
+
+	if len(models) > 0 {
+		if _, err := m.alerts.BulkWrite(ctx, models, options.BulkWrite().SetOrdered(false)); err != nil {
+			Logger.ErrorContext(ctx, "Error saving alert prices", slog.Any("error", err), price_repo_source)
+			return nil, err
+		}
+	}
+	if len(drops) > 0 {
+		Logger.InfoContext(ctx, "Price drops found", slog.Int("count", len(drops)), price_repo_source)
+	}
+	return drops, nil
+}
+
+// MarkPriceAlertsNotified moves the alerts to the prices their users were told about
+func (m MongoPriceRepository) MarkPriceAlertsNotified(ctx context.Context, drops []*PriceDrop) error {
+	ctx, span := Tracer.Start(ctx, "MarkPriceAlertsNotified")
+	defer span.End()
+
+	now := time.Now()
+	models := make([]mongo.WriteModel, 0, len(drops))
+	for _, drop := range drops {
+		models = append(models, mongo.NewUpdateOneModel().
+			SetFilter(bson.D{{Key: "_id", Value: drop.AlertID}}).
+			SetUpdate(bson.D{{Key: "$set", Value: bson.M{"last_price": drop.UnitPrice.Price, "last_notified_at": now}}}))
+	}
+	_, err := m.alerts.BulkWrite(ctx, models, options.BulkWrite().SetOrdered(false))
+	if err != nil {
+		Logger.ErrorContext(ctx, "Error marking price alerts notified", slog.Any("error", err), price_repo_source)
+	}
+	return err
+}
+
+// cheapest reads the stores near the alert that sell its ingredient and picks the lowest unit price
+func (m MongoPriceRepository) cheapest(ctx context.Context, alert *PriceAlert) (*PriceDrop, error) {
+	filter := bson.D{
+		geoWithinFilter("stores.location", alert.Location, alert.Radius),
+		{Key: "stores.items.catalog_id", Value: alert.CatalogID},
+	}
+	projection := bson.D{
+		{Key: "stores._id", Value: 1},
+		{Key: "stores.name", Value: 1},
+		{Key: "stores.location", Value: 1},
+		{Key: "stores.items", Value: 1},
+	}
+	cursor, err := m.vendor.Find(ctx, filter, options.Find().SetProjection(projection))
+	if err != nil {
+		Logger.ErrorContext(ctx, "Error finding stores for a price alert", slog.Any("error", err), price_repo_source)
+		return nil, err
+	}
+	var vendors []*Vendor
+	if err := cursor.All(ctx, &vendors); err != nil {
+		Logger.ErrorContext(ctx, "Error decoding stores for a price alert", slog.Any("error", err), price_repo_source)
+		return nil, err
+	}
+	now := time.Now()
+	for _, vendor := range vendors {
+		for _, store := range vendor.Stores {
+			dropEndedPromotions(store.Items, now)
+		}
+	}
+	return cheapestNearby(vendors, alert), nil
+}
@@ -0,0 +1,145 @@
+package main
+
+import (
+	"net/url"
+	"testing"
+
+	"go.mongodb.org/mongo-driver/v2/bson"
+)
+
+func TestPriceChanges(t *testing.T) {
+	store := bson.NewObjectID()
+	milk, flour, salt := bson.NewObjectID(), bson.NewObjectID(), bson.NewObjectID()
+	before := stockSnapshot{
+		{store, milk}:  {name: "Milk", quantity: 10, price: 3},
+		{store, flour}: {name: "Flour", quantity: 5, price: 2},
+	}
+	after := stockSnapshot{
+		{store, milk}:  {name: "Milk", quantity: 4, price: 2.5},
+		{store, flour}: {name: "Flour", quantity: 1, price: 2},
+		{store, salt}:  {name: "Salt", quantity: 3, price: 1},
+	}
+
+	points := map[bson.ObjectID]*PricePoint{}
+	for _, point := range priceChanges(before, after) {
+		points[point.ItemID] = point
+	}
+	if len(points) != 2 {
+		t.Fatalf("expected the changed and the new item got %d points", len(points))
+	}
+	if point := points[milk]; point == nil || point.Price != 2.5 || point.PreviousPrice != 3 {
+		t.Fatalf("expected milk to go from 3 to 2.5 got %+v", point)
+	}
+	if point := points[salt]; point == nil || point.Price != 1 || point.PreviousPrice != 0 {
+		t.Fatalf("expected salt to start its history got %+v", point)
+	}
+}
+
+func TestNewPriceHistory(t *testing.T) {
+	history := newPriceHistory([]*PricePoint{{Price: 3}, {Price: 2.5}, {Price: 4}})
+	if history.Low != 2.5 || history.High != 4 || history.Average != 3.17 {
+		t.Fatalf("unexpected summary %+v", history)
+	}
+	if empty := newPriceHistory(nil); empty.Points == nil || empty.Low != 0 {
+		t.Fatalf("expected an empty history got %+v", empty)
+	}
+}
+
+func TestParseTimeRange(t *testing.T) {
+	from, to, err := parseTimeRange(url.Values{"from": {"2024-12-01T00:00:00Z"}})
+	if err != nil || from.IsZero() || !to.IsZero() {
+		t.Fatalf("expected only from got %v %v %v", from, to, err)
+	}
+	if _, _, err := parseTimeRange(url.Values{"from": {"2024-12-02T00:00:00Z"}, "to": {"2024-12-01T00:00:00Z"}}); err == nil {
+		t.Fatal("expected to before from to fail")
+	}
+	if _, _, err := parseTimeRange(url.Values{"to": {"yesterday"}}); err == nil {
+		t.Fatal("expected a bad time to fail")
+	}
+}
+
+func TestQuotePer(t *testing.T) {
+	if price, ok := quotePer(3, 500, "g", "kg", 0); !ok || price != 6 {
+		t.Fatalf("expected 6 per kg got %v %v", price, ok)
+	}
+	if price, ok := quotePer(2, 1, "l", "kg", 1.03); !ok || price != 1.94 {
+		t.Fatalf("expected a litre of milk converted by density got %v %v", price, ok)
+	}
+	if _, ok := quotePer(2, 1, "l", "kg", 0); ok {
+		t.Fatal("expected volume to mass without a density to fail")
+	}
+	if per, ok := basePer("dozen"); !ok || per != "each" {
+		t.Fatalf("expected each got %q", per)
+	}
+}
+
+func TestCheapestNearby(t *testing.T) {
+	catalog := bson.NewObjectID()
+	alert := &PriceAlert{ID: bson.NewObjectID(), CatalogID: catalog, Location: newPoint(40.75, -74.00), Radius: 5, Per: "kg"}
+
+	linked := func(name string, quantity int, unit string, price float64) *Item {
+		item := newTestItem(name, quantity, unit, price)
+		item.CatalogID = catalog
+		return item
+	}
+	promoted := linked("Flour", 1, "kg", 4)
+	promoted.Promotion = &ItemPromotion{Kind: promotionFixedPrice, Price: 2}
+	soldOut := linked("Flour", 1, "kg", 1)
+	soldOut.Quantity = 0
+	vendors := []*Vendor{
+		{Common: Common{ID: bson.NewObjectID()}, Stores: []*Store{
+			{ID: bson.NewObjectID(), Name: "Near", Location: newPoint(40.76, -74.00), Items: []*Item{
+				linked("Flour", 2, "kg", 5), newTestItem("Sugar", 1, "kg", 1), soldOut,
+			}},
+			{ID: bson.NewObjectID(), Name: "Far", Location: newPoint(41.5, -74.00), Items: []*Item{linked("Flour", 1, "kg", 0.5)}},
+		}},
+		{Common: Common{ID: bson.NewObjectID()}, Stores: []*Store{
+			{ID: bson.NewObjectID(), Name: "Sale", Location: newPoint(40.74, -74.00), Items: []*Item{promoted}},
+		}},
+	}
+
+	best := cheapestNearby(vendors, alert)
+	if best == nil || best.StoreName != "Sale" || best.UnitPrice.Price != 2 || best.VendorID != vendors[1].ID {
+		t.Fatalf("expected the promoted flour got %+v", best)
+	}
+	alert.CatalogID = bson.NewObjectID()
+	if best := cheapestNearby(vendors, alert); best != nil {
+		t.Fatalf("expected nothing for another ingredient got %+v", best)
+	}
+}
+
+func TestPriceAlertEvaluate(t *testing.T) {
+	at := func(price float64) *PriceDrop { return &PriceDrop{UnitPrice: &UnitPrice{Price: price, Per: "kg"}} }
+
+	alert := &PriceAlert{LastPrice: 5}
+	if drop := alert.evaluate(at(5)); drop != nil {
+		t.Fatal("expected no drop at the same price")
+	}
+	if drop := alert.evaluate(at(6)); drop != nil || alert.LastPrice != 6 {
+		t.Fatalf("expected a rise to move the last price got %v", alert.LastPrice)
+	}
+	if drop := alert.evaluate(at(4.5)); drop == nil || drop.PreviousPrice != 6 {
+		t.Fatalf("expected a drop from 6 got %+v", drop)
+	}
+	if drop := alert.evaluate(nil); drop != nil || alert.LastPrice != 0 {
+		t.Fatal("expected the last price cleared when nobody sells it")
+	}
+	if drop := alert.evaluate(at(4)); drop != nil {
+		t.Fatal("expected the first price without a target not to be a drop")
+	}
+
+	target := &PriceAlert{TargetPrice: 3}
+	if drop := target.evaluate(at(3.5)); drop != nil {
+		t.Fatal("expected a price above the target not to notify")
+	}
+	if drop := target.evaluate(at(3.2)); drop != nil {
+		t.Fatal("expected a drop that misses the target not to notify")
+	}
+	if drop := target.evaluate(at(2.9)); drop == nil {
+		t.Fatal("expected a drop under the target")
+	}
+	fresh := &PriceAlert{TargetPrice: 3}
+	if drop := fresh.evaluate(at(2)); drop == nil {
+		t.Fatal("expected a first price under the target to notify")
+	}
+}
@@ -0,0 +1,470 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"math"
+	"slices"
+	"strings"
+	"time"
+
+	"go.mongodb.org/mongo-driver/v2/bson"
+	"go.mongodb.org/mongo-driver/v2/mongo"
+	"go.mongodb.org/mongo-driver/v2/mongo/options"
+)
+
+const (
+	promotionPercentOff = "percent_off"
+	promotionFixedPrice = "fixed_price"
+	promotionBuyXGetY   = "buy_x_get_y"
+
+	promotionScheduled = "scheduled"
+	promotionActive    = "active"
+	promotionEnded     = "ended"
+
+	promotionInterval = time.Minute
+)
+
+var promotion_repo_source = slog.Any("source", "PromotionRepository")
+
+func roundCents(price float64) float64 {
+	return math.Round(price*100) / 100
+}
+
+func (p *Promotion) validate(now time.Time) error {
+	p.Name, p.Kind = strings.TrimSpace(p.Name), strings.ToLower(strings.TrimSpace(p.Kind))
+	if p.Name == "" {
+		return errors.New("a promotion needs a name")
+	}
+	switch p.Kind {
+	case promotionPercentOff:
+		if p.Value <= 0 || p.Value >= 100 {
+			return errors.New("a percent_off promotion needs a value above 0 and below 100")
+		}
+		p.Buy, p.Get = 0, 0
+	case promotionFixedPrice:
+		if p.Value <= 0 {
+			return errors.New("a fixed_price promotion needs a price above 0")
+		}
+		p.Buy, p.Get = 0, 0
+	case promotionBuyXGetY:
+		if p.Buy < 1 || p.Get < 1 {
+			return errors.New("a buy_x_get_y promotion needs buy and get of at least 1")
+		}
+		p.Value = 0
+	default:
+		return fmt.Errorf("kind must be %s, %s or %s", promotionPercentOff, promotionFixedPrice, promotionBuyXGetY)
+	}
+	if len(p.ItemIDs) == 0 && len(p.CategoryIDs) == 0 {
+		return errors.New("a promotion needs item_ids or category_ids")
+	}
+	if p.StartsAt.IsZero() {
+		p.StartsAt = now
+	}
+	if !p.EndsAt.After(p.StartsAt) || !p.EndsAt.After(now) {
+		return errors.New("ends_at must be after starts_at and in the future")
+	}
+	p.Status = p.statusAt(now)
+	return nil
+}
+
+func (p *Promotion) statusAt(now time.Time) string {
+	switch {
+	case now.Before(p.StartsAt):
+		return promotionScheduled
+	case now.Before(p.EndsAt):
+		return promotionActive
+	}
+	return promotionEnded
+}
+
+// covers is whether the promotion applies to the item of the store, categories is the category path of every
+// catalog ingredient the items link to
+func (p *Promotion) covers(storeID bson.ObjectID, item *Item, categories map[bson.ObjectID][]bson.ObjectID) bool {
+	if !p.StoreID.IsZero() && p.StoreID != storeID {
+		return false
+	}
+	if slices.Contains(p.ItemIDs, item.IngredientID) {
+		return true
+	}
+	path := categories[item.CatalogID]
+	return slices.ContainsFunc(p.CategoryIDs, func(id bson.ObjectID) bool { return slices.Contains(path, id) })
+}
+
+// forItem is the promotion as it prices the item, a fixed price never raises the regular price
+func (p *Promotion) forItem(item *Item) *ItemPromotion {
+	price := item.Price
+	switch p.Kind {
+	case promotionPercentOff:
+		price = roundCents(item.Price * (100 - p.Value) / 100)
+	case promotionFixedPrice:
+		price = min(p.Value, item.Price)
+	}
+	return &ItemPromotion{
+		PromotionID: p.ID,
+		Name:        p.Name,
+		Kind:        p.Kind,
+		Price:       price,
+		Buy:         p.Buy,
+		Get:         p.Get,
+		StartsAt:    p.StartsAt,
+		EndsAt:      p.EndsAt,
+	}
+}
+
+// averagePrice is what a pack costs on average when the promotion is used in full, it ranks promotions that
+// cover the same item
+func (p *ItemPromotion) averagePrice() float64 {
+	if p.Kind == promotionBuyXGetY {
+		return p.Price * float64(p.Buy) / float64(p.Buy+p.Get)
+	}
+	return p.Price
+}
+
+func (p *ItemPromotion) liveAt(now time.Time) bool {
+	return !now.Before(p.StartsAt) && now.Before(p.EndsAt)
+}
+
+func samePromotion(a, b *ItemPromotion) bool {
+	if a == nil || b == nil {
+		return a == b
+	}
+	return a.PromotionID == b.PromotionID && a.Price == b.Price && a.Buy == b.Buy && a.Get == b.Get &&
+		a.StartsAt.Equal(b.StartsAt) && a.EndsAt.Equal(b.EndsAt)
+}
+
+// unitPrice is what one pack of the item costs now, the promoted price while a promotion runs
+func (i *Item) unitPrice() float64 {
+	if i.Promotion != nil {
+		return i.Promotion.Price
+	}
+	return i.Price
+}
+
+// linePrice is what packs of the item cost, a buy-X-get-Y promotion makes every Get packs of each Buy+Get free
+func (i *Item) linePrice(packs int) float64 {
+	paid := packs
+	if promo := i.Promotion; promo != nil && promo.Kind == promotionBuyXGetY {
+		paid -= packs / (promo.Buy + promo.Get) * promo.Get
+	}
+	return roundCents(i.unitPrice() * float64(paid))
+}
+
+// dropEndedPromotions clears the promotions of the items that are not running at now, the job only writes
+// them once a minute so the reads check the window themselves
+func dropEndedPromotions(items []*Item, now time.Time) {
+	for _, item := range items {
+		if item.Promotion != nil && !item.Promotion.liveAt(now) {
+			item.Promotion = nil
+		}
+	}
+}
+
+// storePromotions is the promotion every item of the stores should carry, the one with the lowest average
+// price when several running promotions cover it
+func storePromotions(stores []*Store, promotions []*Promotion, categories map[bson.ObjectID][]bson.ObjectID,
+	now time.Time) map[stockKey]*ItemPromotion {
+	promos := make(map[stockKey]*ItemPromotion)
+	for _, promotion := range promotions {
+		if promotion.statusAt(now) != promotionActive {
+			continue
+		}
+		for _, store := range stores {
+			for _, item := range store.Items {
+				if item == nil || !promotion.covers(store.ID, item, categories) {
+					continue
+				}
+				key := stockKey{store.ID, item.IngredientID}
+				promo := promotion.forItem(item)
+				if current, ok := promos[key]; !ok || promo.averagePrice() < current.averagePrice() {
+					promos[key] = promo
+				}
+			}
+		}
+	}
+	return promos
+}
+
+// priceOrder prices the order items from the store's items and their running promotions, the total is
+// recomputed so clients can not set their own prices. The lines take the catalog link of the items for tax
+func priceOrder(store *Store, order *Order) error {
+	items := make(map[bson.ObjectID]*Item, len(store.Items))
+	for _, item := range store.Items {
+		if item != nil {
+			items[item.IngredientID] = item
+		}
+	}
+	total := 0.0
+	for _, line := range order.Items {
+		item, ok := items[line.IngredientID]
+		if !ok {
+			return fmt.Errorf("store %s does not sell %s", store.Name, line.Name)
+		}
+		if line.Quantity < 1 {
+			return fmt.Errorf("%s needs a quantity of at least 1", item.Name)
+		}
+		line.Price, line.Promotion, line.CatalogID = item.Price, item.Promotion, item.CatalogID
+		total += line.linePrice(line.Quantity)
+	}
+	order.Subtotal = roundCents(total)
+	order.TotalPrice = order.Subtotal
+	return nil
+}
+
+// applyPromotions is the promotion job
+func applyPromotions(ctx context.Context) error {
+	changed, err := Repos.Promotion.ApplyPromotions(ctx, time.Now())
+	if changed > 0 {
+		Logger.InfoContext(ctx, "Promotions applied to store items", slog.Int("items", changed), promotion_repo_source)
+	}
+	return err
+}
+
+// PromotionRepository keeps the vendor promotions and writes the running ones on the store items they cover
+type PromotionRepository interface {
+	CreatePromotion(context.Context, *Promotion) error
+	FindPromotions(context.Context, ID, string) ([]*Promotion, error)
+	UpdatePromotion(context.Context, *Promotion) error
+	DeletePromotion(context.Context, ID, bson.ObjectID) error
+	ApplyPromotions(context.Context, time.Time) (int, error)
+	ApplyVendorPromotions(context.Context, bson.ObjectID, time.Time) (int, error)
+}
+
+type MongoPromotionRepository struct {
+	col     *mongo.Collection
+	vendor  *mongo.Collection
+	catalog *mongo.Collection
+}
+
+func newMongoPromotionRepository(client *mongo.Client, dbName string) PromotionRepository {
+	db := client.Database(dbName)
+	return &MongoPromotionRepository{col: db.Collection("promotions"), vendor: db.Collection("vendor"),
+		catalog: db.Collection("catalog")}
+}
+
+func (m MongoPromotionRepository) CreatePromotion(ctx context.Context, promotion *Promotion) error {
+	ctx, span := Tracer.Start(ctx, "CreatePromotion")
+	defer span.End()
+
+	promotion.ID = bson.NewObjectID()
+	promotion.CreatedAt = time.Now()
+	promotion.UpdatedAt = promotion.CreatedAt
+	if _, err := m.col.InsertOne(ctx, promotion); err != nil {
+		Logger.ErrorContext(ctx, "Error creating promotion", slog.Any("error", err), promotion_repo_source)
+		return err
+	}
+	Logger.InfoContext(ctx, "Promotion created", slog.String("promotionID", promotion.ID.Hex()),
+		slog.String("vendorID", promotion.VendorID.Hex()), promotion_repo_source)
+	return nil
+}
+
+// FindPromotions lists the vendor's promotions by start, status narrows them to scheduled, active or ended
+func (m MongoPromotionRepository) FindPromotions(ctx context.Context, vendorID ID, status string) ([]*Promotion, error) {
+	ctx, span := Tracer.Start(ctx, "FindPromotions")
+	defer span.End()
+
+	filter := bson.D{{Key: "vendor_id", Value: vendorID.value}}
+	if status != "" {
+		filter = append(filter, bson.E{Key: "status", Value: status})
+	}
+	cursor, err := m.col.Find(ctx, filter, options.Find().SetSort(bson.D{{Key: "starts_at", Value: -1}}))
+	if err != nil {
+		Logger.ErrorContext(ctx, "Error finding promotions", slog.Any("error", err), promotion_repo_source)
+		return nil, err
+	}
+	promotions := []*Promotion{}
+	if err := cursor.All(ctx, &promotions); err != nil {
+		Logger.ErrorContext(ctx, "Error decoding promotions", slog.Any("error", err), promotion_repo_source)
+		return nil, err
+	}
+	return promotions, nil
+}
+
+// UpdatePromotion replaces the rule and window of a promotion of the vendor, NoItems when it is not theirs
+func (m MongoPromotionRepository) UpdatePromotion(ctx context.Context, promotion *Promotion) error {
+	ctx, span := Tracer.Start(ctx, "UpdatePromotion")
+	defer span.End()
+
+	promotion.UpdatedAt = time.Now()
+	filter := bson.D{{Key: "_id", Value: promotion.ID}, {Key: "vendor_id", Value: promotion.VendorID}}
+	update := bson.D{{Key: "$set", Value: bson.M{
+		"store_id":     promotion.StoreID,
+		"name":         promotion.Name,
+		"kind":         promotion.Kind,
+		"value":        promotion.Value,
+		"buy":          promotion.Buy,
+		"get":          promotion.Get,
+		"item_ids":     promotion.ItemIDs,
+		"category_ids": promotion.CategoryIDs,
+		"starts_at":    promotion.StartsAt,
+		"ends_at":      promotion.EndsAt,
+		"status":       promotion.Status,
+		"updated_at":   promotion.UpdatedAt,
+	}}}
+	result, err := m.col.UpdateOne(ctx, filter, update)
+	if err != nil {
+		Logger.ErrorContext(ctx, "Error updating promotion", slog.Any("error", err), promotion_repo_source)
+		return err
+	}
+	if result.MatchedCount == 0 {
+		return &NoItems{}
+	}
+	return nil
+}
+
+func (m MongoPromotionRepository) DeletePromotion(ctx context.Context, vendorID ID, promotionID bson.ObjectID) error {
+	ctx, span := Tracer.Start(ctx, "DeletePromotion")
+	defer span.End()
+
+	result, err := m.col.DeleteOne(ctx, bson.D{{Key: "_id", Value: promotionID}, {Key: "vendor_id", Value: vendorID.value}})
+	if err != nil {
+		Logger.ErrorContext(ctx, "Error deleting promotion", slog.Any("error", err), promotion_repo_source)
+		return err
+	}
+	if result.DeletedCount == 0 {
+		return &NoItems{}
+	}
+	return nil
+}
+
+// ApplyPromotions moves promotions whose window opened or closed to their new status and rewrites the items of
+// every vendor with a promotion that started, ended or is running, running ones pick up items added since
+func (m MongoPromotionRepository) ApplyPromotions(ctx context.Context, now time.Time) (int, error) {
+	ctx, span := Tracer.Start(ctx, "ApplyPromotions")
+	defer span.End()
+
+	filter := bson.D{{Key: "status", Value: bson.D{{Key: "$in", Value: bson.A{promotionScheduled, promotionActive}}}}}
+	cursor, err := m.col.Find(ctx, filter)
+	if err != nil {
+		Logger.ErrorContext(ctx, "Error finding open promotions", slog.Any("error", err), promotion_repo_source)
+		return 0, err
+	}
+	var promotions []*Promotion
+	if err := cursor.All(ctx, &promotions); err != nil {
+		Logger.ErrorContext(ctx, "Error decoding promotions", slog.Any("error", err), promotion_repo_source)
+		return 0, err
+	}
+
+	var models []mongo.WriteModel
+	var vendors []bson.ObjectID
+	for _, promotion := range promotions {
+		status := promotion.statusAt(now)
+		if status != promotion.Status {
+			models = append(models, mongo.NewUpdateOneModel().
+				SetFilter(bson.D{{Key: "_id", Value: promotion.ID}, {Key: "status", Value: promotion.Status}}).
+				SetUpdate(bson.D{{Key: "$set", Value: bson.M{"status": status, "updated_at": now}}}))
+		}
+		if (status != promotion.Status || status == promotionActive) && !slices.Contains(vendors, promotion.VendorID) {
+			vendors = append(vendors, promotion.VendorID)
+		}
+	}
+	if len(models) > 0 {
+		if _, err := m.col.BulkWrite(ctx, models, options.BulkWrite().SetOrdered(false)); err != nil {
+			Logger.ErrorContext(ctx, "Error updating promotion statuses", slog.Any("error", err), promotion_repo_source)
+			return 0, err
+		}
+	}
+
+	changed := 0
+	var errs []error
+	for _, vendorID := range vendors {
+		n, err := m.ApplyVendorPromotions(ctx, vendorID, now)
+		changed += n
+		errs = append(errs, err)
+	}
+	return changed, errors.Join(errs...)
+}
+
+// ApplyVendorPromotions writes the running promotions on the vendor's store items and clears the ones that
+// ended, it returns how many items changed
+func (m MongoPromotionRepository) ApplyVendorPromotions(ctx context.Context, vendorID bson.ObjectID, now time.Time) (int, error) {
+	ctx, span := Tracer.Start(ctx, "ApplyVendorPromotions")
+	defer span.End()
+
+	var vendor Vendor
+	projection := bson.D{{Key: "stores._id", Value: 1}, {Key: "stores.items", Value: 1}}
+	err := m.vendor.FindOne(ctx, bson.D{{Key: "_id", Value: vendorID}}, options.FindOne().SetProjection(projection)).Decode(&vendor)
+	if err != nil {
+		if errors.Is(err, mongo.ErrNoDocuments) {
+			return 0, nil
+		}
+		Logger.ErrorContext(ctx, "Error reading vendor stores", slog.String("vendorID", vendorID.Hex()),
+			slog.Any("error", err), promotion_repo_source)
+		return 0, err
+	}
+
+	filter := bson.D{
+		{Key: "vendor_id", Value: vendorID},
+		{Key: "starts_at", Value: bson.D{{Key: "$lte", Value: now}}},
+		{Key: "ends_at", Value: bson.D{{Key: "$gt", Value: now}}},
+	}
+	cursor, err := m.col.Find(ctx, filter)
+	if err != nil {
+		Logger.ErrorContext(ctx, "Error finding running promotions", slog.Any("error", err), promotion_repo_source)
+		return 0, err
+	}
+	var promotions []*Promotion
+	if err := cursor.All(ctx, &promotions); err != nil {
+		Logger.ErrorContext(ctx, "Error decoding promotions", slog.Any("error", err), promotion_repo_source)
+		return 0, err
+	}
+
+	categories, err := m.categoryPaths(ctx, vendor.Stores, promotions)
+	if err != nil {
+		return 0, err
+	}
+	promos := storePromotions(vendor.Stores, promotions, categories, now)
+
+	var models []mongo.WriteModel
+	for _, store := range vendor.Stores {
+		for _, item := range store.Items {
+			if item == nil {
+				continue
+			}
+			promo := promos[stockKey{store.ID, item.IngredientID}]
+			if samePromotion(item.Promotion, promo) {
+				continue
+			}
+			update := bson.D{{Key: "$unset", Value: bson.M{"stores.$[s].items.$[i].promotion": ""}}}
+			if promo != nil {
+				update = bson.D{{Key: "$set", Value: bson.M{"stores.$[s].items.$[i].promotion": promo}}}
+			}
+			models = append(models, mongo.NewUpdateOneModel().
+				SetFilter(bson.D{{Key: "_id", Value: vendorID}}).
+				SetUpdate(update).
+				SetArrayFilters([]any{bson.M{"s._id": store.ID}, bson.M{"i.ingredient_id": item.IngredientID}}))
+		}
+	}
+	if len(models) == 0 {
+		return 0, nil
+	}
+	if _, err := m.vendor.BulkWrite(ctx, models, options.BulkWrite().SetOrdered(false)); err != nil {
+		Logger.ErrorContext(ctx, "Error writing item promotions", slog.String("vendorID", vendorID.Hex()),
+			slog.Any("error", err), promotion_repo_source)
+		return 0, err
+	}
+	return len(models), nil
+}
+
+// categoryPaths reads the category path of the catalog ingredients the store items link to, only when one
+// of the promotions covers categories
+func (m MongoPromotionRepository) categoryPaths(ctx context.Context, stores []*Store, promotions []*Promotion) (map[bson.ObjectID][]bson.ObjectID, error) {
+	if !slices.ContainsFunc(promotions, func(p *Promotion) bool { return len(p.CategoryIDs) > 0 }) {
+		return nil, nil
+	}
+	var ids []bson.ObjectID
+	for _, store := range stores {
+		for _, item := range store.Items {
+			if item != nil && !item.CatalogID.IsZero() {
+				ids = append(ids, item.CatalogID)
+			}
+		}
+	}
+	if len(ids) == 0 {
+		return nil, nil
+	}
+
+	return findCategoryPaths(ctx, m.catalog, ids, promotion_repo_source)
+}
@@ -0,0 +1,125 @@
+package main
+
+import (
+	"testing"
+	"time"
+
+	"go.mongodb.org/mongo-driver/v2/bson"
+)
+
+func TestPromotionValidate(t *testing.T) {
+	now := time.Date(2024, 12, 20, 12, 0, 0, 0, time.UTC)
+	item := []bson.ObjectID{bson.NewObjectID()}
+	bad := []*Promotion{
+		{Name: "Sale", Kind: promotionPercentOff, Value: 100, ItemIDs: item, EndsAt: now.Add(time.Hour)},
+		{Name: "Sale", Kind: promotionFixedPrice, ItemIDs: item, EndsAt: now.Add(time.Hour)},
+		{Name: "Sale", Kind: promotionBuyXGetY, Buy: 2, ItemIDs: item, EndsAt: now.Add(time.Hour)},
+		{Name: "Sale", Kind: "bogo", ItemIDs: item, EndsAt: now.Add(time.Hour)},
+		{Name: "Sale", Kind: promotionPercentOff, Value: 10, EndsAt: now.Add(time.Hour)},
+		{Name: "Sale", Kind: promotionPercentOff, Value: 10, ItemIDs: item, EndsAt: now.Add(-time.Hour)},
+		{Kind: promotionPercentOff, Value: 10, ItemIDs: item, EndsAt: now.Add(time.Hour)},
+	}
+	for _, promotion := range bad {
+		if promotion.validate(now) == nil {
+			t.Fatalf("expected %+v to fail", promotion)
+		}
+	}
+
+	promotion := &Promotion{Name: " Weekend ", Kind: "Percent_Off", Value: 20, ItemIDs: item, EndsAt: now.Add(time.Hour)}
+	if err := promotion.validate(now); err != nil || promotion.Status != promotionActive || !promotion.StartsAt.Equal(now) {
+		t.Fatalf("expected an active promotion starting now got %+v %v", promotion, err)
+	}
+	promotion = &Promotion{Name: "Later", Kind: promotionFixedPrice, Value: 1, ItemIDs: item,
+		StartsAt: now.Add(time.Hour), EndsAt: now.Add(2 * time.Hour)}
+	if err := promotion.validate(now); err != nil || promotion.Status != promotionScheduled {
+		t.Fatalf("expected a scheduled promotion got %+v %v", promotion, err)
+	}
+	if promotion.statusAt(now.Add(2*time.Hour)) != promotionEnded {
+		t.Fatal("expected the promotion to end at ends_at")
+	}
+}
+
+func TestLinePrice(t *testing.T) {
+	item := newTestItem("Milk", 1, "litre", 3)
+	if price := item.linePrice(3); price != 9 {
+		t.Fatalf("expected the regular price got %v", price)
+	}
+
+	item.Promotion = (&Promotion{Kind: promotionPercentOff, Value: 15}).forItem(item)
+	if price := item.linePrice(2); price != 5.1 {
+		t.Fatalf("expected 15%% off got %v", price)
+	}
+	item.Promotion = (&Promotion{Kind: promotionFixedPrice, Value: 5}).forItem(item)
+	if item.Promotion.Price != 3 {
+		t.Fatalf("expected a fixed price above the regular price to keep it got %v", item.Promotion.Price)
+	}
+	// buy two get one, seven packs pay for five
+	item.Promotion = (&Promotion{Kind: promotionBuyXGetY, Buy: 2, Get: 1}).forItem(item)
+	if price := item.linePrice(7); price != 15 {
+		t.Fatalf("expected two free packs got %v", price)
+	}
+}
+
+func TestStorePromotions(t *testing.T) {
+	now := time.Date(2024, 12, 20, 12, 0, 0, 0, time.UTC)
+	dairy, bakery := bson.NewObjectID(), bson.NewObjectID()
+	milk, bread := newTestItem("Milk", 1, "litre", 4), newTestItem("Bread", 1, "count", 3)
+	milk.CatalogID, bread.CatalogID = bson.NewObjectID(), bson.NewObjectID()
+	categories := map[bson.ObjectID][]bson.ObjectID{milk.CatalogID: {dairy}, bread.CatalogID: {bakery}}
+	stores := []*Store{
+		{ID: bson.NewObjectID(), Items: []*Item{milk, bread}},
+		{ID: bson.NewObjectID(), Items: []*Item{newTestItem("Milk", 1, "litre", 4)}},
+	}
+
+	window := func(p *Promotion) *Promotion {
+		p.ID, p.StartsAt, p.EndsAt = bson.NewObjectID(), now.Add(-time.Hour), now.Add(time.Hour)
+		return p
+	}
+	ended := window(&Promotion{Kind: promotionFixedPrice, Value: 1, ItemIDs: []bson.ObjectID{bread.IngredientID}})
+	ended.EndsAt = now
+	promotions := []*Promotion{
+		window(&Promotion{Kind: promotionPercentOff, Value: 10, CategoryIDs: []bson.ObjectID{dairy}}),
+		window(&Promotion{Kind: promotionBuyXGetY, Buy: 1, Get: 1, StoreID: stores[0].ID, ItemIDs: []bson.ObjectID{milk.IngredientID}}),
+		ended,
+	}
+
+	promos := storePromotions(stores, promotions, categories, now)
+	if promo := promos[stockKey{stores[0].ID, milk.IngredientID}]; promo == nil || promo.PromotionID != promotions[1].ID {
+		t.Fatalf("expected buy one get one to beat 10%% off got %+v", promo)
+	}
+	if promo := promos[stockKey{stores[0].ID, bread.IngredientID}]; promo != nil {
+		t.Fatalf("expected the ended promotion to be left out got %+v", promo)
+	}
+	// the second store's milk links to no catalog category
+	if len(promos) != 1 {
+		t.Fatalf("expected one promoted item got %d", len(promos))
+	}
+}
+
+func TestPriceOrder(t *testing.T) {
+	now := time.Now()
+	milk, bread := newTestItem("Milk", 1, "litre", 4), newTestItem("Bread", 1, "count", 3)
+	milk.Promotion = &ItemPromotion{Kind: promotionBuyXGetY, Price: 4, Buy: 1, Get: 1,
+		StartsAt: now.Add(-time.Hour), EndsAt: now.Add(time.Hour)}
+	bread.Promotion = &ItemPromotion{Kind: promotionFixedPrice, Price: 1, StartsAt: now.Add(-2 * time.Hour),
+		EndsAt: now.Add(-time.Hour)}
+	store := &Store{Name: "Corner", Items: []*Item{milk, bread}}
+	dropEndedPromotions(store.Items, now)
+
+	order := &Order{TotalPrice: 1, Items: []*Item{
+		{Ingredient: Ingredient{IngredientID: milk.IngredientID, Price: 0.5}, Quantity: 3},
+		{Ingredient: Ingredient{IngredientID: bread.IngredientID}, Quantity: 2},
+	}}
+	if err := priceOrder(store, order); err != nil {
+		t.Fatal(err)
+	}
+	if order.Subtotal != 8+6 || order.TotalPrice != order.Subtotal || order.Items[0].Price != 4 ||
+		order.Items[0].Promotion == nil || order.Items[1].Promotion != nil {
+		t.Fatalf("expected the store prices with the running promotion got %v %+v", order.TotalPrice, order.Items)
+	}
+
+	order.Items = append(order.Items, &Item{Ingredient: Ingredient{IngredientID: bson.NewObjectID(), Name: "Salt"}, Quantity: 1})
+	if err := priceOrder(store, order); err == nil {
+		t.Fatal("expected an item the store does not sell to fail")
+	}
+}
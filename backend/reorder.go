@@ -0,0 +1,106 @@
+package main
+
+import (
+	"cmp"
+	"slices"
+
+	"go.mongodb.org/mongo-driver/v2/bson"
+)
+
+const (
+	lineRepriced    = "repriced"
+	lineReduced     = "reduced"
+	lineSubstituted = "substituted"
+	lineUnavailable = "unavailable"
+)
+
+// repriceLines matches earlier lines against what the store sells today. A line keeps its item at today's price
+// and is cut to what is in stock, an item the store dropped or sold out of is replaced by another item of the
+// same catalog ingredient, and a line nothing can be found for is left out. Every difference is flagged
+func repriceLines(lines []*Item, store *Store) ([]*Item, []*LineChange) {
+	items := make(map[bson.ObjectID]*Item, len(store.Items))
+	for _, item := range store.Items {
+		if item != nil {
+			items[item.IngredientID] = item
+		}
+	}
+
+	priced, changes := []*Item{}, []*LineChange{}
+	for _, line := range lines {
+		if line.Quantity < 1 {
+			continue
+		}
+		change := &LineChange{IngredientID: line.IngredientID, Name: line.Name, Quantity: line.Quantity,
+			OldPrice: line.unitPrice()}
+		item, ok := items[line.IngredientID]
+		if !ok || item.Quantity < 1 {
+			if item = substituteItem(store.Items, line); item == nil {
+				change.Kind = lineUnavailable
+				changes = append(changes, change)
+				continue
+			}
+			change.Kind, change.SubstituteID, change.SubstituteName = lineSubstituted, item.IngredientID, item.Name
+		}
+
+		next := *item
+		next.Quantity, next.ReorderThreshold, next.Pricing, next.NameKeys = min(line.Quantity, item.Quantity), 0, nil, nil
+		priced = append(priced, &next)
+
+		change.NewQuantity, change.NewPrice = next.Quantity, next.unitPrice()
+		switch {
+		case change.Kind != "":
+		case next.Quantity < line.Quantity:
+			change.Kind = lineReduced
+		case change.NewPrice != change.OldPrice:
+			change.Kind = lineRepriced
+		default:
+			continue
+		}
+		changes = append(changes, change)
+	}
+	return priced, changes
+}
+
+// substituteItem is the in stock item of the store selling the same catalog ingredient as the line, in the same
+// unit and closest pack size where it can, nil when there is none
+func substituteItem(items []*Item, line *Item) *Item {
+	if line.CatalogID.IsZero() {
+		return nil
+	}
+	var candidates []*Item
+	for _, item := range items {
+		if item != nil && item.CatalogID == line.CatalogID && item.IngredientID != line.IngredientID && item.Quantity > 0 {
+			candidates = append(candidates, item)
+		}
+	}
+	if len(candidates) == 0 {
+		return nil
+	}
+	distance := func(item *Item) int {
+		d := item.UnitQuantity - line.UnitQuantity
+		return max(d, -d)
+	}
+	return slices.MinFunc(candidates, func(a, b *Item) int {
+		if sameA, sameB := a.Unit == line.Unit, b.Unit == line.Unit; sameA != sameB {
+			if sameA {
+				return -1
+			}
+			return 1
+		}
+		if c := cmp.Compare(distance(a), distance(b)); c != 0 {
+			return c
+		}
+		return cmp.Compare(a.unitPrice(), b.unitPrice())
+	})
+}
+
+// reorderCart is a cart for the store of a past order, priced against the store's current inventory
+func reorderCart(order *UserOrder, store *Store) (*Cart, []*LineChange) {
+	items, changes := repriceLines(order.Items, store)
+	cart := &Cart{VendorID: order.VendorID, StoreID: order.StoreID, Items: items}
+	for _, item := range items {
+		cart.TotalPrice += item.linePrice(item.Quantity)
+	}
+	cart.TotalPrice = roundCents(cart.TotalPrice)
+	return cart, changes
+}
@@ -0,0 +1,68 @@
+package main
+
+import (
+	"testing"
+
+	"go.mongodb.org/mongo-driver/v2/bson"
+)
+
+func TestRepriceLines(t *testing.T) {
+	milk, eggs, flour := newTestItem("Milk", 1, "litre", 4), newTestItem("Eggs", 12, "piece", 3), newTestItem("Flour", 1, "kg", 2)
+	butter, gone := newTestItem("Butter", 250, "g", 5), newTestItem("Saffron", 1, "g", 9)
+	butter.CatalogID = bson.NewObjectID()
+
+	lines := []*Item{milk, eggs, flour, butter, gone}
+	for _, line := range lines {
+		line.Quantity = 3
+	}
+
+	todayMilk, todayEggs, todayFlour := *milk, *eggs, *flour
+	todayMilk.Quantity, todayEggs.Price, todayEggs.Quantity, todayFlour.Quantity = 20, 3.5, 20, 1
+	soldOut := *butter
+	soldOut.Quantity = 0
+	small, large, litre := newTestItem("Butter", 200, "g", 4), newTestItem("Butter", 500, "g", 8), newTestItem("Butter", 1, "litre", 1)
+	for _, item := range []*Item{small, large, litre} {
+		item.CatalogID = butter.CatalogID
+	}
+	store := &Store{Items: []*Item{&todayMilk, &todayEggs, &todayFlour, &soldOut, large, small, litre}}
+
+	items, changes := repriceLines(lines, store)
+	if len(items) != 4 {
+		t.Fatalf("expected 4 lines got %d", len(items))
+	}
+	if items[0].Quantity != 3 || items[1].Price != 3.5 || items[2].Quantity != 1 || items[3].IngredientID != small.IngredientID {
+		t.Fatalf("unexpected lines %+v %+v %+v %+v", items[0], items[1], items[2], items[3])
+	}
+	if len(changes) != 4 {
+		t.Fatalf("expected 4 changes got %d", len(changes))
+	}
+	expected := []string{lineRepriced, lineReduced, lineSubstituted, lineUnavailable}
+	for i, change := range changes {
+		if change.Kind != expected[i] {
+			t.Fatalf("change %d: expected %s got %s", i, expected[i], change.Kind)
+		}
+	}
+	if changes[0].OldPrice != 3 || changes[0].NewPrice != 3.5 || changes[1].NewQuantity != 1 || changes[2].SubstituteID != small.IngredientID {
+		t.Fatalf("unexpected changes %+v %+v %+v", changes[0], changes[1], changes[2])
+	}
+	if todayFlour.Quantity != 1 {
+		t.Fatal("expected the store's items to be left alone")
+	}
+}
+
+func TestReorderCart(t *testing.T) {
+	milk := newTestItem("Milk", 1, "litre", 4)
+	milk.Quantity = 2
+	vendorID, storeID := bson.NewObjectID(), bson.NewObjectID()
+	order := &UserOrder{VendorID: vendorID, Order: Order{StoreID: storeID, Items: []*Item{milk}}}
+	today := *milk
+	today.Price, today.Quantity = 4.333, 10
+
+	cart, changes := reorderCart(order, &Store{Items: []*Item{&today}})
+	if cart.VendorID != vendorID || cart.StoreID != storeID || cart.TotalPrice != 8.67 {
+		t.Fatalf("unexpected cart %+v", cart)
+	}
+	if len(changes) != 1 || changes[0].Kind != lineRepriced {
+		t.Fatalf("expected the milk to be repriced got %+v", changes)
+	}
+}
@@ -1,11 +1,14 @@
 package main
 
 import (
+	"cmp"
 	"context"
 	"errors"
 	"fmt"
 	"log/slog"
 	"os"
+	"slices"
+	"time"
 
 	"go.mongodb.org/mongo-driver/v2/bson"
 	"go.mongodb.org/mongo-driver/v2/mongo"
@@ -22,9 +25,23 @@ var (
 )
 
 type Repositories struct {
-	User   UserRepository
-	Vendor VendorRepository
-	Admin  AdminRepository
+	User         UserRepository
+	Vendor       VendorRepository
+	Admin        AdminRepository
+	Catalog      CatalogRepository
+	Alert        AlertRepository
+	Notification NotificationRepository
+	Ledger       LedgerRepository
+	Slot         SlotRepository
+	Promotion    PromotionRepository
+	Coupon       CouponRepository
+	Price        PriceRepository
+	Tax          TaxRepository
+	Invoice      InvoiceRepository
+	Settlement   SettlementRepository
+	Review       ReviewRepository
+	Issue        IssueRepository
+	Subscription SubscriptionRepository
 }
 
 type UserRepository interface {
@@ -61,12 +78,17 @@ type VendorRepository interface {
 	FindVendorByEmail(context.Context, string) (*Vendor, error)
 	FindStores(context.Context, ID) ([]*Store, error)
 	FindVendorOrders(context.Context, ID) ([]*VendorOrder, error)
-	FindAllIngredients(context.Context, []*ReqIng) ([]*ResIng, error)
+	FindAllIngredients(context.Context, *ReqIngArray) ([]*ResIng, error)
 	FindVendorStore(context.Context, ID, ID) ([]*Item, error)
+	FindStore(context.Context, ID, ID) (*Store, error)
+	FindNearbyStores(context.Context, *GeoJSON, float64, string) ([]*NearbyStore, error)
+	StreamStoreItems(context.Context, ID, ID, func(*Item) error) error
+	IndexItemNames(context.Context) (int, error)
 
 	UpdateUserOrder(context.Context, ID, *AcceptUserOrderReq) error
 	UpdateVendor(context.Context, *Common) error
 	UpdateStores(context.Context, ID, []*Store) error
+	ImportStoreItems(context.Context, ID, ID, *ImportDiff) error
 	UpdateVendorOrders(context.Context, ID, []*VendorOrder) error
 
 	DeleteVendor(context.Context, ID) error
@@ -79,20 +101,16 @@ type AdminRepository interface {
 	UserRepository
 	VendorRepository
 	CreateAdmin(context.Context, *Admin) (ID, error)
-	CreateIngredients(context.Context, ID, []*Ingredient) ([]*ID, error)
 
 	FindUsers(context.Context, ID) ([]*Common, error)
 	FindVendors(context.Context, ID) ([]*Common, error)
 	FindVendorStores(context.Context, ID) ([]*Vendor, error)
 	FindAdminByEmail(context.Context, string) (*Admin, error)
 	FindAdminByID(context.Context, ID) (*Admin, error)
-	FindIngredients(context.Context, ID) ([]*Ingredient, error)
 
 	UpdateAdmin(context.Context, *Common) error
-	UpdateIngredients(context.Context, ID, []*Ingredient) error
 
 	Delete(context.Context, ID) error
-	DeleteIngredients(context.Context, ID, []*ID) error
 }
 
 func initMongoRepositories(mongoClient *mongo.Client) (*Repositories, error) {
@@ -102,15 +120,36 @@ func initMongoRepositories(mongoClient *mongo.Client) (*Repositories, error) {
 	}
 	ur, vr := newMongoUserRepository(mongoClient, dbName), newMongoVendorRepository(mongoClient, dbName)
 	mongoRepos := &Repositories{
-		User:   ur,
-		Vendor: vr,
-		Admin:  newMongoAdminRepository(mongoClient, dbName, ur, vr),
+		User:         ur,
+		Vendor:       vr,
+		Admin:        newMongoAdminRepository(mongoClient, dbName, ur, vr),
+		Catalog:      newMongoCatalogRepository(mongoClient, dbName),
+		Alert:        newMongoAlertRepository(mongoClient, dbName),
+		Notification: newMongoNotificationRepository(mongoClient, dbName),
+		Ledger:       newMongoLedgerRepository(mongoClient, dbName),
+		Slot:         newMongoSlotRepository(mongoClient, dbName),
+		Promotion:    newMongoPromotionRepository(mongoClient, dbName),
+		Coupon:       newMongoCouponRepository(mongoClient, dbName),
+		Price:        newMongoPriceRepository(mongoClient, dbName),
+		Tax:          newMongoTaxRepository(mongoClient, dbName),
+		Invoice:      newMongoInvoiceRepository(mongoClient, dbName),
+		Settlement:   newMongoSettlementRepository(mongoClient, dbName),
+		Review:       newMongoReviewRepository(mongoClient, dbName),
+		Issue:        newMongoIssueRepository(mongoClient, dbName),
+		Subscription: newMongoSubscriptionRepository(mongoClient, dbName),
 	}
 	return mongoRepos, nil
 }
 
 type MongoUserRepository struct{ col *mongo.Collection }
-type MongoVendorRepository struct{ col *mongo.Collection }
+type MongoVendorRepository struct {
+	col    *mongo.Collection
+	ledger *mongo.Collection
+	prices *mongo.Collection
+	// settlements and accounts are written with the order status so a delivered order always accrues
+	settlements *mongo.Collection
+	accounts    *mongo.Collection
+}
 type MongoAdminRepository struct {
 	UserRepository
 	VendorRepository
@@ -124,7 +163,10 @@ func newMongoUserRepository(client *mongo.Client, dbName string) UserRepository
 }
 
 func newMongoVendorRepository(client *mongo.Client, dbName string) VendorRepository {
-	return &MongoVendorRepository{col: client.Database(dbName).Collection("vendor")}
+	db := client.Database(dbName)
+	return &MongoVendorRepository{col: db.Collection("vendor"), ledger: db.Collection("inventory_ledger"),
+		prices: db.Collection("price_history"), settlements: db.Collection("settlements"),
+		accounts: db.Collection("settlement_accounts")}
 }
 
 func newMongoAdminRepository(client *mongo.Client, dbName string, ur UserRepository, vr VendorRepository) AdminRepository {
@@ -370,12 +412,29 @@ func (m MongoVendorRepository) CreateStores(ctx context.Context, id ID, stores [
 	ctx, span := Tracer.Start(ctx, "CreateVendorStores")
 	defer span.End()
 
+	for _, store := range stores {
+		if err := store.validate(); err != nil {
+			return nil, err
+		}
+	}
+
 	ids := AssignIDs(stores)
+	for _, store := range stores {
+		for _, item := range store.Items {
+			if item.IngredientID == bson.NilObjectID {
+				item.IngredientID = bson.NewObjectID()
+			}
+			item.NameKeys = nameKeys(item.Name)
+		}
+	}
 
 	Logger.InfoContext(ctx, "Adding Store/s to vendor", slog.Any("Store/s", stores), vendor_repo_source)
 	filter, update := getFilterPush(id, "stores", stores)
 
-	if err := createContainers(ctx, m.col, id, filter, update, vendor_repo_source); err != nil {
+	err := m.withLedger(ctx, id, ledgerAdjust, func(sessCtx context.Context) error {
+		return createContainers(sessCtx, m.col, id, filter, update, vendor_repo_source)
+	})
+	if err != nil {
 		Logger.ErrorContext(ctx, "Error in adding vendor stores", slog.Any("error", err), vendor_repo_source)
 		return nil, err
 	}
@@ -428,19 +487,27 @@ func (m MongoVendorRepository) FindStores(ctx context.Context, id ID) ([]*Store,
 	return findContainer[[]*Store](ctx, m.col, id, "stores", vendor_repo_source)
 }
 
-func (m MongoVendorRepository) FindAllIngredients(ctx context.Context, req []*ReqIng) ([]*ResIng, error) {
+func (m MongoVendorRepository) FindAllIngredients(ctx context.Context, req *ReqIngArray) ([]*ResIng, error) {
 	ctx, span := Tracer.Start(ctx, "FindAllIngredients")
 	defer span.End()
 	Logger.InfoContext(ctx, "Finding ingredients across all vendors", slog.Any("requirements", req), vendor_repo_source)
 
-	cursor, err := m.col.Find(ctx, bson.D{})
+	catalog, err := getAllIngredients(ctx, vendor_repo_source)
 	if err != nil {
-		Logger.ErrorContext(ctx, "Error finding vendors", slog.Any("error", err), vendor_repo_source)
+		Logger.ErrorContext(ctx, "Matching without catalog aliases and densities", slog.Any("error", err), vendor_repo_source)
+	}
+	matcher := newIngredientMatcher(catalog)
+
+	cursor, err := m.col.Aggregate(ctx, comparePipeline(req, matcher.nameStems(req.Compare), matcher.catalogIDs(req.Compare)))
+	if err != nil {
+		Logger.ErrorContext(ctx, "Error aggregating vendor stores", slog.Any("error", err), vendor_repo_source)
 		return nil, err
 	}
 	defer cursor.Close(ctx)
 
-	var results []*ResIng
+	var matches []*StoreMatch
+	var vendorIDs []bson.ObjectID
+	now := time.Now()
 	for cursor.Next(ctx) {
 		var vendor Vendor
 		if err := cursor.Decode(&vendor); err != nil {
@@ -448,68 +515,14 @@ func (m MongoVendorRepository) FindAllIngredients(ctx context.Context, req []*Re
 			continue
 		}
 
-		vendorResult := &ResIng{
-			ID:     vendor.ID,
-			Stores: []*Store{},
-		}
-
 		for _, store := range vendor.Stores {
-			storeMatch := &Store{
-				ID:        store.ID,
-				Name:      store.Name,
-				StoreType: store.StoreType,
-				Location:  store.Location,
-				Items:     []*Item{},
-			}
-
-			for _, reqIng := range req {
-				var bestMatch *Item
-
-				// Tier 1: Exact match (name, unit, and unit quantity match exactly)
-				for _, item := range store.Items {
-					if item.Name == reqIng.Name && item.Unit == reqIng.Unit && item.UnitQuantity == reqIng.UnitQuantity {
-						bestMatch = createMatchItem(item)
-						break
-					}
-				}
-
-				// Tier 2: Ceiling match (smallest unit quantity greater than required)
-				if bestMatch == nil {
-					var minDiff int = -1
-					for _, item := range store.Items {
-						if item.Name == reqIng.Name && item.Unit == reqIng.Unit && item.UnitQuantity > reqIng.UnitQuantity {
-							diff := item.UnitQuantity - reqIng.UnitQuantity
-							if minDiff == -1 || diff < minDiff {
-								bestMatch = createMatchItem(item)
-								minDiff = diff
-							}
-						}
-					}
-				}
-
-				// Tier 3: Any match (just name and unit match, regardless of quantity)
-				if bestMatch == nil {
-					for _, item := range store.Items {
-						if item.Name == reqIng.Name && item.Unit == reqIng.Unit {
-							bestMatch = createMatchItem(item)
-							break
-						}
-					}
-				}
-
-				if bestMatch != nil {
-					storeMatch.Items = append(storeMatch.Items, bestMatch)
-				}
-			}
-
-			if len(storeMatch.Items) > 0 {
-				vendorResult.Stores = append(vendorResult.Stores, storeMatch)
+			dropEndedPromotions(store.Items, now)
+			if match := matcher.matchStore(store, req); match != nil {
+				match.StoreStatus = store.status(now)
+				matches = append(matches, match)
+				vendorIDs = append(vendorIDs, vendor.ID)
 			}
 		}
-
-		if len(vendorResult.Stores) > 0 {
-			results = append(results, vendorResult)
-		}
 	}
 
 	if err := cursor.Err(); err != nil {
@@ -517,28 +530,17 @@ func (m MongoVendorRepository) FindAllIngredients(ctx context.Context, req []*Re
 		return nil, err
 	}
 
-	if len(results) == 0 {
+	if len(matches) == 0 {
 		Logger.ErrorContext(ctx, "No match found", vendor_repo_source)
 		return nil, &NoItems{}
 	}
 
-	Logger.InfoContext(ctx, "Found matching ingredients", slog.Int("vendorCount", len(results)), vendor_repo_source)
+	results := rankStores(matches, vendorIDs, req.SortBy)
+	Logger.InfoContext(ctx, "Found matching ingredients", slog.Int("vendorCount", len(results)),
+		slog.Int("storeCount", len(matches)), vendor_repo_source)
 	return results, nil
 }
 
-func createMatchItem(item *Item) *Item {
-	return &Item{
-		Ingredient: Ingredient{
-			IngredientID: item.IngredientID,
-			Name:         item.Name,
-			UnitQuantity: item.UnitQuantity,
-			Unit:         item.Unit,
-			Price:        item.Price,
-		},
-		Quantity: item.Quantity,
-	}
-}
-
 func (m MongoVendorRepository) FindVendorStore(ctx context.Context, vendorid ID, storeid ID) ([]*Item, error) {
 	ctx, span := Tracer.Start(ctx, "FindVendorStore")
 	defer span.End()
@@ -570,6 +572,7 @@ func (m MongoVendorRepository) FindVendorStore(ctx context.Context, vendorid ID,
 	}
 
 	items := result.Stores[0].Items
+	dropEndedPromotions(items, time.Now())
 	if len(items) == 0 {
 		Logger.InfoContext(ctx, "No items found in store", slog.String("vendorID", vendorid.String()),
 			slog.String("storeID", storeid.String()), vendor_repo_source)
@@ -581,6 +584,93 @@ func (m MongoVendorRepository) FindVendorStore(ctx context.Context, vendorid ID,
 	return items, nil
 }
 
+// FindStore is a store of the vendor, its items carry only the promotions running now
+func (m MongoVendorRepository) FindStore(ctx context.Context, vendorID, storeID ID) (*Store, error) {
+	ctx, span := Tracer.Start(ctx, "FindStore")
+	defer span.End()
+
+	filter := bson.D{{Key: "_id", Value: vendorID.value}}
+	projection := bson.D{{Key: "stores", Value: bson.D{{Key: "$elemMatch", Value: bson.D{{Key: "_id", Value: storeID.value}}}}}}
+	var vendor Vendor
+	if err := m.col.FindOne(ctx, filter, options.FindOne().SetProjection(projection)).Decode(&vendor); err != nil {
+		if errors.Is(err, mongo.ErrNoDocuments) {
+			return nil, &NoItems{}
+		}
+		Logger.ErrorContext(ctx, "Error finding store", slog.String("vendorID", vendorID.String()),
+			slog.String("storeID", storeID.String()), slog.Any("error", err), vendor_repo_source)
+		return nil, err
+	}
+	if len(vendor.Stores) != 1 {
+		Logger.ErrorContext(ctx, "Store not found", slog.String("vendorID", vendorID.String()),
+			slog.String("storeID", storeID.String()), vendor_repo_source)
+		return nil, &NoItems{}
+	}
+	store := vendor.Stores[0]
+	dropEndedPromotions(store.Items, time.Now())
+	return store, nil
+}
+
+func (m MongoVendorRepository) FindNearbyStores(ctx context.Context, center *GeoJSON, radius float64, storeType string) ([]*NearbyStore, error) {
+	ctx, span := Tracer.Start(ctx, "FindNearbyStores")
+	defer span.End()
+
+	Logger.InfoContext(ctx, "Finding stores near location", slog.Any("center", center.Coordinates),
+		slog.Float64("radius", radius), slog.String("storeType", storeType), vendor_repo_source)
+
+	filter := bson.D{geoWithinFilter("stores.location", center, radius)}
+	if storeType != "" {
+		filter = append(filter, bson.E{Key: "stores.store_type", Value: storeType})
+	}
+	projection := bson.D{
+		{Key: "stores.items", Value: 0},
+		{Key: "orders", Value: 0},
+		{Key: "password_hash", Value: 0},
+	}
+
+	cursor, err := m.col.Find(ctx, filter, options.Find().SetProjection(projection))
+	if err != nil {
+		Logger.ErrorContext(ctx, "Error finding vendors near location", slog.Any("error", err), vendor_repo_source)
+		return nil, err
+	}
+	defer cursor.Close(ctx)
+
+	// the filter only tells us that some store of the vendor is in range so every store is checked again here
+	var stores []*NearbyStore
+	now := time.Now()
+	for cursor.Next(ctx) {
+		var vendor Vendor
+		if err := cursor.Decode(&vendor); err != nil {
+			Logger.ErrorContext(ctx, "Error decoding vendor", slog.Any("error", err), vendor_repo_source)
+			continue
+		}
+
+		for _, store := range vendor.Stores {
+			if store.Location.validPoint() != nil || (storeType != "" && store.StoreType != storeType) {
+				continue
+			}
+			if distance := haversineMiles(center, store.Location); distance <= radius {
+				stores = append(stores, &NearbyStore{VendorID: vendor.ID, Store: store, StoreStatus: store.status(now),
+					Distance: distance})
+			}
+		}
+	}
+
+	if err := cursor.Err(); err != nil {
+		Logger.ErrorContext(ctx, "Error iterating vendors", slog.Any("error", err), vendor_repo_source)
+		return nil, err
+	}
+
+	if len(stores) == 0 {
+		Logger.ErrorContext(ctx, "No stores found near location", vendor_repo_source)
+		return nil, &NoItems{}
+	}
+
+	slices.SortFunc(stores, func(a, b *NearbyStore) int { return cmp.Compare(a.Distance, b.Distance) })
+
+	Logger.InfoContext(ctx, "Found nearby stores", slog.Int("count", len(stores)), vendor_repo_source)
+	return stores, nil
+}
+
 func (m MongoVendorRepository) FindVendorOrders(ctx context.Context, id ID) ([]*VendorOrder, error) {
 	ctx, span := Tracer.Start(ctx, "FindVendorOrders")
 	defer span.End()
@@ -601,15 +691,43 @@ func (m MongoVendorRepository) UpdateStores(ctx context.Context, id ID, stores [
 	defer span.End()
 
 	Logger.InfoContext(ctx, "Updating stores for vendor", slog.String("vendorID", id.String()), vendor_repo_source)
-	return processContainers(ctx, m.col, id, stores, vendor_repo_source)
+	for _, store := range stores {
+		if err := store.validate(); err != nil {
+			return err
+		}
+	}
+	return m.withLedger(ctx, id, ledgerAdjust, func(sessCtx context.Context) error {
+		return processContainers(sessCtx, m.col, id, stores, vendor_repo_source)
+	})
 }
 
+// UpdateVendorOrders changes the status of the vendor's orders in one transaction, each goes the same way as
+// UpdateUserOrder so the user's copy, the stock and the settlement ledger follow every change
 func (m MongoVendorRepository) UpdateVendorOrders(ctx context.Context, id ID, orders []*VendorOrder) error {
 	ctx, span := Tracer.Start(ctx, "UpdateVendorOrders")
 	defer span.End()
 
 	Logger.InfoContext(ctx, "Updating orders for vendor", slog.String("vendorID", id.String()), vendor_repo_source)
-	return processContainers(ctx, m.col, id, orders, vendor_repo_source)
+	session, err := MongoClient.StartSession(sesOp)
+	if err != nil {
+		Logger.ErrorContext(ctx, "Unable to create a session", slog.Any("error", err), vendor_repo_source)
+		return err
+	}
+	defer session.EndSession(ctx)
+
+	_, err = session.WithTransaction(ctx, func(sessCtx context.Context) (any, error) {
+		for _, order := range orders {
+			if order.ID == bson.NilObjectID || order.OrderStatus == "" {
+				continue
+			}
+			req := &AcceptUserOrderReq{OrderID: order.ID, OrderStatus: order.OrderStatus, UpdatedAt: time.Now()}
+			if err := m.changeOrderStatus(sessCtx, id, req); err != nil {
+				return nil, err
+			}
+		}
+		return nil, nil
+	})
+	return err
 }
 
 func (m MongoVendorRepository) UpdateUserOrder(ctx context.Context, id ID, ord *AcceptUserOrderReq) error {
@@ -624,145 +742,164 @@ func (m MongoVendorRepository) UpdateUserOrder(ctx context.Context, id ID, ord *
 	defer session.EndSession(ctx)
 
 	_, err = session.WithTransaction(ctx, func(sessCtx context.Context) (any, error) {
-		dbName := os.Getenv("DB_NAME")
-		userCollection := MongoClient.Database(dbName).Collection("user")
-		vendorCollection := MongoClient.Database(dbName).Collection("vendor")
+		return nil, m.changeOrderStatus(sessCtx, id, ord)
+	})
+	return err
+}
+
+// changeOrderStatus moves one order of the vendor to the new status inside the caller's transaction, the user
+// whose copy is updated is the one stored on the vendor's order
+func (m MongoVendorRepository) changeOrderStatus(ctx context.Context, id ID, ord *AcceptUserOrderReq) error {
+	dbName := os.Getenv("DB_NAME")
+	userCollection := MongoClient.Database(dbName).Collection("user")
+	vendorCollection := MongoClient.Database(dbName).Collection("vendor")
 
-		Logger.InfoContext(sessCtx, "Updating the user order", slog.String("vendorID", id.String()),
-			slog.String("Req", fmt.Sprintf("%+v", ord)), vendor_repo_source)
+	Logger.InfoContext(ctx, "Finding the order and its current status", slog.String("vendorID", id.String()),
+		slog.String("Req", fmt.Sprintf("%+v", ord)), vendor_repo_source)
 
-		userFilter := bson.D{
-			{Key: "_id", Value: ord.UserID},
-			{Key: "orders._id", Value: ord.OrderID},
-		}
-		userUpdate := bson.D{
-			{Key: "$set", Value: bson.M{
-				"orders.$.order_status": ord.OrderStatus,
-				"orders.$.updated_at":   ord.UpdatedAt,
+	var vendor Vendor
+	vendorProjection := bson.D{
+		{Key: "orders", Value: bson.D{
+			{Key: "$elemMatch", Value: bson.D{
+				{Key: "_id", Value: ord.OrderID},
 			}},
-		}
+		}},
+	}
 
-		userResult, err := userCollection.UpdateOne(sessCtx, userFilter, userUpdate)
-		if err != nil {
-			Logger.ErrorContext(sessCtx, "Error updating user order status", slog.String("vendorID", id.String()),
-				slog.String("Req", fmt.Sprintf("%+v", ord)), slog.Any("error", err), vendor_repo_source)
-			return nil, err
-		}
+	if err := vendorCollection.FindOne(ctx, bson.D{{Key: "_id", Value: id.value}}, options.FindOne().SetProjection(vendorProjection)).Decode(&vendor); err != nil {
+		Logger.ErrorContext(ctx, "Error retrieving vendor order details", slog.String("vendorID", id.String()),
+			slog.String("orderID", ord.OrderID.Hex()), slog.Any("error", err), vendor_repo_source)
+		return err
+	}
 
-		if userResult.MatchedCount == 0 {
-			err := fmt.Errorf("user with ID %s or order with ID %s not found", ord.UserID.Hex(), ord.OrderID.Hex())
-			Logger.ErrorContext(sessCtx, "User or order not found", slog.String("vendorID", id.String()),
-				slog.String("Req", fmt.Sprintf("%+v", ord)), slog.Any("error", err), vendor_repo_source)
-			return nil, err
-		}
+	if len(vendor.Orders) != 1 {
+		err := fmt.Errorf("could not find order %s for vendor %s", ord.OrderID.Hex(), id.String())
+		Logger.ErrorContext(ctx, "Order not found in vendor document", slog.String("vendorID", id.String()),
+			slog.String("orderID", ord.OrderID.Hex()), slog.Any("error", err), vendor_repo_source)
+		return err
+	}
 
-		Logger.InfoContext(sessCtx, "Updated the user order status successfully", slog.String("vendorID", id.String()),
-			slog.String("Req", fmt.Sprintf("%+v", ord)), vendor_repo_source)
+	Logger.InfoContext(ctx, "Found the order items successfully", slog.String("vendorID", id.String()),
+		slog.String("Req", fmt.Sprintf("%+v", ord)), vendor_repo_source)
 
-		Logger.InfoContext(sessCtx, "Updating the vendor order status", slog.String("vendorID", id.String()),
-			slog.String("Req", fmt.Sprintf("%+v", ord)), vendor_repo_source)
+	vendorOrder := vendor.Orders[0]
+	userID := vendorOrder.UserID
 
-		vendorFilter := bson.D{
-			{Key: "_id", Value: id.value},
-			{Key: "orders._id", Value: ord.OrderID},
-		}
-		vendorUpdate := bson.D{
-			{Key: "$set", Value: bson.M{
-				"orders.$.order_status": ord.OrderStatus,
-				"orders.$.updated_at":   ord.UpdatedAt,
-			}},
-		}
+	Logger.InfoContext(ctx, "Updating the user order", slog.String("vendorID", id.String()),
+		slog.String("Req", fmt.Sprintf("%+v", ord)), vendor_repo_source)
 
-		vendorResult, err := vendorCollection.UpdateOne(sessCtx, vendorFilter, vendorUpdate)
-		if err != nil {
-			Logger.ErrorContext(sessCtx, "Error updating vendor order", slog.String("vendorID", id.String()),
-				slog.String("Req", fmt.Sprintf("%+v", ord)), slog.Any("error", err), vendor_repo_source)
-			return nil, err
-		}
+	userFilter := bson.D{
+		{Key: "_id", Value: userID},
+		{Key: "orders._id", Value: ord.OrderID},
+	}
+	userUpdate := bson.D{
+		{Key: "$set", Value: bson.M{
+			"orders.$.order_status": ord.OrderStatus,
+			"orders.$.updated_at":   ord.UpdatedAt,
+		}},
+	}
 
-		if vendorResult.MatchedCount == 0 {
-			err := fmt.Errorf("vendor order with ID %s not found for vendor %s", ord.OrderID.Hex(), id.String())
-			Logger.ErrorContext(sessCtx, "Vendor order not found", slog.String("vendorID", id.String()),
-				slog.String("orderID", ord.OrderID.Hex()), slog.Any("error", err), vendor_repo_source)
-			return nil, err
-		}
+	userResult, err := userCollection.UpdateOne(ctx, userFilter, userUpdate)
+	if err != nil {
+		Logger.ErrorContext(ctx, "Error updating user order status", slog.String("vendorID", id.String()),
+			slog.String("Req", fmt.Sprintf("%+v", ord)), slog.Any("error", err), vendor_repo_source)
+		return err
+	}
 
-		Logger.InfoContext(sessCtx, "Updated the vendor order status successfully", slog.String("vendorID", id.String()),
-			slog.String("Req", fmt.Sprintf("%+v", ord)), vendor_repo_source)
+	if userResult.MatchedCount == 0 {
+		err := fmt.Errorf("user with ID %s or order with ID %s not found", userID.Hex(), ord.OrderID.Hex())
+		Logger.ErrorContext(ctx, "User or order not found", slog.String("vendorID", id.String()),
+			slog.String("Req", fmt.Sprintf("%+v", ord)), slog.Any("error", err), vendor_repo_source)
+		return err
+	}
 
-		Logger.InfoContext(sessCtx, "Finding the order to the items to decrement", slog.String("vendorID", id.String()),
-			slog.String("Req", fmt.Sprintf("%+v", ord)), vendor_repo_source)
+	Logger.InfoContext(ctx, "Updated the user order status successfully", slog.String("vendorID", id.String()),
+		slog.String("Req", fmt.Sprintf("%+v", ord)), vendor_repo_source)
 
-		var vendor Vendor
-		vendorProjection := bson.D{
-			{Key: "orders", Value: bson.D{
-				{Key: "$elemMatch", Value: bson.D{
-					{Key: "_id", Value: ord.OrderID},
-				}},
-			}},
-		}
+	Logger.InfoContext(ctx, "Updating the vendor order status", slog.String("vendorID", id.String()),
+		slog.String("Req", fmt.Sprintf("%+v", ord)), vendor_repo_source)
 
-		if err := vendorCollection.FindOne(sessCtx, bson.D{{Key: "_id", Value: id.value}}, options.FindOne().SetProjection(vendorProjection)).Decode(&vendor); err != nil {
-			Logger.ErrorContext(sessCtx, "Error retrieving vendor order details", slog.String("vendorID", id.String()),
-				slog.String("orderID", ord.OrderID.Hex()), slog.Any("error", err), vendor_repo_source)
-			return nil, err
-		}
+	vendorFilter := bson.D{
+		{Key: "_id", Value: id.value},
+		{Key: "orders._id", Value: ord.OrderID},
+	}
+	vendorUpdate := bson.D{
+		{Key: "$set", Value: bson.M{
+			"orders.$.order_status": ord.OrderStatus,
+			"orders.$.updated_at":   ord.UpdatedAt,
+		}},
+	}
 
-		if len(vendor.Orders) != 1 {
-			err := fmt.Errorf("could not find order %s for vendor %s", ord.OrderID.Hex(), id.String())
-			Logger.ErrorContext(sessCtx, "Order not found in vendor document", slog.String("vendorID", id.String()),
-				slog.String("orderID", ord.OrderID.Hex()), slog.Any("error", err), vendor_repo_source)
-			return nil, err
-		}
+	vendorResult, err := vendorCollection.UpdateOne(ctx, vendorFilter, vendorUpdate)
+	if err != nil {
+		Logger.ErrorContext(ctx, "Error updating vendor order", slog.String("vendorID", id.String()),
+			slog.String("Req", fmt.Sprintf("%+v", ord)), slog.Any("error", err), vendor_repo_source)
+		return err
+	}
 
-		Logger.InfoContext(sessCtx, "Found the order items successfully", slog.String("vendorID", id.String()),
-			slog.String("Req", fmt.Sprintf("%+v", ord)), vendor_repo_source)
+	if vendorResult.MatchedCount == 0 {
+		err := fmt.Errorf("vendor order with ID %s not found for vendor %s", ord.OrderID.Hex(), id.String())
+		Logger.ErrorContext(ctx, "Vendor order not found", slog.String("vendorID", id.String()),
+			slog.String("orderID", ord.OrderID.Hex()), slog.Any("error", err), vendor_repo_source)
+		return err
+	}
 
-		Logger.InfoContext(sessCtx, "Decrementing the values in the store from the orders", slog.String("vendorID", id.String()),
-			slog.String("Req", fmt.Sprintf("%+v", ord)), vendor_repo_source)
+	Logger.InfoContext(ctx, "Updated the vendor order status successfully", slog.String("vendorID", id.String()),
+		slog.String("Req", fmt.Sprintf("%+v", ord)), vendor_repo_source)
 
-		vendorOrder := vendor.Orders[0]
-		storeID := vendorOrder.StoreID
+	if err := m.recordSettlement(ctx, id, &vendorOrder.Order, ord.OrderStatus, time.Now()); err != nil {
+		return err
+	}
 
-		var models []mongo.WriteModel
-		for _, item := range vendorOrder.Items {
-			updateItemFilters := []any{
-				bson.M{"s._id": storeID},
-				bson.M{"i.ingredient_id": item.IngredientID},
-			}
+	Logger.InfoContext(ctx, "Decrementing the values in the store from the orders", slog.String("vendorID", id.String()),
+		slog.String("Req", fmt.Sprintf("%+v", ord)), vendor_repo_source)
 
-			decUpdate := bson.D{{Key: "$inc", Value: bson.M{
-				"stores.$[s].items.$[i].quantity": -item.Quantity,
-			}}}
+	before, err := m.snapshotStock(ctx, id)
+	if err != nil {
+		return err
+	}
+	storeID := vendorOrder.StoreID
 
-			models = append(models, mongo.NewUpdateOneModel().
-				SetFilter(bson.D{{Key: "_id", Value: id.value}}).
-				SetUpdate(decUpdate).
-				SetArrayFilters(updateItemFilters))
+	var models []mongo.WriteModel
+	for _, item := range vendorOrder.Items {
+		updateItemFilters := []any{
+			bson.M{"s._id": storeID},
+			bson.M{"i.ingredient_id": item.IngredientID},
 		}
 
-		if len(models) > 0 {
-			bulkResult, err := vendorCollection.BulkWrite(sessCtx, models)
-			if err != nil {
-				Logger.ErrorContext(sessCtx, "Error updating store inventory", slog.String("vendorID", id.String()),
-					slog.String("storeID", storeID.Hex()), slog.Any("error", err), vendor_repo_source)
-				return nil, err
-			}
+		decUpdate := bson.D{{Key: "$inc", Value: bson.M{
+			"stores.$[s].items.$[i].quantity": -item.Quantity,
+		}}}
 
-			Logger.InfoContext(sessCtx, "Store inventory updated", slog.String("vendorID", id.String()),
-				slog.String("storeID", storeID.Hex()), slog.Int64("matchedCount", bulkResult.MatchedCount),
-				slog.Int64("modifiedCount", bulkResult.ModifiedCount),
-				vendor_repo_source)
+		models = append(models, mongo.NewUpdateOneModel().
+			SetFilter(bson.D{{Key: "_id", Value: id.value}}).
+			SetUpdate(decUpdate).
+			SetArrayFilters(updateItemFilters))
+	}
+
+	if len(models) > 0 {
+		bulkResult, err := vendorCollection.BulkWrite(ctx, models)
+		if err != nil {
+			Logger.ErrorContext(ctx, "Error updating store inventory", slog.String("vendorID", id.String()),
+				slog.String("storeID", storeID.Hex()), slog.Any("error", err), vendor_repo_source)
+			return err
 		}
 
-		Logger.InfoContext(sessCtx, "Order transaction completed successfully", slog.String("vendorID", id.String()),
-			slog.String("userID", ord.UserID.Hex()), slog.String("orderID", ord.OrderID.Hex()),
-			slog.String("status", ord.OrderStatus), vendor_repo_source)
+		Logger.InfoContext(ctx, "Store inventory updated", slog.String("vendorID", id.String()),
+			slog.String("storeID", storeID.Hex()), slog.Int64("matchedCount", bulkResult.MatchedCount),
+			slog.Int64("modifiedCount", bulkResult.ModifiedCount),
+			vendor_repo_source)
+	}
 
-		return nil, nil
-	})
+	if err := m.recordLedger(ctx, id, before, ledgerOrder, ord.OrderID); err != nil {
+		return err
+	}
 
-	return err
+	Logger.InfoContext(ctx, "Order transaction completed successfully", slog.String("vendorID", id.String()),
+		slog.String("userID", userID.Hex()), slog.String("orderID", ord.OrderID.Hex()),
+		slog.String("status", ord.OrderStatus), vendor_repo_source)
+
+	return nil
 }
 
 func (m MongoVendorRepository) DeleteVendor(ctx context.Context, id ID) error {
@@ -781,7 +918,10 @@ func (m MongoVendorRepository) DeleteStores(ctx context.Context, id ID, ids []*I
 		slog.String("vendorID", id.String()), slog.Any("storeIDs", ids), vendor_repo_source)
 
 	filter, update := getFilterDelete(id, "stores", ids)
-	if err := deleteContainers(ctx, m.col, id, filter, update, vendor_repo_source); err != nil {
+	err := m.withLedger(ctx, id, ledgerAdjust, func(sessCtx context.Context) error {
+		return deleteContainers(sessCtx, m.col, id, filter, update, vendor_repo_source)
+	})
+	if err != nil {
 		return err
 	}
 	Logger.InfoContext(ctx, "Stores deleted successfully", slog.String("vendorID", id.String()), vendor_repo_source)
@@ -810,7 +950,9 @@ func (m MongoVendorRepository) DeleteStoreItems(ctx context.Context, docId, stor
 	Logger.InfoContext(ctx, "Deleting items from store", slog.String("vendorID", docId.String()),
 		slog.String("StoreId", storeId.String()), slog.Any("items", items), vendor_repo_source)
 
-	return processDeleteItems(ctx, m.col, docId, storeId, "stores", items, vendor_repo_source)
+	return m.withLedger(ctx, docId, ledgerAdjust, func(sessCtx context.Context) error {
+		return processDeleteItems(sessCtx, m.col, docId, storeId, "stores", items, vendor_repo_source)
+	})
 }
 
 func (v MongoAdminRepository) CreateAdmin(ctx context.Context, admin *Admin) (id ID, err error) {
@@ -823,43 +965,6 @@ func (v MongoAdminRepository) CreateAdmin(ctx context.Context, admin *Admin) (id
 	return create(ctx, admin, v.col, admin_repo_source)
 }
 
-func (v MongoAdminRepository) CreateIngredients(ctx context.Context, id ID, ingredients []*Ingredient) ([]*ID, error) {
-	ctx, span := Tracer.Start(ctx, "CreateIngredients")
-	defer span.End()
-
-	Logger.InfoContext(ctx, "Adding ingredients to admin", slog.Any("ingredients", ingredients), admin_repo_source)
-
-	ids := make([]*ID, len(ingredients))
-	for i, ingredient := range ingredients {
-		if ingredient.IngredientID == bson.NilObjectID {
-			ingredient.IngredientID = bson.NewObjectID()
-		}
-		ids[i] = &ID{ingredient.IngredientID}
-	}
-
-	filter := bson.D{{Key: "_id", Value: id.value}}
-	update := bson.D{{Key: "$push", Value: bson.M{"ingredients": bson.M{"$each": ingredients}}}}
-
-	result, err := v.col.UpdateOne(ctx, filter, update)
-	if err != nil {
-		Logger.ErrorContext(ctx, "Error adding ingredients", slog.Any("error", err), admin_repo_source)
-		return nil, err
-	}
-
-	if !result.Acknowledged {
-		Logger.ErrorContext(ctx, "Write concern returned false", slog.String("ID", id.String()), admin_repo_source)
-		return nil, fmt.Errorf("write concern returned false")
-	}
-
-	if result.MatchedCount == 0 {
-		Logger.ErrorContext(ctx, "Admin not found", slog.String("ID", id.String()), admin_repo_source)
-		return nil, fmt.Errorf("admin with ID %s not found", id.String())
-	}
-
-	Logger.InfoContext(ctx, "Ingredients added successfully", slog.String("adminId", id.String()), admin_repo_source)
-	return ids, nil
-}
-
 func (v MongoAdminRepository) FindUsers(ctx context.Context, id ID) (users []*Common, err error) {
 	ctx, span := Tracer.Start(ctx, "FindUsers")
 	defer span.End()
@@ -974,32 +1079,6 @@ func (v MongoAdminRepository) FindAdminByID(ctx context.Context, id ID) (*Admin,
 	return findById[*Admin](ctx, v.col, id, admin_repo_source)
 }
 
-func (v MongoAdminRepository) FindIngredients(ctx context.Context, id ID) ([]*Ingredient, error) {
-	ctx, span := Tracer.Start(ctx, "FindIngredients")
-	defer span.End()
-
-	Logger.InfoContext(ctx, "Finding ingredients for admin", slog.String("AdminId", id.String()), admin_repo_source)
-	filter := bson.D{{Key: "_id", Value: id.value}}
-	projection := bson.D{{Key: "ingredients", Value: 1}, {Key: "_id", Value: 0}}
-
-	var result struct {
-		Ingredients []*Ingredient `bson:"ingredients"`
-	}
-
-	if err := v.col.FindOne(ctx, filter, options.FindOne().SetProjection(projection)).Decode(&result); err != nil {
-		if errors.Is(err, mongo.ErrNoDocuments) {
-			Logger.ErrorContext(ctx, "Admin not found", slog.String("ID", id.String()), admin_repo_source)
-			return nil, fmt.Errorf("admin with ID %s not found", id.String())
-		}
-		Logger.ErrorContext(ctx, "Error finding ingredients", slog.Any("error", err), admin_repo_source)
-		return nil, err
-	}
-
-	Logger.InfoContext(ctx, "Ingredients found successfully",
-		slog.Int("count", len(result.Ingredients)), admin_repo_source)
-	return result.Ingredients, nil
-}
-
 func (v MongoAdminRepository) UpdateAdmin(ctx context.Context, admin *Common) error {
 	ctx, span := Tracer.Start(ctx, "UpdateAdmin")
 	defer span.End()
@@ -1007,77 +1086,6 @@ func (v MongoAdminRepository) UpdateAdmin(ctx context.Context, admin *Common) er
 	return update(ctx, admin, v.col, admin_repo_source)
 }
 
-func (v MongoAdminRepository) UpdateIngredients(ctx context.Context, id ID, ingredients []*Ingredient) error {
-	ctx, span := Tracer.Start(ctx, "UpdateIngredients")
-	defer span.End()
-
-	Logger.InfoContext(ctx, "Updating ingredients for admin", slog.String("adminID", id.String()), admin_repo_source)
-
-	if err := checkIfDocumentExists(ctx, v.col, id.value); err != nil {
-		return err
-	}
-
-	var models []mongo.WriteModel
-
-	for _, ingredient := range ingredients {
-		if ingredient.IngredientID == bson.NilObjectID {
-
-			ingredient.IngredientID = bson.NewObjectID()
-			pushModel := mongo.NewUpdateOneModel().
-				SetFilter(bson.D{{Key: "_id", Value: id.value}}).
-				SetUpdate(bson.D{{Key: "$push", Value: bson.M{"ingredients": ingredient}}})
-			models = append(models, pushModel)
-		} else {
-
-			filter := bson.D{
-				{Key: "_id", Value: id.value},
-				{Key: "ingredients.ingredient_id", Value: ingredient.IngredientID},
-			}
-
-			update := bson.D{{Key: "$set", Value: bson.M{}}}
-			setValue := update[0].Value.(bson.M)
-
-			if ingredient.Name != "" {
-				setValue["ingredients.$.name"] = ingredient.Name
-			}
-			if ingredient.UnitQuantity != 0 {
-				setValue["ingredients.$.unit_quantity"] = ingredient.UnitQuantity
-			}
-			if ingredient.Unit != "" {
-				setValue["ingredients.$.unit"] = ingredient.Unit
-			}
-			if ingredient.Price != 0 {
-				setValue["ingredients.$.price"] = ingredient.Price
-			}
-
-			if len(setValue) > 0 {
-				models = append(models, mongo.NewUpdateOneModel().SetFilter(filter).SetUpdate(update))
-			}
-		}
-	}
-
-	if len(models) > 0 {
-		result, err := v.col.BulkWrite(ctx, models)
-		if err != nil {
-			Logger.ErrorContext(ctx, "Error in bulk update", slog.Any("error", err), admin_repo_source)
-			return err
-		}
-		if !result.Acknowledged {
-			Logger.ErrorContext(ctx, "Write concern returned false", slog.String("ID", id.String()), admin_repo_source)
-			return fmt.Errorf("write concern returned false")
-		}
-		Logger.InfoContext(ctx, "Ingredients updated successfully",
-			slog.Int64("matchedCount", result.MatchedCount),
-			slog.Int64("modifiedCount", result.ModifiedCount),
-			slog.Int64("insertedCount", result.InsertedCount),
-			admin_repo_source)
-	} else {
-		Logger.InfoContext(ctx, "No updates to perform", admin_repo_source)
-	}
-
-	return nil
-}
-
 func (v MongoAdminRepository) Delete(ctx context.Context, id ID) error {
 	ctx, span := Tracer.Start(ctx, "DeleteAdmin")
 	defer span.End()
@@ -1086,59 +1094,17 @@ func (v MongoAdminRepository) Delete(ctx context.Context, id ID) error {
 	return deletes(ctx, v.col, id, admin_repo_source)
 }
 
-func (v MongoAdminRepository) DeleteIngredients(ctx context.Context, id ID, ids []*ID) error {
-	ctx, span := Tracer.Start(ctx, "DeleteIngredients")
-	defer span.End()
-
-	Logger.InfoContext(ctx, "Deleting ingredients for admin",
-		slog.String("adminID", id.String()), slog.Any("ingredientIDs", ids), admin_repo_source)
-
-	objIDs := make([]bson.ObjectID, len(ids))
-	for i, ingID := range ids {
-		objIDs[i] = ingID.value
-	}
-
-	filter := bson.D{{Key: "_id", Value: id.value}}
-	update := bson.D{{Key: "$pull", Value: bson.M{
-		"ingredients": bson.M{"ingredient_id": bson.M{"$in": objIDs}},
-	}}}
-
-	result, err := v.col.UpdateOne(ctx, filter, update)
-	if err != nil {
-		Logger.ErrorContext(ctx, "Error deleting ingredients", slog.Any("error", err), admin_repo_source)
-		return err
-	}
-
-	if !result.Acknowledged {
-		Logger.ErrorContext(ctx, "Write concern returned false", slog.String("ID", id.String()), admin_repo_source)
-		return fmt.Errorf("write concern returned false")
-	}
-
-	if result.MatchedCount == 0 {
-		Logger.ErrorContext(ctx, "Admin not found", slog.String("ID", id.String()), admin_repo_source)
-		return fmt.Errorf("admin with ID %s not found", id.String())
-	}
-
-	if result.ModifiedCount == 0 {
-		Logger.ErrorContext(ctx, "No ingredients were deleted, they may not exist",
-			slog.String("adminID", id.String()), admin_repo_source)
-		return fmt.Errorf("no ingredients were deleted with adminID: %s", id.String())
-	}
-
-	Logger.InfoContext(ctx, "Ingredients deleted successfully", slog.String("adminID", id.String()), admin_repo_source)
-	return nil
-}
-
+// getAllIngredients is the shared catalog every admin edits, users and vendors see the same ingredients
 func getAllIngredients(ctx context.Context, source slog.Attr) ([]*Ingredient, error) {
-	ctx, span := Tracer.Start(ctx, "GetAdminIngredients")
+	ctx, span := Tracer.Start(ctx, "GetAllIngredients")
 	defer span.End()
 
-	admin := Admin{}
-	if err := MongoClient.Database(os.Getenv("DB_NAME")).Collection("admin").FindOne(ctx, bson.D{}).Decode(&admin); err != nil {
-		Logger.ErrorContext(ctx, "Error in Fetching the single saved admin", slog.Any("error", err), source)
+	ingredients, err := Repos.Catalog.FindAllCatalogIngredients(ctx)
+	if err != nil {
+		Logger.ErrorContext(ctx, "Error in Fetching the catalog", slog.Any("error", err), source)
 		return nil, err
 	}
 
 	Logger.InfoContext(ctx, "Ingrediets found Successfully", source)
-	return admin.Ingredients, nil
+	return ingredients, nil
 }
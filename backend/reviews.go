@@ -0,0 +1,247 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"math"
+	"net/url"
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	"go.mongodb.org/mongo-driver/v2/bson"
+	"go.mongodb.org/mongo-driver/v2/mongo"
+	"go.mongodb.org/mongo-driver/v2/mongo/options"
+)
+
+const (
+	reviewPublished = "published"
+	reviewHidden    = "hidden"
+
+	maxReviewText         = 2000
+	defaultReviewPageSize = 20
+	maxReviewPageSize     = 100
+)
+
+var (
+	review_repo_source = slog.Any("source", "ReviewRepository")
+
+	errReviewExists = errors.New("the order has already been reviewed")
+)
+
+func (r *Review) validate() error {
+	if r.Rating < 1 || r.Rating > 5 {
+		return errors.New("rating must be from 1 to 5 stars")
+	}
+	r.Text = strings.TrimSpace(r.Text)
+	if utf8.RuneCountInString(r.Text) > maxReviewText {
+		return fmt.Errorf("a review can be at most %d characters", maxReviewText)
+	}
+	return nil
+}
+
+func (r *ReviewReply) validate() error {
+	r.Text = strings.TrimSpace(r.Text)
+	if r.Text == "" {
+		return errors.New("a reply needs text")
+	}
+	if utf8.RuneCountInString(r.Text) > maxReviewText {
+		return fmt.Errorf("a reply can be at most %d characters", maxReviewText)
+	}
+	return nil
+}
+
+// parseReviewQuery reads status, page and page_size, only admins may list hidden reviews
+func parseReviewQuery(q url.Values, moderator bool) (*ReviewQuery, error) {
+	query := &ReviewQuery{Status: q.Get("status"), Page: 1, PageSize: defaultReviewPageSize}
+	switch query.Status {
+	case "", reviewPublished, reviewHidden:
+	default:
+		return nil, fmt.Errorf("status must be %s or %s", reviewPublished, reviewHidden)
+	}
+	if !moderator {
+		query.Status = reviewPublished
+	}
+	if err := parsePaging(q, &query.Page, &query.PageSize, maxReviewPageSize); err != nil {
+		return nil, err
+	}
+	return query, nil
+}
+
+// newStoreRating is the rating shown on a store, nil when it has no published reviews
+func newStoreRating(average float64, count int) *StoreRating {
+	if count == 0 {
+		return nil
+	}
+	return &StoreRating{Average: math.Round(average*100) / 100, Count: count}
+}
+
+// ReviewRepository keeps the reviews of delivered orders and the store ratings they add up to
+type ReviewRepository interface {
+	CreateReview(context.Context, *Review) error
+	FindReviews(context.Context, *ReviewQuery) ([]*Review, error)
+	ReplyToReview(context.Context, ID, bson.ObjectID, *ReviewReply) (*Review, error)
+	SetReviewStatus(context.Context, bson.ObjectID, string) (*Review, error)
+	DeleteReview(context.Context, bson.ObjectID) (*Review, error)
+	RefreshStoreRating(context.Context, bson.ObjectID, bson.ObjectID) error
+}
+
+type MongoReviewRepository struct {
+	col    *mongo.Collection
+	vendor *mongo.Collection
+}
+
+func newMongoReviewRepository(client *mongo.Client, dbName string) ReviewRepository {
+	db := client.Database(dbName)
+	return &MongoReviewRepository{col: db.Collection("reviews"), vendor: db.Collection("vendor")}
+}
+
+func (m MongoReviewRepository) CreateReview(ctx context.Context, review *Review) error {
+	ctx, span := Tracer.Start(ctx, "CreateReview")
+	defer span.End()
+
+	review.ID, review.Status, review.CreatedAt = bson.NewObjectID(), reviewPublished, time.Now()
+	review.UpdatedAt = review.CreatedAt
+	if _, err := m.col.InsertOne(ctx, review); err != nil {
+		if mongo.IsDuplicateKeyError(err) {
+			return errReviewExists
+		}
+		Logger.ErrorContext(ctx, "Error creating review", slog.Any("error", err), review_repo_source)
+		return err
+	}
+	Logger.InfoContext(ctx, "Review created", slog.String("reviewID", review.ID.Hex()),
+		slog.String("storeID", review.StoreID.Hex()), slog.Int("rating", review.Rating), review_repo_source)
+	return nil
+}
+
+// FindReviews lists the reviews the query matches newest first
+func (m MongoReviewRepository) FindReviews(ctx context.Context, query *ReviewQuery) ([]*Review, error) {
+	ctx, span := Tracer.Start(ctx, "FindReviews")
+	defer span.End()
+
+	filter := bson.D{}
+	if !query.VendorID.IsZero() {
+		filter = append(filter, bson.E{Key: "vendor_id", Value: query.VendorID})
+	}
+	if !query.StoreID.IsZero() {
+		filter = append(filter, bson.E{Key: "store_id", Value: query.StoreID})
+	}
+	if query.Status != "" {
+		filter = append(filter, bson.E{Key: "status", Value: query.Status})
+	}
+	opts := options.Find().
+		SetSort(bson.D{{Key: "created_at", Value: -1}}).
+		SetSkip(int64((query.Page - 1) * query.PageSize)).
+		SetLimit(int64(query.PageSize))
+	cursor, err := m.col.Find(ctx, filter, opts)
+	if err != nil {
+		Logger.ErrorContext(ctx, "Error finding reviews", slog.Any("error", err), review_repo_source)
+		return nil, err
+	}
+	reviews := []*Review{}
+	if err := cursor.All(ctx, &reviews); err != nil {
+		Logger.ErrorContext(ctx, "Error decoding reviews", slog.Any("error", err), review_repo_source)
+		return nil, err
+	}
+	return reviews, nil
+}
+
+// ReplyToReview sets the vendor's reply on a review of one of their stores, a second reply replaces the first
+func (m MongoReviewRepository) ReplyToReview(ctx context.Context, vendorID ID, reviewID bson.ObjectID, reply *ReviewReply) (*Review, error) {
+	ctx, span := Tracer.Start(ctx, "ReplyToReview")
+	defer span.End()
+
+	reply.At = time.Now()
+	filter := bson.D{{Key: "_id", Value: reviewID}, {Key: "vendor_id", Value: vendorID.value}}
+	update := bson.D{{Key: "$set", Value: bson.M{"reply": reply, "updated_at": reply.At}}}
+	return m.updateReview(ctx, filter, update)
+}
+
+// SetReviewStatus publishes or hides a review, hidden reviews do not count towards the store rating
+func (m MongoReviewRepository) SetReviewStatus(ctx context.Context, reviewID bson.ObjectID, status string) (*Review, error) {
+	ctx, span := Tracer.Start(ctx, "SetReviewStatus")
+	defer span.End()
+
+	update := bson.D{{Key: "$set", Value: bson.M{"status": status, "updated_at": time.Now()}}}
+	return m.updateReview(ctx, bson.D{{Key: "_id", Value: reviewID}}, update)
+}
+
+func (m MongoReviewRepository) updateReview(ctx context.Context, filter, update bson.D) (*Review, error) {
+	var review Review
+	opts := options.FindOneAndUpdate().SetReturnDocument(options.After)
+	if err := m.col.FindOneAndUpdate(ctx, filter, update, opts).Decode(&review); err != nil {
+		if errors.Is(err, mongo.ErrNoDocuments) {
+			return nil, &NoItems{}
+		}
+		Logger.ErrorContext(ctx, "Error updating review", slog.Any("error", err), review_repo_source)
+		return nil, err
+	}
+	return &review, nil
+}
+
+// DeleteReview removes a review and returns it so the rating of its store can be refreshed
+func (m MongoReviewRepository) DeleteReview(ctx context.Context, reviewID bson.ObjectID) (*Review, error) {
+	ctx, span := Tracer.Start(ctx, "DeleteReview")
+	defer span.End()
+
+	var review Review
+	if err := m.col.FindOneAndDelete(ctx, bson.D{{Key: "_id", Value: reviewID}}).Decode(&review); err != nil {
+		if errors.Is(err, mongo.ErrNoDocuments) {
+			return nil, &NoItems{}
+		}
+		Logger.ErrorContext(ctx, "Error deleting review", slog.Any("error", err), review_repo_source)
+		return nil, err
+	}
+	return &review, nil
+}
+
+// RefreshStoreRating writes the average of the store's published reviews on the store
+func (m MongoReviewRepository) RefreshStoreRating(ctx context.Context, vendorID, storeID bson.ObjectID) error {
+	ctx, span := Tracer.Start(ctx, "RefreshStoreRating")
+	defer span.End()
+
+	pipeline := mongo.Pipeline{
+		{{Key: "$match", Value: bson.D{
+			{Key: "vendor_id", Value: vendorID},
+			{Key: "store_id", Value: storeID},
+			{Key: "status", Value: reviewPublished},
+		}}},
+		{{Key: "$group", Value: bson.D{
+			{Key: "_id", Value: nil},
+			{Key: "average", Value: bson.D{{Key: "$avg", Value: "$rating"}}},
+			{Key: "count", Value: bson.D{{Key: "$sum", Value: 1}}},
+		}}},
+	}
+	cursor, err := m.col.Aggregate(ctx, pipeline)
+	if err != nil {
+		Logger.ErrorContext(ctx, "Error averaging store reviews", slog.Any("error", err), review_repo_source)
+		return err
+	}
+	var totals []struct {
+		Average float64 `bson:"average"`
+		Count   int     `bson:"count"`
+	}
+	if err := cursor.All(ctx, &totals); err != nil {
+		Logger.ErrorContext(ctx, "Error decoding store reviews", slog.Any("error", err), review_repo_source)
+		return err
+	}
+
+	var rating *StoreRating
+	if len(totals) == 1 {
+		rating = newStoreRating(totals[0].Average, totals[0].Count)
+	}
+	update := bson.D{{Key: "$unset", Value: bson.M{"stores.$[s].rating": ""}}}
+	if rating != nil {
+		update = bson.D{{Key: "$set", Value: bson.M{"stores.$[s].rating": rating}}}
+	}
+	_, err = m.vendor.UpdateOne(ctx, bson.D{{Key: "_id", Value: vendorID}}, update,
+		options.UpdateOne().SetArrayFilters([]any{bson.M{"s._id": storeID}}))
+	if err != nil {
+		Logger.ErrorContext(ctx, "Error writing store rating", slog.String("storeID", storeID.Hex()),
+			slog.Any("error", err), review_repo_source)
+		return err
+	}
+	return nil
+}
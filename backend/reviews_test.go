@@ -0,0 +1,50 @@
+package main
+
+import (
+	"net/url"
+	"strings"
+	"testing"
+)
+
+func TestReviewValidate(t *testing.T) {
+	for _, rating := range []int{0, 6} {
+		if (&Review{Rating: rating}).validate() == nil {
+			t.Fatalf("expected %d stars to fail", rating)
+		}
+	}
+	if (&Review{Rating: 4, Text: strings.Repeat("a", maxReviewText+1)}).validate() == nil {
+		t.Fatal("expected a long review to fail")
+	}
+	review := &Review{Rating: 5, Text: "  Fresh and on time "}
+	if err := review.validate(); err != nil || review.Text != "Fresh and on time" {
+		t.Fatalf("expected a trimmed review got %q %v", review.Text, err)
+	}
+	if (&ReviewReply{Text: "  "}).validate() == nil {
+		t.Fatal("expected an empty reply to fail")
+	}
+}
+
+func TestParseReviewQuery(t *testing.T) {
+	query, err := parseReviewQuery(url.Values{"status": {reviewHidden}, "page": {"2"}}, false)
+	if err != nil || query.Status != reviewPublished || query.Page != 2 || query.PageSize != defaultReviewPageSize {
+		t.Fatalf("expected users to only see published reviews got %+v %v", query, err)
+	}
+	if query, err := parseReviewQuery(url.Values{"status": {reviewHidden}}, true); err != nil || query.Status != reviewHidden {
+		t.Fatalf("expected admins to list hidden reviews got %+v %v", query, err)
+	}
+	if _, err := parseReviewQuery(url.Values{"status": {"flagged"}}, true); err == nil {
+		t.Fatal("expected an unknown status to fail")
+	}
+	if _, err := parseReviewQuery(url.Values{"page_size": {"500"}}, true); err == nil {
+		t.Fatal("expected a page size over the limit to fail")
+	}
+}
+
+func TestNewStoreRating(t *testing.T) {
+	if rating := newStoreRating(0, 0); rating != nil {
+		t.Fatalf("expected no rating without reviews got %+v", rating)
+	}
+	if rating := newStoreRating(13.0/3, 3); rating.Average != 4.33 || rating.Count != 3 {
+		t.Fatalf("unexpected rating %+v", rating)
+	}
+}
@@ -0,0 +1,454 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"log/slog"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"go.mongodb.org/mongo-driver/v2/bson"
+	"go.mongodb.org/mongo-driver/v2/mongo"
+	"go.mongodb.org/mongo-driver/v2/mongo/options"
+)
+
+const (
+	settlementSale       = "sale"
+	settlementCommission = "commission"
+	settlementRefund     = "refund"
+
+	statementDue  = "due"
+	statementPaid = "paid"
+
+	// defaultCommissionRate is the platform commission in percent when PLATFORM_COMMISSION is not set
+	defaultCommissionRate = 10.0
+	settlementInterval    = time.Hour
+)
+
+var (
+	settlement_repo_source = slog.Any("source", "SettlementRepository")
+
+	errStatementPaid = errors.New("the statement has already been paid")
+)
+
+// platformCommission is the commission in percent vendors without their own rate pay on their sales
+func platformCommission() float64 {
+	value := os.Getenv("PLATFORM_COMMISSION")
+	if value == "" {
+		return defaultCommissionRate
+	}
+	rate, err := strconv.ParseFloat(value, 64)
+	if err != nil || rate < 0 || rate > 100 {
+		Logger.Error("PLATFORM_COMMISSION must be a percentage, using the default", slog.String("value", value),
+			settlement_repo_source)
+		return defaultCommissionRate
+	}
+	return rate
+}
+
+func validCommission(rate float64) error {
+	if rate < 0 || rate > 100 {
+		return errors.New("rate must be a percentage from 0 to 100")
+	}
+	return nil
+}
+
+// orderSettlement is what a delivered order earns the vendor and the commission on it. The vendor is owed the
+// total plus a platform coupon's discount, the platform funds those, and the commission is charged on the goods
+// after a vendor coupon's discount
+func orderSettlement(order *Order, rate float64) (sale, commission float64) {
+	subtotal := order.Subtotal
+	if subtotal == 0 {
+		for _, item := range order.Items {
+			subtotal += item.linePrice(item.Quantity)
+		}
+	}
+	sale = order.TotalPrice
+	if discount := order.Discount; discount != nil {
+		if discount.Issuer == couponIssuerPlatform {
+			sale += discount.Amount
+		} else {
+			subtotal -= discount.Amount
+		}
+	}
+	return roundCents(sale), roundCents(max(subtotal, 0) * rate / 100)
+}
+
+// accrualEntries are the entries of an order that was delivered
+func accrualEntries(vendorID bson.ObjectID, order *Order, rate float64, now time.Time) []*SettlementEntry {
+	sale, commission := orderSettlement(order, rate)
+	entry := func(kind string, amount, rate float64) *SettlementEntry {
+		return &SettlementEntry{ID: bson.NewObjectID(), VendorID: vendorID, StoreID: order.StoreID, OrderID: order.ID,
+			Kind: kind, Amount: amount, Rate: rate, At: now}
+	}
+	return []*SettlementEntry{entry(settlementSale, sale, 0), entry(settlementCommission, -commission, rate)}
+}
+
+// reversalEntries refund what is left of an order's entries, the vendor gives back the sale less earlier refunds
+// and the platform gives back the commission
+func reversalEntries(order *Order, entries []*SettlementEntry, now time.Time) []*SettlementEntry {
+	if len(entries) == 0 {
+		return nil
+	}
+	owed, commission := 0.0, 0.0
+	for _, entry := range entries {
+		if entry.Kind == settlementCommission {
+			commission += entry.Amount
+		} else {
+			owed += entry.Amount
+		}
+	}
+	var reversal []*SettlementEntry
+	add := func(kind string, amount float64) {
+		if amount = roundCents(amount); amount != 0 {
+			reversal = append(reversal, &SettlementEntry{ID: bson.NewObjectID(), VendorID: entries[0].VendorID,
+				StoreID: order.StoreID, OrderID: order.ID, Kind: kind, Amount: amount, At: now})
+		}
+	}
+	add(settlementRefund, -owed)
+	add(settlementCommission, -commission)
+	return reversal
+}
+
+// statementPeriodEnd is the start of the week of now, statements cover whole weeks from Monday in UTC
+func statementPeriodEnd(now time.Time) time.Time {
+	day := now.UTC().Truncate(24 * time.Hour)
+	return day.AddDate(0, 0, -(int(day.Weekday())+6)%7)
+}
+
+// newStatement sums the entries of a vendor that accrued before end, the period starts a week before end or at
+// the first entry when older ones were left over
+func newStatement(vendorID bson.ObjectID, entries []*SettlementEntry, end, now time.Time) *PayoutStatement {
+	statement := &PayoutStatement{ID: bson.NewObjectID(), VendorID: vendorID, PeriodStart: end.AddDate(0, 0, -7),
+		PeriodEnd: end, Entries: len(entries), Status: statementDue, CreatedAt: now}
+	for _, entry := range entries {
+		switch entry.Kind {
+		case settlementSale:
+			statement.Sales += entry.Amount
+		case settlementCommission:
+			statement.Commission += entry.Amount
+		case settlementRefund:
+			statement.Refunds += entry.Amount
+		}
+		if entry.At.Before(statement.PeriodStart) {
+			statement.PeriodStart = entry.At
+		}
+	}
+	statement.Sales, statement.Commission, statement.Refunds = roundCents(statement.Sales),
+		roundCents(statement.Commission), roundCents(statement.Refunds)
+	statement.Net = roundCents(statement.Sales + statement.Commission + statement.Refunds)
+	return statement
+}
+
+// findCommissionRate is the vendor's own commission or the platform's
+func findCommissionRate(ctx context.Context, accounts *mongo.Collection, vendorID bson.ObjectID) (float64, error) {
+	var account struct {
+		CommissionRate *float64 `bson:"commission_rate"`
+	}
+	if err := accounts.FindOne(ctx, bson.D{{Key: "_id", Value: vendorID}}).Decode(&account); err != nil {
+		if errors.Is(err, mongo.ErrNoDocuments) {
+			return platformCommission(), nil
+		}
+		Logger.ErrorContext(ctx, "Error reading the vendor commission", slog.String("vendorID", vendorID.Hex()),
+			slog.Any("error", err), settlement_repo_source)
+		return 0, err
+	}
+	if account.CommissionRate == nil {
+		return platformCommission(), nil
+	}
+	return *account.CommissionRate, nil
+}
+
+// recordSettlement accrues an order that was just delivered and refunds one that left delivered, it runs in the
+// transaction that changes the order status
+func (m MongoVendorRepository) recordSettlement(sessCtx context.Context, vendorID ID, order *Order, to string, now time.Time) error {
+	from := order.OrderStatus
+	delivered := func(status string) bool { return strings.EqualFold(status, orderDelivered) }
+	var entries []*SettlementEntry
+	switch {
+	case !delivered(from) && delivered(to):
+		rate, err := findCommissionRate(sessCtx, m.accounts, vendorID.value)
+		if err != nil {
+			return err
+		}
+		entries = accrualEntries(vendorID.value, order, rate, now)
+	case delivered(from) && !delivered(to):
+		cursor, err := m.settlements.Find(sessCtx, bson.D{{Key: "vendor_id", Value: vendorID.value}, {Key: "order_id", Value: order.ID}})
+		if err != nil {
+			Logger.ErrorContext(sessCtx, "Error reading order settlement", slog.Any("error", err), vendor_repo_source)
+			return err
+		}
+		var accrued []*SettlementEntry
+		if err := cursor.All(sessCtx, &accrued); err != nil {
+			Logger.ErrorContext(sessCtx, "Error decoding order settlement", slog.Any("error", err), vendor_repo_source)
+			return err
+		}
+		entries = reversalEntries(order, accrued, now)
+	}
+	if len(entries) == 0 {
+		return nil
+	}
+	if _, err := m.settlements.InsertMany(sessCtx, entries); err != nil {
+		Logger.ErrorContext(sessCtx, "Error recording order settlement", slog.String("orderID", order.ID.Hex()),
+			slog.Any("error", err), vendor_repo_source)
+		return err
+	}
+	Logger.InfoContext(sessCtx, "Order settlement recorded", slog.String("vendorID", vendorID.String()),
+		slog.String("orderID", order.ID.Hex()), slog.String("status", to), vendor_repo_source)
+	return nil
+}
+
+// closeStatements is the payout statement job
+func closeStatements(ctx context.Context) error {
+	closed, err := Repos.Settlement.CloseStatements(ctx, statementPeriodEnd(time.Now()))
+	if closed > 0 {
+		Logger.InfoContext(ctx, "Payout statements created", slog.Int("statements", closed), settlement_repo_source)
+	}
+	return err
+}
+
+// SettlementRepository keeps what the platform owes each vendor and the statements it is paid out on
+type SettlementRepository interface {
+	FindPayouts(context.Context, ID) (*Payouts, error)
+	FindStatement(context.Context, bson.ObjectID, bson.ObjectID) (*PayoutStatement, []*SettlementEntry, error)
+	FindStatements(context.Context, string) ([]*PayoutStatement, error)
+	MarkStatementPaid(context.Context, bson.ObjectID, string, time.Time) (*PayoutStatement, error)
+	SetCommissionRate(context.Context, ID, *float64) error
+	CloseStatements(context.Context, time.Time) (int, error)
+}
+
+type MongoSettlementRepository struct {
+	col        *mongo.Collection
+	statements *mongo.Collection
+	accounts   *mongo.Collection
+}
+
+func newMongoSettlementRepository(client *mongo.Client, dbName string) SettlementRepository {
+	db := client.Database(dbName)
+	return &MongoSettlementRepository{col: db.Collection("settlements"), statements: db.Collection("payout_statements"),
+		accounts: db.Collection("settlement_accounts")}
+}
+
+// FindPayouts is the vendor's commission, what accrued since the last statement and their statements newest
+// first
+func (m MongoSettlementRepository) FindPayouts(ctx context.Context, vendorID ID) (*Payouts, error) {
+	ctx, span := Tracer.Start(ctx, "FindPayouts")
+	defer span.End()
+
+	rate, err := findCommissionRate(ctx, m.accounts, vendorID.value)
+	if err != nil {
+		return nil, err
+	}
+	payouts := &Payouts{CommissionRate: rate, Statements: []*PayoutStatement{}}
+
+	pipeline := mongo.Pipeline{
+		{{Key: "$match", Value: bson.D{
+			{Key: "vendor_id", Value: vendorID.value},
+			{Key: "statement_id", Value: bson.D{{Key: "$exists", Value: false}}},
+		}}},
+		{{Key: "$group", Value: bson.D{{Key: "_id", Value: nil}, {Key: "pending", Value: bson.D{{Key: "$sum", Value: "$amount"}}}}}},
+	}
+	cursor, err := m.col.Aggregate(ctx, pipeline)
+	if err != nil {
+		Logger.ErrorContext(ctx, "Error summing pending settlement", slog.Any("error", err), settlement_repo_source)
+		return nil, err
+	}
+	var pending []struct {
+		Pending float64 `bson:"pending"`
+	}
+	if err := cursor.All(ctx, &pending); err != nil {
+		Logger.ErrorContext(ctx, "Error decoding pending settlement", slog.Any("error", err), settlement_repo_source)
+		return nil, err
+	}
+	if len(pending) == 1 {
+		payouts.Pending = roundCents(pending[0].Pending)
+	}
+
+	cursor, err = m.statements.Find(ctx, bson.D{{Key: "vendor_id", Value: vendorID.value}},
+		options.Find().SetSort(bson.D{{Key: "period_end", Value: -1}}))
+	if err != nil {
+		Logger.ErrorContext(ctx, "Error finding payout statements", slog.Any("error", err), settlement_repo_source)
+		return nil, err
+	}
+	if err := cursor.All(ctx, &payouts.Statements); err != nil {
+		Logger.ErrorContext(ctx, "Error decoding payout statements", slog.Any("error", err), settlement_repo_source)
+		return nil, err
+	}
+	return payouts, nil
+}
+
+// FindStatement reads a statement with its entries, a nil vendor is an admin who may read any statement
+func (m MongoSettlementRepository) FindStatement(ctx context.Context, vendorID, statementID bson.ObjectID) (*PayoutStatement, []*SettlementEntry, error) {
+	ctx, span := Tracer.Start(ctx, "FindStatement")
+	defer span.End()
+
+	filter := bson.D{{Key: "_id", Value: statementID}}
+	if !vendorID.IsZero() {
+		filter = append(filter, bson.E{Key: "vendor_id", Value: vendorID})
+	}
+	var statement PayoutStatement
+	if err := m.statements.FindOne(ctx, filter).Decode(&statement); err != nil {
+		if errors.Is(err, mongo.ErrNoDocuments) {
+			return nil, nil, &NoItems{}
+		}
+		Logger.ErrorContext(ctx, "Error finding payout statement", slog.Any("error", err), settlement_repo_source)
+		return nil, nil, err
+	}
+	cursor, err := m.col.Find(ctx, bson.D{{Key: "statement_id", Value: statementID}}, options.Find().SetSort(bson.D{{Key: "at", Value: 1}}))
+	if err != nil {
+		Logger.ErrorContext(ctx, "Error finding statement entries", slog.Any("error", err), settlement_repo_source)
+		return nil, nil, err
+	}
+	entries := []*SettlementEntry{}
+	if err := cursor.All(ctx, &entries); err != nil {
+		Logger.ErrorContext(ctx, "Error decoding statement entries", slog.Any("error", err), settlement_repo_source)
+		return nil, nil, err
+	}
+	return &statement, entries, nil
+}
+
+// FindStatements lists the statements of every vendor oldest first, status narrows them to due or paid
+func (m MongoSettlementRepository) FindStatements(ctx context.Context, status string) ([]*PayoutStatement, error) {
+	ctx, span := Tracer.Start(ctx, "FindStatements")
+	defer span.End()
+
+	filter := bson.D{}
+	if status != "" {
+		filter = append(filter, bson.E{Key: "status", Value: status})
+	}
+	cursor, err := m.statements.Find(ctx, filter, options.Find().SetSort(bson.D{{Key: "period_end", Value: 1}}))
+	if err != nil {
+		Logger.ErrorContext(ctx, "Error finding payout statements", slog.Any("error", err), settlement_repo_source)
+		return nil, err
+	}
+	statements := []*PayoutStatement{}
+	if err := cursor.All(ctx, &statements); err != nil {
+		Logger.ErrorContext(ctx, "Error decoding payout statements", slog.Any("error", err), settlement_repo_source)
+		return nil, err
+	}
+	return statements, nil
+}
+
+// MarkStatementPaid records that the statement was paid out under the reference, errStatementPaid when it
+// already was
+func (m MongoSettlementRepository) MarkStatementPaid(ctx context.Context, statementID bson.ObjectID, reference string, now time.Time) (*PayoutStatement, error) {
+	ctx, span := Tracer.Start(ctx, "MarkStatementPaid")
+	defer span.End()
+
+	filter := bson.D{{Key: "_id", Value: statementID}, {Key: "status", Value: statementDue}}
+	update := bson.D{{Key: "$set", Value: bson.M{"status": statementPaid, "reference": reference, "paid_at": now}}}
+	var statement PayoutStatement
+	opts := options.FindOneAndUpdate().SetReturnDocument(options.After)
+	if err := m.statements.FindOneAndUpdate(ctx, filter, update, opts).Decode(&statement); err != nil {
+		if !errors.Is(err, mongo.ErrNoDocuments) {
+			Logger.ErrorContext(ctx, "Error marking statement paid", slog.Any("error", err), settlement_repo_source)
+			return nil, err
+		}
+		count, err := m.statements.CountDocuments(ctx, bson.D{{Key: "_id", Value: statementID}})
+		if err != nil {
+			Logger.ErrorContext(ctx, "Error finding payout statement", slog.Any("error", err), settlement_repo_source)
+			return nil, err
+		}
+		if count > 0 {
+			return nil, errStatementPaid
+		}
+		return nil, &NoItems{}
+	}
+	Logger.InfoContext(ctx, "Payout statement paid", slog.String("statementID", statementID.Hex()),
+		slog.String("vendorID", statement.VendorID.Hex()), slog.Float64("net", statement.Net), settlement_repo_source)
+	return &statement, nil
+}
+
+// SetCommissionRate gives the vendor their own commission, nil puts them back on the platform rate. Orders
+// already delivered keep the commission they accrued
+func (m MongoSettlementRepository) SetCommissionRate(ctx context.Context, vendorID ID, rate *float64) error {
+	ctx, span := Tracer.Start(ctx, "SetCommissionRate")
+	defer span.End()
+
+	update := bson.D{{Key: "$unset", Value: bson.M{"commission_rate": ""}}}
+	if rate != nil {
+		update = bson.D{{Key: "$set", Value: bson.M{"commission_rate": *rate}}}
+	}
+	update = append(update, bson.E{Key: "$currentDate", Value: bson.M{"updated_at": true}})
+	if _, err := m.accounts.UpdateOne(ctx, bson.D{{Key: "_id", Value: vendorID.value}}, update, options.UpdateOne().SetUpsert(true)); err != nil {
+		Logger.ErrorContext(ctx, "Error setting the vendor commission", slog.Any("error", err), settlement_repo_source)
+		return err
+	}
+	return nil
+}
+
+// CloseStatements puts the entries every vendor accrued before end on a new statement, one transaction per
+// vendor so an entry is on exactly one statement
+func (m MongoSettlementRepository) CloseStatements(ctx context.Context, end time.Time) (int, error) {
+	ctx, span := Tracer.Start(ctx, "CloseStatements")
+	defer span.End()
+
+	open := bson.D{
+		{Key: "statement_id", Value: bson.D{{Key: "$exists", Value: false}}},
+		{Key: "at", Value: bson.D{{Key: "$lt", Value: end}}},
+	}
+	var vendorIDs []bson.ObjectID
+	if err := m.col.Distinct(ctx, "vendor_id", open).Decode(&vendorIDs); err != nil {
+		Logger.ErrorContext(ctx, "Error finding vendors to settle", slog.Any("error", err), settlement_repo_source)
+		return 0, err
+	}
+
+	closed := 0
+	var errs []error
+	for _, vendorID := range vendorIDs {
+		if err := m.closeStatement(ctx, vendorID, open, end); err != nil {
+			errs = append(errs, err)
+			continue
+		}
+		closed++
+	}
+	return closed, errors.Join(errs...)
+}
+
+func (m MongoSettlementRepository) closeStatement(ctx context.Context, vendorID bson.ObjectID, open bson.D, end time.Time) error {
+	session, err := MongoClient.StartSession(sesOp)
+	if err != nil {
+		Logger.ErrorContext(ctx, "Unable to create a session", slog.Any("error", err), settlement_repo_source)
+		return err
+	}
+	defer session.EndSession(ctx)
+
+	_, err = session.WithTransaction(ctx, func(sessCtx context.Context) (any, error) {
+		filter := append(bson.D{{Key: "vendor_id", Value: vendorID}}, open...)
+		cursor, err := m.col.Find(sessCtx, filter)
+		if err != nil {
+			Logger.ErrorContext(sessCtx, "Error finding settlement entries", slog.Any("error", err), settlement_repo_source)
+			return nil, err
+		}
+		var entries []*SettlementEntry
+		if err := cursor.All(sessCtx, &entries); err != nil {
+			Logger.ErrorContext(sessCtx, "Error decoding settlement entries", slog.Any("error", err), settlement_repo_source)
+			return nil, err
+		}
+		if len(entries) == 0 {
+			return nil, nil
+		}
+
+		statement := newStatement(vendorID, entries, end, time.Now())
+		if _, err := m.statements.InsertOne(sessCtx, statement); err != nil {
+			Logger.ErrorContext(sessCtx, "Error saving payout statement", slog.Any("error", err), settlement_repo_source)
+			return nil, err
+		}
+		ids := make([]bson.ObjectID, len(entries))
+		for i, entry := range entries {
+			ids[i] = entry.ID
+		}
+		_, err = m.col.UpdateMany(sessCtx, bson.D{{Key: "_id", Value: bson.D{{Key: "$in", Value: ids}}}},
+			bson.D{{Key: "$set", Value: bson.M{"statement_id": statement.ID}}})
+		if err != nil {
+			Logger.ErrorContext(sessCtx, "Error settling entries", slog.Any("error", err), settlement_repo_source)
+			return nil, err
+		}
+		Logger.InfoContext(sessCtx, "Payout statement created", slog.String("vendorID", vendorID.Hex()),
+			slog.String("statementID", statement.ID.Hex()), slog.Float64("net", statement.Net), settlement_repo_source)
+		return nil, nil
+	})
+	return err
+}
@@ -0,0 +1,93 @@
+package main
+
+import (
+	"testing"
+	"time"
+
+	"go.mongodb.org/mongo-driver/v2/bson"
+)
+
+func TestOrderSettlement(t *testing.T) {
+	order := &Order{Subtotal: 100, DeliveryFee: 5, TotalPrice: 113}
+	if sale, commission := orderSettlement(order, 10); sale != 113 || commission != 10 {
+		t.Fatalf("expected the total and 10%% of the goods got %v %v", sale, commission)
+	}
+
+	order.Discount, order.TotalPrice = &Discount{Issuer: couponIssuerPlatform, Amount: 20}, 93
+	if sale, commission := orderSettlement(order, 10); sale != 113 || commission != 10 {
+		t.Fatalf("expected the platform to fund its coupon got %v %v", sale, commission)
+	}
+	order.Discount.Issuer = couponIssuerVendor
+	if sale, commission := orderSettlement(order, 10); sale != 93 || commission != 8 {
+		t.Fatalf("expected the vendor coupon to lower the sale and commission got %v %v", sale, commission)
+	}
+}
+
+func TestReversalEntries(t *testing.T) {
+	now := time.Now()
+	vendorID := bson.NewObjectID()
+	order := &Order{ID: bson.NewObjectID(), StoreID: bson.NewObjectID(), Subtotal: 50, TotalPrice: 55}
+	accrued := accrualEntries(vendorID, order, 12, now)
+	if len(accrued) != 2 || accrued[0].Amount != 55 || accrued[1].Amount != -6 || accrued[1].Rate != 12 {
+		t.Fatalf("unexpected accrual %+v %+v", accrued[0], accrued[1])
+	}
+	accrued = append(accrued, &SettlementEntry{VendorID: vendorID, Kind: settlementRefund, Amount: -5})
+
+	reversal := reversalEntries(order, accrued, now)
+	if len(reversal) != 2 || reversal[0].Kind != settlementRefund || reversal[0].Amount != -50 ||
+		reversal[1].Kind != settlementCommission || reversal[1].Amount != 6 {
+		t.Fatalf("expected the rest of the sale refunded and the commission given back got %+v", reversal)
+	}
+	total := 0.0
+	for _, entry := range append(accrued, reversal...) {
+		total += entry.Amount
+	}
+	if roundCents(total) != 0 {
+		t.Fatalf("expected the order to net to zero got %v", total)
+	}
+	if reversal := reversalEntries(order, nil, now); reversal != nil {
+		t.Fatal("expected nothing to reverse for an order that never accrued")
+	}
+}
+
+func TestStatementPeriodEnd(t *testing.T) {
+	monday := time.Date(2024, 12, 16, 0, 0, 0, 0, time.UTC)
+	for _, now := range []time.Time{monday, monday.Add(30 * time.Hour), monday.AddDate(0, 0, 6).Add(23 * time.Hour)} {
+		if end := statementPeriodEnd(now); !end.Equal(monday) {
+			t.Fatalf("expected %v for %v got %v", monday, now, end)
+		}
+	}
+}
+
+func TestNewStatement(t *testing.T) {
+	end := time.Date(2024, 12, 16, 0, 0, 0, 0, time.UTC)
+	old := end.AddDate(0, 0, -10)
+	entries := []*SettlementEntry{
+		{Kind: settlementSale, Amount: 100.1, At: old},
+		{Kind: settlementCommission, Amount: -10.01, At: end.Add(-time.Hour)},
+		{Kind: settlementRefund, Amount: -20, At: end.Add(-time.Hour)},
+		{Kind: settlementCommission, Amount: 2, At: end.Add(-time.Hour)},
+	}
+	statement := newStatement(bson.NewObjectID(), entries, end, end)
+	if statement.Sales != 100.1 || statement.Commission != -8.01 || statement.Refunds != -20 || statement.Net != 72.09 {
+		t.Fatalf("unexpected sums %+v", statement)
+	}
+	if !statement.PeriodStart.Equal(old) || statement.Status != statementDue || statement.Entries != 4 {
+		t.Fatalf("expected the period to start at the left over entry got %+v", statement)
+	}
+}
+
+func TestPlatformCommission(t *testing.T) {
+	t.Setenv("PLATFORM_COMMISSION", "")
+	if rate := platformCommission(); rate != defaultCommissionRate {
+		t.Fatalf("expected the default got %v", rate)
+	}
+	t.Setenv("PLATFORM_COMMISSION", "7.5")
+	if rate := platformCommission(); rate != 7.5 {
+		t.Fatalf("expected 7.5 got %v", rate)
+	}
+	t.Setenv("PLATFORM_COMMISSION", "120")
+	if rate := platformCommission(); rate != defaultCommissionRate {
+		t.Fatalf("expected a bad rate to fall back got %v", rate)
+	}
+}
@@ -0,0 +1,413 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"slices"
+	"strings"
+	"time"
+
+	"go.mongodb.org/mongo-driver/v2/bson"
+	"go.mongodb.org/mongo-driver/v2/mongo"
+	"go.mongodb.org/mongo-driver/v2/mongo/options"
+)
+
+const (
+	holdHeld     = "held"
+	holdBooked   = "booked"
+	holdReleased = "released"
+
+	// slotHoldTTL is how long a user has to place the order after holding a slot
+	slotHoldTTL         = 10 * time.Minute
+	slotReleaseInterval = time.Minute
+	defaultSlotDays     = 7
+	maxSlotDays         = 28
+)
+
+var (
+	slot_repo_source = slog.Any("source", "SlotRepository")
+
+	errSlotFull = errors.New("the slot is full")
+	errNoHold   = errors.New("the slot hold does not exist or has expired")
+
+	// slotReleasingStatuses are the order statuses that give the order's slot back
+	slotReleasingStatuses = []string{"rejected", "cancelled", "canceled"}
+)
+
+func releasesSlot(status string) bool {
+	return slices.Contains(slotReleasingStatuses, strings.ToLower(status))
+}
+
+func slotKey(storeID bson.ObjectID, method string, start time.Time) string {
+	return fmt.Sprintf("%s:%s:%d", storeID.Hex(), method, start.Unix())
+}
+
+func (t *SlotTemplate) validate() error {
+	if t.Day < int(time.Sunday) || t.Day > int(time.Saturday) {
+		return errors.New("day must be 0 for sunday through 6 for saturday")
+	}
+	if deliveryMethod(t.Method) == "" {
+		return fmt.Errorf("slot method must be %s or %s", deliveryMethodDelivery, deliveryMethodPickup)
+	}
+	t.Method = deliveryMethod(t.Method)
+	start, err := parseClock(t.Start)
+	if err != nil {
+		return err
+	}
+	end, err := parseClock(t.End)
+	if err != nil {
+		return err
+	}
+	if end <= start {
+		return fmt.Errorf("slot %s to %s must end after it starts on the same day", t.Start, t.End)
+	}
+	if t.Capacity < 1 {
+		return errors.New("slot capacity must be at least 1")
+	}
+	return nil
+}
+
+// slots are the store's slots of the method that start after from over the next days, in the time zone of
+// its hours. Holidays close the slots too
+func (s *Store) slots(vendorID bson.ObjectID, method string, from time.Time, days int) []*Slot {
+	loc := time.UTC
+	closed := map[string]bool{}
+	if s.Hours != nil {
+		if l, err := time.LoadLocation(s.Hours.TimeZone); err == nil {
+			loc = l
+		}
+		for _, holiday := range s.Hours.Holidays {
+			closed[holiday.Date] = true
+		}
+	}
+
+	local := from.In(loc)
+	var slots []*Slot
+	for d := range days {
+		day := time.Date(local.Year(), local.Month(), local.Day()+d, 0, 0, 0, 0, loc)
+		if closed[day.Format(holidayLayout)] {
+			continue
+		}
+		for _, template := range s.SlotTemplates {
+			if template.Day != int(day.Weekday()) || (method != "" && template.Method != method) {
+				continue
+			}
+			start, _ := parseClock(template.Start)
+			end, _ := parseClock(template.End)
+			slot := &Slot{
+				VendorID: vendorID,
+				StoreID:  s.ID,
+				Method:   template.Method,
+				Start:    time.Date(day.Year(), day.Month(), day.Day(), 0, start, 0, 0, loc),
+				End:      time.Date(day.Year(), day.Month(), day.Day(), 0, end, 0, 0, loc),
+				Capacity: template.Capacity,
+			}
+			if !slot.Start.After(from) {
+				continue
+			}
+			slot.ID = slotKey(s.ID, slot.Method, slot.Start)
+			slot.Available = slot.Capacity
+			slots = append(slots, slot)
+		}
+	}
+	slices.SortFunc(slots, func(a, b *Slot) int { return a.Start.Compare(b.Start) })
+	return slots
+}
+
+// findSlot is the slot of the store that starts at start
+func (s *Store) findSlot(vendorID bson.ObjectID, method string, start, now time.Time) *Slot {
+	days := int(start.Sub(now).Hours()/24) + 2
+	if days > maxSlotDays+1 {
+		return nil
+	}
+	for _, slot := range s.slots(vendorID, method, now, days) {
+		if slot.Start.Equal(start) {
+			return slot
+		}
+	}
+	return nil
+}
+
+type SlotRepository interface {
+	FindSlotUsage(context.Context, []string) (map[string]int, error)
+	HoldSlot(context.Context, ID, *Slot) (*SlotHold, error)
+	BookHold(context.Context, ID, bson.ObjectID, bson.ObjectID, string) (*SlotHold, error)
+	AttachOrder(context.Context, bson.ObjectID, bson.ObjectID) error
+	ReleaseHold(context.Context, ID, bson.ObjectID) error
+	ReleaseOrderSlot(context.Context, string, ID, bson.ObjectID) error
+	ReleaseExpiredHolds(context.Context) (int, error)
+}
+
+type MongoSlotRepository struct {
+	usage *mongo.Collection
+	holds *mongo.Collection
+}
+
+func newMongoSlotRepository(client *mongo.Client, dbName string) SlotRepository {
+	db := client.Database(dbName)
+	return &MongoSlotRepository{usage: db.Collection("slot_usage"), holds: db.Collection("slot_holds")}
+}
+
+// FindSlotUsage is how many places of each slot are held or booked, slots nobody took are left out
+func (m MongoSlotRepository) FindSlotUsage(ctx context.Context, keys []string) (map[string]int, error) {
+	ctx, span := Tracer.Start(ctx, "FindSlotUsage")
+	defer span.End()
+
+	used := make(map[string]int, len(keys))
+	if len(keys) == 0 {
+		return used, nil
+	}
+	cursor, err := m.usage.Find(ctx, bson.D{{Key: "_id", Value: bson.D{{Key: "$in", Value: keys}}}})
+	if err != nil {
+		Logger.ErrorContext(ctx, "Error reading slot usage", slog.Any("error", err), slot_repo_source)
+		return nil, err
+	}
+	var slots []*Slot
+	if err := cursor.All(ctx, &slots); err != nil {
+		Logger.ErrorContext(ctx, "Error decoding slot usage", slog.Any("error", err), slot_repo_source)
+		return nil, err
+	}
+	for _, slot := range slots {
+		used[slot.ID] = slot.Used
+	}
+	return used, nil
+}
+
+// HoldSlot takes a place in the slot for the user. The place is taken by one conditional increment so two
+// users can never take the last place, a full slot fails the filter and the upsert then hits the existing
+// slot's id
+func (m MongoSlotRepository) HoldSlot(ctx context.Context, user ID, slot *Slot) (*SlotHold, error) {
+	ctx, span := Tracer.Start(ctx, "HoldSlot")
+	defer span.End()
+
+	Logger.InfoContext(ctx, "Holding a slot", slog.String("userID", user.String()), slog.String("slot", slot.ID),
+		slot_repo_source)
+	now := time.Now()
+	hold := &SlotHold{
+		ID:        bson.NewObjectID(),
+		SlotID:    slot.ID,
+		UserID:    user.value,
+		VendorID:  slot.VendorID,
+		StoreID:   slot.StoreID,
+		Method:    slot.Method,
+		Start:     slot.Start,
+		End:       slot.End,
+		Status:    holdHeld,
+		ExpiresAt: now.Add(slotHoldTTL),
+		CreatedAt: now,
+	}
+
+	session, err := MongoClient.StartSession(sesOp)
+	if err != nil {
+		Logger.ErrorContext(ctx, "Unable to create a session", slog.Any("error", err), slot_repo_source)
+		return nil, err
+	}
+	defer session.EndSession(ctx)
+
+	_, err = session.WithTransaction(ctx, func(sessCtx context.Context) (any, error) {
+		filter := bson.D{{Key: "_id", Value: slot.ID}, {Key: "used", Value: bson.D{{Key: "$lt", Value: slot.Capacity}}}}
+		update := bson.D{
+			{Key: "$inc", Value: bson.M{"used": 1}},
+			{Key: "$setOnInsert", Value: bson.M{
+				"vendor_id": slot.VendorID, "store_id": slot.StoreID, "method": slot.Method, "start": slot.Start,
+				"end": slot.End,
+			}},
+			{Key: "$set", Value: bson.M{"capacity": slot.Capacity}},
+		}
+		if _, err := m.usage.UpdateOne(sessCtx, filter, update, options.UpdateOne().SetUpsert(true)); err != nil {
+			if mongo.IsDuplicateKeyError(err) {
+				return nil, errSlotFull
+			}
+			Logger.ErrorContext(sessCtx, "Error taking a slot place", slog.Any("error", err), slot_repo_source)
+			return nil, err
+		}
+		if _, err := m.holds.InsertOne(sessCtx, hold); err != nil {
+			Logger.ErrorContext(sessCtx, "Error saving the slot hold", slog.Any("error", err), slot_repo_source)
+			return nil, err
+		}
+		return nil, nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return hold, nil
+}
+
+// BookHold turns the user's live hold into a booking for an order of the store and method
+func (m MongoSlotRepository) BookHold(ctx context.Context, user ID, holdID, storeID bson.ObjectID, method string) (*SlotHold, error) {
+	ctx, span := Tracer.Start(ctx, "BookHold")
+	defer span.End()
+
+	filter := bson.D{
+		{Key: "_id", Value: holdID},
+		{Key: "user_id", Value: user.value},
+		{Key: "store_id", Value: storeID},
+		{Key: "method", Value: method},
+		{Key: "status", Value: holdHeld},
+		{Key: "expires_at", Value: bson.D{{Key: "$gt", Value: time.Now()}}},
+	}
+	update := bson.D{{Key: "$set", Value: bson.M{"status": holdBooked}}}
+	opts := options.FindOneAndUpdate().SetReturnDocument(options.After)
+
+	var hold SlotHold
+	if err := m.holds.FindOneAndUpdate(ctx, filter, update, opts).Decode(&hold); err != nil {
+		if errors.Is(err, mongo.ErrNoDocuments) {
+			return nil, errNoHold
+		}
+		Logger.ErrorContext(ctx, "Error booking the slot hold", slog.Any("error", err), slot_repo_source)
+		return nil, err
+	}
+	return &hold, nil
+}
+
+func (m MongoSlotRepository) AttachOrder(ctx context.Context, holdID, orderID bson.ObjectID) error {
+	ctx, span := Tracer.Start(ctx, "AttachOrder")
+	defer span.End()
+
+	filter := bson.D{{Key: "_id", Value: holdID}, {Key: "status", Value: holdBooked}}
+	if _, err := m.holds.UpdateOne(ctx, filter, bson.D{{Key: "$set", Value: bson.M{"order_id": orderID}}}); err != nil {
+		Logger.ErrorContext(ctx, "Error linking the order to its slot", slog.Any("error", err), slot_repo_source)
+		return err
+	}
+	return nil
+}
+
+// release marks the hold matching filter released and gives its place back in the same transaction, it is
+// false when no hold matched
+func (m MongoSlotRepository) release(ctx context.Context, filter bson.D) (bool, error) {
+	session, err := MongoClient.StartSession(sesOp)
+	if err != nil {
+		Logger.ErrorContext(ctx, "Unable to create a session", slog.Any("error", err), slot_repo_source)
+		return false, err
+	}
+	defer session.EndSession(ctx)
+
+	released, err := session.WithTransaction(ctx, func(sessCtx context.Context) (any, error) {
+		var hold SlotHold
+		update := bson.D{{Key: "$set", Value: bson.M{"status": holdReleased, "released_at": time.Now()}}}
+		if err := m.holds.FindOneAndUpdate(sessCtx, filter, update).Decode(&hold); err != nil {
+			if errors.Is(err, mongo.ErrNoDocuments) {
+				return false, nil
+			}
+			return false, err
+		}
+		_, err := m.usage.UpdateOne(sessCtx, bson.D{{Key: "_id", Value: hold.SlotID}, {Key: "used", Value: bson.D{{Key: "$gt", Value: 0}}}},
+			bson.D{{Key: "$inc", Value: bson.M{"used": -1}}})
+		return err == nil, err
+	})
+	if err != nil {
+		Logger.ErrorContext(ctx, "Error releasing a slot hold", slog.Any("error", err), slot_repo_source)
+		return false, err
+	}
+	return released.(bool), nil
+}
+
+func (m MongoSlotRepository) ReleaseHold(ctx context.Context, user ID, holdID bson.ObjectID) error {
+	ctx, span := Tracer.Start(ctx, "ReleaseHold")
+	defer span.End()
+
+	// a booking that never made it onto an order can be given back too
+	filter := bson.D{
+		{Key: "_id", Value: holdID},
+		{Key: "user_id", Value: user.value},
+		{Key: "status", Value: bson.D{{Key: "$in", Value: bson.A{holdHeld, holdBooked}}}},
+		{Key: "order_id", Value: bson.D{{Key: "$exists", Value: false}}},
+	}
+	released, err := m.release(ctx, filter)
+	if err != nil {
+		return err
+	}
+	if !released {
+		return &NoItems{}
+	}
+	return nil
+}
+
+// ReleaseOrderSlot gives back the slot booked for the order, an order without a slot has nothing to release.
+// ownerKey is the field of the hold the owner is matched on, user_id or vendor_id, so only the caller's own
+// order can free a slot
+func (m MongoSlotRepository) ReleaseOrderSlot(ctx context.Context, ownerKey string, owner ID, orderID bson.ObjectID) error {
+	ctx, span := Tracer.Start(ctx, "ReleaseOrderSlot")
+	defer span.End()
+
+	filter := bson.D{{Key: "order_id", Value: orderID}, {Key: ownerKey, Value: owner.value}, {Key: "status", Value: holdBooked}}
+	released, err := m.release(ctx, filter)
+	if err != nil {
+		return err
+	}
+	if released {
+		Logger.InfoContext(ctx, "Released the order's slot", slog.String("orderID", orderID.Hex()), slot_repo_source)
+	}
+	return nil
+}
+
+// ReleaseExpiredHolds gives back the places of holds that were never booked
+func (m MongoSlotRepository) ReleaseExpiredHolds(ctx context.Context) (int, error) {
+	ctx, span := Tracer.Start(ctx, "ReleaseExpiredHolds")
+	defer span.End()
+
+	filter := bson.D{{Key: "status", Value: holdHeld}, {Key: "expires_at", Value: bson.D{{Key: "$lte", Value: time.Now()}}}}
+	count := 0
+	for {
+		released, err := m.release(ctx, filter)
+		if err != nil {
+			return count, err
+		}
+		if !released {
+			break
+		}
+		count++
+	}
+	if count > 0 {
+		Logger.InfoContext(ctx, "Released expired slot holds", slog.Int("count", count), slot_repo_source)
+	}
+	return count, nil
+}
+
+// releaseExpiredHolds is the job that frees the slots of abandoned checkouts
+func releaseExpiredHolds(ctx context.Context) error {
+	_, err := Repos.Slot.ReleaseExpiredHolds(ctx)
+	return err
+}
+
+// releaseOrderSlots gives back the slots of the owner's orders whose new status gives them up, see
+// ReleaseOrderSlot for ownerKey
+func releaseOrderSlots(ctx context.Context, ownerKey string, owner ID, orders []*Order, source slog.Attr) {
+	for _, order := range orders {
+		if order.ID == bson.NilObjectID || !releasesSlot(order.OrderStatus) {
+			continue
+		}
+		if err := Repos.Slot.ReleaseOrderSlot(ctx, ownerKey, owner, order.ID); err != nil {
+			Logger.ErrorContext(ctx, "Unable to release the order's slot", slog.String("orderID", order.ID.Hex()),
+				slog.Any("error", err), source)
+		}
+	}
+}
+
+// releaseSlotHolds gives back the holds of orders that were not placed
+func releaseSlotHolds(ctx context.Context, user ID, orders []*UserOrder, source slog.Attr) {
+	for _, order := range orders {
+		if order.Slot == nil {
+			continue
+		}
+		if err := Repos.Slot.ReleaseHold(ctx, user, order.Slot.HoldID); err != nil {
+			Logger.ErrorContext(ctx, "Unable to release the slot hold", slog.String("holdID", order.Slot.HoldID.Hex()),
+				slog.Any("error", err), source)
+		}
+	}
+}
+
+func ordersOf[O interface{ *UserOrder | *VendorOrder }](orders []O) []*Order {
+	res := make([]*Order, len(orders))
+	for i, order := range orders {
+		switch o := any(order).(type) {
+		case *UserOrder:
+			res[i] = &o.Order
+		case *VendorOrder:
+			res[i] = &o.Order
+		}
+	}
+	return res
+}
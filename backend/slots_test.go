@@ -0,0 +1,92 @@
+package main
+
+import (
+	"testing"
+	"time"
+
+	"go.mongodb.org/mongo-driver/v2/bson"
+)
+
+func testSlotStore(t *testing.T) *Store {
+	store := &Store{
+		ID:    bson.NewObjectID(),
+		Name:  "Corner",
+		Hours: testHours(t),
+		SlotTemplates: []*SlotTemplate{
+			{Day: 1, Start: "10:00", End: "12:00", Method: "Deliver", Capacity: 5},
+			{Day: 1, Start: "09:00", End: "10:00", Method: "pickup", Capacity: 2},
+			{Day: 3, Start: "10:00", End: "12:00", Method: "delivery", Capacity: 5},
+		},
+	}
+	if err := store.validate(); err != nil {
+		t.Fatal(err)
+	}
+	return store
+}
+
+func TestSlotTemplateValidate(t *testing.T) {
+	bad := []*SlotTemplate{
+		{Day: 9, Start: "10:00", End: "11:00", Method: "pickup", Capacity: 1},
+		{Day: 1, Start: "10:00", End: "11:00", Method: "drone", Capacity: 1},
+		{Day: 1, Start: "11:00", End: "10:00", Method: "pickup", Capacity: 1},
+		{Day: 1, Start: "10:00", End: "11:00", Method: "pickup"},
+	}
+	for _, template := range bad {
+		if template.validate() == nil {
+			t.Fatalf("expected %+v to fail", template)
+		}
+	}
+}
+
+func TestStoreSlots(t *testing.T) {
+	store := testSlotStore(t)
+	vendor := bson.NewObjectID()
+
+	// monday morning after the pickup slot started, christmas wednesday is a holiday
+	slots := store.slots(vendor, "", newYork(t, "2024-12-23 09:30"), 7)
+	if len(slots) != 1 || !slots[0].Start.Equal(newYork(t, "2024-12-23 10:00")) || slots[0].Method != deliveryMethodDelivery {
+		t.Fatalf("expected only the monday delivery slot got %+v", slots)
+	}
+
+	slots = store.slots(vendor, deliveryMethodPickup, newYork(t, "2024-12-15 12:00"), 7)
+	if len(slots) != 1 || slots[0].Capacity != 2 || slots[0].Available != 2 ||
+		slots[0].ID != slotKey(store.ID, deliveryMethodPickup, newYork(t, "2024-12-16 09:00")) {
+		t.Fatalf("expected the monday pickup slot got %+v", slots)
+	}
+
+	slots = store.slots(vendor, "", newYork(t, "2024-12-15 12:00"), 7)
+	for i := 1; i < len(slots); i++ {
+		if slots[i].Start.Before(slots[i-1].Start) {
+			t.Fatalf("expected the slots in order got %+v", slots)
+		}
+	}
+	if len(slots) != 3 {
+		t.Fatalf("expected three slots in the week got %d", len(slots))
+	}
+}
+
+func TestFindSlot(t *testing.T) {
+	store := testSlotStore(t)
+	now := newYork(t, "2024-12-15 12:00")
+
+	if slot := store.findSlot(bson.NewObjectID(), deliveryMethodDelivery, newYork(t, "2024-12-18 10:00"), now); slot == nil {
+		t.Fatal("expected the wednesday delivery slot")
+	}
+	if slot := store.findSlot(bson.NewObjectID(), deliveryMethodPickup, newYork(t, "2024-12-18 10:00"), now); slot != nil {
+		t.Fatalf("expected no wednesday pickup slot got %+v", slot)
+	}
+	if slot := store.findSlot(bson.NewObjectID(), deliveryMethodDelivery, newYork(t, "2024-12-16 10:30"), now); slot != nil {
+		t.Fatalf("expected a start that is not a slot start to miss got %+v", slot)
+	}
+	if slot := store.findSlot(bson.NewObjectID(), deliveryMethodDelivery, now.Add(60*24*time.Hour), now); slot != nil {
+		t.Fatalf("expected a slot too far ahead to miss got %+v", slot)
+	}
+}
+
+func TestReleasesSlot(t *testing.T) {
+	for status, expected := range map[string]bool{"Rejected": true, "cancelled": true, "accepted": false, "": false} {
+		if releasesSlot(status) != expected {
+			t.Fatalf("%q expected %v", status, expected)
+		}
+	}
+}
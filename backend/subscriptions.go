@@ -0,0 +1,495 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"slices"
+	"time"
+
+	"go.mongodb.org/mongo-driver/v2/bson"
+	"go.mongodb.org/mongo-driver/v2/mongo"
+	"go.mongodb.org/mongo-driver/v2/mongo/options"
+)
+
+const (
+	subscriptionActive = "active"
+	subscriptionPaused = "paused"
+
+	frequencyWeekly   = "weekly"
+	frequencyBiweekly = "biweekly"
+
+	orderPending = "pending"
+
+	alertSubscription         = "subscription"
+	subscriptionEventUpcoming = "upcoming"
+	subscriptionEventPlaced   = "placed"
+	subscriptionEventFailed   = "failed"
+
+	// subscriptionNotice is how long before an occurrence the user is reminded of the order
+	subscriptionNotice   = 24 * time.Hour
+	subscriptionInterval = 15 * time.Minute
+	upcomingOccurrences  = 4
+)
+
+var (
+	subscription_repo_source = slog.Any("source", "SubscriptionRepository")
+
+	errSubscriptionChanged = errors.New("the subscription changed in the meantime, try again")
+)
+
+// subscriptionEvent is the notification sent about an occurrence, the upcoming one previews the order at
+// today's prices
+type subscriptionEvent struct {
+	Event        string        `json:"event"`
+	Subscription *Subscription `json:"subscription"`
+	Occurrence   time.Time     `json:"occurrence"`
+	Items        []*Item       `json:"items,omitempty"`
+	Changes      []*LineChange `json:"changes,omitempty"`
+	Total        float64       `json:"total,omitempty"`
+	OrderID      bson.ObjectID `json:"order_id,omitzero"`
+	Error        string        `json:"error,omitempty"`
+}
+
+func frequencyDays(frequency string) int {
+	switch frequency {
+	case frequencyWeekly:
+		return 7
+	case frequencyBiweekly:
+		return 14
+	}
+	return 0
+}
+
+// validate checks the subscription can be placed, nextRun is the next_run the user asked for if any
+func (s *Subscription) validate(nextRun, now time.Time) error {
+	if frequencyDays(s.Frequency) == 0 {
+		return fmt.Errorf("frequency must be %s or %s", frequencyWeekly, frequencyBiweekly)
+	}
+	if s.DeliveryMethod = deliveryMethod(s.DeliveryMethod); s.DeliveryMethod == "" {
+		return fmt.Errorf("delivery_method must be %s or %s", deliveryMethodDelivery, deliveryMethodPickup)
+	}
+	if s.DeliveryMethod == deliveryMethodDelivery {
+		if err := s.DeliveryLocation.validPoint(); err != nil {
+			return err
+		}
+	}
+	if !nextRun.IsZero() && !nextRun.After(now) {
+		return errors.New("next_run must be in the future")
+	}
+	return nil
+}
+
+// subscriptionLines checks the store sells every item and copies what it sells them as, the prices are what
+// later changes are flagged against
+func subscriptionLines(store *Store, lines []*Item) error {
+	if len(lines) == 0 {
+		return errors.New("a subscription needs at least one item")
+	}
+	seen := make(map[bson.ObjectID]bool, len(lines))
+	for _, line := range lines {
+		if seen[line.IngredientID] {
+			return errors.New("each item can be in a subscription once")
+		}
+		seen[line.IngredientID] = true
+		i := slices.IndexFunc(store.Items, func(item *Item) bool { return item != nil && item.IngredientID == line.IngredientID })
+		if i < 0 {
+			return fmt.Errorf("store %s does not sell %s", store.Name, line.Name)
+		}
+		item := store.Items[i]
+		if line.Quantity < 1 {
+			return fmt.Errorf("%s needs a quantity of at least 1", item.Name)
+		}
+		line.Ingredient, line.CatalogID, line.Promotion = item.Ingredient, item.CatalogID, nil
+		line.ReorderThreshold, line.Pricing = 0, nil
+	}
+	return nil
+}
+
+// edit copies the fields the user set, the items have been checked against the store already
+func (s *Subscription) edit(req *Subscription) {
+	if req.Items != nil {
+		s.Items = req.Items
+	}
+	if req.DeliveryMethod != "" {
+		s.DeliveryMethod = req.DeliveryMethod
+	}
+	if req.DeliveryLocation != nil {
+		s.DeliveryLocation = req.DeliveryLocation
+	}
+	if req.Frequency != "" {
+		s.Frequency = req.Frequency
+	}
+	if !req.NextRun.IsZero() {
+		s.NextRun, s.Skips = req.NextRun.UTC(), nil
+	}
+}
+
+// occurrenceAfter is the first occurrence on the subscription's schedule after t
+func (s *Subscription) occurrenceAfter(t time.Time) time.Time {
+	next, days := s.NextRun, frequencyDays(s.Frequency)
+	if days == 0 {
+		return next
+	}
+	if next.After(t) {
+		return next
+	}
+	periods := int(t.Sub(next)/(time.Duration(days)*24*time.Hour)) + 1
+	next = next.AddDate(0, 0, periods*days)
+	for !next.After(t) {
+		next = next.AddDate(0, 0, days)
+	}
+	return next
+}
+
+// upcoming is the next occurrences from NextRun and whether the user skipped them
+func (s *Subscription) upcoming() []*Occurrence {
+	occurrences := make([]*Occurrence, 0, upcomingOccurrences)
+	at := s.NextRun
+	for range upcomingOccurrences {
+		occurrences = append(occurrences, &Occurrence{At: at, Skipped: s.skipped(at)})
+		at = at.AddDate(0, 0, frequencyDays(s.Frequency))
+	}
+	return occurrences
+}
+
+func (s *Subscription) skipped(at time.Time) bool {
+	return slices.ContainsFunc(s.Skips, at.Equal)
+}
+
+// setSkips replaces the skipped occurrences, each has to be one of the upcoming ones
+func (s *Subscription) setSkips(occurrences []time.Time) error {
+	upcoming := s.upcoming()
+	skips := make([]time.Time, 0, len(occurrences))
+	for _, at := range occurrences {
+		if !slices.ContainsFunc(upcoming, func(o *Occurrence) bool { return o.At.Equal(at) }) {
+			return fmt.Errorf("%s is not one of the next %d occurrences", at.Format(time.RFC3339), upcomingOccurrences)
+		}
+		if !slices.ContainsFunc(skips, at.Equal) {
+			skips = append(skips, at.UTC())
+		}
+	}
+	slices.SortFunc(skips, time.Time.Compare)
+	s.Skips = skips
+	return nil
+}
+
+// advance is the first occurrence after both now and NextRun, occurrences missed while the job was down are
+// not caught up on, and the skips still ahead of it
+func (s *Subscription) advance(now time.Time) (time.Time, []time.Time) {
+	if s.NextRun.After(now) {
+		now = s.NextRun
+	}
+	next := s.occurrenceAfter(now)
+	var skips []time.Time
+	for _, at := range s.Skips {
+		if !at.Before(next) {
+			skips = append(skips, at)
+		}
+	}
+	return next, skips
+}
+
+// subscriptionOrder builds the pending order of an occurrence the way checkout prices one, items the store no
+// longer has are substituted or left out. The errors are meant for the user
+func subscriptionOrder(ctx context.Context, sub *Subscription, now time.Time) (*UserOrder, []*LineChange, error) {
+	store, err := Repos.Vendor.FindStore(ctx, ID{sub.VendorID}, ID{sub.StoreID})
+	if err != nil {
+		if errors.Is(err, &NoItems{}) {
+			return nil, nil, errors.New("the store no longer exists")
+		}
+		return nil, nil, errors.New("the store could not be read")
+	}
+	items, changes := repriceLines(sub.Items, store)
+	if len(items) == 0 {
+		return nil, changes, errors.New("none of the items are available")
+	}
+
+	order := &UserOrder{VendorID: sub.VendorID, Order: Order{StoreID: sub.StoreID, DeliveryMethod: sub.DeliveryMethod,
+		OrderStatus: orderPending, Items: items, DeliveryLocation: sub.DeliveryLocation, CreatedAt: now, UpdatedAt: now}}
+	if err := checkOrderWindow(store, &order.Order, now, true); err != nil {
+		return nil, changes, err
+	}
+	if _, err := checkDelivery(store, &order.Order); err != nil {
+		return nil, changes, err
+	}
+	rules, categories, err := Repos.Tax.FindOrderTaxes(ctx, store.Region, order.Items)
+	if err != nil {
+		return nil, changes, errors.New("the taxes of the order could not be read")
+	}
+	taxOrder(store, &order.Order, rules, categories)
+	return order, changes, nil
+}
+
+// runSubscriptions is the subscription job, it places the orders that are due and reminds users of the ones
+// coming up within subscriptionNotice
+func runSubscriptions(ctx context.Context) error {
+	now := time.Now()
+	subs, err := Repos.Subscription.FindDueSubscriptions(ctx, now.Add(subscriptionNotice))
+	if err != nil {
+		return err
+	}
+	var errs []error
+	for _, sub := range subs {
+		if sub.NextRun.After(now) {
+			errs = append(errs, remindSubscription(ctx, sub))
+			continue
+		}
+		errs = append(errs, placeSubscription(ctx, sub, now))
+	}
+	return errors.Join(errs...)
+}
+
+// remindSubscription tells the user what the next order will look like at today's prices so they can still
+// skip or edit it, each occurrence is reminded once
+func remindSubscription(ctx context.Context, sub *Subscription) error {
+	if sub.skipped(sub.NextRun) || (sub.RemindedFor != nil && sub.RemindedFor.Equal(sub.NextRun)) {
+		return nil
+	}
+	event := &subscriptionEvent{Event: subscriptionEventUpcoming, Subscription: sub, Occurrence: sub.NextRun}
+	if store, err := Repos.Vendor.FindStore(ctx, ID{sub.VendorID}, ID{sub.StoreID}); err == nil {
+		event.Items, event.Changes = repriceLines(sub.Items, store)
+		for _, item := range event.Items {
+			event.Total += item.linePrice(item.Quantity)
+		}
+		event.Total = roundCents(event.Total)
+	}
+	if _, err := notify(ctx, ID{sub.UserID}, alertSubscription, event); err != nil {
+		Logger.ErrorContext(ctx, "Unable to remind the user of the subscription", slog.String("subscriptionID", sub.ID.Hex()),
+			slog.Any("error", err), subscription_repo_source)
+		return err
+	}
+	return Repos.Subscription.MarkSubscriptionReminded(ctx, sub.ID, sub.NextRun)
+}
+
+// placeSubscription moves the subscription to its next occurrence before placing the order, so an occurrence
+// is never ordered twice even when placing fails half way
+func placeSubscription(ctx context.Context, sub *Subscription, now time.Time) error {
+	occurrence := sub.NextRun
+	next, skips := sub.advance(now)
+	if err := Repos.Subscription.ClaimOccurrence(ctx, sub.ID, occurrence, next, skips); err != nil {
+		if errors.Is(err, errSubscriptionChanged) {
+			return nil
+		}
+		return err
+	}
+
+	run := &SubscriptionRun{Occurrence: occurrence, Skipped: sub.skipped(occurrence), At: now}
+	if !run.Skipped {
+		order, changes, err := subscriptionOrder(ctx, sub, now)
+		run.Changes = changes
+		if err == nil {
+			var ids []*ID
+			if ids, err = placeUserOrders(ctx, ID{sub.UserID}, []*UserOrder{order}, subscription_repo_source); err != nil {
+				err = errors.New("the order could not be saved")
+			} else {
+				run.OrderID = ids[0].value
+			}
+		}
+		if err != nil {
+			run.Error = err.Error()
+		}
+	}
+	if err := Repos.Subscription.RecordSubscriptionRun(ctx, sub.ID, run); err != nil {
+		return err
+	}
+	if run.Skipped {
+		return nil
+	}
+
+	event := &subscriptionEvent{Event: subscriptionEventPlaced, Subscription: sub, Occurrence: occurrence,
+		Changes: run.Changes, OrderID: run.OrderID, Error: run.Error}
+	if run.Error != "" {
+		event.Event = subscriptionEventFailed
+		Logger.InfoContext(ctx, "Subscription order not placed", slog.String("subscriptionID", sub.ID.Hex()),
+			slog.String("reason", run.Error), subscription_repo_source)
+	}
+	if _, err := notify(ctx, ID{sub.UserID}, alertSubscription, event); err != nil {
+		Logger.ErrorContext(ctx, "Unable to notify the user of the subscription order", slog.String("subscriptionID", sub.ID.Hex()),
+			slog.Any("error", err), subscription_repo_source)
+	}
+	return nil
+}
+
+// SubscriptionRepository keeps the users' recurring orders and where each one is in its schedule
+type SubscriptionRepository interface {
+	CreateSubscription(context.Context, *Subscription) error
+	FindSubscriptions(context.Context, ID) ([]*Subscription, error)
+	FindSubscription(context.Context, ID, bson.ObjectID) (*Subscription, error)
+	UpdateSubscription(context.Context, *Subscription, time.Time) error
+	DeleteSubscription(context.Context, ID, bson.ObjectID) error
+	FindDueSubscriptions(context.Context, time.Time) ([]*Subscription, error)
+	MarkSubscriptionReminded(context.Context, bson.ObjectID, time.Time) error
+	ClaimOccurrence(context.Context, bson.ObjectID, time.Time, time.Time, []time.Time) error
+	RecordSubscriptionRun(context.Context, bson.ObjectID, *SubscriptionRun) error
+}
+
+type MongoSubscriptionRepository struct{ col *mongo.Collection }
+
+func newMongoSubscriptionRepository(client *mongo.Client, dbName string) SubscriptionRepository {
+	return &MongoSubscriptionRepository{col: client.Database(dbName).Collection("subscriptions")}
+}
+
+func (m MongoSubscriptionRepository) CreateSubscription(ctx context.Context, sub *Subscription) error {
+	ctx, span := Tracer.Start(ctx, "CreateSubscription")
+	defer span.End()
+
+	sub.ID, sub.CreatedAt = bson.NewObjectID(), time.Now()
+	sub.UpdatedAt = sub.CreatedAt
+	if _, err := m.col.InsertOne(ctx, sub); err != nil {
+		Logger.ErrorContext(ctx, "Error creating subscription", slog.Any("error", err), subscription_repo_source)
+		return err
+	}
+	Logger.InfoContext(ctx, "Subscription created", slog.String("subscriptionID", sub.ID.Hex()),
+		slog.String("frequency", sub.Frequency), subscription_repo_source)
+	return nil
+}
+
+func (m MongoSubscriptionRepository) FindSubscriptions(ctx context.Context, userID ID) ([]*Subscription, error) {
+	ctx, span := Tracer.Start(ctx, "FindSubscriptions")
+	defer span.End()
+
+	opts := options.Find().SetSort(bson.D{{Key: "created_at", Value: 1}})
+	cursor, err := m.col.Find(ctx, bson.D{{Key: "user_id", Value: userID.value}}, opts)
+	if err != nil {
+		Logger.ErrorContext(ctx, "Error finding subscriptions", slog.Any("error", err), subscription_repo_source)
+		return nil, err
+	}
+	subs := []*Subscription{}
+	if err := cursor.All(ctx, &subs); err != nil {
+		Logger.ErrorContext(ctx, "Error decoding subscriptions", slog.Any("error", err), subscription_repo_source)
+		return nil, err
+	}
+	return subs, nil
+}
+
+func (m MongoSubscriptionRepository) FindSubscription(ctx context.Context, userID ID, subID bson.ObjectID) (*Subscription, error) {
+	ctx, span := Tracer.Start(ctx, "FindSubscription")
+	defer span.End()
+
+	var sub Subscription
+	if err := m.col.FindOne(ctx, bson.D{{Key: "_id", Value: subID}, {Key: "user_id", Value: userID.value}}).Decode(&sub); err != nil {
+		if errors.Is(err, mongo.ErrNoDocuments) {
+			return nil, &NoItems{}
+		}
+		Logger.ErrorContext(ctx, "Error finding subscription", slog.Any("error", err), subscription_repo_source)
+		return nil, err
+	}
+	return &sub, nil
+}
+
+// UpdateSubscription saves what the user changed, seen is when the subscription read was last updated and it
+// is errSubscriptionChanged when the job or another request got to it first
+func (m MongoSubscriptionRepository) UpdateSubscription(ctx context.Context, sub *Subscription, seen time.Time) error {
+	ctx, span := Tracer.Start(ctx, "UpdateSubscription")
+	defer span.End()
+
+	sub.UpdatedAt = time.Now()
+	filter := bson.D{{Key: "_id", Value: sub.ID}, {Key: "user_id", Value: sub.UserID}, {Key: "updated_at", Value: seen}}
+	update := bson.D{{Key: "$set", Value: bson.M{
+		"items":             sub.Items,
+		"delivery_method":   sub.DeliveryMethod,
+		"delivery_location": sub.DeliveryLocation,
+		"frequency":         sub.Frequency,
+		"status":            sub.Status,
+		"next_run":          sub.NextRun,
+		"skips":             sub.Skips,
+		"updated_at":        sub.UpdatedAt,
+	}}}
+	result, err := m.col.UpdateOne(ctx, filter, update)
+	if err != nil {
+		Logger.ErrorContext(ctx, "Error updating subscription", slog.Any("error", err), subscription_repo_source)
+		return err
+	}
+	if result.MatchedCount == 0 {
+		count, err := m.col.CountDocuments(ctx, bson.D{{Key: "_id", Value: sub.ID}, {Key: "user_id", Value: sub.UserID}})
+		if err != nil {
+			Logger.ErrorContext(ctx, "Error finding subscription", slog.Any("error", err), subscription_repo_source)
+			return err
+		}
+		if count > 0 {
+			return errSubscriptionChanged
+		}
+		return &NoItems{}
+	}
+	return nil
+}
+
+func (m MongoSubscriptionRepository) DeleteSubscription(ctx context.Context, userID ID, subID bson.ObjectID) error {
+	ctx, span := Tracer.Start(ctx, "DeleteSubscription")
+	defer span.End()
+
+	result, err := m.col.DeleteOne(ctx, bson.D{{Key: "_id", Value: subID}, {Key: "user_id", Value: userID.value}})
+	if err != nil {
+		Logger.ErrorContext(ctx, "Error deleting subscription", slog.Any("error", err), subscription_repo_source)
+		return err
+	}
+	if result.DeletedCount == 0 {
+		return &NoItems{}
+	}
+	return nil
+}
+
+// FindDueSubscriptions lists the active subscriptions whose next occurrence is before the time
+func (m MongoSubscriptionRepository) FindDueSubscriptions(ctx context.Context, before time.Time) ([]*Subscription, error) {
+	ctx, span := Tracer.Start(ctx, "FindDueSubscriptions")
+	defer span.End()
+
+	filter := bson.D{{Key: "status", Value: subscriptionActive}, {Key: "next_run", Value: bson.D{{Key: "$lte", Value: before}}}}
+	cursor, err := m.col.Find(ctx, filter, options.Find().SetSort(bson.D{{Key: "next_run", Value: 1}}))
+	if err != nil {
+		Logger.ErrorContext(ctx, "Error finding due subscriptions", slog.Any("error", err), subscription_repo_source)
+		return nil, err
+	}
+	subs := []*Subscription{}
+	if err := cursor.All(ctx, &subs); err != nil {
+		Logger.ErrorContext(ctx, "Error decoding due subscriptions", slog.Any("error", err), subscription_repo_source)
+		return nil, err
+	}
+	return subs, nil
+}
+
+func (m MongoSubscriptionRepository) MarkSubscriptionReminded(ctx context.Context, subID bson.ObjectID, occurrence time.Time) error {
+	ctx, span := Tracer.Start(ctx, "MarkSubscriptionReminded")
+	defer span.End()
+
+	filter := bson.D{{Key: "_id", Value: subID}, {Key: "next_run", Value: occurrence}}
+	if _, err := m.col.UpdateOne(ctx, filter, bson.D{{Key: "$set", Value: bson.M{"reminded_for": occurrence}}}); err != nil {
+		Logger.ErrorContext(ctx, "Error marking subscription reminded", slog.Any("error", err), subscription_repo_source)
+		return err
+	}
+	return nil
+}
+
+// ClaimOccurrence moves an active subscription from the occurrence to the next one, errSubscriptionChanged when
+// it is no longer at the occurrence or was paused
+func (m MongoSubscriptionRepository) ClaimOccurrence(ctx context.Context, subID bson.ObjectID, occurrence, next time.Time, skips []time.Time) error {
+	ctx, span := Tracer.Start(ctx, "ClaimOccurrence")
+	defer span.End()
+
+	filter := bson.D{{Key: "_id", Value: subID}, {Key: "status", Value: subscriptionActive}, {Key: "next_run", Value: occurrence}}
+	update := bson.D{{Key: "$set", Value: bson.M{"next_run": next, "skips": skips, "updated_at": time.Now()}}}
+	result, err := m.col.UpdateOne(ctx, filter, update)
+	if err != nil {
+		Logger.ErrorContext(ctx, "Error claiming subscription occurrence", slog.Any("error", err), subscription_repo_source)
+		return err
+	}
+	if result.MatchedCount == 0 {
+		return errSubscriptionChanged
+	}
+	return nil
+}
+
+func (m MongoSubscriptionRepository) RecordSubscriptionRun(ctx context.Context, subID bson.ObjectID, run *SubscriptionRun) error {
+	ctx, span := Tracer.Start(ctx, "RecordSubscriptionRun")
+	defer span.End()
+
+	if _, err := m.col.UpdateOne(ctx, bson.D{{Key: "_id", Value: subID}}, bson.D{{Key: "$set", Value: bson.M{"last_run": run}}}); err != nil {
+		Logger.ErrorContext(ctx, "Error recording subscription run", slog.Any("error", err), subscription_repo_source)
+		return err
+	}
+	Logger.InfoContext(ctx, "Subscription occurrence run", slog.String("subscriptionID", subID.Hex()),
+		slog.String("orderID", run.OrderID.Hex()), slog.Bool("skipped", run.Skipped), subscription_repo_source)
+	return nil
+}
@@ -0,0 +1,81 @@
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+func TestSubscriptionSchedule(t *testing.T) {
+	start := time.Date(2026, 3, 2, 9, 0, 0, 0, time.UTC)
+	sub := &Subscription{Frequency: frequencyBiweekly, NextRun: start}
+
+	upcoming := sub.upcoming()
+	if len(upcoming) != upcomingOccurrences || !upcoming[1].At.Equal(start.AddDate(0, 0, 14)) {
+		t.Fatalf("unexpected occurrences %+v", upcoming)
+	}
+	if err := sub.setSkips([]time.Time{start.AddDate(0, 0, 28), start.AddDate(0, 0, 14), start.AddDate(0, 0, 28)}); err != nil {
+		t.Fatal(err)
+	}
+	if len(sub.Skips) != 2 || !sub.Skips[0].Equal(start.AddDate(0, 0, 14)) || !sub.upcoming()[2].Skipped {
+		t.Fatalf("unexpected skips %v", sub.Skips)
+	}
+	if err := sub.setSkips([]time.Time{start.AddDate(0, 0, 7)}); err == nil {
+		t.Fatal("expected an occurrence off the schedule to be rejected")
+	}
+	if err := sub.setSkips([]time.Time{start.AddDate(0, 0, 14*upcomingOccurrences)}); err == nil {
+		t.Fatal("expected an occurrence past the upcoming ones to be rejected")
+	}
+
+	next, skips := sub.advance(start.Add(time.Minute))
+	if !next.Equal(start.AddDate(0, 0, 14)) || len(skips) != 2 {
+		t.Fatalf("expected the next occurrence with both skips got %v %v", next, skips)
+	}
+	next, skips = sub.advance(start.AddDate(0, 0, 20))
+	if !next.Equal(start.AddDate(0, 0, 28)) || len(skips) != 1 {
+		t.Fatalf("expected missed occurrences to be passed over got %v %v", next, skips)
+	}
+}
+
+func TestSubscriptionValidate(t *testing.T) {
+	now := time.Now()
+	sub := &Subscription{Frequency: frequencyWeekly, DeliveryMethod: deliveryMethodPickup}
+	if err := sub.validate(now.Add(time.Hour), now); err != nil {
+		t.Fatal(err)
+	}
+	for i, bad := range []struct {
+		sub     Subscription
+		nextRun time.Time
+	}{
+		{Subscription{Frequency: "daily", DeliveryMethod: deliveryMethodPickup}, now.Add(time.Hour)},
+		{Subscription{Frequency: frequencyWeekly, DeliveryMethod: "drone"}, now.Add(time.Hour)},
+		{Subscription{Frequency: frequencyWeekly, DeliveryMethod: deliveryMethodDelivery}, now.Add(time.Hour)},
+		{Subscription{Frequency: frequencyWeekly, DeliveryMethod: deliveryMethodPickup}, now.Add(-time.Hour)},
+	} {
+		if err := bad.sub.validate(bad.nextRun, now); err == nil {
+			t.Fatalf("case %d: expected the subscription to be rejected", i)
+		}
+	}
+}
+
+func TestSubscriptionLines(t *testing.T) {
+	milk, eggs := newTestItem("Milk", 1, "litre", 4), newTestItem("Eggs", 12, "piece", 3)
+	store := &Store{Name: "Corner", Items: []*Item{milk, eggs}}
+
+	lines := []*Item{{Ingredient: Ingredient{IngredientID: milk.IngredientID}, Quantity: 2}}
+	if err := subscriptionLines(store, lines); err != nil {
+		t.Fatal(err)
+	}
+	if lines[0].Name != "Milk" || lines[0].Price != 4 || lines[0].Quantity != 2 {
+		t.Fatalf("expected the store's milk got %+v", lines[0])
+	}
+	for i, bad := range [][]*Item{
+		nil,
+		{{Ingredient: Ingredient{IngredientID: eggs.IngredientID}}},
+		{newTestItem("Flour", 1, "kg", 2)},
+		{{Ingredient: Ingredient{IngredientID: eggs.IngredientID}, Quantity: 1}, {Ingredient: Ingredient{IngredientID: eggs.IngredientID}, Quantity: 1}},
+	} {
+		if err := subscriptionLines(store, bad); err == nil {
+			t.Fatalf("case %d: expected the lines to be rejected", i)
+		}
+	}
+}
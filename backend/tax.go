@@ -0,0 +1,305 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"log/slog"
+	"slices"
+	"strings"
+	"time"
+
+	"go.mongodb.org/mongo-driver/v2/bson"
+	"go.mongodb.org/mongo-driver/v2/mongo"
+	"go.mongodb.org/mongo-driver/v2/mongo/options"
+)
+
+const feeDelivery = "delivery"
+
+var (
+	tax_repo_source = slog.Any("source", "TaxRepository")
+
+	errTaxRuleExists   = errors.New("a tax rule for the region, store type and category already exists")
+	errTaxRuleCategory = errors.New("the tax rule category does not exist")
+)
+
+func normalizeRegion(region string) string {
+	return strings.ToUpper(strings.TrimSpace(region))
+}
+
+func (r *TaxRule) validate() error {
+	r.Name, r.Region, r.StoreType = strings.TrimSpace(r.Name), normalizeRegion(r.Region), strings.TrimSpace(r.StoreType)
+	if r.Name == "" {
+		return errors.New("a tax rule needs a name")
+	}
+	if r.Region == "" {
+		return errors.New("a tax rule needs a region")
+	}
+	if r.Rate < 0 || r.Rate > 100 {
+		return errors.New("rate must be a percentage from 0 to 100")
+	}
+	return nil
+}
+
+// specificity ranks the rule for a line of the store whose catalog ingredient sits under path, a category
+// beats a store type and a deeper category beats the categories above it. ok is false when the rule does not
+// match the line
+func (r *TaxRule) specificity(store *Store, path []bson.ObjectID) (rank int, ok bool) {
+	if r.Region != store.Region {
+		return 0, false
+	}
+	if r.StoreType != "" {
+		if !strings.EqualFold(r.StoreType, store.StoreType) {
+			return 0, false
+		}
+		rank++
+	}
+	if !r.CategoryID.IsZero() {
+		depth := slices.Index(path, r.CategoryID)
+		if depth < 0 {
+			return 0, false
+		}
+		rank += 2 * (depth + 1)
+	}
+	return rank, true
+}
+
+// taxRuleFor is the rule that taxes a line, nil when no rule of the store's region matches it
+func taxRuleFor(rules []*TaxRule, store *Store, path []bson.ObjectID) *TaxRule {
+	var best *TaxRule
+	bestRank := -1
+	for _, rule := range rules {
+		if rank, ok := rule.specificity(store, path); ok && rank > bestRank {
+			best, bestRank = rule, rank
+		}
+	}
+	return best
+}
+
+// splitDiscount shares the discount between the line amounts in proportion to them, the last line takes the
+// rounding so the shares add up to the discount
+func splitDiscount(discount float64, amounts []float64) []float64 {
+	shares := make([]float64, len(amounts))
+	total := 0.0
+	for _, amount := range amounts {
+		total += amount
+	}
+	if discount <= 0 || total <= 0 {
+		return shares
+	}
+	left := discount
+	for i, amount := range amounts {
+		share := roundCents(discount * amount / total)
+		if i == len(amounts)-1 {
+			share = roundCents(left)
+		}
+		shares[i] = min(max(share, 0), amount)
+		left -= shares[i]
+	}
+	return shares
+}
+
+// taxOrder prices every line of an order that has been priced, delivered and discounted, taxes what is left
+// of each line after its share of the discount and sets the breakdown and the total. Fees are not taxed
+func taxOrder(store *Store, order *Order, rules []*TaxRule, categories map[bson.ObjectID][]bson.ObjectID) {
+	amounts := make([]float64, len(order.Items))
+	for i, line := range order.Items {
+		amounts[i] = line.linePrice(line.Quantity)
+	}
+	discount := 0.0
+	if order.Discount != nil {
+		discount = order.Discount.Amount
+	}
+	shares := splitDiscount(discount, amounts)
+
+	tax := 0.0
+	for i, line := range order.Items {
+		pricing := &LinePricing{Amount: amounts[i], Discount: shares[i]}
+		if rule := taxRuleFor(rules, store, categories[line.CatalogID]); rule != nil {
+			pricing.TaxRuleID, pricing.TaxRate = rule.ID, rule.Rate
+			pricing.Tax = roundCents((pricing.Amount - pricing.Discount) * rule.Rate / 100)
+		}
+		pricing.Total = roundCents(pricing.Amount - pricing.Discount + pricing.Tax)
+		line.Pricing = pricing
+		tax += pricing.Tax
+	}
+
+	breakdown := &PriceBreakdown{Subtotal: order.Subtotal, Discount: discount, Fees: []*Fee{}, Tax: roundCents(tax)}
+	fees := 0.0
+	if order.DeliveryFee > 0 {
+		breakdown.Fees = append(breakdown.Fees, &Fee{Kind: feeDelivery, Amount: order.DeliveryFee})
+		fees += order.DeliveryFee
+	}
+	breakdown.Total = roundCents(breakdown.Subtotal - breakdown.Discount + fees + breakdown.Tax)
+	order.Breakdown, order.TotalPrice = breakdown, breakdown.Total
+}
+
+// TaxRepository keeps the tax rules admins set per region
+type TaxRepository interface {
+	CreateTaxRule(context.Context, *TaxRule) error
+	FindTaxRules(context.Context, string) ([]*TaxRule, error)
+	UpdateTaxRule(context.Context, *TaxRule) error
+	DeleteTaxRule(context.Context, bson.ObjectID) error
+	FindOrderTaxes(context.Context, string, []*Item) ([]*TaxRule, map[bson.ObjectID][]bson.ObjectID, error)
+}
+
+type MongoTaxRepository struct {
+	col        *mongo.Collection
+	catalog    *mongo.Collection
+	categories *mongo.Collection
+}
+
+func newMongoTaxRepository(client *mongo.Client, dbName string) TaxRepository {
+	db := client.Database(dbName)
+	return &MongoTaxRepository{col: db.Collection("tax_rules"), catalog: db.Collection("catalog"),
+		categories: db.Collection("categories")}
+}
+
+// checkCategory is errTaxRuleCategory when the rule names a category that is not in the catalog
+func (m MongoTaxRepository) checkCategory(ctx context.Context, rule *TaxRule) error {
+	if rule.CategoryID.IsZero() {
+		return nil
+	}
+	count, err := m.categories.CountDocuments(ctx, bson.D{{Key: "_id", Value: rule.CategoryID}})
+	if err != nil {
+		Logger.ErrorContext(ctx, "Error checking the tax rule category", slog.Any("error", err), tax_repo_source)
+		return err
+	}
+	if count == 0 {
+		return errTaxRuleCategory
+	}
+	return nil
+}
+
+func (m MongoTaxRepository) CreateTaxRule(ctx context.Context, rule *TaxRule) error {
+	ctx, span := Tracer.Start(ctx, "CreateTaxRule")
+	defer span.End()
+
+	if err := m.checkCategory(ctx, rule); err != nil {
+		return err
+	}
+	rule.ID, rule.CreatedAt = bson.NewObjectID(), time.Now()
+	rule.UpdatedAt = rule.CreatedAt
+	if _, err := m.col.InsertOne(ctx, rule); err != nil {
+		if mongo.IsDuplicateKeyError(err) {
+			return errTaxRuleExists
+		}
+		Logger.ErrorContext(ctx, "Error creating tax rule", slog.Any("error", err), tax_repo_source)
+		return err
+	}
+	Logger.InfoContext(ctx, "Tax rule created", slog.String("ruleID", rule.ID.Hex()), slog.String("region", rule.Region),
+		slog.Float64("rate", rule.Rate), tax_repo_source)
+	return nil
+}
+
+// FindTaxRules lists the rules of the region, every rule when region is empty
+func (m MongoTaxRepository) FindTaxRules(ctx context.Context, region string) ([]*TaxRule, error) {
+	ctx, span := Tracer.Start(ctx, "FindTaxRules")
+	defer span.End()
+
+	filter := bson.D{}
+	if region != "" {
+		filter = append(filter, bson.E{Key: "region", Value: region})
+	}
+	sort := bson.D{{Key: "region", Value: 1}, {Key: "created_at", Value: 1}}
+	cursor, err := m.col.Find(ctx, filter, options.Find().SetSort(sort))
+	if err != nil {
+		Logger.ErrorContext(ctx, "Error finding tax rules", slog.Any("error", err), tax_repo_source)
+		return nil, err
+	}
+	rules := []*TaxRule{}
+	if err := cursor.All(ctx, &rules); err != nil {
+		Logger.ErrorContext(ctx, "Error decoding tax rules", slog.Any("error", err), tax_repo_source)
+		return nil, err
+	}
+	return rules, nil
+}
+
+// UpdateTaxRule replaces what the rule matches and its rate, orders already placed keep the tax they were
+// charged
+func (m MongoTaxRepository) UpdateTaxRule(ctx context.Context, rule *TaxRule) error {
+	ctx, span := Tracer.Start(ctx, "UpdateTaxRule")
+	defer span.End()
+
+	if err := m.checkCategory(ctx, rule); err != nil {
+		return err
+	}
+	rule.UpdatedAt = time.Now()
+	set := bson.M{"name": rule.Name, "region": rule.Region, "rate": rule.Rate, "updated_at": rule.UpdatedAt}
+	unset := bson.M{}
+	if rule.StoreType != "" {
+		set["store_type"] = rule.StoreType
+	} else {
+		unset["store_type"] = ""
+	}
+	if !rule.CategoryID.IsZero() {
+		set["category_id"] = rule.CategoryID
+	} else {
+		unset["category_id"] = ""
+	}
+	update := bson.D{{Key: "$set", Value: set}}
+	if len(unset) > 0 {
+		update = append(update, bson.E{Key: "$unset", Value: unset})
+	}
+
+	var updated TaxRule
+	opts := options.FindOneAndUpdate().SetReturnDocument(options.After)
+	if err := m.col.FindOneAndUpdate(ctx, bson.D{{Key: "_id", Value: rule.ID}}, update, opts).Decode(&updated); err != nil {
+		if errors.Is(err, mongo.ErrNoDocuments) {
+			return &NoItems{}
+		}
+		if mongo.IsDuplicateKeyError(err) {
+			return errTaxRuleExists
+		}
+		Logger.ErrorContext(ctx, "Error updating tax rule", slog.Any("error", err), tax_repo_source)
+		return err
+	}
+	*rule = updated
+	return nil
+}
+
+func (m MongoTaxRepository) DeleteTaxRule(ctx context.Context, ruleID bson.ObjectID) error {
+	ctx, span := Tracer.Start(ctx, "DeleteTaxRule")
+	defer span.End()
+
+	result, err := m.col.DeleteOne(ctx, bson.D{{Key: "_id", Value: ruleID}})
+	if err != nil {
+		Logger.ErrorContext(ctx, "Error deleting tax rule", slog.Any("error", err), tax_repo_source)
+		return err
+	}
+	if result.DeletedCount == 0 {
+		return &NoItems{}
+	}
+	return nil
+}
+
+// FindOrderTaxes reads the rules of the region and the category path of the catalog ingredients the order
+// items link to, the paths only when one of the rules is for a category
+func (m MongoTaxRepository) FindOrderTaxes(ctx context.Context, region string, items []*Item) ([]*TaxRule, map[bson.ObjectID][]bson.ObjectID, error) {
+	ctx, span := Tracer.Start(ctx, "FindOrderTaxes")
+	defer span.End()
+
+	if region == "" {
+		return nil, nil, nil
+	}
+	rules, err := m.FindTaxRules(ctx, region)
+	if err != nil {
+		return nil, nil, err
+	}
+	if !slices.ContainsFunc(rules, func(r *TaxRule) bool { return !r.CategoryID.IsZero() }) {
+		return rules, nil, nil
+	}
+	var ids []bson.ObjectID
+	for _, item := range items {
+		if !item.CatalogID.IsZero() {
+			ids = append(ids, item.CatalogID)
+		}
+	}
+	if len(ids) == 0 {
+		return rules, nil, nil
+	}
+	categories, err := findCategoryPaths(ctx, m.catalog, ids, tax_repo_source)
+	if err != nil {
+		return nil, nil, err
+	}
+	return rules, categories, nil
+}
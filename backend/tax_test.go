@@ -0,0 +1,98 @@
+package main
+
+import (
+	"testing"
+
+	"go.mongodb.org/mongo-driver/v2/bson"
+)
+
+func TestTaxRuleValidate(t *testing.T) {
+	bad := []*TaxRule{
+		{Region: "US-NY", Rate: 8},
+		{Name: "Sales", Rate: 8},
+		{Name: "Sales", Region: "US-NY", Rate: -1},
+		{Name: "Sales", Region: "US-NY", Rate: 101},
+	}
+	for _, rule := range bad {
+		if rule.validate() == nil {
+			t.Fatalf("expected %+v to fail", rule)
+		}
+	}
+	rule := &TaxRule{Name: " Groceries ", Region: " us-ny ", Rate: 0}
+	if err := rule.validate(); err != nil || rule.Region != "US-NY" || rule.Name != "Groceries" {
+		t.Fatalf("expected an exempt rule for US-NY got %+v %v", rule, err)
+	}
+}
+
+func TestTaxRuleFor(t *testing.T) {
+	food, produce := bson.NewObjectID(), bson.NewObjectID()
+	store := &Store{Region: "US-NY", StoreType: "Grocery"}
+	region := &TaxRule{ID: bson.NewObjectID(), Region: "US-NY", Rate: 8}
+	grocery := &TaxRule{ID: bson.NewObjectID(), Region: "US-NY", StoreType: "grocery", Rate: 4}
+	foodRule := &TaxRule{ID: bson.NewObjectID(), Region: "US-NY", CategoryID: food, Rate: 2}
+	produceRule := &TaxRule{ID: bson.NewObjectID(), Region: "US-NY", CategoryID: produce, Rate: 0}
+	other := &TaxRule{ID: bson.NewObjectID(), Region: "US-NJ", Rate: 6}
+	rules := []*TaxRule{other, region, grocery, foodRule, produceRule}
+
+	if rule := taxRuleFor(rules, store, nil); rule != grocery {
+		t.Fatalf("expected the store type rule for an uncategorised line got %+v", rule)
+	}
+	if rule := taxRuleFor(rules, store, []bson.ObjectID{food}); rule != foodRule {
+		t.Fatalf("expected the category to beat the store type got %+v", rule)
+	}
+	if rule := taxRuleFor(rules, store, []bson.ObjectID{food, produce}); rule != produceRule {
+		t.Fatalf("expected the subcategory to beat its parent got %+v", rule)
+	}
+	if rule := taxRuleFor(rules, &Store{Region: "US-NY", StoreType: "Bakery"}, nil); rule != region {
+		t.Fatalf("expected the region rule got %+v", rule)
+	}
+	if rule := taxRuleFor(rules, &Store{StoreType: "Grocery"}, nil); rule != nil {
+		t.Fatalf("expected no rule for a store without a region got %+v", rule)
+	}
+}
+
+func TestSplitDiscount(t *testing.T) {
+	shares := splitDiscount(10, []float64{30, 30, 40})
+	if shares[0] != 3 || shares[1] != 3 || shares[2] != 4 {
+		t.Fatalf("expected shares by amount got %v", shares)
+	}
+	shares = splitDiscount(1, []float64{1, 1, 1})
+	if total := roundCents(shares[0] + shares[1] + shares[2]); total != 1 || shares[2] != 0.34 {
+		t.Fatalf("expected the last line to take the rounding got %v", shares)
+	}
+	if shares := splitDiscount(0, []float64{5}); shares[0] != 0 {
+		t.Fatalf("expected no discount got %v", shares)
+	}
+}
+
+func TestTaxOrder(t *testing.T) {
+	food := bson.NewObjectID()
+	store := &Store{Region: "US-NY", StoreType: "Grocery"}
+	rules := []*TaxRule{
+		{ID: bson.NewObjectID(), Region: "US-NY", Rate: 10},
+		{ID: bson.NewObjectID(), Region: "US-NY", CategoryID: food, Rate: 0},
+	}
+	milk, soap := newTestItem("Milk", 1, "litre", 4), newTestItem("Soap", 1, "count", 6)
+	milk.CatalogID = bson.NewObjectID()
+	categories := map[bson.ObjectID][]bson.ObjectID{milk.CatalogID: {food}}
+
+	order := &Order{DeliveryFee: 3, Discount: &Discount{Amount: 2}, Items: []*Item{
+		{Ingredient: Ingredient{IngredientID: milk.IngredientID, Price: 4}, CatalogID: milk.CatalogID, Quantity: 1},
+		{Ingredient: Ingredient{IngredientID: soap.IngredientID, Price: 6}, Quantity: 1},
+	}}
+	order.Subtotal = 10
+	taxOrder(store, order, rules, categories)
+
+	line := order.Items[1].Pricing
+	if line == nil || line.Amount != 6 || line.Discount != 1.2 || line.Tax != 0.48 || line.TaxRuleID != rules[0].ID {
+		t.Fatalf("expected the soap taxed after its share of the discount got %+v", line)
+	}
+	if line := order.Items[0].Pricing; line.Tax != 0 || line.TaxRuleID != rules[1].ID || line.Total != 3.2 {
+		t.Fatalf("expected the milk exempt got %+v", line)
+	}
+	breakdown := order.Breakdown
+	if breakdown.Subtotal != 10 || breakdown.Discount != 2 || breakdown.Tax != 0.48 || len(breakdown.Fees) != 1 ||
+		breakdown.Total != 11.48 || order.TotalPrice != breakdown.Total {
+		t.Fatalf("unexpected breakdown %+v", breakdown)
+	}
+}
@@ -0,0 +1,135 @@
+package main
+
+import (
+	"math"
+	"strings"
+)
+
+type dimension string
+
+const (
+	mass   dimension = "mass"
+	volume dimension = "volume"
+	count  dimension = "count"
+)
+
+// unitDef converts a unit to the base unit of its dimension, grams for mass, millilitres for volume and
+// single pieces for count
+type unitDef struct {
+	dim    dimension
+	factor float64
+}
+
+var unitTable = map[string]unitDef{
+	"mg": {mass, 0.001},
+	"g":  {mass, 1},
+	"kg": {mass, 1000},
+	"oz": {mass, 28.349523125},
+	"lb": {mass, 453.59237},
+
+	"ml":     {volume, 1},
+	"cl":     {volume, 10},
+	"dl":     {volume, 100},
+	"l":      {volume, 1000},
+	"tsp":    {volume, 4.92892159375},
+	"tbsp":   {volume, 14.78676478125},
+	"fl oz":  {volume, 29.5735295625},
+	"cup":    {volume, 236.5882365},
+	"pint":   {volume, 473.176473},
+	"quart":  {volume, 946.352946},
+	"gallon": {volume, 3785.411784},
+
+	"each":  {count, 1},
+	"dozen": {count, 12},
+}
+
+var unitAliases = map[string]string{
+	"milligram": "mg", "milligrams": "mg",
+	"gram": "g", "grams": "g", "gm": "g", "gms": "g",
+	"kilogram": "kg", "kilograms": "kg", "kgs": "kg", "kilo": "kg", "kilos": "kg",
+	"ounce": "oz", "ounces": "oz",
+	"pound": "lb", "pounds": "lb", "lbs": "lb",
+
+	"millilitre": "ml", "milliliter": "ml", "millilitres": "ml", "milliliters": "ml",
+	"centilitre": "cl", "centiliter": "cl",
+	"decilitre": "dl", "deciliter": "dl",
+	"litre": "l", "liter": "l", "litres": "l", "liters": "l", "ltr": "l",
+	"teaspoon": "tsp", "teaspoons": "tsp",
+	"tablespoon": "tbsp", "tablespoons": "tbsp", "tbs": "tbsp",
+	"floz": "fl oz", "fluid ounce": "fl oz", "fluid ounces": "fl oz",
+	"cups":  "cup",
+	"pints": "pint", "pt": "pint",
+	"quarts": "quart", "qt": "quart",
+	"gallons": "gallon", "gal": "gallon",
+
+	"count": "each", "ea": "each", "pc": "each", "pcs": "each", "piece": "each", "pieces": "each",
+	"unit": "each", "units": "each", "whole": "each", "serving": "each", "servings": "each",
+	"dozens": "dozen", "dz": "dozen",
+}
+
+// baseUnits is what a normalized unit price is quoted per for each dimension
+var baseUnits = map[dimension]struct {
+	name   string
+	factor float64
+}{
+	mass:   {"kg", 1000},
+	volume: {"l", 1000},
+	count:  {"each", 1},
+}
+
+func canonicalUnit(unit string) string {
+	u := strings.Join(strings.Fields(strings.ToLower(unit)), " ")
+	u = strings.TrimSuffix(u, ".")
+	if alias, ok := unitAliases[u]; ok {
+		return alias
+	}
+	return u
+}
+
+func lookupUnit(unit string) (unitDef, bool) {
+	def, ok := unitTable[canonicalUnit(unit)]
+	return def, ok
+}
+
+// amount is a quantity expressed in the base unit of its dimension
+type amount struct {
+	value float64
+	dim   dimension
+}
+
+func toAmount(quantity float64, unit string) (amount, bool) {
+	def, ok := lookupUnit(unit)
+	if !ok {
+		return amount{}, false
+	}
+	return amount{quantity * def.factor, def.dim}, true
+}
+
+// in converts the amount to the target dimension, density is in grams per millilitre and is only used to go
+// between mass and volume, a zero density means the conversion is not possible
+func (a amount) in(dim dimension, density float64) (float64, bool) {
+	switch {
+	case a.dim == dim:
+		return a.value, true
+	case density <= 0:
+		return 0, false
+	case a.dim == volume && dim == mass:
+		return a.value * density, true
+	case a.dim == mass && dim == volume:
+		return a.value / density, true
+	}
+	return 0, false
+}
+
+func sameAmount(a, b float64) bool {
+	return math.Abs(a-b) <= 1e-9*math.Max(math.Abs(a), math.Abs(b))
+}
+
+// unitPrice quotes the price of the pack per kg, l or each so packs of different sizes can be compared
+func unitPrice(price float64, pack amount) *UnitPrice {
+	base, ok := baseUnits[pack.dim]
+	if !ok || pack.value <= 0 {
+		return nil
+	}
+	return &UnitPrice{Price: math.Round(price/pack.value*base.factor*100) / 100, Per: base.name}
+}
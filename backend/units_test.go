@@ -0,0 +1,46 @@
+package main
+
+import "testing"
+
+func TestAmountConversion(t *testing.T) {
+	tests := []struct {
+		quantity float64
+		unit     string
+		dim      dimension
+		density  float64
+		want     float64
+		ok       bool
+	}{
+		{2, "kg", mass, 0, 2000, true},
+		{1, " Pounds ", mass, 0, 453.59237, true},
+		{3, "tbsp", volume, 0, 44.36029434375, true},
+		{2, "dozen", count, 0, 24, true},
+		{250, "ml", mass, 0.92, 230, true},
+		{460, "g", volume, 0.92, 500, true},
+		{250, "ml", mass, 0, 0, false},
+		{1, "each", mass, 1, 0, false},
+	}
+
+	for _, test := range tests {
+		a, ok := toAmount(test.quantity, test.unit)
+		if !ok {
+			t.Fatalf("%v %s -> unit not found", test.quantity, test.unit)
+		}
+		got, ok := a.in(test.dim, test.density)
+		if ok != test.ok || (ok && !sameAmount(got, test.want)) {
+			t.Fatalf("%v %s in %s -> expected %v %v got %v %v", test.quantity, test.unit, test.dim, test.want, test.ok, got, ok)
+		}
+	}
+
+	if _, ok := toAmount(1, "pinch"); ok {
+		t.Fatal("unknown unit should not convert")
+	}
+}
+
+func TestUnitPrice(t *testing.T) {
+	small, _ := toAmount(500, "g")
+	large, _ := toAmount(2, "kg")
+	if a, b := unitPrice(3, small), unitPrice(10, large); a.Price != 6 || b.Price != 5 || a.Per != "kg" {
+		t.Fatalf("expected 6 and 5 per kg got %+v and %+v", a, b)
+	}
+}
@@ -236,6 +236,21 @@ func processContainers[C containers](ctx context.Context, col *mongo.Collection,
 			}
 
 			fieldsToUpdate := bson.M{}
+			if store.Hours != nil {
+				fieldsToUpdate["stores.$.hours"] = store.Hours
+			}
+			if store.DeliveryZones != nil {
+				fieldsToUpdate["stores.$.delivery_zones"] = store.DeliveryZones
+			}
+			if store.SlotTemplates != nil {
+				fieldsToUpdate["stores.$.slot_templates"] = store.SlotTemplates
+			}
+			if store.Region != "" {
+				fieldsToUpdate["stores.$.region"] = store.Region
+			}
+			if store.Address != "" {
+				fieldsToUpdate["stores.$.address"] = store.Address
+			}
 			models = updateContainers("stores", store.Items, ID{store.ID}, id, models, updateStoreFilter)
 
 			if len(fieldsToUpdate) > 0 {
@@ -279,6 +294,9 @@ func updateContainers(c string, items []*Item, con, doc ID, models []mongo.Write
 	if len(items) > 0 {
 		var itemsToInsert []any
 		for _, item := range items {
+			if c == "stores" {
+				item.NameKeys = nameKeys(item.Name)
+			}
 			if item.IngredientID == bson.NilObjectID {
 				item.IngredientID = bson.NewObjectID()
 				itemsToInsert = append(itemsToInsert, item)
@@ -291,6 +309,9 @@ func updateContainers(c string, items []*Item, con, doc ID, models []mongo.Write
 
 				if item.Name != "" {
 					itemFieldsToUpdate[c+".$[r].items.$[i].name"] = item.Name
+					if item.NameKeys != nil {
+						itemFieldsToUpdate[c+".$[r].items.$[i].name_keys"] = item.NameKeys
+					}
 				}
 				if item.UnitQuantity != 0 {
 					itemFieldsToUpdate[c+".$[r].items.$[i].unit_quantity"] = item.UnitQuantity
@@ -304,6 +325,12 @@ func updateContainers(c string, items []*Item, con, doc ID, models []mongo.Write
 				if item.Price != 0 {
 					itemFieldsToUpdate[c+".$[r].items.$[i].price"] = item.Price
 				}
+				if item.CatalogID != bson.NilObjectID {
+					itemFieldsToUpdate[c+".$[r].items.$[i].catalog_id"] = item.CatalogID
+				}
+				if item.ReorderThreshold != 0 {
+					itemFieldsToUpdate[c+".$[r].items.$[i].reorder_threshold"] = item.ReorderThreshold
+				}
 
 				if len(itemFieldsToUpdate) > 0 {
 					itemUpdate := bson.D{{Key: "$set", Value: itemFieldsToUpdate}}